@@ -54,6 +54,11 @@ func declareUsersRoutes(opts *config.Options, router *gin.RouterGroup) {
 	apiUsersRoutes.GET("", getUser)
 	apiUsersRoutes.PUT("", updateUser)
 	apiUsersRoutes.DELETE("", deleteUser)
+	apiUsersRoutes.GET("/quota", getUserQuota)
+	apiUsersRoutes.PUT("/quota", updateUserQuota)
+	apiUsersRoutes.POST("/impersonate", func(c *gin.Context) {
+		impersonateUser(opts, c)
+	})
 
 	declareDomainsRoutes(opts, apiUsersRoutes)
 	declareProvidersRoutes(opts, apiUsersRoutes)
@@ -123,6 +128,26 @@ func deleteUser(c *gin.Context) {
 	ApiResponse(c, true, storage.MainStore.DeleteUser(user))
 }
 
+func getUserQuota(c *gin.Context) {
+	user := c.MustGet("user").(*happydns.User)
+
+	c.JSON(http.StatusOK, user.Quota)
+}
+
+func updateUserQuota(c *gin.Context) {
+	user := c.MustGet("user").(*happydns.User)
+
+	var quota happydns.UserQuota
+	err := c.ShouldBindJSON(&quota)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
+		return
+	}
+	user.Quota = quota
+
+	ApiResponse(c, user.Quota, storage.MainStore.UpdateUser(user))
+}
+
 func tidyDB(c *gin.Context) {
 	ApiResponse(c, true, storage.MainStore.Tidy())
 }
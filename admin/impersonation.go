@@ -0,0 +1,108 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/api"
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/model"
+)
+
+type impersonateForm struct {
+	// Impersonator is the name of the person acting as the user.
+	Impersonator string `json:"impersonator"`
+
+	// Reason explains why the user is impersonated.
+	Reason string `json:"reason"`
+
+	// Duration is the lifetime of the token (default 15m).
+	Duration string `json:"duration,omitempty"`
+}
+
+type impersonateResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Cookie    string    `json:"cookie"`
+}
+
+func impersonateUser(opts *config.Options, c *gin.Context) {
+	user := c.MustGet("user").(*happydns.User)
+
+	var form impersonateForm
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
+		return
+	}
+
+	if form.Reason == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": "Please give the reason why you impersonate this user."})
+		return
+	}
+
+	duration := 15 * time.Minute
+	if form.Duration != "" {
+		duration, err = time.ParseDuration(form.Duration)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Invalid duration: %s", err.Error())})
+			return
+		}
+	}
+
+	token, claims, err := api.NewImpersonationToken(opts, user, form.Impersonator, duration)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
+		return
+	}
+
+	logging.FromContext(c).WithFields(log.Fields{
+		"impersonator": form.Impersonator,
+		"user_id":      user.Id.String(),
+		"email":        user.Email,
+		"reason":       form.Reason,
+		"expires_at":   claims.ExpiresAt.Time,
+	}).Warn("impersonation token issued")
+
+	c.JSON(http.StatusOK, impersonateResponse{
+		Token:     token,
+		ExpiresAt: claims.ExpiresAt.Time,
+		Cookie:    api.COOKIE_NAME,
+	})
+}
@@ -0,0 +1,445 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/storage"
+	"git.happydns.org/happydomain/utils"
+)
+
+const (
+	// acmeChallengeLifetime is the delay after which a challenge is
+	// removed, when the client didn't clean it up.
+	acmeChallengeLifetime = time.Hour
+
+	// acmeChallengeTTL is the TTL of the published challenges, kept short
+	// as they are short-lived.
+	acmeChallengeTTL = 60
+
+	// acmeMaxChallenges is the number of values kept at the same time for
+	// a Credential: a certificate for a domain and its wildcard needs two.
+	acmeMaxChallenges = 2
+
+	// acmeExpirationInterval is the delay between two checks of expired
+	// challenges.
+	acmeExpirationInterval = time.Minute
+)
+
+// Changes of the challenges of a Credential are serialized by the lock of
+// its domain (see lockDomain), which is also needed to publish them.
+var (
+	// acmePendingMu protects acmePending.
+	acmePendingMu sync.Mutex
+
+	// acmePending holds the Credentials having published challenges.
+	acmePending = map[string]happydns.Identifier{}
+)
+
+// declareACMERoutes exposes the APIs used by certificate clients to solve
+// DNS-01 challenges: the httpreq provider of lego and the update route of
+// acme-dns. Clients authenticate with a Credential identifier and its secret.
+func declareACMERoutes(router *gin.RouterGroup) {
+	apiACMERoutes := router.Group("/acme")
+
+	apiACMERoutes.POST("/present", presentHTTPReqChallenge)
+	apiACMERoutes.POST("/cleanup", cleanupHTTPReqChallenge)
+
+	apiACMERoutes.POST("/update", updateACMEDNSChallenge)
+}
+
+// httpreqForm is the body sent by lego's httpreq provider. In its raw mode,
+// Domain and KeyAuth are sent instead of FQDN and Value.
+type httpreqForm struct {
+	FQDN    string `json:"fqdn"`
+	Value   string `json:"value"`
+	Domain  string `json:"domain,omitempty"`
+	Token   string `json:"token,omitempty"`
+	KeyAuth string `json:"keyAuth,omitempty"`
+}
+
+// acmeDNSForm is the body of the acme-dns update route.
+type acmeDNSForm struct {
+	Subdomain string `json:"subdomain"`
+	Txt       string `json:"txt"`
+}
+
+// acmeChallengeName returns the name where the challenges of the Credential
+// are published.
+func acmeChallengeName(cred *happydns.Credential, domain *happydns.Domain) string {
+	return dns.Fqdn(utils.DomainJoin("_acme-challenge", credentialFQDN(cred, domain)))
+}
+
+// validACMEValue tells whether the given value looks like a DNS-01
+// challenge: the base64url encoded SHA-256 of a key authorization.
+func validACMEValue(value string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	return err == nil && len(b) == sha256.Size
+}
+
+// acmeCredential authenticates the client and registers the Credential's
+// owner and domain in the context.
+func acmeCredential(c *gin.Context, login, secret string) (*happydns.Credential, error) {
+	cred, err := authenticateCredential(c.ClientIP(), login, secret, happydns.CredentialACME)
+	if err != nil {
+		logging.FromContext(c).WithError(err).WithField("credential", login).Info("acme authentication failed")
+		return nil, err
+	}
+
+	user, err := storage.MainStore.GetUser(cred.IdUser)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the credential owner: %w", err)
+	}
+	c.Set("LoggedUser", user)
+
+	domain, err := storage.MainStore.GetDomain(user, cred.IdDomain)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the credential domain: %w", err)
+	}
+	c.Set("domain", domain)
+
+	return cred, nil
+}
+
+// httpreqChallenge extracts the challenge from the request and checks it
+// concerns the Credential.
+func httpreqChallenge(c *gin.Context) (*happydns.Credential, string, bool) {
+	login, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="happyDomain"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"errmsg": "Authentication required."})
+		return nil, "", false
+	}
+
+	cred, err := acmeCredential(c, login, secret)
+	if errors.Is(err, errAuthThrottled) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"errmsg": "Too many authentication failures, try again later."})
+		return nil, "", false
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"errmsg": "Invalid credential."})
+		return nil, "", false
+	}
+
+	var form httpreqForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
+		return nil, "", false
+	}
+
+	// In raw mode, lego sends the key authorization to be hashed
+	if form.FQDN == "" && form.Domain != "" {
+		form.FQDN = utils.DomainJoin("_acme-challenge", dns.Fqdn(strings.TrimPrefix(form.Domain, "*.")))
+		h := sha256.Sum256([]byte(form.KeyAuth))
+		form.Value = base64.RawURLEncoding.EncodeToString(h[:])
+	}
+
+	domain := c.MustGet("domain").(*happydns.Domain)
+	if !strings.EqualFold(dns.Fqdn(form.FQDN), acmeChallengeName(cred, domain)) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"errmsg": fmt.Sprintf("This credential doesn't allow to change %q.", form.FQDN)})
+		return nil, "", false
+	}
+
+	if !validACMEValue(form.Value) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": "Invalid challenge value."})
+		return nil, "", false
+	}
+
+	return cred, form.Value, true
+}
+
+func presentHTTPReqChallenge(c *gin.Context) {
+	cred, value, ok := httpreqChallenge(c)
+	if !ok {
+		return
+	}
+
+	if err := changeACMEChallenges(logging.FromContext(c), cred, value, true); err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to present ACME challenge")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": fmt.Sprintf("Unable to publish the challenge: %s", err.Error())})
+		return
+	}
+
+	c.JSON(http.StatusOK, httpreqForm{FQDN: acmeChallengeName(cred, c.MustGet("domain").(*happydns.Domain)), Value: value})
+}
+
+func cleanupHTTPReqChallenge(c *gin.Context) {
+	cred, value, ok := httpreqChallenge(c)
+	if !ok {
+		return
+	}
+
+	if err := changeACMEChallenges(logging.FromContext(c), cred, value, false); err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to clean up ACME challenge")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": fmt.Sprintf("Unable to remove the challenge: %s", err.Error())})
+		return
+	}
+
+	c.JSON(http.StatusOK, httpreqForm{FQDN: acmeChallengeName(cred, c.MustGet("domain").(*happydns.Domain)), Value: value})
+}
+
+// updateACMEDNSChallenge implements the update route of acme-dns. As in
+// acme-dns, there is no clean up: only the last values are kept, and they
+// expire anyway.
+func updateACMEDNSChallenge(c *gin.Context) {
+	cred, err := acmeCredential(c, c.GetHeader("X-Api-User"), c.GetHeader("X-Api-Key"))
+	if errors.Is(err, errAuthThrottled) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too_many_requests"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "forbidden"})
+		return
+	}
+
+	var form acmeDNSForm
+	if err := c.ShouldBindJSON(&form); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "malformed_json_payload"})
+		return
+	}
+
+	// The acme-dns subdomain is the Credential identifier
+	if form.Subdomain != "" && form.Subdomain != cred.Id.String() {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "forbidden"})
+		return
+	}
+
+	if !validACMEValue(form.Txt) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "bad_txt"})
+		return
+	}
+
+	if err := changeACMEChallenges(logging.FromContext(c), cred, form.Txt, true); err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to present ACME challenge")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"txt": form.Txt})
+}
+
+// changeACMEChallenges adds or removes a value among the Credential's
+// challenges, then publishes them.
+func changeACMEChallenges(logger *log.Entry, cred *happydns.Credential, value string, present bool) error {
+	unlock := lockDomainID(cred.IdDomain)
+	defer unlock()
+
+	// Work on the stored Credential, that may have changed since the
+	// authentication
+	cred, err := storage.MainStore.GetCredential(cred.Id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	cred.PruneChallenges(now)
+
+	var challenges []happydns.CredentialChallenge
+	for _, ch := range cred.Challenges {
+		if ch.Value != value {
+			challenges = append(challenges, ch)
+		}
+	}
+	if present {
+		challenges = append(challenges, happydns.CredentialChallenge{
+			Value:     value,
+			ExpiresAt: now.Add(acmeChallengeLifetime),
+		})
+		if len(challenges) > acmeMaxChallenges {
+			challenges = challenges[len(challenges)-acmeMaxChallenges:]
+		}
+	}
+	cred.Challenges = challenges
+	cred.LastUsed = &now
+
+	return publishACMEChallenges(logger, cred)
+}
+
+// publishACMEChallenges replaces the published challenges of the Credential
+// by its current ones, then saves it. The caller has to hold the lock of the
+// Credential's domain.
+func publishACMEChallenges(logger *log.Entry, cred *happydns.Credential) error {
+	user, err := storage.MainStore.GetUser(cred.IdUser)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the credential owner: %w", err)
+	}
+
+	domain, err := storage.MainStore.GetDomain(user, cred.IdDomain)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the credential domain: %w", err)
+	}
+
+	name := acmeChallengeName(cred, domain)
+
+	var rrs []dns.RR
+	for _, ch := range cred.Challenges {
+		rrs = append(rrs, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    acmeChallengeTTL,
+			},
+			Txt: []string{ch.Value},
+		})
+	}
+
+	_, err = publishRRsets(logger.WithField("domain", domain.DomainName), user, domain, name, []uint16{dns.TypeTXT}, rrs)
+	if err != nil {
+		return err
+	}
+
+	acmePendingMu.Lock()
+	if len(cred.Challenges) > 0 {
+		acmePending[cred.Id.String()] = cred.Id
+	} else {
+		delete(acmePending, cred.Id.String())
+	}
+	acmePendingMu.Unlock()
+
+	return storage.MainStore.UpdateCredential(cred)
+}
+
+// withdrawACMEChallenges removes all the published challenges of a
+// Credential, before its deletion.
+func withdrawACMEChallenges(logger *log.Entry, cred *happydns.Credential) error {
+	if len(cred.Challenges) == 0 {
+		return nil
+	}
+
+	unlock := lockDomainID(cred.IdDomain)
+	defer unlock()
+
+	cred.Challenges = nil
+	return publishACMEChallenges(logger, cred)
+}
+
+// ExpireACMEChallenges removes, until the context is done, the challenges
+// that haven't been cleaned up by their client in time.
+func ExpireACMEChallenges(ctx context.Context) {
+	logger := log.WithField("job", "acme_expiration")
+
+	if err := loadPendingACMEChallenges(); err != nil {
+		logger.WithError(err).Error("unable to list pending ACME challenges")
+	}
+
+	ticker := time.NewTicker(acmeExpirationInterval)
+	defer ticker.Stop()
+
+	for {
+		expireACMEChallenges(logger, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// loadPendingACMEChallenges looks for the Credentials having challenges,
+// published before a restart.
+func loadPendingACMEChallenges() error {
+	users, err := storage.MainStore.GetUsers()
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		credentials, err := storage.MainStore.GetCredentials(user)
+		if err != nil {
+			return err
+		}
+
+		acmePendingMu.Lock()
+		for _, cred := range credentials {
+			if len(cred.Challenges) > 0 {
+				acmePending[cred.Id.String()] = cred.Id
+			}
+		}
+		acmePendingMu.Unlock()
+	}
+
+	return nil
+}
+
+func expireACMEChallenges(logger *log.Entry, now time.Time) {
+	acmePendingMu.Lock()
+	pending := make([]happydns.Identifier, 0, len(acmePending))
+	for _, id := range acmePending {
+		pending = append(pending, id)
+	}
+	acmePendingMu.Unlock()
+
+	for _, id := range pending {
+		expireACMECredential(logger.WithField("credential", id.String()), id, now)
+	}
+}
+
+// expireACMECredential removes the expired challenges of a Credential.
+func expireACMECredential(logger *log.Entry, id happydns.Identifier, now time.Time) {
+	cred, err := storage.MainStore.GetCredential(id)
+	if err != nil {
+		acmePendingMu.Lock()
+		delete(acmePending, id.String())
+		acmePendingMu.Unlock()
+		return
+	}
+
+	unlock := lockDomainID(cred.IdDomain)
+	defer unlock()
+
+	// The Credential may have changed while waiting for the lock
+	if cred, err = storage.MainStore.GetCredential(id); err != nil || !cred.PruneChallenges(now) {
+		return
+	}
+
+	if err := publishACMEChallenges(logger, cred); err != nil {
+		// Retried at the next tick
+		logger.WithError(err).Warn("unable to remove expired ACME challenges")
+	} else {
+		logger.Info("expired ACME challenges removed")
+	}
+}
@@ -0,0 +1,396 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/storage"
+	database "git.happydns.org/happydomain/storage/memory"
+)
+
+type acmeTest struct {
+	t      *testing.T
+	router *gin.Engine
+	user   *happydns.User
+}
+
+func newACMETest(t *testing.T) *acmeTest {
+	storage.MainStore = database.NewMemoryStorage()
+	authFailures = newKeyedLimiters(authFailureInterval, authFailureBurst)
+
+	acmePendingMu.Lock()
+	acmePending = map[string]happydns.Identifier{}
+	acmePendingMu.Unlock()
+
+	user := &happydns.User{Email: "user@example.com"}
+	if err := storage.MainStore.CreateUser(user); err != nil {
+		t.Fatalf("unable to create the user: %s", err.Error())
+	}
+
+	router := gin.New()
+	declareACMERoutes(router.Group("/api"))
+
+	return &acmeTest{t: t, router: router, user: user}
+}
+
+// newDomain stores a domain hosted by a TestProvider, serving an address
+// and a TXT record for www.
+func (at *acmeTest) newDomain(name string, fail bool) *happydns.Domain {
+	src, err := storage.MainStore.CreateProvider(at.user, &TestProvider{Fail: fail}, "test")
+	if err != nil {
+		at.t.Fatalf("unable to create the provider: %s", err.Error())
+	}
+
+	domain := newTestDomain(at.t, at.user, name)
+	domain.IdProvider = src.Id
+	if err := storage.MainStore.UpdateDomain(domain); err != nil {
+		at.t.Fatalf("unable to update the domain: %s", err.Error())
+	}
+
+	setTestZone(at.t, strings.TrimSuffix(name, "."), "www."+name+" 300 IN A 192.0.2.1", "www."+name+" 300 IN TXT \"v=spf1 -all\"")
+
+	return domain
+}
+
+// newCredential stores a Credential for www and returns its login and
+// secret.
+func (at *acmeTest) newCredential(domain *happydns.Domain, scope happydns.CredentialScope) (string, string) {
+	cred := &happydns.Credential{
+		IdUser:    at.user.Id,
+		IdDomain:  domain.Id,
+		Scope:     scope,
+		Subdomain: "www",
+	}
+	secret, err := cred.GenerateSecret()
+	if err != nil {
+		at.t.Fatalf("GenerateSecret: %s", err.Error())
+	}
+	if err := storage.MainStore.CreateCredential(cred); err != nil {
+		at.t.Fatalf("unable to create the credential: %s", err.Error())
+	}
+	return cred.Id.String(), secret
+}
+
+// httpreq sends a request of lego's httpreq provider.
+func (at *acmeTest) httpreq(route, login, secret string, form httpreqForm) int {
+	body, _ := json.Marshal(form)
+	req := httptest.NewRequest("POST", "/api/acme/"+route, bytes.NewReader(body))
+	req.RemoteAddr = "203.0.113.1:1234"
+	if login != "" {
+		req.SetBasicAuth(login, secret)
+	}
+
+	w := httptest.NewRecorder()
+	at.router.ServeHTTP(w, req)
+	return w.Code
+}
+
+// acmeDNS sends a request of an acme-dns client.
+func (at *acmeTest) acmeDNS(login, secret string, form acmeDNSForm) (int, string) {
+	body, _ := json.Marshal(form)
+	req := httptest.NewRequest("POST", "/api/acme/update", bytes.NewReader(body))
+	req.RemoteAddr = "203.0.113.1:1234"
+	req.Header.Set("X-Api-User", login)
+	req.Header.Set("X-Api-Key", secret)
+
+	w := httptest.NewRecorder()
+	at.router.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+// checkChallenges checks the TXT records served for the challenges of
+// www, and the challenges stored in the Credential.
+func (at *acmeTest) checkChallenges(step string, domain *happydns.Domain, login string, values ...string) {
+	at.t.Helper()
+
+	zone := strings.TrimSuffix(domain.DomainName, ".")
+	var served []string
+	for _, record := range testZone(zone) {
+		if strings.HasPrefix(record, "_acme-challenge.www."+domain.DomainName) {
+			served = append(served, record[strings.LastIndex(record, "\t")+1:])
+		} else if !strings.HasPrefix(record, "www."+domain.DomainName) {
+			at.t.Errorf("%s: unexpected record %s", step, record)
+		}
+	}
+	if len(testZone(zone)) != len(served)+2 {
+		at.t.Errorf("%s: the other records have changed: %v", step, testZone(zone))
+	}
+
+	var expected []string
+	for _, v := range values {
+		expected = append(expected, `"`+v+`"`)
+	}
+	if strings.Join(served, " ") != strings.Join(expected, " ") && !(len(served) == 2 && len(expected) == 2 && served[0] == expected[1] && served[1] == expected[0]) {
+		at.t.Errorf("%s: got challenges %v served, expected %v", step, served, expected)
+	}
+
+	id, _ := happydns.NewIdentifierFromString(login)
+	cred, err := storage.MainStore.GetCredential(id)
+	if err != nil {
+		at.t.Fatalf("unable to retrieve the credential: %s", err.Error())
+	}
+	var stored []string
+	for _, ch := range cred.Challenges {
+		stored = append(stored, ch.Value)
+	}
+	if strings.Join(stored, " ") != strings.Join(values, " ") {
+		at.t.Errorf("%s: got challenges %v stored, expected %v", step, stored, values)
+	}
+
+	acmePendingMu.Lock()
+	_, pending := acmePending[login]
+	acmePendingMu.Unlock()
+	if pending != (len(values) > 0) {
+		at.t.Errorf("%s: the credential pending state is %v, expected %v", step, pending, len(values) > 0)
+	}
+}
+
+func acmeValue(keyAuth string) string {
+	h := sha256.Sum256([]byte(keyAuth))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func TestACMEPresentCleanup(t *testing.T) {
+	at := newACMETest(t)
+	domain := at.newDomain("example.com.", false)
+	login, secret := at.newCredential(domain, happydns.CredentialACME)
+
+	fqdn := "_acme-challenge.www.example.com."
+	v1, v2, v3 := acmeValue("1"), acmeValue("2"), acmeValue("3")
+
+	if code := at.httpreq("present", login, secret, httpreqForm{FQDN: fqdn, Value: v1}); code != http.StatusOK {
+		t.Fatalf("present: got %d, expected 200", code)
+	}
+	at.checkChallenges("present", domain, login, v1)
+
+	// In raw mode, the key authorization is hashed
+	if code := at.httpreq("present", login, secret, httpreqForm{Domain: "*.www.example.com", KeyAuth: "2"}); code != http.StatusOK {
+		t.Fatalf("present raw: got %d, expected 200", code)
+	}
+	at.checkChallenges("present raw", domain, login, v1, v2)
+
+	// Only the last values are kept
+	if code := at.httpreq("present", login, secret, httpreqForm{FQDN: fqdn, Value: v3}); code != http.StatusOK {
+		t.Fatalf("present third: got %d, expected 200", code)
+	}
+	at.checkChallenges("present third", domain, login, v2, v3)
+
+	if code := at.httpreq("cleanup", login, secret, httpreqForm{FQDN: fqdn, Value: v2}); code != http.StatusOK {
+		t.Fatalf("cleanup: got %d, expected 200", code)
+	}
+	at.checkChallenges("cleanup", domain, login, v3)
+
+	if code := at.httpreq("cleanup", login, secret, httpreqForm{FQDN: fqdn, Value: v3}); code != http.StatusOK {
+		t.Fatalf("cleanup last: got %d, expected 200", code)
+	}
+	at.checkChallenges("cleanup last", domain, login)
+
+	// acme-dns replaces the oldest value
+	for _, v := range []string{v1, v2, v3} {
+		if code, body := at.acmeDNS(login, secret, acmeDNSForm{Subdomain: login, Txt: v}); code != http.StatusOK {
+			t.Fatalf("acme-dns update: got %d %s, expected 200", code, body)
+		}
+	}
+	at.checkChallenges("acme-dns", domain, login, v2, v3)
+}
+
+func TestACMERequestErrors(t *testing.T) {
+	at := newACMETest(t)
+	domain := at.newDomain("example.com.", false)
+	login, secret := at.newCredential(domain, happydns.CredentialACME)
+	dyndnsLogin, dyndnsSecret := at.newCredential(domain, happydns.CredentialDynDNS)
+
+	fqdn := "_acme-challenge.www.example.com."
+	value := acmeValue("1")
+
+	for _, tc := range []struct {
+		name          string
+		login, secret string
+		form          httpreqForm
+		code          int
+	}{
+		{"no authentication", "", "", httpreqForm{FQDN: fqdn, Value: value}, http.StatusUnauthorized},
+		{"wrong secret", login, secret + "x", httpreqForm{FQDN: fqdn, Value: value}, http.StatusUnauthorized},
+		{"dyndns credential", dyndnsLogin, dyndnsSecret, httpreqForm{FQDN: fqdn, Value: value}, http.StatusUnauthorized},
+		{"another name", login, secret, httpreqForm{FQDN: "_acme-challenge.example.com.", Value: value}, http.StatusForbidden},
+		{"another subdomain", login, secret, httpreqForm{Domain: "mail.example.com", KeyAuth: "1"}, http.StatusForbidden},
+		{"invalid value", login, secret, httpreqForm{FQDN: fqdn, Value: "not a challenge"}, http.StatusBadRequest},
+	} {
+		if code := at.httpreq("present", tc.login, tc.secret, tc.form); code != tc.code {
+			t.Errorf("%s: got %d, expected %d", tc.name, code, tc.code)
+		}
+		authFailures = newKeyedLimiters(authFailureInterval, authFailureBurst)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		login, secret string
+		form          acmeDNSForm
+		code          int
+	}{
+		{"wrong secret", login, "x", acmeDNSForm{Txt: value}, http.StatusUnauthorized},
+		{"another subdomain", login, secret, acmeDNSForm{Subdomain: dyndnsLogin, Txt: value}, http.StatusUnauthorized},
+		{"invalid value", login, secret, acmeDNSForm{Txt: "x"}, http.StatusBadRequest},
+	} {
+		if code, body := at.acmeDNS(tc.login, tc.secret, tc.form); code != tc.code {
+			t.Errorf("acme-dns %s: got %d %s, expected %d", tc.name, code, body, tc.code)
+		}
+		authFailures = newKeyedLimiters(authFailureInterval, authFailureBurst)
+	}
+
+	at.checkChallenges("errors", domain, login)
+
+	// Throttled clients are told so
+	for i := 0; i < authFailureBurst; i++ {
+		at.httpreq("present", login, "wrong", httpreqForm{FQDN: fqdn, Value: value})
+	}
+	if code := at.httpreq("present", login, secret, httpreqForm{FQDN: fqdn, Value: value}); code != http.StatusTooManyRequests {
+		t.Errorf("after %d failures, got %d, expected 429", authFailureBurst, code)
+	}
+}
+
+func TestACMEFailedPublish(t *testing.T) {
+	at := newACMETest(t)
+	domain := at.newDomain("example.com.", true)
+	login, secret := at.newCredential(domain, happydns.CredentialACME)
+
+	if code := at.httpreq("present", login, secret, httpreqForm{FQDN: "_acme-challenge.www.example.com.", Value: acmeValue("1")}); code != http.StatusInternalServerError {
+		t.Errorf("got %d, expected 500", code)
+	}
+
+	// The Credential is only saved once published
+	at.checkChallenges("failed present", domain, login)
+}
+
+func TestACMEExpiration(t *testing.T) {
+	at := newACMETest(t)
+	domain := at.newDomain("example.com.", false)
+	login, secret := at.newCredential(domain, happydns.CredentialACME)
+	value := acmeValue("1")
+
+	if code := at.httpreq("present", login, secret, httpreqForm{FQDN: "_acme-challenge.www.example.com.", Value: value}); code != http.StatusOK {
+		t.Fatalf("present: got %d, expected 200", code)
+	}
+
+	logger := log.WithField("test", t.Name())
+
+	expireACMEChallenges(logger, time.Now())
+	at.checkChallenges("before expiration", domain, login, value)
+
+	expireACMEChallenges(logger, time.Now().Add(acmeChallengeLifetime+time.Minute))
+	at.checkChallenges("after expiration", domain, login)
+
+	// Pending challenges are found again after a restart
+	id, _ := happydns.NewIdentifierFromString(login)
+	cred, _ := storage.MainStore.GetCredential(id)
+	cred.Challenges = []happydns.CredentialChallenge{{Value: value, ExpiresAt: time.Now()}}
+	if err := storage.MainStore.UpdateCredential(cred); err != nil {
+		t.Fatalf("unable to update the credential: %s", err.Error())
+	}
+	if err := loadPendingACMEChallenges(); err != nil {
+		t.Fatalf("loadPendingACMEChallenges: %s", err.Error())
+	}
+	acmePendingMu.Lock()
+	_, pending := acmePending[login]
+	acmePendingMu.Unlock()
+	if !pending {
+		t.Errorf("the credential with challenges is not pending after loadPendingACMEChallenges")
+	}
+}
+
+func TestACMEDomainsDontBlockEachOther(t *testing.T) {
+	at := newACMETest(t)
+	slow := at.newDomain("slow.example.", false)
+	fast := at.newDomain("fast.example.", false)
+	slowLogin, slowSecret := at.newCredential(slow, happydns.CredentialACME)
+	fastLogin, fastSecret := at.newCredential(fast, happydns.CredentialACME)
+
+	block := make(chan struct{})
+	testProviderMu.Lock()
+	testProviderBlocks["slow.example"] = block
+	testProviderMu.Unlock()
+	defer func() {
+		testProviderMu.Lock()
+		delete(testProviderBlocks, "slow.example")
+		testProviderMu.Unlock()
+	}()
+
+	slowDone := make(chan int)
+	go func() {
+		slowDone <- at.httpreq("present", slowLogin, slowSecret, httpreqForm{FQDN: "_acme-challenge.www.slow.example.", Value: acmeValue("slow")})
+	}()
+
+	fastDone := make(chan int)
+	go func() {
+		fastDone <- at.httpreq("present", fastLogin, fastSecret, httpreqForm{FQDN: "_acme-challenge.www.fast.example.", Value: acmeValue("fast")})
+		expireACMEChallenges(log.WithField("test", t.Name()), time.Now())
+		fastDone <- 0
+	}()
+
+	select {
+	case code := <-fastDone:
+		if code != http.StatusOK {
+			t.Errorf("fast domain: got %d, expected 200", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the fast domain is blocked by the slow one")
+	}
+
+	select {
+	case <-slowDone:
+		t.Errorf("the slow domain didn't wait for its provider")
+	default:
+	}
+
+	close(block)
+
+	if code := <-slowDone; code != http.StatusOK {
+		t.Errorf("slow domain: got %d, expected 200", code)
+	}
+	<-fastDone
+
+	at.checkChallenges("slow", slow, slowLogin, acmeValue("slow"))
+	at.checkChallenges("fast", fast, fastLogin, acmeValue("fast"))
+}
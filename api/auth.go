@@ -33,7 +33,6 @@ package api
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -42,6 +41,7 @@ import (
 	"github.com/golang-jwt/jwt/v4"
 
 	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
 	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/storage"
 )
@@ -51,6 +51,10 @@ type UserProfile struct {
 	Email         string    `json:"email"`
 	EmailVerified bool      `json:"email_verified"`
 	CreatedAt     time.Time `json:"created_at"`
+
+	// Impersonator is filled when the token has been issued to an
+	// administrator acting as the user.
+	Impersonator string `json:"impersonator,omitempty"`
 }
 
 type UserClaims struct {
@@ -69,7 +73,10 @@ func updateUserFromClaims(user *happydns.User, claims *UserClaims) {
 
 func retrieveUserFromClaims(claims *UserClaims) (user *happydns.User, err error) {
 	user, err = storage.MainStore.GetUser(claims.Profile.UserId)
-	if err != nil {
+	if claims.Profile.Impersonator != "" {
+		// Don't alter the user when someone is acting on its behalf
+		return
+	} else if err != nil {
 		// The user doesn't exists yet: create it!
 		user = &happydns.User{
 			Id:        claims.Profile.UserId,
@@ -108,12 +115,20 @@ func retrieveSessionFromClaims(claims *UserClaims, user *happydns.User, session_
 			IssuedAt: time.Now(),
 		}
 
+		if claims.Profile.Impersonator != "" {
+			session.SetValue(IMPERSONATOR_KEY, claims.Profile.Impersonator)
+		}
+
 		err = storage.MainStore.UpdateSession(session)
 		if err != nil {
 			err = fmt.Errorf("has a correct JWT, but an error occurs when creating the session: %w", err)
 			return
 		}
 
+		if claims.Profile.Impersonator != "" {
+			return
+		}
+
 		// Update user's data
 		updateUserFromClaims(user, claims)
 
@@ -167,7 +182,7 @@ func authMiddleware(opts *config.Options, optional bool) gin.HandlerFunc {
 				return []byte(opts.JWTSecretKey), nil
 			}, jwt.WithValidMethods([]string{signingMethod.Name}))
 		if err != nil {
-			log.Printf("%s provide a bad JWT claims: %s", c.ClientIP(), err.Error())
+			logging.FromContext(c).WithError(err).Warn("bad JWT claims provided")
 			c.SetCookie(COOKIE_NAME, "", -1, opts.BaseURL+"/", "", opts.DevProxy == "", true)
 			requireLogin(opts, c, "Something goes wrong with your session. Please reconnect.")
 			return
@@ -175,23 +190,34 @@ func authMiddleware(opts *config.Options, optional bool) gin.HandlerFunc {
 
 		// Check that required fields are filled
 		if len(claims.Profile.UserId) == 0 {
-			log.Printf("%s: no UserId found in JWT claims", c.ClientIP())
+			logging.FromContext(c).Warn("no UserId found in JWT claims")
 			c.SetCookie(COOKIE_NAME, "", -1, opts.BaseURL+"/", "", opts.DevProxy == "", true)
 			requireLogin(opts, c, "Something goes wrong with your session. Please reconnect.")
 			return
 		}
 
 		if claims.Profile.Email == "" {
-			log.Printf("%s: no Email found in JWT claims", c.ClientIP())
+			logging.FromContext(c).Warn("no Email found in JWT claims")
 			c.SetCookie(COOKIE_NAME, "", -1, opts.BaseURL+"/", "", opts.DevProxy == "", true)
 			requireLogin(opts, c, "Something goes wrong with your session. Please reconnect.")
 			return
 		}
 
+		if claims.Profile.Impersonator != "" {
+			if claims.ExpiresAt == nil {
+				logging.FromContext(c).WithField("impersonator", claims.Profile.Impersonator).Warn("impersonation token without expiration")
+				requireLogin(opts, c, "Something goes wrong with your session. Please reconnect.")
+				return
+			}
+
+			c.Set("Impersonator", claims.Profile.Impersonator)
+			c.Header(IMPERSONATOR_HEADER, claims.Profile.Impersonator)
+		}
+
 		// Retrieve corresponding user
 		user, err := retrieveUserFromClaims(claims)
 		if err != nil {
-			log.Printf("%s %s", c.ClientIP(), err.Error())
+			logging.FromContext(c).WithError(err).Warn("unable to retrieve user from JWT claims")
 			c.SetCookie(COOKIE_NAME, "", -1, opts.BaseURL+"/", "", opts.DevProxy == "", true)
 			requireLogin(opts, c, "Something goes wrong with your session. Please reconnect.")
 			return
@@ -203,7 +229,7 @@ func authMiddleware(opts *config.Options, optional bool) gin.HandlerFunc {
 		session_id := append([]byte(claims.Profile.UserId), []byte(claims.ID)...)
 		session, err := retrieveSessionFromClaims(claims, user, session_id)
 		if err != nil {
-			log.Printf("%s %s", c.ClientIP(), err.Error())
+			logging.FromContext(c).WithError(err).Warn("unable to retrieve session from JWT claims")
 			c.SetCookie(COOKIE_NAME, "", -1, opts.BaseURL+"/", "", opts.DevProxy == "", true)
 			requireLogin(opts, c, "Your session has expired. Please reconnect.")
 			return
@@ -214,6 +240,10 @@ func authMiddleware(opts *config.Options, optional bool) gin.HandlerFunc {
 		// We are now ready to continue
 		c.Next()
 
+		if claims.Profile.Impersonator != "" {
+			auditImpersonation(c)
+		}
+
 		// On return, check if the session has changed
 		if session.HasChanged() {
 			storage.MainStore.UpdateSession(session)
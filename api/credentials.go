@@ -0,0 +1,229 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/storage"
+	"git.happydns.org/happydomain/utils"
+)
+
+// credentialScopes lists the scopes a User can request.
+var credentialScopes = map[happydns.CredentialScope]bool{
+	happydns.CredentialDynDNS: true,
+	happydns.CredentialACME:   true,
+}
+
+func declareCredentialsRoutes(cfg *config.Options, router *gin.RouterGroup) {
+	router.GET("/credentials", getDomainCredentials)
+	router.POST("/credentials", forbidImpersonation, addDomainCredential)
+
+	apiCredentialsRoutes := router.Group("/credentials/:cid")
+	apiCredentialsRoutes.Use(credentialHandler)
+
+	apiCredentialsRoutes.DELETE("", deleteDomainCredential)
+}
+
+// apiCredential is the Credential as shown to its owner. The secret is only
+// given at creation.
+type apiCredential struct {
+	Id         happydns.Identifier            `json:"id"`
+	IdDomain   happydns.Identifier            `json:"id_domain"`
+	Scope      happydns.CredentialScope       `json:"scope"`
+	Subdomain  string                         `json:"subdomain"`
+	Hostname   string                         `json:"hostname"`
+	Comment    string                         `json:"comment,omitempty"`
+	CreatedAt  time.Time                      `json:"created_at"`
+	LastUsed   *time.Time                     `json:"last_used,omitempty"`
+	Challenges []happydns.CredentialChallenge `json:"challenges,omitempty"`
+	Secret     string                         `json:"secret,omitempty"`
+}
+
+type credentialForm struct {
+	Scope     happydns.CredentialScope `json:"scope"`
+	Subdomain string                   `json:"subdomain"`
+	Comment   string                   `json:"comment,omitempty"`
+}
+
+func newAPICredential(cred *happydns.Credential, domain *happydns.Domain) *apiCredential {
+	return &apiCredential{
+		Id:         cred.Id,
+		IdDomain:   cred.IdDomain,
+		Scope:      cred.Scope,
+		Subdomain:  cred.Subdomain,
+		Hostname:   credentialFQDN(cred, domain),
+		Comment:    cred.Comment,
+		CreatedAt:  cred.CreatedAt,
+		LastUsed:   cred.LastUsed,
+		Challenges: cred.Challenges,
+	}
+}
+
+// relativeSubdomain returns the given name relative to the domain, as
+// services are stored in zones: the apex is an empty string.
+func relativeSubdomain(name string, domain *happydns.Domain) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	dn := strings.ToLower(strings.TrimSuffix(domain.DomainName, "."))
+
+	if name == "@" || name == dn {
+		return ""
+	}
+	return strings.TrimSuffix(name, "."+dn)
+}
+
+// credentialFQDN returns the absolute name the Credential gives access to.
+func credentialFQDN(cred *happydns.Credential, domain *happydns.Domain) string {
+	return utils.DomainJoin(cred.Subdomain, domain.DomainName)
+}
+
+func getDomainCredentials(c *gin.Context) {
+	user := c.MustGet("LoggedUser").(*happydns.User)
+	domain := c.MustGet("domain").(*happydns.Domain)
+
+	credentials, err := storage.MainStore.GetCredentials(user)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to GetCredentials")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to retrieve your credentials now."})
+		return
+	}
+
+	ret := []*apiCredential{}
+	for _, cred := range credentials {
+		if cred.IdDomain.Equals(domain.Id) {
+			ret = append(ret, newAPICredential(cred, domain))
+		}
+	}
+
+	c.JSON(http.StatusOK, ret)
+}
+
+func addDomainCredential(c *gin.Context) {
+	user := c.MustGet("LoggedUser").(*happydns.User)
+	domain := c.MustGet("domain").(*happydns.Domain)
+
+	var form credentialForm
+	err := c.ShouldBindJSON(&form)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Info("sends invalid credential JSON")
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
+		return
+	}
+
+	if !credentialScopes[form.Scope] {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unknown credential scope %q.", form.Scope)})
+		return
+	}
+
+	subdomain := relativeSubdomain(form.Subdomain, domain)
+	if subdomain != "" {
+		if _, ok := dns.IsDomainName(subdomain); !ok {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("%q is not a valid subdomain of %s.", form.Subdomain, domain.DomainName)})
+			return
+		}
+	}
+
+	cred := &happydns.Credential{
+		IdUser:    user.Id,
+		IdDomain:  domain.Id,
+		Scope:     form.Scope,
+		Subdomain: subdomain,
+		Comment:   form.Comment,
+		CreatedAt: time.Now(),
+	}
+
+	secret, err := cred.GenerateSecret()
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to generate credential secret")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create your credential now."})
+		return
+	}
+
+	err = storage.MainStore.CreateCredential(cred)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to CreateCredential")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create your credential now."})
+		return
+	}
+
+	ret := newAPICredential(cred, domain)
+	ret.Secret = secret
+
+	c.JSON(http.StatusOK, ret)
+}
+
+func credentialHandler(c *gin.Context) {
+	domain := c.MustGet("domain").(*happydns.Domain)
+
+	cid, err := happydns.NewIdentifierFromString(c.Param("cid"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Invalid credential identifier: %s", err.Error())})
+		return
+	}
+
+	cred, err := storage.MainStore.GetCredential(cid)
+	if err != nil || !cred.IdDomain.Equals(domain.Id) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"errmsg": "Credential not found"})
+		return
+	}
+
+	c.Set("credential", cred)
+
+	c.Next()
+}
+
+func deleteDomainCredential(c *gin.Context) {
+	cred := c.MustGet("credential").(*happydns.Credential)
+
+	if err := withdrawACMEChallenges(logging.FromContext(c), cred); err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to withdraw ACME challenges")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": fmt.Sprintf("Unable to remove the published challenges: %s", err.Error())})
+		return
+	}
+
+	if err := storage.MainStore.DeleteCredential(cred); err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to DeleteCredential")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to delete your credential now."})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, true)
+}
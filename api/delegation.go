@@ -0,0 +1,525 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/services/abstract"
+	"git.happydns.org/happydomain/utils"
+)
+
+const (
+	delegationOK      = "ok"
+	delegationWarning = "warning"
+	delegationError   = "error"
+
+	delegationLame          = "lame"
+	delegationUnreachable   = "unreachable"
+	delegationIndeterminate = "indeterminate"
+
+	// delegationMaxParents bounds the number of parent servers asked for
+	// the referral.
+	delegationMaxParents = 4
+
+	// delegationMaxChecks bounds the number of delegations checked at
+	// once.
+	delegationMaxChecks = 16
+
+	// delegationMaxQueries bounds the number of queries made to check the
+	// name servers of a delegation, apart from the ones following the
+	// referrals to it.
+	delegationMaxQueries = 64
+)
+
+// delegationIssue is a problem found on a delegation.
+type delegationIssue struct {
+	// Severity is either warning or error.
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// delegationServer is the state of a delegated name server.
+type delegationServer struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+
+	// Status is one of ok, lame (answering but not authoritative),
+	// unreachable or indeterminate (not checked, as too many queries were
+	// made).
+	Status string `json:"status"`
+	Serial uint32 `json:"serial,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// delegationReport is the health of the delegation of a domain, as seen
+// from its parent zone and from its name servers.
+type delegationReport struct {
+	Domain string `json:"domain"`
+	Parent string `json:"parent,omitempty"`
+
+	// Status is ok, warning or error, after the worst issue.
+	Status string `json:"status"`
+
+	// ZoneNS are the name servers set in happyDomain.
+	ZoneNS []string `json:"zone_ns"`
+
+	// ParentNS are the name servers given in the referral of the parent
+	// zone, along with their glue.
+	ParentNS []string            `json:"parent_ns,omitempty"`
+	Glue     map[string][]string `json:"glue,omitempty"`
+
+	// ChildNS are the name servers served by the delegated zone.
+	ChildNS []string `json:"child_ns,omitempty"`
+
+	DS     []string    `json:"ds,omitempty"`
+	DNSKEY []dnssecKey `json:"dnskey,omitempty"`
+
+	Servers []delegationServer `json:"servers,omitempty"`
+	Issues  []delegationIssue  `json:"issues"`
+}
+
+func (r *delegationReport) issue(severity string, format string, a ...interface{}) {
+	r.Issues = append(r.Issues, delegationIssue{Severity: severity, Message: fmt.Sprintf(format, a...)})
+
+	if severity == delegationError || r.Status == delegationOK {
+		r.Status = severity
+	}
+}
+
+// nameSet returns the sorted, lowercased and deduplicated names.
+func nameSet(names []string) (set []string) {
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.ToLower(dns.Fqdn(name))
+		if !seen[name] {
+			seen[name] = true
+			set = append(set, name)
+		}
+	}
+	sort.Strings(set)
+	return
+}
+
+// referral follows the referrals from the root down to the parent of name,
+// returning the parent zone and its servers.
+func (t *tracer) referral(name string) (string, []traceServer, error) {
+	zone := "."
+	servers := t.roots
+
+	for {
+		r, err := t.ask(zone, servers, name, dns.TypeNS, 0, false)
+		if err != nil {
+			return zone, nil, err
+		}
+
+		var cut string
+		var nss []dns.RR
+		if !r.Authoritative {
+			for _, rr := range r.Ns {
+				if rr.Header().Rrtype == dns.TypeNS {
+					cut = rr.Header().Name
+					nss = append(nss, rr)
+				}
+			}
+		}
+
+		if len(nss) == 0 {
+			if r.Rcode == dns.RcodeNameError {
+				return zone, nil, fmt.Errorf("%s doesn't exist in %s", name, zone)
+			}
+			return zone, nil, fmt.Errorf("%s is not delegated by %s", name, zone)
+		} else if !dns.IsSubDomain(zone, cut) || dns.CountLabel(cut) <= dns.CountLabel(zone) || !dns.IsSubDomain(cut, name) {
+			return zone, nil, fmt.Errorf("servers of %s give an invalid referral to %s", zone, cut)
+		} else if strings.EqualFold(cut, name) {
+			return zone, servers, nil
+		}
+
+		zone = cut
+		servers = traceServers(nss, r.Extra)
+	}
+}
+
+// checkParent asks the servers of the parent zone for the referral to the
+// domain and its DS.
+func (t *tracer) checkParent(report *delegationReport, servers []traceServer) (ds []*dns.DS) {
+	asked := 0
+	for _, server := range servers {
+		addrs, err := t.addresses(server, 1)
+		if err != nil {
+			report.issue(delegationWarning, "%s, server of %s: %s", server.name, report.Parent, err.Error())
+			continue
+		}
+
+		for _, addr := range addrs {
+			if asked >= delegationMaxParents {
+				return
+			}
+			asked++
+
+			r, _, err := t.query(addr, report.Domain, dns.TypeNS)
+			if err != nil {
+				report.issue(delegationWarning, "%s (%s), server of %s, doesn't answer: %s", server.name, addr, report.Parent, err.Error())
+				continue
+			}
+
+			var nss []string
+			glue := map[string][]string{}
+			for _, rr := range append(r.Answer, r.Ns...) {
+				if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, report.Domain) {
+					nss = append(nss, ns.Ns)
+				}
+			}
+			for _, rr := range r.Extra {
+				switch rr := rr.(type) {
+				case *dns.A:
+					glue[strings.ToLower(rr.Hdr.Name)] = append(glue[strings.ToLower(rr.Hdr.Name)], rr.A.String())
+				case *dns.AAAA:
+					glue[strings.ToLower(rr.Hdr.Name)] = append(glue[strings.ToLower(rr.Hdr.Name)], rr.AAAA.String())
+				}
+			}
+			nss = nameSet(nss)
+
+			if len(nss) == 0 {
+				report.issue(delegationError, "%s (%s), server of %s, gives no referral to %s", server.name, addr, report.Parent, report.Domain)
+				continue
+			}
+
+			if report.ParentNS == nil {
+				report.ParentNS = nss
+				report.Glue = glue
+
+				// DS are only asked to the first server
+				if r, _, err := t.query(addr, report.Domain, dns.TypeDS); err == nil {
+					for _, rr := range r.Answer {
+						if d, ok := rr.(*dns.DS); ok {
+							ds = append(ds, d)
+							report.DS = append(report.DS, strings.TrimPrefix(d.String(), d.Hdr.String()))
+						}
+					}
+				}
+			} else if !sameRecords(report.ParentNS, nss) {
+				report.issue(delegationWarning, "servers of %s don't agree on the name servers of %s: %s gives %s, while another one gives %s", report.Parent, report.Domain, server.name, strings.Join(nss, ", "), strings.Join(report.ParentNS, ", "))
+			}
+		}
+	}
+	return
+}
+
+// checkChild queries each name server of the domain, checking it is
+// authoritative and collecting its NS, DNSKEY and addresses of in-bailiwick
+// name servers.
+func (t *tracer) checkChild(report *delegationReport, nss []string) (keys []*dns.DNSKEY, addrs map[string][]string) {
+	var childNS []string
+	addrs = map[string][]string{}
+	serials := map[uint32]bool{}
+
+	for _, ns := range nss {
+		server := traceServer{name: ns}
+		if dns.IsSubDomain(report.Domain, ns) {
+			// In-bailiwick name servers need glue
+			if glue, ok := report.Glue[ns]; ok {
+				server.addrs = glue
+			} else if report.ParentNS != nil {
+				report.issue(delegationError, "missing glue for %s in %s", ns, report.Parent)
+			}
+		}
+
+		a, err := t.addresses(server, 1)
+		if errors.Is(err, errTraceBudget) {
+			report.Servers = append(report.Servers, delegationServer{Name: ns, Status: delegationIndeterminate, Error: err.Error()})
+			report.issue(delegationWarning, "unable to check %s: %s", ns, err.Error())
+			continue
+		} else if err != nil {
+			report.Servers = append(report.Servers, delegationServer{Name: ns, Status: delegationUnreachable, Error: err.Error()})
+			report.issue(delegationError, "lame delegation: %s", err.Error())
+			continue
+		}
+
+		for _, addr := range a {
+			s := delegationServer{Name: ns, Address: addr}
+
+			r, _, err := t.query(addr, report.Domain, dns.TypeSOA)
+			if errors.Is(err, errTraceBudget) {
+				s.Status = delegationIndeterminate
+				s.Error = err.Error()
+				report.issue(delegationWarning, "unable to check %s (%s): %s", ns, addr, err.Error())
+				report.Servers = append(report.Servers, s)
+				continue
+			} else if err != nil {
+				s.Status = delegationUnreachable
+				s.Error = err.Error()
+				report.issue(delegationError, "lame delegation: %s (%s) doesn't answer", ns, addr)
+				report.Servers = append(report.Servers, s)
+				continue
+			}
+
+			var soa *dns.SOA
+			for _, rr := range r.Answer {
+				if rr, ok := rr.(*dns.SOA); ok && strings.EqualFold(rr.Hdr.Name, report.Domain) {
+					soa = rr
+				}
+			}
+			if r.Rcode != dns.RcodeSuccess || !r.Authoritative || soa == nil {
+				s.Status = delegationLame
+				s.Error = fmt.Sprintf("not authoritative (%s)", dns.RcodeToString[r.Rcode])
+				report.issue(delegationError, "lame delegation: %s (%s) is not authoritative for %s", ns, addr, report.Domain)
+				report.Servers = append(report.Servers, s)
+				continue
+			}
+
+			s.Status = delegationOK
+			s.Serial = soa.Serial
+			serials[soa.Serial] = true
+			report.Servers = append(report.Servers, s)
+
+			// The content of the zone is taken from the first
+			// authoritative server
+			if report.ChildNS != nil {
+				continue
+			}
+
+			if r, _, err := t.query(addr, report.Domain, dns.TypeNS); err == nil {
+				for _, rr := range r.Answer {
+					if ns, ok := rr.(*dns.NS); ok {
+						childNS = append(childNS, ns.Ns)
+					}
+				}
+				report.ChildNS = nameSet(childNS)
+			}
+
+			if r, _, err := t.query(addr, report.Domain, dns.TypeDNSKEY); err == nil {
+				for _, rr := range r.Answer {
+					if key, ok := rr.(*dns.DNSKEY); ok {
+						keys = append(keys, key)
+						report.DNSKEY = append(report.DNSKEY, dnssecKey{
+							KeyTag:    key.KeyTag(),
+							Algorithm: dns.AlgorithmToString[key.Algorithm],
+							Flags:     key.Flags,
+						})
+					}
+				}
+			}
+
+			for glued := range report.Glue {
+				for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+					if r, _, err := t.query(addr, glued, rrtype); err == nil {
+						for _, rr := range r.Answer {
+							switch rr := rr.(type) {
+							case *dns.A:
+								addrs[glued] = append(addrs[glued], rr.A.String())
+							case *dns.AAAA:
+								addrs[glued] = append(addrs[glued], rr.AAAA.String())
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if len(serials) > 1 {
+		var list []string
+		for serial := range serials {
+			list = append(list, fmt.Sprintf("%d", serial))
+		}
+		sort.Strings(list)
+		report.issue(delegationWarning, "name servers don't serve the same version of the zone (serials %s)", strings.Join(list, ", "))
+	}
+
+	return
+}
+
+// checkDelegation checks the delegation of domain, expected to be served by
+// zoneNS with the zoneDS.
+func checkDelegation(opts *config.Options, domain string, zoneNS []string, zoneDS []string) *delegationReport {
+	t := newTracer(opts.RootHints, func(ip net.IP) bool {
+		return resolverAllowed(opts, ip)
+	})
+
+	return t.checkDelegation(domain, zoneNS, zoneDS)
+}
+
+func (t *tracer) checkDelegation(domain string, zoneNS []string, zoneDS []string) *delegationReport {
+	report := &delegationReport{
+		Domain: strings.ToLower(dns.Fqdn(domain)),
+		Status: delegationOK,
+		ZoneNS: nameSet(zoneNS),
+		Issues: []delegationIssue{},
+	}
+
+	parent, servers, err := t.referral(report.Domain)
+	report.Parent = parent
+	if err != nil {
+		report.issue(delegationError, "unable to find the referral of the parent zone: %s", err.Error())
+		return report
+	}
+
+	ds := t.checkParent(report, servers)
+	if report.ParentNS == nil {
+		report.issue(delegationError, "no server of %s gives the referral to %s", report.Parent, report.Domain)
+		return report
+	}
+
+	// The name servers are checked with their own budget, whatever the
+	// referral took
+	t.queries, t.maxQueries = 0, delegationMaxQueries
+
+	keys, addrs := t.checkChild(report, nameSet(append(append([]string{}, report.ParentNS...), report.ZoneNS...)))
+
+	// NS sets
+	if report.ChildNS != nil && !sameRecords(report.ParentNS, report.ChildNS) {
+		report.issue(delegationError, "name servers differ between the parent (%s) and the zone (%s)", strings.Join(report.ParentNS, ", "), strings.Join(report.ChildNS, ", "))
+	}
+	if !sameRecords(report.ZoneNS, report.ParentNS) {
+		report.issue(delegationWarning, "the name servers set in happyDomain (%s) are not the ones of the parent zone (%s)", strings.Join(report.ZoneNS, ", "), strings.Join(report.ParentNS, ", "))
+	}
+
+	// Glue
+	for _, ns := range report.ParentNS {
+		glue, ok := report.Glue[ns]
+		if !ok || !dns.IsSubDomain(report.Domain, ns) {
+			continue
+		}
+		served, ok := addrs[ns]
+		if !ok {
+			continue
+		}
+		sort.Strings(glue)
+		sort.Strings(served)
+		if !sameRecords(glue, served) {
+			report.issue(delegationError, "the glue of %s in %s (%s) differs from its addresses in the zone (%s)", ns, report.Parent, strings.Join(glue, ", "), strings.Join(served, ", "))
+		}
+	}
+
+	// DS
+	if zoneDS != nil {
+		sort.Strings(zoneDS)
+		parentDS := append([]string{}, report.DS...)
+		sort.Strings(parentDS)
+		if !sameRecords(zoneDS, parentDS) {
+			report.issue(delegationWarning, "the DS set in happyDomain are not the ones of the parent zone")
+		}
+	}
+	if len(ds) > 0 && len(keys) == 0 && report.ChildNS != nil {
+		report.issue(delegationError, "the parent zone has DS records, but the zone has no DNSKEY")
+	}
+	for _, d := range ds {
+		var match bool
+		for _, key := range keys {
+			if key.KeyTag() == d.KeyTag && key.Algorithm == d.Algorithm {
+				if k := key.ToDS(d.DigestType); k != nil && strings.EqualFold(k.Digest, d.Digest) {
+					match = true
+					break
+				}
+			}
+		}
+		if !match && len(keys) > 0 {
+			report.issue(delegationError, "DS %d (%s) doesn't match any DNSKEY of the zone", d.KeyTag, dns.AlgorithmToString[d.Algorithm])
+		}
+	}
+
+	return report
+}
+
+// getZoneDelegations checks the delegation of the domain, according to the
+// Origin service, and the sub-delegations of the zone.
+func getZoneDelegations(opts *config.Options, c *gin.Context) {
+	domain := c.MustGet("domain").(*happydns.Domain)
+	zone := c.MustGet("zone").(*happydns.Zone)
+
+	type delegation struct {
+		domain string
+		ns     []string
+		ds     []string
+	}
+
+	delegations := []delegation{{
+		domain: domain.DomainName,
+		ns:     zoneNameServers(zone, domain.DomainName),
+	}}
+
+	var subdomains []string
+	for subdomain := range zone.Services {
+		subdomains = append(subdomains, subdomain)
+	}
+	sort.Strings(subdomains)
+
+	for _, subdomain := range subdomains {
+		for _, svc := range zone.Services[subdomain] {
+			if d, ok := svc.Service.(*abstract.Delegation); ok && subdomain != "" {
+				dn := utils.DomainJoin(subdomain, domain.DomainName)
+
+				deleg := delegation{domain: dn, ds: []string{}}
+				for _, ns := range d.NameServers {
+					deleg.ns = append(deleg.ns, utils.DomainFQDN(ns, domain.DomainName))
+				}
+				for _, rr := range d.GenRRs(dn, 0, domain.DomainName) {
+					if ds, ok := rr.(*dns.DS); ok {
+						deleg.ds = append(deleg.ds, strings.TrimPrefix(ds.String(), ds.Hdr.String()))
+					}
+				}
+
+				delegations = append(delegations, deleg)
+			}
+		}
+	}
+
+	if len(delegations) > delegationMaxChecks {
+		delegations = delegations[:delegationMaxChecks]
+	}
+
+	reports := make([]*delegationReport, len(delegations))
+
+	var wg sync.WaitGroup
+	for i, d := range delegations {
+		wg.Add(1)
+		go func(i int, d delegation) {
+			defer wg.Done()
+			reports[i] = checkDelegation(opts, d.domain, d.ns, d.ds)
+		}(i, d)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, reports)
+}
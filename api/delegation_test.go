@@ -0,0 +1,226 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// newTestDelegations starts a fake hierarchy: a root server on 127.0.0.1,
+// the com. server on 127.0.0.2, answering DS queries, an authoritative server
+// of the delegated domains on 127.0.0.3, and a server authoritative for none
+// of them on 127.0.0.4.
+func newTestDelegations(t *testing.T) *tracer {
+	key := newTestZoneKey(t, "ok.com.")
+	other := newTestZoneKey(t, "badds.com.")
+	badKey := newTestZoneKey(t, "badds.com.")
+
+	root := newFakeServer(t, []string{"."},
+		"com. 86400 IN NS ns.nic.com.",
+		"ns.nic.com. 86400 IN A 127.0.0.2",
+	)
+
+	tld := newFakeServer(t, []string{"com."},
+		"ok.com. 3600 IN NS ns1.ok.com.",
+		"ns1.ok.com. 3600 IN A 127.0.0.3",
+		key.key.ToDS(dns.SHA256).String(),
+		"lame.com. 3600 IN NS ns.lame.com.",
+		"ns.lame.com. 3600 IN A 127.0.0.4",
+		"noglue.com. 3600 IN NS ns.noglue.com.",
+		"badglue.com. 3600 IN NS ns.badglue.com.",
+		"ns.badglue.com. 3600 IN A 127.0.0.3",
+		"nsdiff.com. 3600 IN NS ns1.ok.com.",
+		"badds.com. 3600 IN NS ns1.ok.com.",
+		other.key.ToDS(dns.SHA256).String(),
+		"nokey.com. 3600 IN NS ns1.ok.com.",
+		strings.Replace(key.key.ToDS(dns.SHA256).String(), "ok.com.", "nokey.com.", 1),
+	)
+
+	// DS are served by the parent side of the zone cut
+	tldMux := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if q := r.Question[0]; q.Qtype == dns.TypeDS {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Authoritative = true
+			for _, rr := range tld.rrs {
+				if rr.Header().Rrtype == dns.TypeDS && strings.EqualFold(rr.Header().Name, q.Name) {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+			w.WriteMsg(m)
+			return
+		}
+		tld.ServeDNS(w, r)
+	})
+
+	soa := func(zone string) string {
+		return zone + " 3600 IN SOA ns1.ok.com. hostmaster.ok.com. 1 3600 600 86400 300"
+	}
+
+	auth := newFakeServer(t, []string{"ok.com.", "badglue.com.", "nsdiff.com.", "badds.com.", "nokey.com."},
+		soa("ok.com."),
+		"ok.com. 3600 IN NS ns1.ok.com.",
+		"ns1.ok.com. 3600 IN A 127.0.0.3",
+		key.key.String(),
+		soa("badglue.com."),
+		"badglue.com. 3600 IN NS ns.badglue.com.",
+		"ns.badglue.com. 3600 IN A 127.0.0.9",
+		soa("nsdiff.com."),
+		"nsdiff.com. 3600 IN NS ns1.ok.com.",
+		"nsdiff.com. 3600 IN NS ns2.ok.com.",
+		soa("badds.com."),
+		"badds.com. 3600 IN NS ns1.ok.com.",
+		badKey.key.String(),
+		soa("nokey.com."),
+		"nokey.com. 3600 IN NS ns1.ok.com.",
+	)
+
+	port := startFakeServers(t, map[string]dns.Handler{
+		"127.0.0.1": root,
+		"127.0.0.2": tldMux,
+		"127.0.0.3": auth,
+		"127.0.0.4": newFakeServer(t, []string{"example.org."}),
+	})
+
+	var hints []dns.RR
+	for _, record := range []string{". 3600000 IN NS a.root.test.", "a.root.test. 3600000 IN A 127.0.0.1"} {
+		rr, _ := dns.NewRR(record)
+		hints = append(hints, rr)
+	}
+
+	tr := newTracer(hints, func(ip net.IP) bool {
+		return ip.IsLoopback()
+	})
+	tr.port = port
+
+	return tr
+}
+
+// hasIssue tells whether the report has an issue of the given severity
+// containing msg.
+func hasIssue(report *delegationReport, severity, msg string) bool {
+	for _, issue := range report.Issues {
+		if issue.Severity == severity && strings.Contains(issue.Message, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckDelegation(t *testing.T) {
+	for _, tc := range []struct {
+		domain   string
+		zoneNS   []string
+		status   string
+		severity string
+		issue    string
+	}{
+		{domain: "ok.com.", zoneNS: []string{"ns1.ok.com."}, status: delegationOK},
+		{domain: "ok.com.", zoneNS: []string{"ns.example.net."}, status: delegationError, severity: delegationWarning, issue: "the name servers set in happyDomain (ns.example.net.) are not the ones of the parent zone (ns1.ok.com.)"},
+		{domain: "lame.com.", zoneNS: []string{"ns.lame.com."}, status: delegationError, severity: delegationError, issue: "lame delegation: ns.lame.com. (127.0.0.4) is not authoritative"},
+		{domain: "noglue.com.", zoneNS: []string{"ns.noglue.com."}, status: delegationError, severity: delegationError, issue: "missing glue for ns.noglue.com. in com."},
+		{domain: "badglue.com.", zoneNS: []string{"ns.badglue.com."}, status: delegationError, severity: delegationError, issue: "the glue of ns.badglue.com. in com. (127.0.0.3) differs from its addresses in the zone (127.0.0.9)"},
+		{domain: "nsdiff.com.", zoneNS: []string{"ns1.ok.com."}, status: delegationError, severity: delegationError, issue: "name servers differ between the parent (ns1.ok.com.) and the zone (ns1.ok.com., ns2.ok.com.)"},
+		{domain: "badds.com.", zoneNS: []string{"ns1.ok.com."}, status: delegationError, severity: delegationError, issue: "doesn't match any DNSKEY of the zone"},
+		{domain: "nokey.com.", zoneNS: []string{"ns1.ok.com."}, status: delegationError, severity: delegationError, issue: "the parent zone has DS records, but the zone has no DNSKEY"},
+		{domain: "missing.com.", zoneNS: []string{"ns1.ok.com."}, status: delegationError, severity: delegationError, issue: "unable to find the referral of the parent zone"},
+	} {
+		t.Run(tc.domain+" "+strings.Join(tc.zoneNS, ","), func(t *testing.T) {
+			report := newTestDelegations(t).checkDelegation(tc.domain, tc.zoneNS, nil)
+
+			if report.Status != tc.status {
+				t.Errorf("status is %s, expected %s: %+v", report.Status, tc.status, report.Issues)
+			}
+			if tc.issue != "" && !hasIssue(report, tc.severity, tc.issue) {
+				t.Errorf("no %s issue %q in %+v", tc.severity, tc.issue, report.Issues)
+			}
+		})
+	}
+
+	report := newTestDelegations(t).checkDelegation("ok.com.", []string{"ns1.ok.com."}, nil)
+	if len(report.ParentNS) != 1 || len(report.ChildNS) != 1 || len(report.Glue["ns1.ok.com."]) != 1 {
+		t.Errorf("unexpected NS sets: parent %v (glue %v), child %v", report.ParentNS, report.Glue, report.ChildNS)
+	}
+	if len(report.DS) != 1 || len(report.DNSKEY) != 1 {
+		t.Errorf("got %d DS and %d DNSKEY, expected one of each", len(report.DS), len(report.DNSKEY))
+	}
+	if len(report.Servers) != 1 || report.Servers[0].Status != delegationOK || report.Servers[0].Serial != 1 {
+		t.Errorf("unexpected servers %+v", report.Servers)
+	}
+
+	report = newTestDelegations(t).checkDelegation("lame.com.", []string{"ns.lame.com."}, nil)
+	if len(report.Servers) != 1 || report.Servers[0].Status != delegationLame {
+		t.Errorf("lame server reported as %+v", report.Servers)
+	}
+}
+
+func TestCheckChildBudget(t *testing.T) {
+	tr := newTestDelegations(t)
+	tr.maxQueries = 1
+
+	report := &delegationReport{
+		Domain: "ok.com.",
+		Status: delegationOK,
+		Glue:   map[string][]string{"ns1.ok.com.": {"127.0.0.3"}},
+		Issues: []delegationIssue{},
+	}
+	tr.checkChild(report, []string{"ns1.ok.com.", "ns2.ok.com."})
+
+	if len(report.Servers) != 2 {
+		t.Fatalf("got servers %+v, expected 2", report.Servers)
+	}
+	if report.Servers[0].Status != delegationOK {
+		t.Errorf("first server is %s, expected ok", report.Servers[0].Status)
+	}
+
+	// Out of queries, the other server is not said lame
+	if report.Servers[1].Status != delegationIndeterminate {
+		t.Errorf("server checked out of budget is %s, expected indeterminate", report.Servers[1].Status)
+	}
+	if hasIssue(report, delegationError, "lame") || report.Status != delegationWarning {
+		t.Errorf("budget exhaustion reported as %s: %+v", report.Status, report.Issues)
+	}
+
+	// The check of the name servers does not share the budget of the
+	// referral, which takes all the queries allowed here
+	tr = newTestDelegations(t)
+	tr.maxQueries = 4
+
+	report = tr.checkDelegation("ok.com.", []string{"ns1.ok.com."}, nil)
+	if report.Status != delegationOK || len(report.Servers) != 1 || report.Servers[0].Status != delegationOK {
+		t.Errorf("name servers checked after the referral are %+v (%+v)", report.Servers, report.Issues)
+	}
+}
@@ -33,20 +33,25 @@ package api
 
 import (
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/miekg/dns"
 
 	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
 	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/storage"
+	"git.happydns.org/happydomain/utils"
 )
 
 func declareDomainsRoutes(cfg *config.Options, router *gin.RouterGroup) {
 	router.GET("/domains", GetDomains)
-	router.POST("/domains", addDomain)
+	router.POST("/domains", func(c *gin.Context) {
+		addDomain(cfg, c)
+	})
+	router.GET("/reverse_mismatches", getReverseMismatches)
 
 	apiDomainsRoutes := router.Group("/domains/:domain")
 	apiDomainsRoutes.Use(DomainHandler)
@@ -55,6 +60,7 @@ func declareDomainsRoutes(cfg *config.Options, router *gin.RouterGroup) {
 	apiDomainsRoutes.PUT("", UpdateDomain)
 	apiDomainsRoutes.DELETE("", delDomain)
 
+	declareCredentialsRoutes(cfg, apiDomainsRoutes)
 	declareZonesRoutes(cfg, apiDomainsRoutes)
 }
 
@@ -66,7 +72,7 @@ func GetDomains(c *gin.Context) {
 	}
 
 	if domains, err := storage.MainStore.GetDomains(user); err != nil {
-		log.Printf("%s: An error occurs when trying to GetDomains: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to GetDomains")
 		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"errmsg": err})
 	} else if len(domains) > 0 {
 		c.JSON(http.StatusOK, domains)
@@ -75,15 +81,24 @@ func GetDomains(c *gin.Context) {
 	}
 }
 
-func addDomain(c *gin.Context) {
+func addDomain(opts *config.Options, c *gin.Context) {
 	var uz happydns.Domain
 	err := c.ShouldBindJSON(&uz)
 	if err != nil {
-		log.Printf("%s sends invalid Domain JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid Domain JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
 
+	// A network can be given to manage its reverse zone
+	if _, network, err := net.ParseCIDR(uz.DomainName); err == nil {
+		uz.DomainName, err = utils.ReverseZone(network)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
+			return
+		}
+	}
+
 	if len(uz.DomainName) <= 2 {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": "The given domain is invalid."})
 		return
@@ -98,6 +113,18 @@ func addDomain(c *gin.Context) {
 
 	user := c.MustGet("LoggedUser").(*happydns.User)
 
+	if limit := userQuota(opts, user).MaxDomains; limit > 0 {
+		domains, err := storage.MainStore.GetDomains(user)
+		if err != nil {
+			logging.FromContext(c).WithError(err).Error("unable to GetDomains")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create your domain now."})
+			return
+		} else if happydns.Reached(limit, len(domains)) {
+			quotaExceeded(c, limit, "domains")
+			return
+		}
+	}
+
 	provider, err := storage.MainStore.GetProvider(user, uz.IdProvider)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unable to find the provider.")})
@@ -111,13 +138,16 @@ func addDomain(c *gin.Context) {
 	} else if err := provider.DomainExists(uz.DomainName); err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
 		return
-	} else if err := storage.MainStore.CreateDomain(user, &uz); err != nil {
-		log.Printf("%s was unable to CreateDomain: %s", c.ClientIP(), err.Error())
+	}
+
+	uz.Impersonator = impersonator(c)
+	if err := storage.MainStore.CreateDomain(user, &uz); err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to CreateDomain")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create your domain now."})
 		return
-	} else {
-		c.JSON(http.StatusOK, uz)
 	}
+
+	c.JSON(http.StatusOK, uz)
 }
 
 func DomainHandler(c *gin.Context) {
@@ -158,30 +188,32 @@ func DomainHandler(c *gin.Context) {
 }
 
 type apiDomain struct {
-	Id          happydns.Identifier `json:"id"`
-	IdUser      happydns.Identifier `json:"id_owner"`
-	IdProvider  happydns.Identifier `json:"id_provider"`
-	DomainName  string              `json:"domain"`
-	ZoneHistory []happydns.ZoneMeta `json:"zone_history"`
-	Group       string              `json:"group,omitempty"`
+	Id           happydns.Identifier `json:"id"`
+	IdUser       happydns.Identifier `json:"id_owner"`
+	IdProvider   happydns.Identifier `json:"id_provider"`
+	DomainName   string              `json:"domain"`
+	ZoneHistory  []happydns.ZoneMeta `json:"zone_history"`
+	Group        string              `json:"group,omitempty"`
+	SerialPolicy string              `json:"serial_policy,omitempty"`
 }
 
 func GetDomain(c *gin.Context) {
 	domain := c.MustGet("domain").(*happydns.Domain)
 	ret := &apiDomain{
-		Id:          domain.Id,
-		IdUser:      domain.IdUser,
-		IdProvider:  domain.IdProvider,
-		DomainName:  domain.DomainName,
-		ZoneHistory: []happydns.ZoneMeta{},
-		Group:       domain.Group,
+		Id:           domain.Id,
+		IdUser:       domain.IdUser,
+		IdProvider:   domain.IdProvider,
+		DomainName:   domain.DomainName,
+		ZoneHistory:  []happydns.ZoneMeta{},
+		Group:        domain.Group,
+		SerialPolicy: domain.SerialPolicy,
 	}
 
 	for _, zm := range domain.ZoneHistory {
 		zoneMeta, err := storage.MainStore.GetZoneMeta(zm)
 
 		if err != nil {
-			log.Println("%s: An error occurs in getDomain, when retrieving a meta history: %s", c.ClientIP(), err.Error())
+			logging.FromContext(c).WithError(err).WithField("zone_id", zm.String()).Error("unable to retrieve a zone meta from history")
 		} else {
 			ret.ZoneHistory = append(ret.ZoneHistory, *zoneMeta)
 		}
@@ -205,11 +237,17 @@ func UpdateDomain(c *gin.Context) {
 		return
 	}
 
+	if err = happydns.CheckSerialPolicy(domain.SerialPolicy); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
+		return
+	}
+
 	old.Group = domain.Group
+	old.SerialPolicy = domain.SerialPolicy
 
 	err = storage.MainStore.UpdateDomain(old)
 	if err != nil {
-		log.Printf("%s: Unable to UpdateDomain in UpdateDomain: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to UpdateDomain in UpdateDomain")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your domain. Please retry later."})
 		return
 	}
@@ -219,7 +257,7 @@ func UpdateDomain(c *gin.Context) {
 
 func delDomain(c *gin.Context) {
 	if err := storage.MainStore.DeleteDomain(c.MustGet("domain").(*happydns.Domain)); err != nil {
-		log.Printf("%s was unable to DeleteDomain: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to DeleteDomain")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": fmt.Sprintf("Unable to delete your domain: %s", err.Error())})
 		return
 	}
@@ -0,0 +1,404 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+	"golang.org/x/time/rate"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/services"
+	"git.happydns.org/happydomain/services/abstract"
+	"git.happydns.org/happydomain/storage"
+)
+
+const (
+	// dyndnsUpdateInterval is the mean delay between two updates allowed
+	// for a Credential.
+	dyndnsUpdateInterval = 2 * time.Minute
+
+	// dyndnsUpdateBurst is the number of updates a Credential can make
+	// in a row.
+	dyndnsUpdateBurst = 3
+
+	// authFailureInterval is the mean delay between two authentication
+	// failures allowed for a client IP or a login.
+	authFailureInterval = time.Minute
+
+	// authFailureBurst is the number of authentication failures allowed
+	// in a row for a client IP or a login.
+	authFailureBurst = 5
+
+	// limitersPruneSize is the number of limiters above which the idle
+	// ones are forgotten.
+	limitersPruneSize = 1024
+)
+
+// keyedLimiters holds a rate limiter per key.
+type keyedLimiters struct {
+	mu       sync.Mutex
+	every    time.Duration
+	burst    int
+	limiters map[string]*rate.Limiter
+}
+
+func newKeyedLimiters(every time.Duration, burst int) *keyedLimiters {
+	return &keyedLimiters{
+		every:    every,
+		burst:    burst,
+		limiters: map[string]*rate.Limiter{},
+	}
+}
+
+func (l *keyedLimiters) get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, ok := l.limiters[key]
+	if !ok {
+		// A limiter whose bucket is full behaves like a new one
+		if len(l.limiters) >= limitersPruneSize {
+			for k, lim := range l.limiters {
+				if lim.Tokens() >= float64(l.burst) {
+					delete(l.limiters, k)
+				}
+			}
+		}
+
+		limiter = rate.NewLimiter(rate.Every(l.every), l.burst)
+		l.limiters[key] = limiter
+	}
+
+	return limiter
+}
+
+// allow consumes an event for key and tells whether it was allowed.
+func (l *keyedLimiters) allow(key string) bool {
+	return l.get(key).Allow()
+}
+
+// exhausted tells whether the next event for key would be refused,
+// without consuming it.
+func (l *keyedLimiters) exhausted(key string) bool {
+	return l.get(key).Tokens() < 1
+}
+
+var (
+	// errDynDNSAbuse is returned when a Credential is updated too often.
+	errDynDNSAbuse = errors.New("too many updates, try again later")
+
+	// errDynDNSPublish is returned when the provider refused the update.
+	errDynDNSPublish = errors.New("unable to publish")
+
+	// errAuthThrottled is returned when a client IP or a login failed to
+	// authenticate too many times.
+	errAuthThrottled = errors.New("too many authentication failures, try again later")
+)
+
+var (
+	dyndnsRateLimit = newKeyedLimiters(dyndnsUpdateInterval, dyndnsUpdateBurst)
+
+	// authFailures throttles, by client IP and by login, the clients
+	// failing to authenticate with a Credential, so secrets cannot be
+	// brute forced.
+	authFailures = newKeyedLimiters(authFailureInterval, authFailureBurst)
+)
+
+// declareDynDNSRoutes exposes the dyndns2 protocol spoken by home routers
+// and ddclient. Clients authenticate with a Credential identifier as login
+// and its secret as password.
+func declareDynDNSRoutes(cfg *config.Options, router *gin.Engine) {
+	router.GET("/nic/update", func(c *gin.Context) {
+		dyndnsUpdate(cfg, c)
+	})
+}
+
+// dyndnsAnswer responds with one of the dyndns2 return codes.
+func dyndnsAnswer(c *gin.Context, code string, args ...string) {
+	c.String(http.StatusOK, strings.Join(append([]string{code}, args...), " "))
+}
+
+func dyndnsUpdate(opts *config.Options, c *gin.Context) {
+	login, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		c.Header("WWW-Authenticate", `Basic realm="happyDomain"`)
+		c.String(http.StatusUnauthorized, "badauth")
+		return
+	}
+
+	cred, err := authenticateCredential(c.ClientIP(), login, secret, happydns.CredentialDynDNS)
+	if errors.Is(err, errAuthThrottled) {
+		logging.FromContext(c).WithField("credential", login).Warn("dyndns authentication throttled")
+		dyndnsAnswer(c, "abuse")
+		return
+	} else if err != nil {
+		logging.FromContext(c).WithError(err).WithField("credential", login).Info("dyndns authentication failed")
+		c.Header("WWW-Authenticate", `Basic realm="happyDomain"`)
+		c.String(http.StatusUnauthorized, "badauth")
+		return
+	}
+
+	user, err := storage.MainStore.GetUser(cred.IdUser)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Warn("dyndns credential of an unknown user")
+		dyndnsAnswer(c, "badauth")
+		return
+	}
+	c.Set("LoggedUser", user)
+
+	domain, err := storage.MainStore.GetDomain(user, cred.IdDomain)
+	if err != nil {
+		dyndnsAnswer(c, "nohost")
+		return
+	}
+	c.Set("domain", domain)
+
+	hostname := c.Query("hostname")
+	if hostname == "" {
+		dyndnsAnswer(c, "notfqdn")
+		return
+	}
+	for _, h := range strings.Split(hostname, ",") {
+		if !strings.HasSuffix(dns.Fqdn(strings.ToLower(h)), strings.ToLower(domain.DomainName)) || relativeSubdomain(h, domain) != cred.Subdomain {
+			dyndnsAnswer(c, "nohost")
+			return
+		}
+	}
+
+	// Without myip, the client's address is used
+	myip := c.Query("myip")
+	if myip == "" {
+		myip = c.ClientIP()
+	}
+
+	var ipv4, ipv6 net.IP
+	for _, s := range strings.Split(myip, ",") {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil {
+			dyndnsAnswer(c, "dnserr")
+			return
+		} else if ip4 := ip.To4(); ip4 != nil {
+			ipv4 = ip4
+		} else {
+			ipv6 = ip
+		}
+	}
+
+	// Each update rewrites the whole zone being edited
+	unlock := lockDomain(domain)
+	defer unlock()
+
+	changed, err := updateServerAddresses(opts, c, cred, user, domain, ipv4, ipv6)
+	if errors.Is(err, errDynDNSAbuse) {
+		logging.FromContext(c).WithField("credential", cred.Id.String()).Warn("dyndns rate limit reached")
+		dyndnsAnswer(c, "abuse")
+		return
+	} else if errors.Is(err, errDynDNSPublish) {
+		logging.FromContext(c).WithError(err).Warn("unable to publish dyndns update")
+		dyndnsAnswer(c, "dnserr")
+		return
+	} else if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to perform dyndns update")
+		dyndnsAnswer(c, "911")
+		return
+	}
+
+	now := time.Now()
+	cred.LastUsed = &now
+	if err = storage.MainStore.UpdateCredential(cred); err != nil {
+		logging.FromContext(c).WithError(err).Warn("unable to UpdateCredential")
+	}
+
+	if changed {
+		dyndnsAnswer(c, "good", myip)
+	} else {
+		dyndnsAnswer(c, "nochg", myip)
+	}
+}
+
+// authenticateCredential retrieves the Credential designated by login and
+// checks its secret and scope. Failures are counted against the client IP and
+// the login: once either made too many of them, errAuthThrottled is returned
+// without checking the secret.
+func authenticateCredential(clientIP, login, secret string, scope happydns.CredentialScope) (*happydns.Credential, error) {
+	ipKey, loginKey := "ip:"+clientIP, "login:"+login
+	if authFailures.exhausted(ipKey) || authFailures.exhausted(loginKey) {
+		return nil, errAuthThrottled
+	}
+
+	cred, err := checkCredential(login, secret, scope)
+	if err != nil {
+		authFailures.allow(ipKey)
+		authFailures.allow(loginKey)
+		return nil, err
+	}
+
+	return cred, nil
+}
+
+func checkCredential(login, secret string, scope happydns.CredentialScope) (*happydns.Credential, error) {
+	cid, err := happydns.NewIdentifierFromString(login)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential identifier: %w", err)
+	}
+
+	cred, err := storage.MainStore.GetCredential(cid)
+	if err != nil {
+		return nil, fmt.Errorf("credential not found: %w", err)
+	}
+
+	if !cred.CheckSecret(secret) {
+		return nil, fmt.Errorf("bad secret")
+	}
+
+	if cred.Scope != scope {
+		return nil, fmt.Errorf("credential scope is %q, expected %q", cred.Scope, scope)
+	}
+
+	return cred, nil
+}
+
+// updateServerAddresses changes the addresses of the Server service at the
+// Credential's subdomain, in the zone being edited, and publishes only these
+// records. It creates the service if needed. Nothing is done when the
+// addresses are already the expected ones.
+func updateServerAddresses(opts *config.Options, c *gin.Context, cred *happydns.Credential, user *happydns.User, domain *happydns.Domain, ipv4, ipv6 net.IP) (bool, error) {
+	if len(domain.ZoneHistory) == 0 {
+		return false, fmt.Errorf("no zone imported for %s", domain.DomainName)
+	}
+
+	zone, err := storage.MainStore.GetZone(domain.ZoneHistory[0])
+	if err != nil {
+		return false, fmt.Errorf("unable to retrieve zone: %w", err)
+	}
+
+	var svc *happydns.ServiceCombined
+	for _, s := range zone.Services[cred.Subdomain] {
+		if s.Type == "abstract.Server" {
+			svc = s
+			break
+		}
+	}
+
+	isNew := svc == nil
+	if isNew {
+		if limit := userQuota(opts, user).MaxServicesPerZone; happydns.Reached(limit, countServices(zone)) {
+			return false, fmt.Errorf("quota of %d services reached", limit)
+		}
+
+		s, err := svcs.FindService("abstract.Server")
+		if err != nil {
+			return false, err
+		}
+
+		svc = &happydns.ServiceCombined{
+			Service: s,
+			ServiceMeta: happydns.ServiceMeta{
+				Type:    "abstract.Server",
+				OwnerId: user.Id,
+				Domain:  cred.Subdomain,
+			},
+		}
+	}
+
+	server, ok := svc.Service.(*abstract.Server)
+	if !ok {
+		return false, fmt.Errorf("unexpected service type %T", svc.Service)
+	}
+
+	var rrtypes []uint16
+	if ipv4 != nil && (server.A == nil || !server.A.Equal(ipv4)) {
+		server.A = &ipv4
+		rrtypes = append(rrtypes, dns.TypeA)
+	}
+	if ipv6 != nil && (server.AAAA == nil || !server.AAAA.Equal(ipv6)) {
+		server.AAAA = &ipv6
+		rrtypes = append(rrtypes, dns.TypeAAAA)
+	}
+
+	if len(rrtypes) == 0 {
+		return false, nil
+	}
+
+	if !dyndnsRateLimit.allow(cred.Id.String()) {
+		return false, errDynDNSAbuse
+	}
+
+	if isNew {
+		err = zone.AppendService(cred.Subdomain, domain.DomainName, svc)
+	} else {
+		err = zone.EraseService(cred.Subdomain, domain.DomainName, svc.Id, svc)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	name := dns.Fqdn(credentialFQDN(cred, domain))
+	ttl := svc.Ttl
+	if ttl == 0 {
+		ttl = zone.DefaultTTL
+	}
+
+	var rrs []dns.RR
+	for _, rr := range svc.GenRRs(name, ttl, domain.DomainName) {
+		if hasRRType(rrtypes, rr.Header().Rrtype) {
+			rrs = append(rrs, rr)
+		}
+	}
+
+	n, err := publishRRsets(logging.FromContext(c), user, domain, name, rrtypes, rrs)
+	if err != nil {
+		return false, fmt.Errorf("%w: %s", errDynDNSPublish, err)
+	}
+
+	// The zone is only saved once published, so that a failed update is
+	// retried by the next request instead of being considered unchanged.
+	zone.LastModified = time.Now()
+	if err = storage.MainStore.UpdateZone(zone); err != nil {
+		return false, fmt.Errorf("unable to UpdateZone: %w", err)
+	}
+
+	logging.FromContext(c).WithField("hostname", name).WithField("corrections", n).Info("dyndns update published")
+
+	return true, nil
+}
@@ -0,0 +1,239 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/providers"
+	"git.happydns.org/happydomain/services/abstract"
+	"git.happydns.org/happydomain/storage"
+	database "git.happydns.org/happydomain/storage/memory"
+)
+
+type dyndnsTest struct {
+	t      *testing.T
+	router *gin.Engine
+	domain *happydns.Domain
+}
+
+// newDyndnsTest stores a domain whose zone has a Server at home, and whose
+// provider cannot be reached.
+func newDyndnsTest(t *testing.T) *dyndnsTest {
+	storage.MainStore = database.NewMemoryStorage()
+	authFailures = newKeyedLimiters(authFailureInterval, authFailureBurst)
+	dyndnsRateLimit = newKeyedLimiters(dyndnsUpdateInterval, dyndnsUpdateBurst)
+
+	user := &happydns.User{Email: "user@example.com"}
+	if err := storage.MainStore.CreateUser(user); err != nil {
+		t.Fatalf("unable to create the user: %s", err.Error())
+	}
+
+	// Nothing listens on port 1, so publications fail
+	src, err := storage.MainStore.CreateProvider(user, &providers.DDNSServer{Server: "127.0.0.1:1"}, "unreachable")
+	if err != nil {
+		t.Fatalf("unable to create the provider: %s", err.Error())
+	}
+
+	domain := newTestDomain(t, user, "example.com.")
+	domain.IdProvider = src.Id
+	if err := storage.MainStore.UpdateDomain(domain); err != nil {
+		t.Fatalf("unable to update the domain: %s", err.Error())
+	}
+
+	zone, _ := storage.MainStore.GetZone(domain.ZoneHistory[0])
+	ip := net.ParseIP("192.0.2.1")
+	if err := zone.AppendService("home", domain.DomainName, &happydns.ServiceCombined{
+		Service:     &abstract.Server{A: &ip},
+		ServiceMeta: happydns.ServiceMeta{Type: "abstract.Server", OwnerId: user.Id},
+	}); err != nil {
+		t.Fatalf("unable to add the server: %s", err.Error())
+	}
+	if err := storage.MainStore.UpdateZone(zone); err != nil {
+		t.Fatalf("unable to save the zone: %s", err.Error())
+	}
+
+	router := gin.New()
+	declareDynDNSRoutes(&config.Options{}, router)
+
+	return &dyndnsTest{t: t, router: router, domain: domain}
+}
+
+// newCredential stores a Credential for home and returns its login and
+// secret.
+func (dt *dyndnsTest) newCredential(scope happydns.CredentialScope) (string, string) {
+	cred := &happydns.Credential{
+		IdUser:    dt.domain.IdUser,
+		IdDomain:  dt.domain.Id,
+		Scope:     scope,
+		Subdomain: "home",
+	}
+	secret, err := cred.GenerateSecret()
+	if err != nil {
+		dt.t.Fatalf("GenerateSecret: %s", err.Error())
+	}
+	if err := storage.MainStore.CreateCredential(cred); err != nil {
+		dt.t.Fatalf("unable to create the credential: %s", err.Error())
+	}
+	return cred.Id.String(), secret
+}
+
+// update sends a dyndns2 request from clientIP and returns the status code
+// and the answer.
+func (dt *dyndnsTest) update(clientIP, login, secret string) (int, string) {
+	req := httptest.NewRequest("GET", "/nic/update?hostname=home.example.com&myip=198.51.100.7", nil)
+	req.RemoteAddr = clientIP + ":1234"
+	req.SetBasicAuth(login, secret)
+
+	w := httptest.NewRecorder()
+	dt.router.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func (dt *dyndnsTest) checkAddress(expected string) {
+	zone, err := storage.MainStore.GetZone(dt.domain.ZoneHistory[0])
+	if err != nil {
+		dt.t.Fatalf("unable to retrieve the zone: %s", err.Error())
+	}
+
+	if len(zone.Services["home"]) != 1 || countServices(zone) != 1 {
+		dt.t.Fatalf("got services %v, expected only the server at home", zone.Services)
+	}
+	server, ok := zone.Services["home"][0].Service.(*abstract.Server)
+	if !ok || server.A == nil || server.A.String() != expected {
+		dt.t.Errorf("got server %+v, expected the address %s", zone.Services["home"][0].Service, expected)
+	}
+}
+
+func TestDyndnsAuthentication(t *testing.T) {
+	dt := newDyndnsTest(t)
+	login, secret := dt.newCredential(happydns.CredentialDynDNS)
+	acmeLogin, acmeSecret := dt.newCredential(happydns.CredentialACME)
+
+	for _, tc := range []struct {
+		name, login, secret string
+	}{
+		{"wrong secret", login, secret + "x"},
+		{"empty secret", login, ""},
+		{"secret of another credential", login, acmeSecret},
+		{"unknown login", "AAAA", secret},
+		{"invalid login", "!", secret},
+		{"ACME credential", acmeLogin, acmeSecret},
+	} {
+		if code, body := dt.update("203.0.113.1", tc.login, tc.secret); code != http.StatusUnauthorized || body != "badauth" {
+			t.Errorf("%s: got %d %q, expected 401 badauth", tc.name, code, body)
+		}
+
+		// Each attempt comes from another address and is within the
+		// login's allowance
+		authFailures = newKeyedLimiters(authFailureInterval, authFailureBurst)
+	}
+
+	req := httptest.NewRequest("GET", "/nic/update?hostname=home.example.com", nil)
+	w := httptest.NewRecorder()
+	dt.router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("got %d without credentials, expected 401 with a challenge", w.Code)
+	}
+
+	dt.checkAddress("192.0.2.1")
+}
+
+func TestDyndnsLockout(t *testing.T) {
+	dt := newDyndnsTest(t)
+	login, secret := dt.newCredential(happydns.CredentialDynDNS)
+	other, otherSecret := dt.newCredential(happydns.CredentialDynDNS)
+
+	// Failures for the same login from several addresses
+	for i := 0; i < authFailureBurst; i++ {
+		ip := net.IPv4(203, 0, 113, byte(i+1)).String()
+		if code, body := dt.update(ip, login, "wrong"); code != http.StatusUnauthorized || body != "badauth" {
+			t.Fatalf("failure %d: got %d %q, expected 401 badauth", i+1, code, body)
+		}
+	}
+
+	// Even the right secret is refused now, from any address
+	if _, body := dt.update("203.0.113.100", login, secret); body != "abuse" {
+		t.Errorf("after %d failures for the login, got %q, expected abuse", authFailureBurst, body)
+	}
+
+	// Failures from the same address with several logins
+	authFailures = newKeyedLimiters(authFailureInterval, authFailureBurst)
+	for i := 0; i < authFailureBurst; i++ {
+		unknown := happydns.Identifier{byte(i)}
+		if code, body := dt.update("198.51.100.1", unknown.String(), "wrong"); code != http.StatusUnauthorized || body != "badauth" {
+			t.Fatalf("failure %d: got %d %q, expected 401 badauth", i+1, code, body)
+		}
+	}
+	if _, body := dt.update("198.51.100.1", login, secret); body != "abuse" {
+		t.Errorf("after %d failures from the address, got %q, expected abuse", authFailureBurst, body)
+	}
+
+	// Other addresses and logins are not affected: the request goes up to
+	// the publication
+	if code, body := dt.update("198.51.100.2", other, otherSecret); code != http.StatusOK || body != "dnserr" {
+		t.Errorf("another address got %d %q, expected to be authenticated", code, body)
+	}
+
+	dt.checkAddress("192.0.2.1")
+}
+
+func TestDyndnsFailedPublish(t *testing.T) {
+	dt := newDyndnsTest(t)
+	login, secret := dt.newCredential(happydns.CredentialDynDNS)
+
+	if code, body := dt.update("203.0.113.1", login, secret); code != http.StatusOK || body != "dnserr" {
+		t.Errorf("got %d %q, expected dnserr", code, body)
+	}
+
+	// The zone keeps the published address, so the next request retries
+	dt.checkAddress("192.0.2.1")
+
+	// Successful authentications are not counted as failures, although
+	// failed publications count against the update rate
+	for i := 0; i < authFailureBurst; i++ {
+		if _, body := dt.update("203.0.113.1", login, secret); body != "dnserr" && body != "abuse" {
+			t.Fatalf("request %d: got %q, expected dnserr or abuse", i+2, body)
+		}
+	}
+	if authFailures.exhausted("ip:203.0.113.1") || authFailures.exhausted("login:"+login) {
+		t.Errorf("successful authentications are counted as failures")
+	}
+	dt.checkAddress("192.0.2.1")
+}
@@ -0,0 +1,126 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/model"
+)
+
+const (
+	// IMPERSONATOR_HEADER is set on every response to a request made
+	// while impersonating a user.
+	IMPERSONATOR_HEADER = "X-Happydomain-Impersonator"
+
+	// IMPERSONATOR_KEY flags, in the Session content, the sessions
+	// created by an impersonation token.
+	IMPERSONATOR_KEY = "impersonator"
+
+	// ImpersonationMaxDuration is the longest lifetime of an
+	// impersonation token.
+	ImpersonationMaxDuration = time.Hour
+)
+
+// NewImpersonationToken issues a short-lived token allowing impersonator to
+// act as the given user. Every request made with this token is logged and
+// attributed to the impersonator.
+func NewImpersonationToken(opts *config.Options, user *happydns.User, impersonator string, duration time.Duration) (string, *UserClaims, error) {
+	if impersonator == "" {
+		return "", nil, fmt.Errorf("the impersonator name is required")
+	}
+	if duration <= 0 || duration > ImpersonationMaxDuration {
+		return "", nil, fmt.Errorf("the impersonation duration should be between 0 and %s", ImpersonationMaxDuration)
+	}
+
+	claims, err := newUserClaims(UserProfile{
+		UserId:        user.Id,
+		Email:         user.Email,
+		EmailVerified: true,
+		CreatedAt:     user.CreatedAt,
+		Impersonator:  impersonator,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	claims.ExpiresAt = jwt.NewNumericDate(claims.IssuedAt.Add(duration))
+
+	token, err := signUserClaims(opts, claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, claims, nil
+}
+
+// impersonator returns the name of the administrator acting as the logged
+// user, or an empty string.
+func impersonator(c *gin.Context) string {
+	return c.GetString("Impersonator")
+}
+
+// auditImpersonation records a request made while impersonating a user.
+func auditImpersonation(c *gin.Context) {
+	logging.FromContext(c).WithFields(log.Fields{
+		"method": c.Request.Method,
+		"path":   c.Request.URL.Path,
+		"status": c.Writer.Status(),
+	}).Warn("request made while impersonating a user")
+}
+
+// recordImpersonation marks the zone as modified by the administrator
+// impersonating its owner, so the change remains attributed once the
+// impersonation token has expired.
+func recordImpersonation(c *gin.Context, zone *happydns.ZoneMeta) {
+	if imp := impersonator(c); imp != "" {
+		zone.Impersonator = imp
+	}
+}
+
+// forbidImpersonation stops requests that cannot be made on behalf of a user.
+func forbidImpersonation(c *gin.Context) {
+	if impersonator(c) != "" {
+		logging.FromContext(c).Warn("forbidden request while impersonating a user")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"errmsg": "This action cannot be performed while impersonating a user."})
+		return
+	}
+
+	c.Next()
+}
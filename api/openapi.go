@@ -0,0 +1,403 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/providers"
+	"git.happydns.org/happydomain/services"
+)
+
+// openAPIRoute documents a route declared on the router: the request body it
+// expects and the response it returns on success. A nil Response means that
+// nothing meaningful is returned.
+type openAPIRoute struct {
+	Summary     string
+	Tag         string
+	Auth        bool
+	Request     interface{}
+	Response    interface{}
+	ContentType string
+}
+
+// errorResponse is the shape of every error returned by the API.
+type errorResponse struct {
+	Errmsg string `json:"errmsg"`
+}
+
+// messageResponse is returned by some routes to display a message to the
+// user, without any error.
+type messageResponse struct {
+	Errmsg string `json:"errmsg"`
+}
+
+type versionResponse struct {
+	Version float64 `json:"version"`
+}
+
+type subdomainResponse struct {
+	Services []*happydns.ServiceCombined `json:"services"`
+}
+
+type analyzeResponse struct {
+	Services   map[string][]*happydns.ServiceCombined `json:"services"`
+	DefaultTTL uint32                                 `json:"defaultTTL"`
+}
+
+const zonePath = "/api/domains/:domain/zone/:zoneid"
+
+// openAPIRoutes lists, by "METHOD path" as declared in gin, the documentation
+// of each route.
+var openAPIRoutes = map[string]openAPIRoute{
+	"GET /api/version":      {Summary: "Get the API version", Tag: "misc", Response: versionResponse{}},
+	"GET /api/openapi.json": {Summary: "Get this OpenAPI specification", Tag: "misc", Response: map[string]interface{}{}},
+	"POST /api/resolver":    {Summary: "Resolve a domain name", Tag: "misc", Request: resolverRequest{}, Response: dns.Msg{}},
+	"GET /api/resolvers":    {Summary: "List the resolvers proposed in the resolver tool", Tag: "misc", Response: []happydns.Resolver{}},
+
+	"POST /api/acme/present": {Summary: "Publish an ACME challenge (lego httpreq, Basic authentication with a credential)", Tag: "acme", Request: httpreqForm{}, Response: httpreqForm{}},
+	"POST /api/acme/cleanup": {Summary: "Remove an ACME challenge (lego httpreq, Basic authentication with a credential)", Tag: "acme", Request: httpreqForm{}, Response: httpreqForm{}},
+	"POST /api/acme/update":  {Summary: "Publish an ACME challenge (acme-dns, X-Api-User and X-Api-Key of a credential)", Tag: "acme", Request: acmeDNSForm{}, Response: acmeDNSForm{}},
+
+	"GET /api/auth":         {Summary: "Get the logged user", Tag: "auth", Response: DisplayUser{}},
+	"POST /api/auth":        {Summary: "Log in", Tag: "auth", Request: loginForm{}, Response: DisplayUser{}},
+	"POST /api/auth/logout": {Summary: "Log out", Tag: "auth"},
+
+	"POST /api/users":                   {Summary: "Register a new user", Tag: "users", Request: UploadedUser{}, Response: happydns.UserAuth{}},
+	"PATCH /api/users":                  {Summary: "Send again the validation or recovery link", Tag: "users", Request: UploadedUser{}, Response: messageResponse{}},
+	"POST /api/users/:uid/email":        {Summary: "Validate an email address", Tag: "users", Request: UploadedAddressValidation{}},
+	"POST /api/users/:uid/recovery":     {Summary: "Recover an account", Tag: "users", Request: UploadedAccountRecovery{}},
+	"GET /api/users/:uid":               {Summary: "Get a user", Tag: "users", Auth: true, Response: happydns.User{}},
+	"GET /api/users/:uid/settings":      {Summary: "Get the user settings", Tag: "users", Auth: true, Response: happydns.UserSettings{}},
+	"GET /api/users/:uid/quota":         {Summary: "Get the user limits and current usage", Tag: "users", Auth: true, Response: QuotaReport{}},
+	"POST /api/users/:uid/settings":     {Summary: "Change the user settings", Tag: "users", Auth: true, Request: happydns.UserSettings{}, Response: happydns.UserSettings{}},
+	"POST /api/users/:uid/delete":       {Summary: "Delete the account", Tag: "users", Auth: true, Request: passwordForm{}},
+	"POST /api/users/:uid/new_password": {Summary: "Change the password", Tag: "users", Auth: true, Request: passwordForm{}},
+	"GET /api/session":                  {Summary: "Get the current session", Tag: "users", Auth: true, Response: happydns.Session{}},
+	"DELETE /api/session":               {Summary: "Clear the current session", Tag: "users", Auth: true, Response: true},
+
+	"GET /api/providers/_specs":                 {Summary: "List the available providers", Tag: "providers", Response: map[string]providers.ProviderInfos{}},
+	"GET /api/providers/_specs/:psid":           {Summary: "Get the specification of a provider", Tag: "providers", Response: viewProviderSpec{}},
+	"GET /api/providers/_specs/:psid/icon.png":  {Summary: "Get the icon of a provider", Tag: "providers", ContentType: "image/png"},
+	"POST /api/providers/_specs/:ssid/settings": {Summary: "Go through the provider settings form", Tag: "providers", Auth: true, Request: ProviderSettingsState{}, Response: ProviderSettingsResponse{}},
+	"GET /api/providers":                        {Summary: "List the user's providers", Tag: "providers", Auth: true, Response: []happydns.ProviderMeta{}},
+	"POST /api/providers":                       {Summary: "Add a provider", Tag: "providers", Auth: true, Request: happydns.ProviderCombined{}, Response: happydns.ProviderCombined{}},
+	"GET /api/providers/:pid":                   {Summary: "Get a provider", Tag: "providers", Auth: true, Response: happydns.ProviderCombined{}},
+	"PUT /api/providers/:pid":                   {Summary: "Update a provider", Tag: "providers", Auth: true, Request: happydns.ProviderCombined{}, Response: happydns.ProviderCombined{}},
+	"DELETE /api/providers/:pid":                {Summary: "Delete a provider", Tag: "providers", Auth: true},
+	"GET /api/providers/:pid/domains":           {Summary: "List the domains hosted by a provider", Tag: "providers", Auth: true, Response: []string{}},
+
+	"GET /api/service_specs":                {Summary: "List the available services", Tag: "services", Response: map[string]svcs.ServiceInfos{}},
+	"GET /api/service_specs/:ssid":          {Summary: "Get the specification of a service", Tag: "services", Response: viewServiceSpec{}},
+	"GET /api/service_specs/:ssid/icon.png": {Summary: "Get the icon of a service", Tag: "services", ContentType: "image/png"},
+
+	"GET /api/domains":                                       {Summary: "List the user's domains", Tag: "domains", Auth: true, Response: happydns.Domains{}},
+	"POST /api/domains":                                      {Summary: "Add a domain", Tag: "domains", Auth: true, Request: happydns.Domain{}, Response: happydns.Domain{}},
+	"GET /api/domains/:domain":                               {Summary: "Get a domain and its zone history", Tag: "domains", Auth: true, Response: apiDomain{}},
+	"PUT /api/domains/:domain":                               {Summary: "Update a domain", Tag: "domains", Auth: true, Request: apiDomain{}, Response: happydns.Domain{}},
+	"DELETE /api/domains/:domain":                            {Summary: "Delete a domain", Tag: "domains", Auth: true},
+	"GET /api/domains/:domain/credentials":                   {Summary: "List the credentials of a domain", Tag: "domains", Auth: true, Response: []apiCredential{}},
+	"POST /api/domains/:domain/credentials":                  {Summary: "Create a scoped credential", Tag: "domains", Auth: true, Request: credentialForm{}, Response: apiCredential{}},
+	"GET /api/reverse_mismatches":                            {Summary: "List the mismatches between the addresses of the user's domains and the PTR records of their reverse zones", Tag: "domains", Auth: true, Response: []reverseMismatch{}},
+	"DELETE /api/domains/:domain/credentials/:cid":           {Summary: "Revoke a credential", Tag: "domains", Auth: true},
+	"POST /api/domains/:domain/import_zone":                  {Summary: "Import the zone from the provider", Tag: "zones", Auth: true, Response: happydns.ZoneMeta{}},
+	"POST /api/domains/:domain/diff_zones/:zoneid1/:zoneid2": {Summary: "List the corrections to apply", Tag: "zones", Auth: true, Response: []string{}},
+
+	"GET " + zonePath:                                             {Summary: "Get a zone", Tag: "zones", Auth: true, Response: happydns.Zone{}},
+	"PATCH " + zonePath:                                           {Summary: "Update a service of the zone", Tag: "zones", Auth: true, Request: happydns.ServiceCombined{}, Response: happydns.Zone{}},
+	"POST " + zonePath + "/view":                                  {Summary: "Get the zone in zone file format", Tag: "zones", Auth: true, Response: ""},
+	"POST " + zonePath + "/apply_changes":                         {Summary: "Apply the given corrections", Tag: "zones", Auth: true, Request: []string{}, Response: happydns.ZoneMeta{}},
+	"GET " + zonePath + "/delegations":                            {Summary: "Check the delegation of the domain and of its sub-delegations", Tag: "zones", Auth: true, Response: []delegationReport{}},
+	"GET " + zonePath + "/propagation":                            {Summary: "Check that the zone is served by each name server and resolver (wait up to ?wait= seconds for convergence)", Tag: "zones", Auth: true, Response: propagationReport{}},
+	"POST " + zonePath + "/ttl_plan":                              {Summary: "Lower the TTLs of the given services (or all of them) ahead of a migration", Tag: "zones", Auth: true, Request: ttlPlanRequest{}, Response: happydns.Zone{}},
+	"DELETE " + zonePath + "/ttl_plan":                            {Summary: "Restore the TTLs lowered ahead of a migration", Tag: "zones", Auth: true, Response: happydns.Zone{}},
+	"GET " + zonePath + "/:subdomain":                             {Summary: "List the services of a subdomain", Tag: "zones", Auth: true, Response: subdomainResponse{}},
+	"POST " + zonePath + "/:subdomain/services":                   {Summary: "Add a service", Tag: "zones", Auth: true, Request: happydns.ServiceCombined{}, Response: happydns.Zone{}},
+	"POST " + zonePath + "/:subdomain/services/*psid":             {Summary: "Go through the service settings form", Tag: "zones", Auth: true, Request: ServiceSettingsState{}, Response: ServiceSettingsResponse{}},
+	"GET " + zonePath + "/:subdomain/services/:serviceid":         {Summary: "Get a service", Tag: "zones", Auth: true, Response: happydns.ServiceCombined{}},
+	"DELETE " + zonePath + "/:subdomain/services/:serviceid":      {Summary: "Delete a service", Tag: "zones", Auth: true, Response: happydns.Zone{}},
+	"GET " + zonePath + "/:subdomain/services/:serviceid/records": {Summary: "Get the records generated by a service", Tag: "zones", Auth: true, Response: []serviceRecord{}},
+}
+
+// openAPISchema is a subset of the OpenAPI 3 Schema Object.
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *openAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties *openAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+// schemaGenerator builds schemas from Go types, following encoding/json
+// rules. Named structs are stored as components and referenced.
+type schemaGenerator struct {
+	components map[string]*openAPISchema
+}
+
+var (
+	identifierType = reflect.TypeOf(happydns.Identifier{})
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+func componentName(t reflect.Type) string {
+	return strings.NewReplacer("[", "_", "]", "_", "*", "", " ", "").Replace(t.String())
+}
+
+func (g *schemaGenerator) schemaOf(t reflect.Type) *openAPISchema {
+	switch t {
+	case identifierType:
+		return &openAPISchema{Type: "string", Format: "base64url", Description: "Identifier"}
+	case timeType:
+		return &openAPISchema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &openAPISchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schemaOf(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	case reflect.Bool:
+		return &openAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &openAPISchema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &openAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &openAPISchema{Type: "number"}
+	case reflect.String:
+		return &openAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &openAPISchema{Type: "string", Format: "byte"}
+		}
+		return &openAPISchema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &openAPISchema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Interface:
+		return &openAPISchema{Description: "Depends on the underlying " + t.Name()}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+
+		name := componentName(t)
+		if _, ok := g.components[name]; !ok {
+			// Reserve the name before walking fields, to handle recursive types
+			g.components[name] = &openAPISchema{}
+			*g.components[name] = *g.structSchema(t)
+		}
+		return &openAPISchema{Ref: "#/components/schemas/" + name}
+	}
+
+	return &openAPISchema{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) *openAPISchema {
+	s := &openAPISchema{Type: "object", Properties: map[string]*openAPISchema{}}
+	g.addFields(s, t)
+	return s
+}
+
+func (g *schemaGenerator) addFields(s *openAPISchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+
+		ft := field.Type
+		if field.Anonymous && name == "" {
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = g.schemaOf(field.Type)
+	}
+}
+
+var ginPathParam = regexp.MustCompile(`[:*]([^/]+)`)
+
+func buildOpenAPI(router *gin.Engine) map[string]interface{} {
+	g := &schemaGenerator{components: map[string]*openAPISchema{}}
+	errorSchema := g.schemaOf(reflect.TypeOf(errorResponse{}))
+
+	paths := map[string]map[string]interface{}{}
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+
+		doc := openAPIRoutes[route.Method+" "+route.Path]
+
+		var parameters []map[string]interface{}
+		for _, m := range ginPathParam.FindAllStringSubmatch(route.Path, -1) {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   openAPISchema{Type: "string"},
+			})
+		}
+
+		responses := map[string]interface{}{
+			"default": map[string]interface{}{
+				"description": "Error",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": errorSchema},
+				},
+			},
+		}
+		if doc.ContentType != "" {
+			responses["200"] = map[string]interface{}{
+				"description": "Success",
+				"content": map[string]interface{}{
+					doc.ContentType: map[string]interface{}{"schema": openAPISchema{Type: "string", Format: "binary"}},
+				},
+			}
+		} else if doc.Response != nil {
+			responses["200"] = map[string]interface{}{
+				"description": "Success",
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.schemaOf(reflect.TypeOf(doc.Response))},
+				},
+			}
+		} else {
+			responses["204"] = map[string]interface{}{"description": "Success"}
+		}
+
+		operation := map[string]interface{}{
+			"operationId": strings.ToLower(route.Method) + ginPathParam.ReplaceAllStringFunc(strings.NewReplacer("/", "_", ".", "_").Replace(strings.TrimPrefix(route.Path, "/api")), func(p string) string { return "by_" + p[1:] }),
+			"responses":   responses,
+		}
+		if doc.Summary != "" {
+			operation["summary"] = doc.Summary
+		}
+		if doc.Tag != "" {
+			operation["tags"] = []string{doc.Tag}
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if doc.Request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": g.schemaOf(reflect.TypeOf(doc.Request))},
+				},
+			}
+		}
+		if doc.Auth {
+			operation["security"] = []map[string][]string{{"bearerAuth": {}}, {"cookieAuth": {}}}
+		}
+
+		path := ginPathParam.ReplaceAllString(route.Path, "{$1}")
+		if _, ok := paths[path]; !ok {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	var tags []map[string]string
+	seenTags := map[string]bool{}
+	for _, doc := range openAPIRoutes {
+		if doc.Tag != "" && !seenTags[doc.Tag] {
+			seenTags[doc.Tag] = true
+			tags = append(tags, map[string]string{"name": doc.Tag})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i]["name"] < tags[j]["name"] })
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "happyDomain API",
+			"version": "0.1",
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.components,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"cookieAuth": map[string]string{"type": "apiKey", "in": "cookie", "name": COOKIE_NAME},
+			},
+		},
+	}
+}
+
+func declareOpenAPIRoutes(router *gin.Engine, apiRoutes *gin.RouterGroup) {
+	var once sync.Once
+	var doc map[string]interface{}
+
+	apiRoutes.GET("/openapi.json", func(c *gin.Context) {
+		// Routes are all declared at this stage
+		once.Do(func() {
+			doc = buildOpenAPI(router)
+		})
+
+		c.JSON(http.StatusOK, doc)
+	})
+}
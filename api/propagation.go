@@ -0,0 +1,534 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/storage"
+	"git.happydns.org/happydomain/utils"
+)
+
+const (
+	propagationMatching    = "matching"
+	propagationStale       = "stale"
+	propagationUnreachable = "unreachable"
+
+	// propagationMaxRRsets bounds the number of RRsets checked on each
+	// server.
+	propagationMaxRRsets = 32
+
+	// propagationTimeout bounds each query made to a server.
+	propagationTimeout = 2 * time.Second
+
+	// propagationInterval is the time between two checks, when waiting
+	// for convergence.
+	propagationInterval = 5 * time.Second
+
+	// propagationMaxWait bounds the time a request waits for convergence.
+	propagationMaxWait = 5 * time.Minute
+
+	// propagationMaxWaits is the number of requests each user can have
+	// waiting for convergence at the same time.
+	propagationMaxWaits = 2
+)
+
+// propagationRRset is a RRset expected to be served, empty when it has been
+// deleted.
+type propagationRRset struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Records []string `json:"records"`
+
+	rrtype uint16
+}
+
+// propagationCheck is the state of a RRset on a server.
+type propagationCheck struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Status  string   `json:"status"`
+	Records []string `json:"records,omitempty"`
+
+	// ExpiresIn is, for stale answers of resolvers, the remaining time in
+	// seconds before the cached answer expires.
+	ExpiresIn uint32 `json:"expires_in,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// propagationServer is the state of the zone on a name server or a
+// resolver.
+type propagationServer struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+
+	// Authoritative is true for the name servers of the zone, false for
+	// resolvers.
+	Authoritative bool `json:"authoritative"`
+
+	Status string             `json:"status"`
+	Serial uint32             `json:"serial,omitempty"`
+	Checks []propagationCheck `json:"checks,omitempty"`
+	Error  string             `json:"error,omitempty"`
+
+	// port is the port the server is queried on, 53 when empty.
+	port string
+}
+
+// propagationReport is the state of a zone revision on each server.
+type propagationReport struct {
+	// Expected are the RRsets checked on each server: the ones changed
+	// since the previous revision, or all of them for the first one.
+	Expected []propagationRRset `json:"expected"`
+
+	// Truncated is true when there were too many RRsets to check them
+	// all.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Converged is true when no server is stale anymore.
+	Converged bool `json:"converged"`
+
+	Servers   []propagationServer `json:"servers"`
+	CheckedAt time.Time           `json:"checked_at"`
+}
+
+// propagationRecord formats the data of rr, so that records can be
+// compared between servers. Domain names and hexadecimal are not case
+// sensitive.
+func propagationRecord(rr dns.RR) string {
+	s := strings.TrimPrefix(rr.String(), rr.Header().String())
+
+	switch rr.Header().Rrtype {
+	case dns.TypeNS, dns.TypeCNAME, dns.TypeDNAME, dns.TypePTR, dns.TypeMX, dns.TypeSRV, dns.TypeAAAA:
+		s = strings.ToLower(s)
+	}
+	return s
+}
+
+// zoneRRsets indexes the RRsets of the zone by name and type. The SOA is
+// left apart, as its serial is often managed by the provider.
+func zoneRRsets(zone *happydns.Zone, origin string) map[string]*propagationRRset {
+	ret := map[string]*propagationRRset{}
+
+	for _, rr := range zone.GenerateRRs(origin) {
+		if rr.Header().Rrtype == dns.TypeSOA {
+			continue
+		}
+
+		name := strings.ToLower(dns.Fqdn(rr.Header().Name))
+		key := name + "/" + dns.TypeToString[rr.Header().Rrtype]
+		if _, ok := ret[key]; !ok {
+			ret[key] = &propagationRRset{
+				Name:   name,
+				Type:   dns.TypeToString[rr.Header().Rrtype],
+				rrtype: rr.Header().Rrtype,
+			}
+		}
+		ret[key].Records = append(ret[key].Records, propagationRecord(rr))
+	}
+
+	for _, rrset := range ret {
+		sort.Strings(rrset.Records)
+	}
+
+	return ret
+}
+
+// sameRecords compares two sorted lists of records.
+func sameRecords(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// changedRRsets lists the RRsets of zone that differ from previous. All the
+// RRsets are returned when previous is nil.
+func changedRRsets(zone, previous *happydns.Zone, origin string) (changed []propagationRRset) {
+	current := zoneRRsets(zone, origin)
+
+	var before map[string]*propagationRRset
+	if previous != nil {
+		before = zoneRRsets(previous, origin)
+	}
+
+	for key, rrset := range current {
+		if old, ok := before[key]; previous == nil || !ok || !sameRecords(old.Records, rrset.Records) {
+			changed = append(changed, *rrset)
+		}
+	}
+	for key, rrset := range before {
+		if _, ok := current[key]; !ok {
+			changed = append(changed, propagationRRset{Name: rrset.Name, Type: rrset.Type, Records: []string{}, rrtype: rrset.rrtype})
+		}
+	}
+
+	sort.Slice(changed, func(i, j int) bool {
+		if changed[i].Name == changed[j].Name {
+			return changed[i].Type < changed[j].Type
+		}
+		return canonicalLess(changed[i].Name, changed[j].Name)
+	})
+
+	return
+}
+
+// previousZone returns the revision published before zone, if any.
+func previousZone(domain *happydns.Domain, zone *happydns.Zone) *happydns.Zone {
+	for i, id := range domain.ZoneHistory {
+		if id.Equals(zone.Id) && i+1 < len(domain.ZoneHistory) {
+			if previous, err := storage.MainStore.GetZone(domain.ZoneHistory[i+1]); err == nil {
+				return previous
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// zoneNameServers lists the name servers of the Origin service of zone.
+func zoneNameServers(zone *happydns.Zone, origin string) (nss []string) {
+	if o := zoneOrigin(zone); o != nil {
+		for _, ns := range o.NameServers {
+			nss = append(nss, utils.DomainFQDN(ns, origin))
+		}
+	}
+	return
+}
+
+// propagationServers lists the servers to check: each address of the name
+// servers, then the resolvers of opts.PropagationResolvers. Name servers in
+// denied networks are not queried.
+func propagationServers(ctx context.Context, opts *config.Options, nss []string) (servers []propagationServer) {
+	for _, ns := range nss {
+		lctx, cancel := context.WithTimeout(ctx, propagationTimeout)
+		addrs, err := net.DefaultResolver.LookupIPAddr(lctx, strings.TrimSuffix(ns, "."))
+		cancel()
+
+		if err != nil || len(addrs) == 0 {
+			server := propagationServer{Name: ns, Authoritative: true, Status: propagationUnreachable, Error: "unable to find the address of the name server"}
+			if err != nil {
+				server.Error = err.Error()
+			}
+			servers = append(servers, server)
+			continue
+		}
+
+		for _, addr := range addrs {
+			if !resolverAllowed(opts, addr.IP) {
+				servers = append(servers, propagationServer{Name: ns, Authoritative: true, Status: propagationUnreachable, Error: fmt.Sprintf("%s is not allowed to be queried", addr.IP.String())})
+				continue
+			}
+			servers = append(servers, propagationServer{Name: ns, Address: addr.IP.String(), Authoritative: true})
+		}
+	}
+
+	for _, address := range opts.PropagationResolvers {
+		name := address
+		if resolver := findResolver(opts.Resolvers, address); resolver != nil {
+			name = resolver.Name
+		}
+		servers = append(servers, propagationServer{Name: name, Address: address})
+	}
+
+	return
+}
+
+// checkServer queries server for the SOA of the zone and each expected
+// RRset.
+func checkServer(server *propagationServer, origin string, expected []propagationRRset) {
+	client := &dns.Client{Timeout: propagationTimeout}
+	port := server.port
+	if port == "" {
+		port = "53"
+	}
+	address := net.JoinHostPort(server.Address, port)
+
+	query := func(name string, rrtype uint16) (*dns.Msg, error) {
+		m := new(dns.Msg)
+		m.SetQuestion(name, rrtype)
+		m.RecursionDesired = !server.Authoritative
+		m.SetEdns0(4096, false)
+
+		r, _, err := client.Exchange(m, address)
+		if err == nil && r.Truncated {
+			r, _, err = (&dns.Client{Net: "tcp", Timeout: propagationTimeout}).Exchange(m, address)
+		}
+		if err != nil {
+			return nil, err
+		} else if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+			return nil, fmt.Errorf("server returns %s", dns.RcodeToString[r.Rcode])
+		}
+		return r, nil
+	}
+
+	// An unreachable server is not asked further
+	r, err := query(origin, dns.TypeSOA)
+	if err != nil {
+		server.Status = propagationUnreachable
+		server.Error = err.Error()
+		return
+	}
+	for _, rr := range r.Answer {
+		if soa, ok := rr.(*dns.SOA); ok {
+			server.Serial = soa.Serial
+		}
+	}
+
+	server.Status = propagationMatching
+	for _, rrset := range expected {
+		check := propagationCheck{Name: rrset.Name, Type: rrset.Type}
+
+		r, err := query(rrset.Name, rrset.rrtype)
+		if err != nil {
+			check.Status = propagationUnreachable
+			check.Error = err.Error()
+			if server.Status == propagationMatching {
+				server.Status = propagationUnreachable
+			}
+			server.Checks = append(server.Checks, check)
+			continue
+		}
+
+		// Delegations are given in the authority section
+		rrs := r.Answer
+		if rrset.rrtype == dns.TypeNS && server.Authoritative {
+			rrs = append(rrs, r.Ns...)
+		}
+
+		var expiresIn uint32
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == rrset.rrtype && strings.EqualFold(rr.Header().Name, rrset.Name) {
+				check.Records = append(check.Records, propagationRecord(rr))
+				if rr.Header().Ttl > expiresIn {
+					expiresIn = rr.Header().Ttl
+				}
+			}
+		}
+		sort.Strings(check.Records)
+
+		if len(check.Records) == 0 {
+			// Negative answers are cached for the SOA minimum
+			for _, rr := range r.Ns {
+				if soa, ok := rr.(*dns.SOA); ok {
+					expiresIn = soa.Hdr.Ttl
+					if soa.Minttl < expiresIn {
+						expiresIn = soa.Minttl
+					}
+				}
+			}
+		}
+
+		if sameRecords(check.Records, rrset.Records) {
+			check.Status = propagationMatching
+		} else {
+			check.Status = propagationStale
+			server.Status = propagationStale
+			if !server.Authoritative {
+				check.ExpiresIn = expiresIn
+			}
+		}
+
+		server.Checks = append(server.Checks, check)
+	}
+}
+
+// checkPropagation queries each server in parallel. Name servers with a
+// serial behind the other ones are stale, even if the checked RRsets match.
+func checkPropagation(servers []propagationServer, origin string, expected []propagationRRset) (converged bool) {
+	var wg sync.WaitGroup
+	for i := range servers {
+		if servers[i].Address == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(server *propagationServer) {
+			defer wg.Done()
+			checkServer(server, origin, expected)
+		}(&servers[i])
+	}
+	wg.Wait()
+
+	var serial uint32
+	var hasSerial bool
+	for _, server := range servers {
+		if server.Authoritative && server.Serial != 0 && (!hasSerial || int32(server.Serial-serial) > 0) {
+			serial = server.Serial
+			hasSerial = true
+		}
+	}
+
+	converged = true
+	for i := range servers {
+		if servers[i].Authoritative && servers[i].Status == propagationMatching && int32(servers[i].Serial-serial) < 0 {
+			servers[i].Status = propagationStale
+			servers[i].Error = fmt.Sprintf("serial %d is behind %d", servers[i].Serial, serial)
+		}
+		if servers[i].Status == propagationStale {
+			converged = false
+		}
+	}
+
+	return
+}
+
+// propagationCost is the number of queries made by a check of the servers.
+func propagationCost(servers []propagationServer, expected []propagationRRset) (cost int) {
+	for _, server := range servers {
+		if server.Address != "" {
+			cost += 1 + len(expected)
+		}
+	}
+	return
+}
+
+// propagationWaits counts the requests of each user waiting for
+// convergence.
+type propagationWaits struct {
+	mu      sync.Mutex
+	waiting map[string]int
+}
+
+// acquire registers a waiting request of user, unless they already have
+// propagationMaxWaits ones.
+func (w *propagationWaits) acquire(user string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.waiting[user] >= propagationMaxWaits {
+		return false
+	}
+	w.waiting[user]++
+	return true
+}
+
+func (w *propagationWaits) release(user string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.waiting[user]--; w.waiting[user] <= 0 {
+		delete(w.waiting, user)
+	}
+}
+
+var propagationWaiting = &propagationWaits{waiting: map[string]int{}}
+
+func getZonePropagation(opts *config.Options, c *gin.Context) {
+	domain := c.MustGet("domain").(*happydns.Domain)
+	zone := c.MustGet("zone").(*happydns.Zone)
+
+	var wait time.Duration
+	if w := c.Query("wait"); w != "" {
+		seconds, err := strconv.ParseUint(w, 10, 32)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Invalid wait duration: %q", w)})
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+		if wait > propagationMaxWait {
+			wait = propagationMaxWait
+		}
+	}
+
+	nss := zoneNameServers(zone, domain.DomainName)
+	if len(nss) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": "The zone has no name server to check."})
+		return
+	}
+
+	if wait > 0 {
+		user := domain.IdUser.String()
+		if !propagationWaiting.acquire(user) {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"errmsg": fmt.Sprintf("You already have %d propagation checks waiting, please try again later.", propagationMaxWaits)})
+			return
+		}
+		defer propagationWaiting.release(user)
+	}
+
+	report := propagationReport{
+		Expected: changedRRsets(zone, previousZone(domain, zone), domain.DomainName),
+	}
+	if len(report.Expected) > propagationMaxRRsets {
+		report.Expected = report.Expected[:propagationMaxRRsets]
+		report.Truncated = true
+	}
+
+	ctx := c.Request.Context()
+	deadline := time.Now().Add(wait)
+	for {
+		servers := propagationServers(ctx, opts, nss)
+
+		// While waiting, checks are skipped when over the rate limit
+		if opts.ResolverRateLimit > 0 && !resolverRateLimit.allow(c.ClientIP(), propagationCost(servers, report.Expected), opts.ResolverRateLimit) {
+			if report.Servers == nil {
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"errmsg": "Too many queries, please try again later."})
+				return
+			}
+		} else {
+			report.Servers = servers
+			report.Converged = checkPropagation(report.Servers, domain.DomainName, report.Expected)
+			report.CheckedAt = time.Now()
+		}
+
+		if report.Converged || time.Now().Add(propagationInterval).After(deadline) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(propagationInterval):
+		}
+	}
+
+	c.JSON(http.StatusOK, report)
+}
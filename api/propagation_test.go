@@ -0,0 +1,236 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/model"
+)
+
+// expectedRRset builds the RRset expected to be served, from records.
+func expectedRRset(t *testing.T, name string, rrtype uint16, records ...string) propagationRRset {
+	rrset := propagationRRset{Name: name, Type: dns.TypeToString[rrtype], Records: []string{}, rrtype: rrtype}
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("invalid record %q: %s", record, err.Error())
+		}
+		rrset.Records = append(rrset.Records, propagationRecord(rr))
+	}
+	return rrset
+}
+
+// startPropagationServers serves each handler on its loopback address and
+// returns the servers to check, name servers first.
+func startPropagationServers(t *testing.T, nameServers, resolvers map[string]dns.Handler) []propagationServer {
+	handlers := map[string]dns.Handler{}
+	for addr, h := range nameServers {
+		handlers[addr] = h
+	}
+	for addr, h := range resolvers {
+		handlers[addr] = h
+	}
+	port := startFakeServers(t, handlers)
+
+	var servers []propagationServer
+	for addr := range nameServers {
+		servers = append(servers, propagationServer{Name: "ns." + addr, Address: addr, Authoritative: true, port: port})
+	}
+	for addr := range resolvers {
+		servers = append(servers, propagationServer{Name: "resolver." + addr, Address: addr, port: port})
+	}
+	return servers
+}
+
+func findServer(t *testing.T, servers []propagationServer, name string) *propagationServer {
+	for i := range servers {
+		if servers[i].Name == name {
+			return &servers[i]
+		}
+	}
+	t.Fatalf("server %s not found", name)
+	return nil
+}
+
+func TestCheckPropagation(t *testing.T) {
+	soa := func(serial string) string {
+		return "example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. " + serial + " 3600 600 86400 300"
+	}
+
+	upToDate := newFakeServer(t, []string{"example.com."}, soa("2"), "www.example.com. 300 IN A 192.0.2.2")
+	lagging := newFakeServer(t, []string{"example.com."}, soa("1"), "www.example.com. 300 IN A 192.0.2.2")
+	cached := newFakeServer(t, []string{"example.com."}, soa("2"),
+		"www.example.com. 120 IN A 192.0.2.1",
+		"old.example.com. 60 IN A 192.0.2.9",
+	)
+	failing := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(m)
+	})
+
+	servers := startPropagationServers(t,
+		map[string]dns.Handler{"127.0.0.1": upToDate, "127.0.0.2": lagging, "127.0.0.4": failing},
+		map[string]dns.Handler{"127.0.0.3": cached},
+	)
+	servers = append(servers, propagationServer{Name: "ns.unknown.example.", Authoritative: true, Status: propagationUnreachable, Error: "unable to find the address of the name server"})
+
+	expected := []propagationRRset{
+		expectedRRset(t, "old.example.com.", dns.TypeA),
+		expectedRRset(t, "www.example.com.", dns.TypeA, "www.example.com. 300 IN A 192.0.2.2"),
+	}
+
+	if checkPropagation(servers, "example.com.", expected) {
+		t.Errorf("propagation converged with stale servers")
+	}
+
+	if s := findServer(t, servers, "ns.127.0.0.1"); s.Status != propagationMatching || s.Serial != 2 {
+		t.Errorf("up to date name server is %s with serial %d (%s)", s.Status, s.Serial, s.Error)
+	} else if len(s.Checks) != 2 || s.Checks[0].Status != propagationMatching || s.Checks[1].Status != propagationMatching {
+		t.Errorf("up to date name server checks: %+v", s.Checks)
+	}
+
+	// Matching records, but a serial behind the others
+	if s := findServer(t, servers, "ns.127.0.0.2"); s.Status != propagationStale || s.Serial != 1 || !strings.Contains(s.Error, "serial 1 is behind 2") {
+		t.Errorf("lagging name server is %s with serial %d (%s), expected stale", s.Status, s.Serial, s.Error)
+	}
+
+	// Resolvers report the remaining cache time of their stale answers
+	if s := findServer(t, servers, "resolver.127.0.0.3"); s.Status != propagationStale {
+		t.Errorf("resolver with cached answers is %s, expected stale", s.Status)
+	} else if len(s.Checks) != 2 {
+		t.Errorf("resolver checks: %+v", s.Checks)
+	} else {
+		if c := s.Checks[0]; c.Status != propagationStale || c.ExpiresIn != 60 || len(c.Records) != 1 {
+			t.Errorf("deleted RRset still cached: %+v", c)
+		}
+		if c := s.Checks[1]; c.Status != propagationStale || c.ExpiresIn != 120 || len(c.Records) != 1 || c.Records[0] != "192.0.2.1" {
+			t.Errorf("changed RRset still cached: %+v", c)
+		}
+	}
+
+	if s := findServer(t, servers, "ns.127.0.0.4"); s.Status != propagationUnreachable || !strings.Contains(s.Error, "SERVFAIL") {
+		t.Errorf("failing name server is %s (%s), expected unreachable", s.Status, s.Error)
+	}
+
+	if s := findServer(t, servers, "ns.unknown.example."); s.Status != propagationUnreachable {
+		t.Errorf("name server without address is %s, expected unreachable", s.Status)
+	}
+}
+
+func TestCheckPropagationConverged(t *testing.T) {
+	// 1 follows 4294967295 in serial number arithmetic (RFC 1982)
+	wrapped := newFakeServer(t, []string{"example.com."},
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 3600 600 86400 300",
+		"www.example.com. 300 IN A 192.0.2.2",
+	)
+	before := newFakeServer(t, []string{"example.com."},
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 4294967295 3600 600 86400 300",
+		"www.example.com. 300 IN A 192.0.2.2",
+	)
+
+	expected := []propagationRRset{
+		expectedRRset(t, "www.example.com.", dns.TypeA, "www.example.com. 300 IN A 192.0.2.2"),
+	}
+
+	servers := startPropagationServers(t, map[string]dns.Handler{"127.0.0.1": wrapped, "127.0.0.2": before}, nil)
+	if checkPropagation(servers, "example.com.", expected) {
+		t.Errorf("propagation converged with a serial behind")
+	}
+	if s := findServer(t, servers, "ns.127.0.0.2"); s.Status != propagationStale {
+		t.Errorf("name server before the wrap is %s, expected stale", s.Status)
+	}
+	if s := findServer(t, servers, "ns.127.0.0.1"); s.Status != propagationMatching {
+		t.Errorf("name server after the wrap is %s (%s), expected matching", s.Status, s.Error)
+	}
+
+	servers = startPropagationServers(t, map[string]dns.Handler{"127.0.0.1": wrapped, "127.0.0.2": wrapped}, nil)
+	if !checkPropagation(servers, "example.com.", expected) {
+		t.Errorf("propagation did not converge: %+v", servers)
+	}
+}
+
+func TestPropagationServers(t *testing.T) {
+	opts := &config.Options{
+		Resolvers:            []happydns.Resolver{{Address: "192.0.2.53", Name: "Known resolver"}, {Address: "192.0.2.54", Name: "Other resolver"}},
+		PropagationResolvers: config.StringList{"192.0.2.53", "198.51.100.53"},
+	}
+
+	servers := propagationServers(context.Background(), opts, nil)
+	if len(servers) != 2 {
+		t.Fatalf("got %d servers, expected only the propagation resolvers: %+v", len(servers), servers)
+	}
+	if servers[0].Name != "Known resolver" || servers[0].Address != "192.0.2.53" || servers[0].Authoritative {
+		t.Errorf("unexpected server %+v", servers[0])
+	}
+	if servers[1].Name != "198.51.100.53" || servers[1].Address != "198.51.100.53" {
+		t.Errorf("unexpected server %+v", servers[1])
+	}
+
+	if cost := propagationCost(append(servers, propagationServer{Name: "unreachable"}), make([]propagationRRset, 3)); cost != 8 {
+		t.Errorf("cost is %d, expected 8", cost)
+	}
+}
+
+func TestPropagationWaits(t *testing.T) {
+	w := &propagationWaits{waiting: map[string]int{}}
+
+	for i := 0; i < propagationMaxWaits; i++ {
+		if !w.acquire("alice") {
+			t.Fatalf("wait %d refused", i+1)
+		}
+	}
+	if w.acquire("alice") {
+		t.Errorf("more than %d waits accepted", propagationMaxWaits)
+	}
+	if !w.acquire("bob") {
+		t.Errorf("waits of another user are refused")
+	}
+
+	w.release("alice")
+	if !w.acquire("alice") {
+		t.Errorf("wait refused after a release")
+	}
+
+	w.release("alice")
+	w.release("alice")
+	w.release("bob")
+	if len(w.waiting) != 0 {
+		t.Errorf("waits are not forgotten once released: %v", w.waiting)
+	}
+}
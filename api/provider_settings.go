@@ -33,13 +33,13 @@ package api
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"git.happydns.org/happydomain/config"
 	"git.happydns.org/happydomain/forms"
+	"git.happydns.org/happydomain/internal/logging"
 	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/providers"
 	"git.happydns.org/happydomain/storage"
@@ -82,7 +82,7 @@ func getProviderSettingsState(cfg *config.Options, c *gin.Context) {
 	uss.Provider = src
 	err = c.ShouldBindJSON(&uss)
 	if err != nil {
-		log.Printf("%s sends invalid ProviderSettingsState JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid ProviderSettingsState JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 
@@ -33,13 +33,17 @@ package api
 
 import (
 	"fmt"
-	"log"
 	"net/http"
+	"time"
 
 	dnscontrol "github.com/StackExchange/dnscontrol/v3/providers"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	log "github.com/sirupsen/logrus"
 
 	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/internal/metrics"
 	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/providers"
 	"git.happydns.org/happydomain/storage"
@@ -47,7 +51,9 @@ import (
 
 func declareProvidersRoutes(cfg *config.Options, router *gin.RouterGroup) {
 	router.GET("/providers", getProviders)
-	router.POST("/providers", addProvider)
+	router.POST("/providers", forbidImpersonation, func(c *gin.Context) {
+		addProvider(cfg, c)
+	})
 
 	apiProvidersMetaRoutes := router.Group("/providers/:pid")
 	apiProvidersMetaRoutes.Use(ProviderMetaHandler)
@@ -58,7 +64,7 @@ func declareProvidersRoutes(cfg *config.Options, router *gin.RouterGroup) {
 	apiProviderRoutes.Use(ProviderHandler)
 
 	apiProviderRoutes.GET("", GetProvider)
-	apiProviderRoutes.PUT("", UpdateProvider)
+	apiProviderRoutes.PUT("", forbidImpersonation, UpdateProvider)
 
 	apiProviderRoutes.GET("/domains", getDomainsHostedByProvider)
 }
@@ -78,14 +84,14 @@ func getProviders(c *gin.Context) {
 
 func DecodeProvider(c *gin.Context) (*happydns.ProviderCombined, int, error) {
 	var ust happydns.ProviderMeta
-	err := c.ShouldBindJSON(&ust)
+	err := c.ShouldBindBodyWith(&ust, binding.JSON)
 	if err != nil {
 		return nil, http.StatusBadRequest, err
 	}
 
 	us, err := providers.FindProvider(ust.Type)
 	if err != nil {
-		log.Printf("%s: unable to find provider %s: %s", c.ClientIP(), ust.Type, err.Error())
+		logging.FromContext(c).WithError(err).WithField("provider_type", ust.Type).Info("unable to find provider")
 		return nil, http.StatusInternalServerError, fmt.Errorf("Sorry, we were unable to find the kind of provider in our database. Please report this issue.")
 	}
 
@@ -94,7 +100,7 @@ func DecodeProvider(c *gin.Context) (*happydns.ProviderCombined, int, error) {
 		ust,
 	}
 
-	err = c.ShouldBindJSON(&src)
+	err = c.ShouldBindBodyWith(&src, binding.JSON)
 	if err != nil {
 		return nil, http.StatusBadRequest, err
 	}
@@ -170,9 +176,21 @@ func GetProvider(c *gin.Context) {
 	c.JSON(http.StatusOK, provider)
 }
 
-func addProvider(c *gin.Context) {
+func addProvider(opts *config.Options, c *gin.Context) {
 	user := c.MustGet("LoggedUser").(*happydns.User)
 
+	if limit := userQuota(opts, user).MaxProviders; limit > 0 {
+		providers, err := storage.MainStore.GetProviderMetas(user)
+		if err != nil {
+			logging.FromContext(c).WithError(err).Error("unable to GetProviderMetas")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to create the given provider. Please try again later."})
+			return
+		} else if happydns.Reached(limit, len(providers)) {
+			quotaExceeded(c, limit, "providers")
+			return
+		}
+	}
+
 	src, statuscode, err := DecodeProvider(c)
 	if err != nil {
 		c.AbortWithStatusJSON(statuscode, gin.H{"errmsg": err.Error()})
@@ -181,7 +199,7 @@ func addProvider(c *gin.Context) {
 
 	s, err := storage.MainStore.CreateProvider(user, src.Provider, src.Comment)
 	if err != nil {
-		log.Println("%s unable to CreateProvider: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to CreateProvider")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to create the given provider. Please try again later."})
 		return
 	}
@@ -202,7 +220,7 @@ func UpdateProvider(c *gin.Context) {
 	src.OwnerId = provider.OwnerId
 
 	if err := storage.MainStore.UpdateProvider(src); err != nil {
-		log.Println("%s unable to UpdateProvider: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to UpdateProvider")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update the provider. Please try again later."})
 		return
 	}
@@ -217,7 +235,7 @@ func deleteProvider(c *gin.Context) {
 	// Check if the provider has no more domain associated
 	domains, err := storage.MainStore.GetDomains(user)
 	if err != nil {
-		log.Println("%s unable to GetDomains for user id=%x email=%s: %s", c.ClientIP(), user.Id, user.Email, err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to GetDomains")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to perform this action. Please try again later."})
 		return
 	}
@@ -230,7 +248,7 @@ func deleteProvider(c *gin.Context) {
 	}
 
 	if err := storage.MainStore.DeleteProvider(providermeta); err != nil {
-		log.Println("%s unable to DeleteProvider %x for user id=%x email=%s: %s", c.ClientIP(), providermeta.Id, user.Id, user.Email, err.Error())
+		logging.FromContext(c).WithError(err).WithField("provider_id", providermeta.Id.String()).Error("unable to DeleteProvider")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to delete your provider. Please try again later."})
 		return
 	}
@@ -261,3 +279,26 @@ func getDomainsHostedByProvider(c *gin.Context) {
 
 	c.JSON(http.StatusOK, domains)
 }
+
+// observeProviderCall records metrics about a call to the provider and logs
+// it along with the request context.
+func observeProviderCall(c *gin.Context, provider *happydns.ProviderCombined, operation string, start time.Time, err error) {
+	logProviderCall(logging.FromContext(c), provider, operation, start, err)
+}
+
+// logProviderCall is observeProviderCall for calls made outside of a request.
+func logProviderCall(logger *log.Entry, provider *happydns.ProviderCombined, operation string, start time.Time, err error) {
+	metrics.ObserveProviderCall(provider.DNSControlName(), operation, start, err)
+
+	entry := logger.WithFields(log.Fields{
+		"provider":    provider.DNSControlName(),
+		"provider_id": provider.Id.String(),
+		"operation":   operation,
+		"duration":    time.Since(start).String(),
+	})
+	if err != nil {
+		entry.WithError(err).Warn("provider call failed")
+	} else {
+		entry.Debug("provider call succeeded")
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/StackExchange/dnscontrol/v3/models"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/internal/metrics"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/storage"
+)
+
+// domainLock is a mutex shared by the requests working on the same domain.
+type domainLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	domainLocksMu sync.Mutex
+	domainLocks   = map[string]*domainLock{}
+)
+
+// lockDomain waits until no other caller holds the lock of the domain, then
+// takes it. The returned function releases it.
+func lockDomain(domain *happydns.Domain) (unlock func()) {
+	return lockDomainID(domain.Id)
+}
+
+// lockDomainID is lockDomain for callers only knowing the domain identifier.
+func lockDomainID(id happydns.Identifier) (unlock func()) {
+	key := id.String()
+
+	domainLocksMu.Lock()
+	l, ok := domainLocks[key]
+	if !ok {
+		l = &domainLock{}
+		domainLocks[key] = l
+	}
+	l.refs++
+	domainLocksMu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		domainLocksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(domainLocks, key)
+		}
+		domainLocksMu.Unlock()
+	}
+}
+
+// publishRRsets replaces on the provider the records of the given types at
+// the given name by rrs. Other records, as currently served by the provider,
+// are left untouched, whatever the content of the zone being edited. It
+// returns the number of corrections applied.
+//
+// As the records are computed from what the provider currently serves, the
+// caller has to hold the domain lock (see lockDomain), so concurrent
+// publications don't undo each other.
+func publishRRsets(logger *log.Entry, user *happydns.User, domain *happydns.Domain, name string, rrtypes []uint16, rrs []dns.RR) (int, error) {
+	provider, err := storage.MainStore.GetProvider(user, domain.IdProvider)
+	if err != nil {
+		return 0, fmt.Errorf("unable to find the provider: %w", err)
+	}
+
+	start := time.Now()
+	current, err := provider.ImportZone(domain)
+	logProviderCall(logger, provider, metrics.ProviderImportZone, start, err)
+	if err != nil {
+		return 0, err
+	}
+
+	var records []dns.RR
+	for _, rr := range current {
+		if strings.EqualFold(rr.Header().Name, name) && hasRRType(rrtypes, rr.Header().Rrtype) {
+			continue
+		}
+		records = append(records, rr)
+	}
+	records = append(records, rrs...)
+
+	rcs, err := models.RRstoRCs(records, strings.TrimSuffix(domain.DomainName, "."))
+	if err != nil {
+		return 0, err
+	}
+
+	start = time.Now()
+	corrections, err := provider.GetDomainCorrections(&models.DomainConfig{
+		Name:    strings.TrimSuffix(domain.DomainName, "."),
+		Records: rcs,
+	})
+	logProviderCall(logger, provider, metrics.ProviderGetDomainCorrections, start, err)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, cr := range corrections {
+		logger.WithField("provider", provider.DNSControlName()).WithField("correction", cr.Msg).Info("applying correction")
+
+		start := time.Now()
+		err := cr.F()
+		logProviderCall(logger, provider, metrics.ProviderApplyCorrection, start, err)
+		if err != nil {
+			return i, fmt.Errorf("unable to apply %q: %w", cr.Msg, err)
+		}
+	}
+
+	return len(corrections), nil
+}
+
+func hasRRType(rrtypes []uint16, rrtype uint16) bool {
+	for _, t := range rrtypes {
+		if t == rrtype {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/StackExchange/dnscontrol/v3/models"
+	dnscontrol "github.com/StackExchange/dnscontrol/v3/providers"
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/providers"
+)
+
+// TestProvider serves zones held in memory, to test publications. Its
+// calls block while its zone is in testProviderBlocks, and fail when Fail is
+// set.
+type TestProvider struct {
+	Fail bool `json:"fail,omitempty"`
+}
+
+var (
+	testProviderMu     sync.Mutex
+	testProviderZones  = map[string][]dns.RR{}
+	testProviderBlocks = map[string]chan struct{}{}
+)
+
+func init() {
+	providers.RegisterProvider(func() happydns.Provider {
+		return &TestProvider{}
+	}, providers.ProviderInfos{
+		Name: "Test provider",
+	})
+}
+
+func (p *TestProvider) NewDNSServiceProvider() (dnscontrol.DNSServiceProvider, error) {
+	return &testDNSProvider{fail: p.Fail}, nil
+}
+
+func (p *TestProvider) DNSControlName() string {
+	return "TEST"
+}
+
+// setTestZone defines the records served for the zone.
+func setTestZone(t *testing.T, zone string, records ...string) {
+	var rrs []dns.RR
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("invalid record %q: %s", record, err.Error())
+		}
+		rrs = append(rrs, rr)
+	}
+
+	testProviderMu.Lock()
+	defer testProviderMu.Unlock()
+	testProviderZones[zone] = rrs
+}
+
+// testZone returns the records served for the zone, sorted.
+func testZone(zone string) (records []string) {
+	testProviderMu.Lock()
+	defer testProviderMu.Unlock()
+
+	for _, rr := range testProviderZones[zone] {
+		records = append(records, rr.String())
+	}
+	sort.Strings(records)
+	return
+}
+
+type testDNSProvider struct {
+	fail bool
+}
+
+func (p *testDNSProvider) wait(domain string) error {
+	testProviderMu.Lock()
+	block := testProviderBlocks[domain]
+	testProviderMu.Unlock()
+
+	if block != nil {
+		<-block
+	}
+
+	if p.fail {
+		return errors.New("provider unavailable")
+	}
+	return nil
+}
+
+func (p *testDNSProvider) GetNameservers(domain string) ([]*models.Nameserver, error) {
+	return nil, nil
+}
+
+func (p *testDNSProvider) GetZoneRecords(domain string) (models.Records, error) {
+	if err := p.wait(domain); err != nil {
+		return nil, err
+	}
+
+	testProviderMu.Lock()
+	defer testProviderMu.Unlock()
+
+	var rcs models.Records
+	for _, rr := range testProviderZones[domain] {
+		rc, err := models.RRtoRC(rr, domain)
+		if err != nil {
+			return nil, err
+		}
+		rcs = append(rcs, &rc)
+	}
+	return rcs, nil
+}
+
+func (p *testDNSProvider) GetDomainCorrections(dc *models.DomainConfig) ([]*models.Correction, error) {
+	var rrs []dns.RR
+	var wanted []string
+	for _, rc := range dc.Records {
+		rr := rc.ToRR()
+		rrs = append(rrs, rr)
+		wanted = append(wanted, rr.String())
+	}
+	sort.Strings(wanted)
+
+	if strings.Join(wanted, "\n") == strings.Join(testZone(dc.Name), "\n") {
+		return nil, nil
+	}
+
+	return []*models.Correction{{
+		Msg: "replace the zone",
+		F: func() error {
+			testProviderMu.Lock()
+			defer testProviderMu.Unlock()
+			testProviderZones[dc.Name] = rrs
+			return nil
+		},
+	}}, nil
+}
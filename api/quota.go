@@ -0,0 +1,145 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/storage"
+)
+
+// minZoneHistory is the number of revisions always retained: the zone
+// being edited and the last published one.
+const minZoneHistory = 2
+
+// QuotaUsage reports the resources currently used by a User.
+type QuotaUsage struct {
+	Domains   int `json:"domains"`
+	Providers int `json:"providers"`
+}
+
+// QuotaReport gives a User its limits along with its current usage.
+type QuotaReport struct {
+	Limits happydns.UserQuota `json:"limits"`
+	Usage  QuotaUsage         `json:"usage"`
+}
+
+// userQuota returns the limits applying to the given User.
+func userQuota(opts *config.Options, user *happydns.User) happydns.UserQuota {
+	return opts.Quota.Override(user.Quota)
+}
+
+func quotaExceeded(c *gin.Context, limit int, what string) {
+	logging.FromContext(c).WithField("limit", limit).Infof("quota of %s reached", what)
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"errmsg": fmt.Sprintf("You have reached your quota of %d %s.", limit, what)})
+}
+
+func countServices(zone *happydns.Zone) (n int) {
+	for _, services := range zone.Services {
+		n += len(services)
+	}
+	return
+}
+
+// trimZoneHistory removes from the domain's history the oldest revisions of
+// its zone, beyond the User's limit, and returns them. They have to be
+// deleted with deleteZoneRevisions once the domain has been saved, so the
+// domain never references a missing zone. The keep revision, being applied,
+// is retained whatever its age.
+func trimZoneHistory(opts *config.Options, user *happydns.User, domain *happydns.Domain, keep happydns.Identifier) (trimmed []happydns.Identifier) {
+	limit := userQuota(opts, user).MaxZoneHistory
+	if limit <= 0 {
+		return nil
+	} else if limit < minZoneHistory {
+		limit = minZoneHistory
+	}
+
+	if len(domain.ZoneHistory) <= limit {
+		return nil
+	}
+
+	history := domain.ZoneHistory[:limit:limit]
+	for _, zid := range domain.ZoneHistory[limit:] {
+		if keep != nil && zid.Equals(keep) {
+			history = append(history, zid)
+		} else {
+			trimmed = append(trimmed, zid)
+		}
+	}
+	domain.ZoneHistory = history
+
+	return
+}
+
+// deleteZoneRevisions deletes the given zones, trimmed from a domain's
+// history.
+func deleteZoneRevisions(zids []happydns.Identifier) error {
+	for _, zid := range zids {
+		if err := storage.MainStore.DeleteZone(&happydns.Zone{ZoneMeta: happydns.ZoneMeta{Id: zid}}); err != nil {
+			return fmt.Errorf("unable to delete zone %s: %w", zid.String(), err)
+		}
+	}
+
+	return nil
+}
+
+func getUserQuota(opts *config.Options, c *gin.Context) {
+	user := c.MustGet("user").(*happydns.User)
+
+	domains, err := storage.MainStore.GetDomains(user)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to GetDomains")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to compute your usage now."})
+		return
+	}
+
+	providers, err := storage.MainStore.GetProviderMetas(user)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to GetProviderMetas")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to compute your usage now."})
+		return
+	}
+
+	c.JSON(http.StatusOK, QuotaReport{
+		Limits: userQuota(opts, user),
+		Usage: QuotaUsage{
+			Domains:   len(domains),
+			Providers: len(providers),
+		},
+	})
+}
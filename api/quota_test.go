@@ -0,0 +1,81 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"testing"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/model"
+)
+
+func TestTrimZoneHistory(t *testing.T) {
+	opts := &config.Options{Quota: happydns.UserQuota{MaxZoneHistory: 3}}
+	user := &happydns.User{}
+
+	history := func() []happydns.Identifier {
+		return []happydns.Identifier{{5}, {4}, {3}, {2}, {1}}
+	}
+
+	domain := &happydns.Domain{ZoneHistory: history()}
+	trimmed := trimZoneHistory(opts, user, domain, nil)
+	if len(domain.ZoneHistory) != 3 || !domain.ZoneHistory[2].Equals(happydns.Identifier{3}) {
+		t.Errorf("got history %v, expected the 3 latest revisions", domain.ZoneHistory)
+	}
+	if len(trimmed) != 2 || !trimmed[0].Equals(happydns.Identifier{2}) || !trimmed[1].Equals(happydns.Identifier{1}) {
+		t.Errorf("got trimmed revisions %v, expected the 2 oldest", trimmed)
+	}
+
+	// An old revision being applied is retained
+	domain = &happydns.Domain{ZoneHistory: history()}
+	trimmed = trimZoneHistory(opts, user, domain, happydns.Identifier{2})
+	if len(domain.ZoneHistory) != 4 || !domain.ZoneHistory[3].Equals(happydns.Identifier{2}) {
+		t.Errorf("got history %v, expected the applied revision to be retained", domain.ZoneHistory)
+	}
+	if len(trimmed) != 1 || !trimmed[0].Equals(happydns.Identifier{1}) {
+		t.Errorf("got trimmed revisions %v, expected only the oldest", trimmed)
+	}
+
+	// Never below the edited and published revisions
+	opts.Quota.MaxZoneHistory = 1
+	domain = &happydns.Domain{ZoneHistory: history()}
+	if trimmed = trimZoneHistory(opts, user, domain, nil); len(domain.ZoneHistory) != minZoneHistory || len(trimmed) != 3 {
+		t.Errorf("got history %v and trimmed %v, expected %d revisions retained", domain.ZoneHistory, trimmed, minZoneHistory)
+	}
+
+	// No limit
+	opts.Quota.MaxZoneHistory = 0
+	domain = &happydns.Domain{ZoneHistory: history()}
+	if trimmed = trimZoneHistory(opts, user, domain, nil); len(domain.ZoneHistory) != 5 || trimmed != nil {
+		t.Errorf("got history %v and trimmed %v, expected nothing trimmed", domain.ZoneHistory, trimmed)
+	}
+}
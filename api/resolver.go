@@ -33,32 +33,80 @@ package api
 
 import (
 	"fmt"
-	"log"
 	"math/rand"
+	"net"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/model"
 )
 
 var (
 	RRToAskForANY = []uint16{dns.TypeSOA, dns.TypeA, dns.TypeAAAA, dns.TypeNS, dns.TypeMX, dns.TypeTXT}
 )
 
-func declareResolverRoutes(router *gin.RouterGroup) {
-	router.POST("/resolver", runResolver)
+func declareResolverRoutes(cfg *config.Options, router *gin.RouterGroup) {
+	router.GET("/resolvers", func(c *gin.Context) {
+		c.JSON(http.StatusOK, cfg.Resolvers)
+	})
+
+	handlers := []gin.HandlerFunc{}
+	if cfg.ResolverRequireAuth {
+		handlers = append(handlers, authMiddleware(cfg, false))
+	}
+	handlers = append(handlers, func(c *gin.Context) {
+		runResolver(cfg, c)
+	})
+	router.POST("/resolver", handlers...)
 }
 
 type resolverRequest struct {
-	Resolver   string `json:"resolver"`
-	Custom     string `json:"custom,omitempty"`
+	// Resolver is the address of a well-known resolver, "local" or
+	// "custom".
+	Resolver string `json:"resolver"`
+
+	// Custom is the resolver to use when Resolver is "custom", only over
+	// UDP or TCP.
+	Custom string `json:"custom,omitempty"`
+
+	// Transport is one of udp (default), tcp, tls or https.
+	Transport string `json:"transport,omitempty"`
+
+	// Port overrides the default port of the transport.
+	Port uint16 `json:"port,omitempty"`
+
+	// Method is the HTTP method used for DNS-over-HTTPS: POST (default)
+	// or GET.
+	Method string `json:"method,omitempty"`
+
+	// Trace resolves the name iteratively from the root servers, instead
+	// of asking a resolver.
+	Trace bool `json:"trace,omitempty"`
+
+	// Validate checks the DNSSEC chain of trust of the answer, from the
+	// trust anchors. It is ignored when tracing.
+	Validate bool `json:"validate,omitempty"`
+
 	DomainName string `json:"domain"`
 	Type       string `json:"type"`
 }
 
-func resolverANYQuestion(client dns.Client, resolver string, dn string) (r *dns.Msg, err error) {
+// findResolver returns the well-known resolver with the given address.
+func findResolver(resolvers []happydns.Resolver, address string) *happydns.Resolver {
+	for i := range resolvers {
+		if resolvers[i].Address == address {
+			return &resolvers[i]
+		}
+	}
+	return nil
+}
+
+func resolverANYQuestion(client resolverExchanger, dn string) (r *dns.Msg, err error) {
 	var response *dns.Msg
 
 	for _, rrType := range RRToAskForANY {
@@ -71,7 +119,7 @@ func resolverANYQuestion(client dns.Client, resolver string, dn string) (r *dns.
 		m.RecursionDesired = true
 		m.SetEdns0(4096, true)
 
-		response, _, err = client.Exchange(m, resolver)
+		response, err = client.Exchange(m)
 		if err != nil {
 			return
 		}
@@ -94,53 +142,108 @@ func resolverANYQuestion(client dns.Client, resolver string, dn string) (r *dns.
 	return
 }
 
-func resolverQuestion(client dns.Client, resolver string, dn string, rrType uint16) (*dns.Msg, error) {
+func resolverQuestion(client resolverExchanger, dn string, rrType uint16) (*dns.Msg, error) {
 	m := new(dns.Msg)
 	m.SetQuestion(dn, rrType)
 	m.RecursionDesired = true
 	m.SetEdns0(4096, true)
 
-	r, _, err := client.Exchange(m, resolver)
-
-	return r, err
+	return client.Exchange(m)
 }
 
-func runResolver(c *gin.Context) {
+func runResolver(opts *config.Options, c *gin.Context) {
 	var urr resolverRequest
 	if err := c.ShouldBindJSON(&urr); err != nil {
-		log.Printf("%s sends invalid ResolverRequest JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid ResolverRequest JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
 
 	urr.DomainName = dns.Fqdn(urr.DomainName)
 
-	if urr.Resolver == "custom" {
-		urr.Resolver = urr.Custom
-	} else if urr.Resolver == "local" {
-		cConf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
-		if err != nil {
-			log.Printf("%s unable to load ClientConfigFromFile: %s", c.ClientIP(), err.Error())
-			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to perform the request. Please try again later."})
+	key := resolverCacheKey(&urr)
+	if opts.ResolverCacheTTL > 0 {
+		if body, ok := resolverResponses.get(key); ok {
+			c.Data(http.StatusOK, "application/json; charset=utf-8", body)
 			return
 		}
+	}
 
-		urr.Resolver = cConf.Servers[rand.Intn(len(cConf.Servers))]
+	if opts.ResolverRateLimit > 0 && !resolverRateLimit.allow(c.ClientIP(), resolverCost(&urr), opts.ResolverRateLimit) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"errmsg": "Too many queries, please try again later."})
+		return
 	}
 
-	if strings.Count(urr.Resolver, ":") > 0 && urr.Resolver[0] != '[' {
-		urr.Resolver = "[" + urr.Resolver + "]"
+	if urr.Trace {
+		traceResolver(opts, c, key, urr)
+		return
 	}
 
-	client := dns.Client{Timeout: time.Second * 5}
+	var tlsName, dohURL string
+	var custom bool
+	if urr.Resolver == "custom" || urr.Resolver == "local" {
+		// Encrypted transports need a known certificate name or URL
+		if urr.Transport != "" && urr.Transport != "udp" && urr.Transport != "tcp" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("The %s transport is only available with the proposed resolvers.", urr.Transport)})
+			return
+		}
+
+		if urr.Resolver == "custom" {
+			urr.Resolver = urr.Custom
+			custom = true
+		} else {
+			// The local resolver is only reachable the way the server
+			// itself uses it
+			if urr.Port != 0 {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": "The port cannot be changed with the local resolver."})
+				return
+			}
+
+			cConf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+			if err != nil {
+				logging.FromContext(c).WithError(err).Error("unable to load ClientConfigFromFile")
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to perform the request. Please try again later."})
+				return
+			}
+
+			urr.Resolver = cConf.Servers[rand.Intn(len(cConf.Servers))]
+		}
+	} else if resolver := findResolver(opts.Resolvers, urr.Resolver); resolver != nil {
+		tlsName = resolver.TLSName
+		dohURL = resolver.DoHURL
+	} else {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unknown resolver %q.", urr.Resolver)})
+		return
+	}
+
+	urr.Resolver = strings.TrimSuffix(strings.TrimPrefix(urr.Resolver, "["), "]")
+
+	// Custom resolvers must not reach the internal network
+	if custom {
+		var err error
+		if urr.Resolver, err = resolveCustomResolver(c.Request.Context(), opts, urr.Resolver); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"errmsg": err.Error()})
+			return
+		}
+	}
+
+	client, err := newResolverExchanger(urr.Transport, urr.Resolver, tlsName, dohURL, urr.Port, strings.ToUpper(urr.Method))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
+		return
+	}
+
+	if urr.Validate {
+		validateResolver(opts, c, key, client, urr)
+		return
+	}
 
 	var r *dns.Msg
-	var err error
 	rrType := dns.StringToType[urr.Type]
 	if rrType == dns.TypeANY {
-		r, err = resolverANYQuestion(client, urr.Resolver+":53", urr.DomainName)
+		r, err = resolverANYQuestion(client, urr.DomainName)
 	} else {
-		r, err = resolverQuestion(client, urr.Resolver+":53", urr.DomainName, rrType)
+		r, err = resolverQuestion(client, urr.DomainName, rrType)
 	}
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
@@ -170,5 +273,60 @@ func runResolver(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, r)
+	respondResolver(opts, c, key, r, r)
+}
+
+// traceResolver responds with each step of an iterative resolution. The
+// steps are given even when the resolution fails, as they show where.
+func traceResolver(opts *config.Options, c *gin.Context, key string, urr resolverRequest) {
+	rrType, ok := dns.StringToType[urr.Type]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unknown record type %q.", urr.Type)})
+		return
+	}
+
+	t := newTracer(opts.RootHints, func(ip net.IP) bool {
+		return resolverAllowed(opts, ip)
+	})
+	r, err := t.resolve(urr.DomainName, rrType, 0, true)
+
+	ret := resolverTrace{
+		Msg:   r,
+		Trace: t.steps,
+	}
+	if err != nil {
+		// Failures are not kept in cache
+		ret.Error = err.Error()
+		r = nil
+	}
+
+	respondResolver(opts, c, key, r, ret)
+}
+
+// validateResolver responds with the answer and the validation of its DNSSEC
+// chain of trust. Negative answers are responded too, as their denial of
+// existence is validated as well.
+func validateResolver(opts *config.Options, c *gin.Context, key string, client resolverExchanger, urr resolverRequest) {
+	rrType, ok := dns.StringToType[urr.Type]
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unknown record type %q.", urr.Type)})
+		return
+	}
+
+	var r *dns.Msg
+	var err error
+	if rrType == dns.TypeANY {
+		r, err = resolverANYQuestion(client, urr.DomainName)
+	} else {
+		r, err = resolverQuestion(client, urr.DomainName, rrType)
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
+		return
+	}
+
+	respondResolver(opts, c, key, r, resolverValidation{
+		Msg:    r,
+		DNSSEC: newValidator(client, opts.TrustAnchors).validate(urr.DomainName, rrType),
+	})
 }
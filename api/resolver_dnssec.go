@@ -0,0 +1,645 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dnssecSecure        = "secure"
+	dnssecInsecure      = "insecure"
+	dnssecBogus         = "bogus"
+	dnssecIndeterminate = "indeterminate"
+
+	// validatorMaxQueries bounds the number of queries made to validate a
+	// name.
+	validatorMaxQueries = 48
+)
+
+// dnssecAlgorithms are the signing algorithms the validator is able to
+// check. Zones signed only with other algorithms are treated as insecure.
+var dnssecAlgorithms = map[uint8]bool{
+	dns.RSASHA1:          true,
+	dns.RSASHA1NSEC3SHA1: true,
+	dns.RSASHA256:        true,
+	dns.RSASHA512:        true,
+	dns.ECDSAP256SHA256:  true,
+	dns.ECDSAP384SHA384:  true,
+	dns.ED25519:          true,
+}
+
+// dnssecDigests are the DS digest types the validator is able to check.
+var dnssecDigests = map[uint8]bool{
+	dns.SHA1:   true,
+	dns.SHA256: true,
+	dns.SHA384: true,
+}
+
+// dnssecKey describes a DNSKEY, without its public key.
+type dnssecKey struct {
+	KeyTag    uint16 `json:"key_tag"`
+	Algorithm string `json:"algorithm"`
+	Flags     uint16 `json:"flags"`
+}
+
+// dnssecLink is a hop of the chain of trust: a zone authenticated by its
+// parent, or a RRset authenticated by its zone.
+type dnssecLink struct {
+	// Name is the zone, or the owner of the RRset.
+	Name string `json:"name"`
+
+	// Type is DNSKEY for zones, else the type of the RRset.
+	Type string `json:"type"`
+
+	// Status is one of secure, insecure, bogus or indeterminate.
+	Status string `json:"status"`
+
+	// DS are the records of the parent zone (or the trust anchors)
+	// authenticating the zone.
+	DS []dns.RR `json:"ds,omitempty"`
+
+	// Keys are the DNSKEY published by the zone.
+	Keys []dnssecKey `json:"keys,omitempty"`
+
+	// Reason explains the status, when not secure.
+	Reason string `json:"reason,omitempty"`
+}
+
+// dnssecReport is the result of the validation of a name: its status, the
+// link that fails and the whole chain from the trust anchor.
+type dnssecReport struct {
+	Status string       `json:"status"`
+	Link   string       `json:"link,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+	Chain  []dnssecLink `json:"chain"`
+}
+
+// resolverValidation is the response to a resolution along with the DNSSEC
+// validation of its answer.
+type resolverValidation struct {
+	*dns.Msg
+	DNSSEC dnssecReport `json:"dnssec"`
+}
+
+// validator walks the chain of trust from a trust anchor down to a name,
+// asking a resolver for DS, DNSKEY and RRSIG records. Checking is disabled
+// on queries, so that a validating resolver still gives bogus data.
+type validator struct {
+	client  resolverExchanger
+	anchors []*dns.DS
+	now     time.Time
+	queries int
+	chain   []dnssecLink
+}
+
+func newValidator(client resolverExchanger, anchors []*dns.DS) *validator {
+	return &validator{
+		client:  client,
+		anchors: anchors,
+		now:     time.Now(),
+	}
+}
+
+func (v *validator) query(name string, qtype uint16) (*dns.Msg, error) {
+	v.queries += 1
+	if v.queries > validatorMaxQueries {
+		return nil, fmt.Errorf("too many queries (more than %d)", validatorMaxQueries)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = true
+	m.CheckingDisabled = true
+	m.SetEdns0(4096, true)
+
+	r, err := v.client.Exchange(m)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", name, dns.TypeToString[qtype], err)
+	} else if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("%s %s: resolver returns %s", name, dns.TypeToString[qtype], dns.RcodeToString[r.Rcode])
+	}
+
+	return r, nil
+}
+
+// rrsetOf extracts from rrs the records of the given name and type, with
+// the signatures covering them.
+func rrsetOf(rrs []dns.RR, name string, rrtype uint16) (set []dns.RR, sigs []*dns.RRSIG) {
+	for _, rr := range rrs {
+		if !strings.EqualFold(rr.Header().Name, name) {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			if sig.TypeCovered == rrtype {
+				sigs = append(sigs, sig)
+			}
+		} else if rr.Header().Rrtype == rrtype {
+			set = append(set, rr)
+		}
+	}
+	return
+}
+
+// verifyRRset checks that at least one of the signatures authenticates the
+// RRset with one of the keys. When none does, the error explains why the
+// last one doesn't.
+func verifyRRset(set []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, now time.Time) (err error) {
+	if len(sigs) == 0 {
+		return errors.New("no RRSIG")
+	}
+
+	for _, sig := range sigs {
+		var key *dns.DNSKEY
+		for _, k := range keys {
+			if k.KeyTag() == sig.KeyTag && k.Algorithm == sig.Algorithm && strings.EqualFold(k.Hdr.Name, sig.SignerName) {
+				key = k
+				break
+			}
+		}
+
+		if key == nil {
+			err = fmt.Errorf("no DNSKEY %d (%s) of %s for the RRSIG", sig.KeyTag, dns.AlgorithmToString[sig.Algorithm], sig.SignerName)
+		} else if !sig.ValidityPeriod(now) {
+			if inception := time.Unix(int64(sig.Inception), 0); now.Before(inception) {
+				err = fmt.Errorf("signature by key %d not yet valid (until %s)", sig.KeyTag, inception.UTC().Format(time.RFC3339))
+			} else {
+				err = fmt.Errorf("signature by key %d expired on %s", sig.KeyTag, time.Unix(int64(sig.Expiration), 0).UTC().Format(time.RFC3339))
+			}
+		} else if e := sig.Verify(key, set); e != nil {
+			err = fmt.Errorf("signature by key %d doesn't verify: %w", sig.KeyTag, e)
+		} else {
+			return nil
+		}
+	}
+
+	return
+}
+
+// zoneKeys fetches the DNSKEY of zone and authenticates them with the DS
+// records of its parent. Keys are returned only when the zone is secure.
+func (v *validator) zoneKeys(zone string, dss []*dns.DS) (keys []*dns.DNSKEY, link dnssecLink) {
+	link = dnssecLink{Name: zone, Type: "DNSKEY"}
+	for _, ds := range dss {
+		link.DS = append(link.DS, ds)
+	}
+
+	// Only DS we understand can authenticate the zone, the others are
+	// ignored.
+	var supported []*dns.DS
+	for _, ds := range dss {
+		if dnssecAlgorithms[ds.Algorithm] && dnssecDigests[ds.DigestType] {
+			supported = append(supported, ds)
+		}
+	}
+	if len(supported) == 0 {
+		link.Status = dnssecInsecure
+		link.Reason = fmt.Sprintf("unsupported DS algorithm %s or digest type %d", dns.AlgorithmToString[dss[0].Algorithm], dss[0].DigestType)
+		return
+	}
+
+	r, err := v.query(zone, dns.TypeDNSKEY)
+	if err != nil {
+		link.Status = dnssecIndeterminate
+		link.Reason = err.Error()
+		return
+	}
+
+	set, sigs := rrsetOf(r.Answer, zone, dns.TypeDNSKEY)
+	var all []*dns.DNSKEY
+	for _, rr := range set {
+		key := rr.(*dns.DNSKEY)
+		all = append(all, key)
+		link.Keys = append(link.Keys, dnssecKey{
+			KeyTag:    key.KeyTag(),
+			Algorithm: dns.AlgorithmToString[key.Algorithm],
+			Flags:     key.Flags,
+		})
+	}
+	if len(all) == 0 {
+		link.Status = dnssecBogus
+		link.Reason = "missing DNSKEY: the parent zone has DS records but the zone publishes no key"
+		return
+	}
+
+	// Find the keys designated by the DS
+	var entrypoints []*dns.DNSKEY
+	var sameAlgorithm, sameTag bool
+	for _, ds := range supported {
+		for _, key := range all {
+			if key.Algorithm != ds.Algorithm {
+				continue
+			}
+			sameAlgorithm = true
+			if key.KeyTag() != ds.KeyTag {
+				continue
+			}
+			sameTag = true
+			if d := key.ToDS(ds.DigestType); d != nil && strings.EqualFold(d.Digest, ds.Digest) {
+				entrypoints = append(entrypoints, key)
+			}
+		}
+	}
+
+	if len(entrypoints) == 0 {
+		link.Status = dnssecBogus
+		if !sameAlgorithm {
+			link.Reason = fmt.Sprintf("algorithm mismatch: DS use %s but no DNSKEY has this algorithm", dns.AlgorithmToString[supported[0].Algorithm])
+		} else if !sameTag {
+			link.Reason = fmt.Sprintf("no DNSKEY matches the key tag of the DS (%d)", supported[0].KeyTag)
+		} else {
+			link.Reason = fmt.Sprintf("the digest of DNSKEY %d doesn't match the DS", supported[0].KeyTag)
+		}
+		return
+	}
+
+	if err = verifyRRset(set, sigs, entrypoints, v.now); err != nil {
+		link.Status = dnssecBogus
+		link.Reason = "DNSKEY RRset: " + err.Error()
+		return
+	}
+
+	link.Status = dnssecSecure
+	return all, link
+}
+
+// canonicalLess compares two names in the canonical DNS order (RFC 4034,
+// section 6.1).
+func canonicalLess(a, b string) bool {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if la[i] != lb[j] {
+			return la[i] < lb[j]
+		}
+	}
+	return len(la) < len(lb)
+}
+
+// nsecCovers tells if name falls strictly between the owner of the NSEC
+// and its next name, taking care of the last NSEC of the zone.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner, next := nsec.Hdr.Name, nsec.NextDomain
+	if canonicalLess(owner, next) {
+		return canonicalLess(owner, name) && canonicalLess(name, next)
+	}
+	return canonicalLess(owner, name) && dns.IsSubDomain(next, name)
+}
+
+func hasType(bitmap []uint16, rrtype uint16) bool {
+	for _, t := range bitmap {
+		if t == rrtype {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyDenial checks the NSEC or NSEC3 records of a negative response: they
+// have to be signed by the zone and to prove that name doesn't exist, or
+// doesn't have records of the given type. It returns whether the type is
+// only covered by an NSEC3 opt-out span.
+//
+// For NXDOMAIN, the closest encloser proof is not verified: it is enough
+// that a NSEC or NSEC3 covers the name.
+func verifyDenial(r *dns.Msg, name string, rrtype uint16, keys []*dns.DNSKEY, now time.Time) (optout bool, err error) {
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+
+	for _, rr := range r.Ns {
+		if rr.Header().Rrtype != dns.TypeNSEC && rr.Header().Rrtype != dns.TypeNSEC3 {
+			continue
+		}
+
+		set, sigs := rrsetOf(r.Ns, rr.Header().Name, rr.Header().Rrtype)
+		if err = verifyRRset(set, sigs, keys, now); err != nil {
+			return false, fmt.Errorf("%s %s: %w", rr.Header().Name, dns.TypeToString[rr.Header().Rrtype], err)
+		}
+
+		switch rr := rr.(type) {
+		case *dns.NSEC:
+			nsecs = append(nsecs, rr)
+		case *dns.NSEC3:
+			nsec3s = append(nsec3s, rr)
+		}
+	}
+
+	if len(nsecs) == 0 && len(nsec3s) == 0 {
+		return false, errors.New("no NSEC or NSEC3 record to prove the denial of existence")
+	}
+
+	if r.Rcode == dns.RcodeNameError {
+		for _, nsec := range nsecs {
+			if nsecCovers(nsec, name) {
+				return false, nil
+			}
+		}
+		if len(nsec3s) > 0 {
+			return verifyClosestEncloser(nsec3s, name)
+		}
+		return false, fmt.Errorf("no NSEC or NSEC3 record covers %s", name)
+	}
+
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, name) {
+			if hasType(nsec.TypeBitMap, rrtype) || hasType(nsec.TypeBitMap, dns.TypeCNAME) {
+				return false, fmt.Errorf("the NSEC of %s claims %s exists", name, dns.TypeToString[rrtype])
+			}
+			return false, nil
+		}
+	}
+	for _, nsec3 := range nsec3s {
+		if nsec3.Match(name) {
+			if hasType(nsec3.TypeBitMap, rrtype) || hasType(nsec3.TypeBitMap, dns.TypeCNAME) {
+				return false, fmt.Errorf("the NSEC3 of %s claims %s exists", name, dns.TypeToString[rrtype])
+			}
+			return false, nil
+		}
+	}
+	if rrtype == dns.TypeDS {
+		// Unsigned delegations may be skipped by NSEC3 opt-out
+		for _, nsec3 := range nsec3s {
+			if nsec3.Flags&1 == 1 && nsec3.Cover(name) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, fmt.Errorf("no NSEC or NSEC3 record matches %s", name)
+}
+
+// verifyClosestEncloser checks the NSEC3 proof that name does not exist
+// (RFC 5155, section 8.4): an ancestor of name, its closest encloser, exists,
+// while the next closer name below it and the wildcard at the closest encloser
+// are covered. The denial is insecure when the next closer name is covered by
+// an opt-out NSEC3.
+func verifyClosestEncloser(nsec3s []*dns.NSEC3, name string) (optout bool, err error) {
+	idx := dns.Split(name)
+	for i := 1; i < len(idx); i++ {
+		encloser := name[idx[i]:]
+		if findNSEC3(nsec3s, func(nsec3 *dns.NSEC3) bool { return nsec3.Match(encloser) }) == nil {
+			continue
+		}
+
+		next := name[idx[i-1]:]
+		cover := findNSEC3(nsec3s, func(nsec3 *dns.NSEC3) bool { return nsec3Covers(nsec3, next) })
+		if cover == nil {
+			return false, fmt.Errorf("no NSEC3 record covers %s, the next closer name to %s", next, encloser)
+		}
+
+		wildcard := "*." + encloser
+		if findNSEC3(nsec3s, func(nsec3 *dns.NSEC3) bool { return nsec3Covers(nsec3, wildcard) }) == nil {
+			return false, fmt.Errorf("no NSEC3 record covers the wildcard %s", wildcard)
+		}
+
+		return cover.Flags&1 == 1, nil
+	}
+
+	return false, fmt.Errorf("no NSEC3 record matches a closest encloser of %s", name)
+}
+
+// nsec3Covers tells whether the hash of name falls strictly between the owner
+// and the next hashes of the NSEC3: dns.NSEC3.Cover also accepts the owner.
+func nsec3Covers(nsec3 *dns.NSEC3, name string) bool {
+	return nsec3.Cover(name) && !nsec3.Match(name)
+}
+
+func findNSEC3(nsec3s []*dns.NSEC3, match func(*dns.NSEC3) bool) *dns.NSEC3 {
+	for _, nsec3 := range nsec3s {
+		if match(nsec3) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+// anchorFor returns the zone of the closest trust anchors above name, with
+// these anchors.
+func (v *validator) anchorFor(name string) (zone string, dss []*dns.DS) {
+	for _, ds := range v.anchors {
+		if !dns.IsSubDomain(ds.Hdr.Name, name) {
+			continue
+		}
+		if zone == "" || dns.CountLabel(ds.Hdr.Name) > dns.CountLabel(zone) {
+			zone = dns.CanonicalName(ds.Hdr.Name)
+			dss = nil
+		}
+		if strings.EqualFold(ds.Hdr.Name, zone) {
+			dss = append(dss, ds)
+		}
+	}
+	return
+}
+
+// walk follows the chain of trust from the trust anchor down to the zone of
+// name. It returns this zone with its keys, or nil keys when the chain
+// stops being secure before.
+func (v *validator) walk(name string) (zone string, keys []*dns.DNSKEY) {
+	zone, dss := v.anchorFor(name)
+	if zone == "" {
+		v.chain = append(v.chain, dnssecLink{
+			Name:   name,
+			Status: dnssecIndeterminate,
+			Reason: "no trust anchor for this name",
+		})
+		return
+	}
+
+	keys, link := v.zoneKeys(zone, dss)
+	v.chain = append(v.chain, link)
+	if keys == nil {
+		return
+	}
+
+	idx := dns.Split(name)
+	for i := len(idx) - dns.CountLabel(zone) - 1; i >= 0; i-- {
+		child := name[idx[i]:]
+
+		r, err := v.query(child, dns.TypeDS)
+		if err != nil {
+			v.chain = append(v.chain, dnssecLink{Name: child, Type: "DS", Status: dnssecIndeterminate, Reason: err.Error()})
+			return zone, nil
+		}
+
+		if set, sigs := rrsetOf(r.Answer, child, dns.TypeDS); len(set) > 0 {
+			// A signed delegation
+			if err = verifyRRset(set, sigs, keys, v.now); err != nil {
+				v.chain = append(v.chain, dnssecLink{Name: child, Type: "DS", Status: dnssecBogus, Reason: "DS RRset: " + err.Error()})
+				return zone, nil
+			}
+
+			dss = nil
+			for _, rr := range set {
+				dss = append(dss, rr.(*dns.DS))
+			}
+
+			zone = child
+			keys, link = v.zoneKeys(zone, dss)
+			v.chain = append(v.chain, link)
+			if keys == nil {
+				return
+			}
+			continue
+		} else if r.Rcode == dns.RcodeNameError {
+			// Nothing below exists, the final answer will tell
+			return
+		}
+
+		// Without DS, child is either in the same zone or an unsigned
+		// delegation: only a zone apex has a SOA.
+		soa, err := v.query(child, dns.TypeSOA)
+		if err != nil {
+			v.chain = append(v.chain, dnssecLink{Name: child, Type: "SOA", Status: dnssecIndeterminate, Reason: err.Error()})
+			return zone, nil
+		}
+		if set, _ := rrsetOf(soa.Answer, child, dns.TypeSOA); len(set) == 0 {
+			continue
+		}
+
+		link := dnssecLink{Name: child, Type: "DS"}
+		if optout, err := verifyDenial(r, child, dns.TypeDS, keys, v.now); err != nil {
+			link.Status = dnssecBogus
+			link.Reason = fmt.Sprintf("missing DS: %s doesn't prove the delegation is unsigned: %s", zone, err.Error())
+		} else if optout {
+			link.Status = dnssecInsecure
+			link.Reason = fmt.Sprintf("the delegation is covered by an NSEC3 opt-out span of %s", zone)
+		} else {
+			link.Status = dnssecInsecure
+			link.Reason = fmt.Sprintf("no DS in %s: the delegation is not signed", zone)
+		}
+		v.chain = append(v.chain, link)
+		return child, nil
+	}
+
+	return
+}
+
+// answer authenticates the response to the given question, with the keys
+// of the zone holding name. The proof of non-existence is checked only when
+// negative is true.
+func (v *validator) answer(zone string, keys []*dns.DNSKEY, name string, rrtype uint16, negative bool) {
+	r, err := v.query(name, rrtype)
+	if err != nil {
+		v.chain = append(v.chain, dnssecLink{Name: name, Type: dns.TypeToString[rrtype], Status: dnssecIndeterminate, Reason: err.Error()})
+		return
+	}
+
+	// Each RRset of the answer, in order
+	type rrsetKey struct {
+		name   string
+		rrtype uint16
+	}
+	var rrsets []rrsetKey
+	seen := map[rrsetKey]bool{}
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+		k := rrsetKey{dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype}
+		if !seen[k] {
+			seen[k] = true
+			rrsets = append(rrsets, k)
+		}
+	}
+
+	var target = name
+	for _, k := range rrsets {
+		link := dnssecLink{Name: k.name, Type: dns.TypeToString[k.rrtype], Status: dnssecSecure}
+
+		if !dns.IsSubDomain(zone, k.name) {
+			link.Status = dnssecIndeterminate
+			link.Reason = fmt.Sprintf("outside of %s, validate %s separately", zone, k.name)
+		} else {
+			set, sigs := rrsetOf(r.Answer, k.name, k.rrtype)
+			if err = verifyRRset(set, sigs, keys, v.now); err != nil {
+				link.Status = dnssecBogus
+				link.Reason = err.Error()
+			}
+			if cname, ok := set[0].(*dns.CNAME); ok {
+				target = cname.Target
+			}
+		}
+
+		v.chain = append(v.chain, link)
+	}
+
+	// Negative response
+	if negative && !seen[rrsetKey{dns.CanonicalName(target), rrtype}] && dns.IsSubDomain(zone, target) {
+		link := dnssecLink{Name: target, Type: dns.TypeToString[rrtype], Status: dnssecSecure}
+		if _, err = verifyDenial(r, target, rrtype, keys, v.now); err != nil {
+			link.Status = dnssecBogus
+			link.Reason = err.Error()
+		} else if r.Rcode == dns.RcodeNameError {
+			link.Reason = "the name doesn't exist"
+		} else {
+			link.Reason = fmt.Sprintf("no %s record", dns.TypeToString[rrtype])
+		}
+		v.chain = append(v.chain, link)
+	}
+}
+
+// validate checks the chain of trust of the response to the given question.
+// ANY questions are validated for each type asked by the resolver tool, only
+// for existing records.
+func (v *validator) validate(name string, rrtype uint16) dnssecReport {
+	zone, keys := v.walk(name)
+
+	if keys != nil {
+		if rrtype == dns.TypeANY {
+			for _, t := range RRToAskForANY {
+				v.answer(zone, keys, name, t, false)
+			}
+		} else {
+			v.answer(zone, keys, name, rrtype, true)
+		}
+	}
+
+	// The status of the chain is the one of its first link that is not
+	// secure.
+	report := dnssecReport{Status: dnssecSecure, Chain: v.chain}
+	for _, link := range v.chain {
+		if link.Status != dnssecSecure {
+			report.Status = link.Status
+			report.Link = link.Name
+			report.Reason = link.Reason
+			break
+		}
+	}
+
+	return report
+}
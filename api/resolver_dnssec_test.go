@@ -0,0 +1,337 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"crypto"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testZoneKey signs the records of a zone in tests.
+type testZoneKey struct {
+	key    *dns.DNSKEY
+	signer crypto.Signer
+}
+
+func newTestZoneKey(t *testing.T, zone string) *testZoneKey {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatalf("unable to generate the key of %s: %s", zone, err.Error())
+	}
+
+	return &testZoneKey{key: key, signer: priv.(crypto.Signer)}
+}
+
+// sign returns the rrset with its signature, valid between inception and
+// expiration.
+func (k *testZoneKey) sign(t *testing.T, rrset []dns.RR, inception, expiration time.Time) []dns.RR {
+	sig := &dns.RRSIG{
+		Algorithm:  k.key.Algorithm,
+		KeyTag:     k.key.KeyTag(),
+		SignerName: k.key.Hdr.Name,
+		Inception:  uint32(inception.Unix()),
+		Expiration: uint32(expiration.Unix()),
+	}
+	if err := sig.Sign(k.signer, rrset); err != nil {
+		t.Fatalf("unable to sign %s: %s", rrset[0].Header().Name, err.Error())
+	}
+
+	return append(append([]dns.RR{}, rrset...), sig)
+}
+
+// fakeResolver answers from records, with their signatures. Negative
+// responses carry the records of denials registered for the name.
+type fakeResolver struct {
+	records []dns.RR
+	denials map[string][]dns.RR
+}
+
+func (f *fakeResolver) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	q := m.Question[0]
+
+	r := new(dns.Msg)
+	r.SetReply(m)
+
+	exists := false
+	for _, rr := range f.records {
+		if !strings.EqualFold(rr.Header().Name, q.Name) {
+			continue
+		}
+		exists = true
+
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == q.Qtype || rr.Header().Rrtype == q.Qtype {
+			r.Answer = append(r.Answer, rr)
+		}
+	}
+
+	if len(r.Answer) == 0 {
+		if !exists {
+			r.Rcode = dns.RcodeNameError
+		}
+		r.Ns = f.denials[strings.ToLower(q.Name)]
+	}
+
+	return r, nil
+}
+
+// newTestHierarchy signs a root zone, com. and example.com. Two other
+// delegations of com. are unsigned: insecure.com. with a proof that it has no
+// DS, nods.com. without.
+func newTestHierarchy(t *testing.T) (*fakeResolver, []*dns.DS) {
+	now := time.Now()
+	inception, expiration := now.Add(-time.Hour), now.Add(24*time.Hour)
+
+	rootKey := newTestZoneKey(t, ".")
+	comKey := newTestZoneKey(t, "com.")
+	exampleKey := newTestZoneKey(t, "example.com.")
+
+	rr := func(s string) dns.RR {
+		r, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("invalid record %q: %s", s, err.Error())
+		}
+		return r
+	}
+
+	f := &fakeResolver{denials: map[string][]dns.RR{}}
+	add := func(rrs ...dns.RR) {
+		f.records = append(f.records, rrs...)
+	}
+
+	add(rootKey.sign(t, []dns.RR{rootKey.key}, inception, expiration)...)
+	add(rootKey.sign(t, []dns.RR{comKey.key.ToDS(dns.SHA256)}, inception, expiration)...)
+
+	add(comKey.sign(t, []dns.RR{comKey.key}, inception, expiration)...)
+	add(comKey.sign(t, []dns.RR{exampleKey.key.ToDS(dns.SHA256)}, inception, expiration)...)
+
+	f.denials["insecure.com."] = comKey.sign(t, []dns.RR{&dns.NSEC{
+		Hdr:        dns.RR_Header{Name: "insecure.com.", Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 3600},
+		NextDomain: "nods.com.",
+		TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+	}}, inception, expiration)
+	add(rr("insecure.com. 3600 IN SOA ns.insecure.com. root.insecure.com. 1 3600 600 86400 300"))
+	add(rr("www.insecure.com. 300 IN A 192.0.2.10"))
+	add(rr("nods.com. 3600 IN SOA ns.nods.com. root.nods.com. 1 3600 600 86400 300"))
+	add(rr("www.nods.com. 300 IN A 192.0.2.11"))
+
+	add(exampleKey.sign(t, []dns.RR{exampleKey.key}, inception, expiration)...)
+	add(exampleKey.sign(t, []dns.RR{rr("www.example.com. 300 IN A 192.0.2.1")}, inception, expiration)...)
+	add(exampleKey.sign(t, []dns.RR{rr("old.example.com. 300 IN A 192.0.2.2")}, now.Add(-48*time.Hour), now.Add(-24*time.Hour))...)
+
+	// The record is changed after its signature
+	bad := exampleKey.sign(t, []dns.RR{rr("bad.example.com. 300 IN A 192.0.2.3")}, inception, expiration)
+	bad[0].(*dns.A).A = net.ParseIP("192.0.2.4")
+	add(bad...)
+
+	// NSEC chain: example.com. bad old www
+	nsec := func(owner, next string, types ...uint16) []dns.RR {
+		return exampleKey.sign(t, []dns.RR{&dns.NSEC{
+			Hdr:        dns.RR_Header{Name: owner, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+			NextDomain: next,
+			TypeBitMap: append(types, dns.TypeRRSIG, dns.TypeNSEC),
+		}}, inception, expiration)
+	}
+	f.denials["nothere.example.com."] = nsec("bad.example.com.", "old.example.com.", dns.TypeA)
+	f.denials["forged.example.com."] = nsec("www.example.com.", "example.com.", dns.TypeA)
+	f.denials["unsigned.example.com."] = nsec("bad.example.com.", "old.example.com.", dns.TypeA)[:1]
+
+	return f, []*dns.DS{rootKey.key.ToDS(dns.SHA256)}
+}
+
+func TestDNSSECValidation(t *testing.T) {
+	f, anchors := newTestHierarchy(t)
+
+	for _, tc := range []struct {
+		test   string
+		name   string
+		status string
+		link   string
+		reason string
+		chain  int
+	}{
+		{test: "valid chain", name: "www.example.com.", status: dnssecSecure, chain: 4},
+		{test: "bad signature", name: "bad.example.com.", status: dnssecBogus, link: "bad.example.com.", reason: "doesn't verify"},
+		{test: "expired signature", name: "old.example.com.", status: dnssecBogus, link: "old.example.com.", reason: "expired"},
+		{test: "insecure delegation", name: "www.insecure.com.", status: dnssecInsecure, link: "insecure.com.", reason: "not signed", chain: 3},
+		{test: "missing DS", name: "www.nods.com.", status: dnssecBogus, link: "nods.com.", reason: "missing DS"},
+		{test: "NXDOMAIN", name: "nothere.example.com.", status: dnssecSecure, chain: 4},
+		{test: "forged NXDOMAIN", name: "forged.example.com.", status: dnssecBogus, link: "forged.example.com.", reason: "no NSEC or NSEC3 record covers"},
+		{test: "unsigned NXDOMAIN", name: "unsigned.example.com.", status: dnssecBogus, link: "unsigned.example.com.", reason: "no RRSIG"},
+	} {
+		t.Run(tc.test, func(t *testing.T) {
+			report := newValidator(f, anchors).validate(tc.name, dns.TypeA)
+
+			if report.Status != tc.status {
+				t.Fatalf("status is %s (%s: %s), expected %s", report.Status, report.Link, report.Reason, tc.status)
+			}
+			if report.Link != tc.link {
+				t.Errorf("failing link is %q, expected %q", report.Link, tc.link)
+			}
+			if !strings.Contains(report.Reason, tc.reason) {
+				t.Errorf("reason is %q, expected it to contain %q", report.Reason, tc.reason)
+			}
+			if tc.chain > 0 && len(report.Chain) != tc.chain {
+				t.Errorf("chain has %d links, expected %d: %v", len(report.Chain), tc.chain, report.Chain)
+			}
+		})
+	}
+}
+
+func TestDNSSECWrongAnchor(t *testing.T) {
+	f, _ := newTestHierarchy(t)
+	other := newTestZoneKey(t, ".")
+
+	report := newValidator(f, []*dns.DS{other.key.ToDS(dns.SHA256)}).validate("www.example.com.", dns.TypeA)
+	if report.Status != dnssecBogus || report.Link != "." {
+		t.Errorf("validation with another trust anchor gives %s at %q, expected bogus at the root", report.Status, report.Link)
+	}
+}
+
+// nsec3Chain is the NSEC3 chain of a zone, hashed without salt nor extra
+// iteration.
+type nsec3Chain struct {
+	zone    string
+	records []*dns.NSEC3
+}
+
+func newNSEC3Chain(zone string, names ...string) *nsec3Chain {
+	var hashes []string
+	for _, name := range names {
+		hashes = append(hashes, dns.HashName(name, dns.SHA1, 0, ""))
+	}
+	sort.Strings(hashes)
+
+	c := &nsec3Chain{zone: zone}
+	for i, hash := range hashes {
+		c.records = append(c.records, &dns.NSEC3{
+			Hdr:        dns.RR_Header{Name: strings.ToLower(hash) + "." + zone, Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 300},
+			Hash:       dns.SHA1,
+			HashLength: 20,
+			NextDomain: hashes[(i+1)%len(hashes)],
+			TypeBitMap: []uint16{dns.TypeA, dns.TypeRRSIG},
+		})
+	}
+	return c
+}
+
+// find returns the NSEC3 matching or covering name.
+func (c *nsec3Chain) find(name string, matching bool) *dns.NSEC3 {
+	for _, nsec3 := range c.records {
+		if (matching && nsec3.Match(name)) || (!matching && nsec3Covers(nsec3, name)) {
+			return nsec3
+		}
+	}
+	return nil
+}
+
+func TestDNSSECNSEC3ClosestEncloser(t *testing.T) {
+	now := time.Now()
+	key := newTestZoneKey(t, "example.org.")
+	chain := newNSEC3Chain("example.org.", "example.org.", "www.example.org.", "mail.example.org.", "ftp.example.org.", "dev.example.org.", "a.dev.example.org.")
+
+	// response builds the NXDOMAIN response carrying the given NSEC3s
+	response := func(nsec3s ...*dns.NSEC3) *dns.Msg {
+		r := new(dns.Msg)
+		r.Rcode = dns.RcodeNameError
+
+		seen := map[*dns.NSEC3]bool{}
+		for _, nsec3 := range nsec3s {
+			if nsec3 == nil || seen[nsec3] {
+				continue
+			}
+			seen[nsec3] = true
+			r.Ns = append(r.Ns, key.sign(t, []dns.RR{nsec3}, now.Add(-time.Hour), now.Add(time.Hour))...)
+		}
+		return r
+	}
+
+	// proof returns the NSEC3s proving name does not exist below encloser
+	proof := func(name, encloser, next string) (match, cover, wildcard *dns.NSEC3) {
+		return chain.find(encloser, true), chain.find(next, false), chain.find("*."+encloser, false)
+	}
+
+	for _, tc := range []struct {
+		name, encloser, next string
+	}{
+		{"nothere.example.org.", "example.org.", "nothere.example.org."},
+		{"b.a.nothere.www.example.org.", "www.example.org.", "nothere.www.example.org."},
+		// None of the NSEC3s of the proof covers this QNAME
+		{"deep.n4.dev.example.org.", "dev.example.org.", "n4.dev.example.org."},
+	} {
+		match, cover, wildcard := proof(tc.name, tc.encloser, tc.next)
+		if match == nil || cover == nil || wildcard == nil {
+			t.Fatalf("%s: incomplete NSEC3 chain", tc.name)
+		}
+		if tc.name == "deep.n4.dev.example.org." {
+			if q := chain.find(tc.name, false); q == match || q == cover || q == wildcard {
+				t.Fatalf("%s: the QNAME is covered by the proof", tc.name)
+			}
+		}
+		keys := []*dns.DNSKEY{key.key}
+
+		if optout, err := verifyDenial(response(match, cover, wildcard), tc.name, dns.TypeA, keys, now); err != nil || optout {
+			t.Errorf("%s: closest encloser proof gives %v (opt-out %t), expected a secure denial", tc.name, err, optout)
+		}
+
+		// The QNAME itself does not need to be covered, but each part of
+		// the proof is needed, unless given by the same NSEC3 as another
+		if _, err := verifyDenial(response(match, wildcard), tc.name, dns.TypeA, keys, now); err == nil && cover != match && cover != wildcard {
+			t.Errorf("%s: denial without a cover of the next closer name succeeded", tc.name)
+		}
+		if _, err := verifyDenial(response(cover, wildcard), tc.name, dns.TypeA, keys, now); err == nil && match != cover && match != wildcard {
+			t.Errorf("%s: denial without the closest encloser succeeded", tc.name)
+		}
+		if _, err := verifyDenial(response(match, cover), tc.name, dns.TypeA, keys, now); err == nil && wildcard != match && wildcard != cover {
+			t.Errorf("%s: denial without a cover of the wildcard succeeded", tc.name)
+		}
+
+		// Opt-out covering the next closer name makes the denial insecure
+		optoutCover := *cover
+		optoutCover.Flags = 1
+		if optout, err := verifyDenial(response(match, &optoutCover, wildcard), tc.name, dns.TypeA, keys, now); err != nil || !optout {
+			t.Errorf("%s: opt-out cover gives %v (opt-out %t), expected an insecure denial", tc.name, err, optout)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+	"golang.org/x/time/rate"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+)
+
+const (
+	// resolverLimitersPrune is the interval between two cleanups of the
+	// rate limiters of IPs not seen recently.
+	resolverLimitersPrune = time.Minute
+
+	// resolverCacheSize bounds the number of responses kept in cache.
+	resolverCacheSize = 1024
+)
+
+// resolverAllowed checks that users can make the resolver tool query ip:
+// allowed networks first, then denied ones.
+func resolverAllowed(opts *config.Options, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if opts.ResolverAllowedNetworks.Contains(ip) {
+		return true
+	}
+	if opts.ResolverDeniedNetworks.Contains(ip) {
+		return false
+	}
+	return len(opts.ResolverAllowedNetworks) == 0
+}
+
+// resolveCustomResolver checks the custom resolver given by a user and
+// returns the IP to query. Each address of a host name has to be allowed,
+// and the query goes to the checked one.
+func resolveCustomResolver(ctx context.Context, opts *config.Options, host string) (string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if !resolverAllowed(opts, ip) {
+			return "", fmt.Errorf("The resolver %s is not allowed.", host)
+		}
+		return host, nil
+	}
+
+	lctx, cancel := context.WithTimeout(ctx, resolverTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(lctx, host)
+	if err != nil || len(addrs) == 0 {
+		return "", fmt.Errorf("Unable to find the address of the resolver %s.", host)
+	}
+
+	for _, addr := range addrs {
+		if !resolverAllowed(opts, addr.IP) {
+			return "", fmt.Errorf("The resolver %s is not allowed.", host)
+		}
+	}
+
+	return addrs[0].IP.String(), nil
+}
+
+// resolverCost is the number of queries that can be made to answer urr.
+func resolverCost(urr *resolverRequest) int {
+	if urr.Trace {
+		return traceMaxQueries
+	}
+
+	cost := 1
+	if urr.Type == "ANY" {
+		cost = len(RRToAskForANY)
+	}
+	if urr.Validate {
+		cost += validatorMaxQueries
+	}
+	return cost
+}
+
+type resolverLimiter struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// resolverLimiters holds a rate limiter per client IP.
+type resolverLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*resolverLimiter
+	pruned   time.Time
+}
+
+// allow accounts n queries for ip, allowing perMinute queries per minute.
+func (l *resolverLimiters) allow(ip string, n int, perMinute int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	// A limiter not used for a minute is full again, as a new one
+	if now.Sub(l.pruned) > resolverLimitersPrune {
+		for k, rl := range l.limiters {
+			if now.Sub(rl.seen) > time.Minute {
+				delete(l.limiters, k)
+			}
+		}
+		l.pruned = now
+	}
+
+	rl, ok := l.limiters[ip]
+	if !ok {
+		rl = &resolverLimiter{limiter: rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), perMinute)}
+		l.limiters[ip] = rl
+	}
+	rl.seen = now
+
+	if n > perMinute {
+		n = perMinute
+	}
+
+	return rl.limiter.AllowN(now, n)
+}
+
+type resolverCacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+// resolverCache keeps the responses of the resolver tool, as sent.
+type resolverCache struct {
+	mu      sync.Mutex
+	entries map[string]resolverCacheEntry
+}
+
+func (rc *resolverCache) get(key string) ([]byte, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	entry, ok := rc.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (rc *resolverCache) set(key string, body []byte, ttl time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	now := time.Now()
+	if len(rc.entries) >= resolverCacheSize {
+		for k, entry := range rc.entries {
+			if now.After(entry.expires) {
+				delete(rc.entries, k)
+			}
+		}
+
+		// Still full, drop any entry
+		for k := range rc.entries {
+			if len(rc.entries) < resolverCacheSize {
+				break
+			}
+			delete(rc.entries, k)
+		}
+	}
+
+	rc.entries[key] = resolverCacheEntry{body: body, expires: now.Add(ttl)}
+}
+
+var (
+	resolverRateLimit = &resolverLimiters{limiters: map[string]*resolverLimiter{}}
+	resolverResponses = &resolverCache{entries: map[string]resolverCacheEntry{}}
+)
+
+// resolverCacheKey identifies the request, once normalized.
+func resolverCacheKey(urr *resolverRequest) string {
+	key, _ := json.Marshal(urr)
+	return string(key)
+}
+
+// resolverTTL is the time the response can be kept in cache: the lowest
+// TTL of its records, bounded by max.
+func resolverTTL(r *dns.Msg, max time.Duration) time.Duration {
+	ttl := max
+	for _, section := range [][]dns.RR{r.Answer, r.Ns} {
+		for _, rr := range section {
+			if d := time.Duration(rr.Header().Ttl) * time.Second; d < ttl {
+				ttl = d
+			}
+		}
+	}
+	return ttl
+}
+
+// respondResolver sends the response, keeping it in cache for as long as
+// the records of r are valid. Nothing is kept without r.
+func respondResolver(opts *config.Options, c *gin.Context, key string, r *dns.Msg, response interface{}) {
+	body, err := json.Marshal(response)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to marshal the resolver response")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to perform the request. Please try again later."})
+		return
+	}
+
+	if opts.ResolverCacheTTL > 0 && r != nil {
+		if ttl := resolverTTL(r, opts.ResolverCacheTTL); ttl > 0 {
+			resolverResponses.set(key, body, ttl)
+		}
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
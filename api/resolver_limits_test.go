@@ -0,0 +1,201 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"git.happydns.org/happydomain/config"
+)
+
+func TestResolverAllowed(t *testing.T) {
+	opts := &config.Options{}
+	if err := opts.ResolverDeniedNetworks.Set("127.0.0.0/8,::1/128,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,64:ff9b::/96"); err != nil {
+		t.Fatalf("invalid denied networks: %s", err.Error())
+	}
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"9.9.9.9", true},
+		{"2620:fe::fe", true},
+		// Loopback
+		{"127.0.0.1", false},
+		{"127.0.0.53", false},
+		{"::1", false},
+		// RFC 1918
+		{"10.0.0.1", false},
+		{"172.20.0.1", false},
+		{"192.168.0.1", false},
+		// NAT64
+		{"64:ff9b::7f00:1", false},
+		{"64:ff9b::909:909", false},
+		// IPv4-mapped
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:192.168.0.1", false},
+		{"::ffff:9.9.9.9", true},
+	}
+
+	for _, test := range tests {
+		if allowed := resolverAllowed(opts, net.ParseIP(test.ip)); allowed != test.allowed {
+			t.Errorf("%s: allowed is %t, expected %t", test.ip, allowed, test.allowed)
+		}
+
+		_, err := resolveCustomResolver(context.Background(), opts, test.ip)
+		if (err == nil) != test.allowed {
+			t.Errorf("%s: resolveCustomResolver returned %v", test.ip, err)
+		}
+	}
+
+	if resolverAllowed(opts, nil) {
+		t.Errorf("an invalid address is allowed")
+	}
+
+	// Allowed networks come first, and deny any other one
+	if err := opts.ResolverAllowedNetworks.Set("10.1.0.0/16"); err != nil {
+		t.Fatalf("invalid allowed networks: %s", err.Error())
+	}
+	for ip, allowed := range map[string]bool{
+		"10.1.2.3":        true,
+		"::ffff:10.1.2.3": true,
+		"10.2.0.1":        false,
+		"9.9.9.9":         false,
+	} {
+		if resolverAllowed(opts, net.ParseIP(ip)) != allowed {
+			t.Errorf("with allowed networks, %s: expected allowed to be %t", ip, allowed)
+		}
+	}
+}
+
+func TestResolverLocalPort(t *testing.T) {
+	opts := &config.Options{}
+
+	body, _ := json.Marshal(resolverRequest{Resolver: "local", Port: 8080, DomainName: "example.com", Type: "A"})
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/api/resolver", bytes.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	runResolver(opts, c)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, expected %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestResolverCost(t *testing.T) {
+	for _, test := range []struct {
+		urr  resolverRequest
+		cost int
+	}{
+		{resolverRequest{Type: "A"}, 1},
+		{resolverRequest{Type: "ANY"}, len(RRToAskForANY)},
+		{resolverRequest{Type: "A", Validate: true}, 1 + validatorMaxQueries},
+		{resolverRequest{Type: "A", Trace: true}, traceMaxQueries},
+		{resolverRequest{Type: "A", Trace: true, Validate: true}, traceMaxQueries},
+	} {
+		if cost := resolverCost(&test.urr); cost != test.cost {
+			t.Errorf("%+v: cost is %d, expected %d", test.urr, cost, test.cost)
+		}
+	}
+}
+
+func TestResolverLimiters(t *testing.T) {
+	l := &resolverLimiters{limiters: map[string]*resolverLimiter{}}
+
+	if !l.allow("192.0.2.1", 8, 10) {
+		t.Fatalf("first queries are denied")
+	}
+	if l.allow("192.0.2.1", 8, 10) {
+		t.Errorf("queries over the limit are allowed")
+	}
+	if !l.allow("192.0.2.1", 2, 10) {
+		t.Errorf("queries under the limit are denied")
+	}
+
+	// Each IP has its own limit
+	if !l.allow("192.0.2.2", 10, 10) {
+		t.Errorf("queries of another IP are denied")
+	}
+
+	// A request costing more than the limit is allowed on a full limiter
+	if !l.allow("192.0.2.3", traceMaxQueries, 10) {
+		t.Errorf("a costly request is never allowed")
+	}
+
+	// Limiters not seen for a while are pruned
+	l.pruned = time.Now().Add(-2 * resolverLimitersPrune)
+	l.limiters["192.0.2.1"].seen = time.Now().Add(-2 * time.Minute)
+	l.allow("192.0.2.4", 1, 10)
+	if _, ok := l.limiters["192.0.2.1"]; ok {
+		t.Errorf("limiter of an IP not seen recently is not pruned")
+	}
+	if _, ok := l.limiters["192.0.2.2"]; !ok {
+		t.Errorf("limiter of an IP seen recently is pruned")
+	}
+}
+
+func TestResolverCache(t *testing.T) {
+	rc := &resolverCache{entries: map[string]resolverCacheEntry{}}
+
+	rc.set("a", []byte("response"), time.Minute)
+	if body, ok := rc.get("a"); !ok || string(body) != "response" {
+		t.Errorf("got %q (%t), expected the cached response", body, ok)
+	}
+	if _, ok := rc.get("b"); ok {
+		t.Errorf("got a response not cached")
+	}
+
+	rc.set("expired", []byte("old"), -time.Second)
+	if _, ok := rc.get("expired"); ok {
+		t.Errorf("got an expired response")
+	}
+
+	// The cache is bounded
+	for i := 0; i < resolverCacheSize+10; i++ {
+		rc.set(string(rune(i+1000)), []byte("x"), time.Minute)
+	}
+	if len(rc.entries) > resolverCacheSize {
+		t.Errorf("%d entries in cache, expected at most %d", len(rc.entries), resolverCacheSize)
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// traceMaxQueries bounds the number of queries made by a trace,
+	// including the ones resolving name servers addresses.
+	traceMaxQueries = 64
+
+	// traceMaxServers is the number of servers tried for a zone, before
+	// giving up.
+	traceMaxServers = 3
+
+	// traceMaxDepth bounds the nested resolutions of name servers
+	// addresses, when glue records are missing.
+	traceMaxDepth = 2
+
+	// traceTimeout bounds each query made by a trace.
+	traceTimeout = 2 * time.Second
+)
+
+// errTraceBudget is returned once a tracer made all the queries it is
+// allowed to.
+var errTraceBudget = errors.New("too many queries")
+
+// traceStep is a query made while tracing a resolution from the root.
+type traceStep struct {
+	// Zone is the zone the queried server is expected to serve.
+	Zone string `json:"zone"`
+
+	// Server is the name of the queried server.
+	Server string `json:"server"`
+
+	// Address is the IP the query has been sent to.
+	Address string `json:"address"`
+
+	// RTT is the time taken by the server to answer, in milliseconds.
+	RTT float64 `json:"rtt_ms"`
+
+	// Rcode is the response code of the server.
+	Rcode string `json:"rcode,omitempty"`
+
+	// Referral are the NS records given by the server to go further.
+	Referral []dns.RR `json:"referral,omitempty"`
+
+	// Glue are the addresses of the referred name servers.
+	Glue []dns.RR `json:"glue,omitempty"`
+
+	// Answer are the records answered by the server.
+	Answer []dns.RR `json:"answer,omitempty"`
+
+	// Error explains why the server didn't answer.
+	Error string `json:"error,omitempty"`
+}
+
+// resolverTrace is the response to a traced resolution: the final
+// response, when there is one, and each step down to it.
+type resolverTrace struct {
+	*dns.Msg
+	Trace []traceStep `json:"trace"`
+	Error string      `json:"error,omitempty"`
+}
+
+type traceServer struct {
+	name  string
+	addrs []string
+
+	// trusted servers come from the configuration, they are not checked
+	// against the denied networks.
+	trusted bool
+}
+
+// tracer resolves names iteratively, from the root servers, keeping each
+// step of the resolution.
+type tracer struct {
+	udp     *dns.Client
+	tcp     *dns.Client
+	roots   []traceServer
+	allowed func(net.IP) bool
+	steps   []traceStep
+
+	// queries is the number of queries made, up to maxQueries.
+	queries    int
+	maxQueries int
+
+	// port is the port every server is queried on.
+	port string
+}
+
+// newTracer prepares a tracer starting from the given root hints. Servers
+// found along the way are only queried on addresses passing allowed, on the
+// standard DNS port.
+func newTracer(hints []dns.RR, allowed func(net.IP) bool) *tracer {
+	roots := traceServers(hints, hints)
+	for i := range roots {
+		roots[i].trusted = true
+	}
+
+	return &tracer{
+		udp:        &dns.Client{Net: "udp", Timeout: traceTimeout},
+		tcp:        &dns.Client{Net: "tcp", Timeout: traceTimeout},
+		roots:      roots,
+		allowed:    allowed,
+		maxQueries: traceMaxQueries,
+		port:       "53",
+	}
+}
+
+// traceServers lists the servers designated by the NS records, with their
+// addresses found among glue.
+func traceServers(nss []dns.RR, glue []dns.RR) (servers []traceServer) {
+	for _, rr := range nss {
+		ns, ok := rr.(*dns.NS)
+		if !ok {
+			continue
+		}
+
+		server := traceServer{name: ns.Ns}
+
+		// IPv4 first, as IPv6 connectivity is less common
+		for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			for _, g := range glue {
+				if g.Header().Rrtype != rrtype || !strings.EqualFold(g.Header().Name, ns.Ns) {
+					continue
+				}
+				switch g := g.(type) {
+				case *dns.A:
+					server.addrs = append(server.addrs, g.A.String())
+				case *dns.AAAA:
+					server.addrs = append(server.addrs, g.AAAA.String())
+				}
+			}
+		}
+
+		servers = append(servers, server)
+	}
+
+	return
+}
+
+// addresses returns the addresses of server that can be queried, resolving
+// them when there is no glue.
+func (t *tracer) addresses(server traceServer, depth int) ([]string, error) {
+	addrs := server.addrs
+	if len(addrs) == 0 {
+		var err error
+		if addrs, err = t.lookupAddrs(server.name, depth); err != nil {
+			return nil, fmt.Errorf("unable to find the address of %s: %w", server.name, err)
+		}
+	}
+
+	if server.trusted {
+		return addrs, nil
+	}
+
+	var allowed []string
+	for _, addr := range addrs {
+		if t.allowed(net.ParseIP(addr)) {
+			allowed = append(allowed, addr)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, fmt.Errorf("the addresses of %s are not allowed to be queried", server.name)
+	}
+	return allowed, nil
+}
+
+// query sends a non-recursive query to the given address, retrying over TCP
+// when the response is truncated.
+func (t *tracer) query(addr string, name string, qtype uint16) (*dns.Msg, time.Duration, error) {
+	if t.queries >= t.maxQueries {
+		return nil, 0, fmt.Errorf("%w, giving up after %d", errTraceBudget, t.maxQueries)
+	}
+	t.queries++
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = false
+	m.SetEdns0(4096, true)
+
+	r, rtt, err := t.udp.Exchange(m, net.JoinHostPort(addr, t.port))
+	if err == nil && r.Truncated {
+		r, rtt, err = t.tcp.Exchange(m, net.JoinHostPort(addr, t.port))
+	}
+
+	return r, rtt, err
+}
+
+// ask queries the servers of a zone, until one gives a usable response.
+func (t *tracer) ask(zone string, servers []traceServer, name string, qtype uint16, depth int, record bool) (*dns.Msg, error) {
+	var lastErr error
+	tried := 0
+	for _, server := range servers {
+		if tried >= traceMaxServers {
+			break
+		}
+
+		step := traceStep{
+			Zone:   zone,
+			Server: server.name,
+		}
+
+		addrs, err := t.addresses(server, depth+1)
+		if err != nil {
+			lastErr = err
+			step.Error = err.Error()
+			if record {
+				t.steps = append(t.steps, step)
+			}
+			continue
+		}
+		tried++
+
+		step.Address = addrs[0]
+		r, rtt, err := t.query(step.Address, name, qtype)
+		step.RTT = float64(rtt.Microseconds()) / 1000
+		if err != nil {
+			lastErr = err
+			step.Error = err.Error()
+		} else {
+			step.Rcode = dns.RcodeToString[r.Rcode]
+			step.Answer = r.Answer
+			if len(r.Answer) == 0 {
+				for _, rr := range r.Ns {
+					if rr.Header().Rrtype == dns.TypeNS {
+						step.Referral = append(step.Referral, rr)
+					}
+				}
+				for _, rr := range r.Extra {
+					if rr.Header().Rrtype == dns.TypeA || rr.Header().Rrtype == dns.TypeAAAA {
+						step.Glue = append(step.Glue, rr)
+					}
+				}
+			}
+		}
+
+		if record {
+			t.steps = append(t.steps, step)
+		}
+
+		// Lame servers are skipped
+		if err == nil && r.Rcode != dns.RcodeRefused && r.Rcode != dns.RcodeServerFailure {
+			return r, nil
+		} else if err == nil {
+			lastErr = fmt.Errorf("%s answers %s", server.name, step.Rcode)
+		}
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("no server of %s gives a usable response: %w", zone, lastErr)
+	}
+	return nil, fmt.Errorf("no server of %s gives a usable response", zone)
+}
+
+// resolve follows the referrals from the root down to the response about
+// name.
+func (t *tracer) resolve(name string, qtype uint16, depth int, record bool) (*dns.Msg, error) {
+	name = dns.Fqdn(name)
+	zone := "."
+	servers := t.roots
+
+	for {
+		r, err := t.ask(zone, servers, name, qtype, depth, record)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(r.Answer) > 0 || r.Authoritative || r.Rcode != dns.RcodeSuccess {
+			return r, nil
+		}
+
+		var cut string
+		var nss []dns.RR
+		for _, rr := range r.Ns {
+			if rr.Header().Rrtype == dns.TypeNS {
+				cut = rr.Header().Name
+				nss = append(nss, rr)
+			}
+		}
+
+		// Neither an answer nor a referral
+		if len(nss) == 0 {
+			return r, nil
+		}
+
+		if !dns.IsSubDomain(zone, cut) || dns.CountLabel(cut) <= dns.CountLabel(zone) || !dns.IsSubDomain(cut, name) {
+			return r, fmt.Errorf("servers of %s give an invalid referral to %s", zone, cut)
+		}
+
+		zone = cut
+		servers = traceServers(nss, r.Extra)
+	}
+}
+
+// lookupAddrs resolves the addresses of a name server without glue.
+func (t *tracer) lookupAddrs(name string, depth int) ([]string, error) {
+	if depth > traceMaxDepth {
+		return nil, fmt.Errorf("too many nested resolutions")
+	}
+
+	var addrs []string
+	for _, rrtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		r, err := t.resolve(name, rrtype, depth, false)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rr := range r.Answer {
+			switch rr := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, rr.A.String())
+			case *dns.AAAA:
+				addrs = append(addrs, rr.AAAA.String())
+			}
+		}
+
+		if len(addrs) > 0 {
+			return addrs, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s has no address", name)
+}
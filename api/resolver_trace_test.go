@@ -0,0 +1,354 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// fakeServer answers as an authoritative server of zones, giving referrals
+// for the delegations found among rrs.
+type fakeServer struct {
+	zones []string
+	rrs   []dns.RR
+}
+
+func newFakeServer(t *testing.T, zones []string, records ...string) *fakeServer {
+	s := &fakeServer{zones: zones}
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("invalid record %q: %s", record, err.Error())
+		}
+		s.rrs = append(s.rrs, rr)
+	}
+	return s
+}
+
+func (s *fakeServer) isApex(name string) bool {
+	for _, zone := range s.zones {
+		if strings.EqualFold(zone, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	q := r.Question[0]
+
+	// Delegations, the closest to the name being used
+	var cut string
+	for _, rr := range s.rrs {
+		name := rr.Header().Name
+		if rr.Header().Rrtype == dns.TypeNS && !s.isApex(name) && dns.IsSubDomain(name, q.Name) && (cut == "" || dns.CountLabel(name) > dns.CountLabel(cut)) {
+			cut = name
+		}
+	}
+	if cut != "" {
+		for _, rr := range s.rrs {
+			if rr.Header().Rrtype == dns.TypeNS && strings.EqualFold(rr.Header().Name, cut) {
+				m.Ns = append(m.Ns, rr)
+				for _, glue := range s.rrs {
+					if glue.Header().Rrtype == dns.TypeA && strings.EqualFold(glue.Header().Name, rr.(*dns.NS).Ns) {
+						m.Extra = append(m.Extra, glue)
+					}
+				}
+			}
+		}
+		w.WriteMsg(m)
+		return
+	}
+
+	for _, zone := range s.zones {
+		if !dns.IsSubDomain(zone, q.Name) {
+			continue
+		}
+
+		m.Authoritative = true
+		exists := false
+		for _, rr := range s.rrs {
+			if strings.EqualFold(rr.Header().Name, q.Name) {
+				exists = true
+				if rr.Header().Rrtype == q.Qtype {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+		}
+		if !exists {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+		return
+	}
+
+	m.Rcode = dns.RcodeRefused
+	w.WriteMsg(m)
+}
+
+// startFakeServers serves each handler on its own loopback address, all on
+// the same port, which is returned.
+func startFakeServers(t *testing.T, handlers map[string]dns.Handler) string {
+	for attempt := 0; attempt < 5; attempt++ {
+		var conns []net.PacketConn
+		port := "0"
+		for addr := range handlers {
+			conn, err := net.ListenPacket("udp", net.JoinHostPort(addr, port))
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+			port = strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port)
+		}
+
+		if len(conns) != len(handlers) {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			continue
+		}
+
+		for _, conn := range conns {
+			addr := conn.LocalAddr().(*net.UDPAddr).IP.String()
+			srv := &dns.Server{PacketConn: conn, Handler: handlers[addr]}
+			started := make(chan bool)
+			srv.NotifyStartedFunc = func() { close(started) }
+			go srv.ActivateAndServe()
+			<-started
+			t.Cleanup(func() { srv.Shutdown() })
+		}
+
+		return port
+	}
+
+	t.Skip("unable to listen on the same port of several loopback addresses")
+	return ""
+}
+
+// newTestTracer starts a fake hierarchy: a root server on 127.0.0.1, the
+// com. and net. servers on 127.0.0.2 and an authoritative server of several
+// domains on 127.0.0.3.
+func newTestTracer(t *testing.T) *tracer {
+	root := newFakeServer(t, []string{"."},
+		"com. 86400 IN NS ns.nic.com.",
+		"net. 86400 IN NS ns.nic.com.",
+		"ns.nic.com. 86400 IN A 127.0.0.2",
+	)
+
+	tld := newFakeServer(t, []string{"com.", "net."},
+		"example.com. 3600 IN NS ns1.example.com.",
+		"ns1.example.com. 3600 IN A 127.0.0.3",
+		"glueless.com. 3600 IN NS ns.example.net.",
+		"example.net. 3600 IN NS ns1.example.com.",
+		"chain1.com. 3600 IN NS ns.chain2.com.",
+		"chain2.com. 3600 IN NS ns.chain3.com.",
+		"chain3.com. 3600 IN NS ns.chain4.com.",
+		"chain4.com. 3600 IN NS ns1.example.com.",
+		"denied.com. 3600 IN NS ns.denied.com.",
+		"ns.denied.com. 3600 IN A 10.0.0.1",
+	)
+
+	// Referrals going sideways are invalid
+	tldMux := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if dns.IsSubDomain("invalid.com.", r.Question[0].Name) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			ns, _ := dns.NewRR("org. 3600 IN NS ns.nic.com.")
+			m.Ns = []dns.RR{ns}
+			w.WriteMsg(m)
+			return
+		}
+		tld.ServeDNS(w, r)
+	})
+
+	auth := newFakeServer(t, []string{"example.com.", "glueless.com.", "example.net.", "chain1.com.", "chain2.com.", "chain3.com.", "chain4.com."},
+		"www.example.com. 300 IN A 192.0.2.1",
+		"ns1.example.com. 300 IN A 127.0.0.3",
+		"www.glueless.com. 300 IN A 192.0.2.2",
+		"ns.example.net. 300 IN A 127.0.0.3",
+		"www.chain1.com. 300 IN A 192.0.2.3",
+		"ns.chain2.com. 300 IN A 127.0.0.3",
+		"ns.chain3.com. 300 IN A 127.0.0.3",
+		"ns.chain4.com. 300 IN A 127.0.0.3",
+	)
+
+	port := startFakeServers(t, map[string]dns.Handler{
+		"127.0.0.1": root,
+		"127.0.0.2": tldMux,
+		"127.0.0.3": auth,
+	})
+
+	var hints []dns.RR
+	for _, record := range []string{". 3600000 IN NS a.root.test.", "a.root.test. 3600000 IN A 127.0.0.1"} {
+		rr, _ := dns.NewRR(record)
+		hints = append(hints, rr)
+	}
+
+	tr := newTracer(hints, func(ip net.IP) bool {
+		return ip.IsLoopback()
+	})
+	tr.port = port
+
+	return tr
+}
+
+func TestTraceReferrals(t *testing.T) {
+	tr := newTestTracer(t)
+
+	r, err := tr.resolve("www.example.com", dns.TypeA, 0, true)
+	if err != nil {
+		t.Fatalf("resolve: %s", err.Error())
+	}
+
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.0.2.1" {
+		t.Errorf("final answer is %v, expected www.example.com. A 192.0.2.1", r.Answer)
+	}
+
+	expected := []struct {
+		zone, server, address string
+		referral, glue        int
+		answer                int
+	}{
+		{".", "a.root.test.", "127.0.0.1", 1, 1, 0},
+		{"com.", "ns.nic.com.", "127.0.0.2", 1, 1, 0},
+		{"example.com.", "ns1.example.com.", "127.0.0.3", 0, 0, 1},
+	}
+
+	if len(tr.steps) != len(expected) {
+		t.Fatalf("trace has %d steps, expected %d: %v", len(tr.steps), len(expected), tr.steps)
+	}
+
+	for i, step := range tr.steps {
+		exp := expected[i]
+		if step.Zone != exp.zone || step.Server != exp.server || step.Address != exp.address {
+			t.Errorf("step #%d asks %s (%s) for %s, expected %s (%s) for %s", i, step.Server, step.Address, step.Zone, exp.server, exp.address, exp.zone)
+		}
+		if len(step.Referral) != exp.referral || len(step.Glue) != exp.glue || len(step.Answer) != exp.answer {
+			t.Errorf("step #%d has %d referrals, %d glue and %d answers, expected %d, %d and %d", i, len(step.Referral), len(step.Glue), len(step.Answer), exp.referral, exp.glue, exp.answer)
+		}
+		if step.Rcode != "NOERROR" || step.Error != "" {
+			t.Errorf("step #%d has rcode %q and error %q", i, step.Rcode, step.Error)
+		}
+	}
+
+	if tr.queries != 3 {
+		t.Errorf("trace made %d queries, expected 3", tr.queries)
+	}
+}
+
+func TestTraceWithoutGlue(t *testing.T) {
+	tr := newTestTracer(t)
+
+	r, err := tr.resolve("www.glueless.com", dns.TypeA, 0, true)
+	if err != nil {
+		t.Fatalf("resolve: %s", err.Error())
+	}
+
+	if len(r.Answer) != 1 || r.Answer[0].(*dns.A).A.String() != "192.0.2.2" {
+		t.Errorf("final answer is %v, expected www.glueless.com. A 192.0.2.2", r.Answer)
+	}
+
+	// The resolution of the name server is not part of the trace
+	if len(tr.steps) != 3 {
+		t.Fatalf("trace has %d steps, expected 3: %v", len(tr.steps), tr.steps)
+	}
+	if last := tr.steps[2]; last.Server != "ns.example.net." || last.Address != "127.0.0.3" {
+		t.Errorf("last step asks %s (%s), expected ns.example.net. (127.0.0.3)", last.Server, last.Address)
+	}
+	if len(tr.steps[1].Glue) != 0 {
+		t.Errorf("referral to glueless.com. comes with %d glue records, expected none", len(tr.steps[1].Glue))
+	}
+
+	if tr.queries <= 3 {
+		t.Errorf("trace made %d queries, expected more to find the name server address", tr.queries)
+	}
+}
+
+func TestTraceNameError(t *testing.T) {
+	tr := newTestTracer(t)
+
+	r, err := tr.resolve("nonexistent.example.com", dns.TypeA, 0, true)
+	if err != nil {
+		t.Fatalf("resolve: %s", err.Error())
+	}
+
+	if r.Rcode != dns.RcodeNameError || !r.Authoritative {
+		t.Errorf("final response has rcode %s (authoritative: %t), expected an authoritative NXDOMAIN", dns.RcodeToString[r.Rcode], r.Authoritative)
+	}
+	if len(tr.steps) != 3 || tr.steps[2].Rcode != "NXDOMAIN" {
+		t.Errorf("trace doesn't end with NXDOMAIN: %v", tr.steps)
+	}
+}
+
+func TestTraceFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		queries int
+		err     string
+		step    string
+	}{
+		{name: "www.chain1.com", err: "too many nested resolutions", step: "unable to find the address of ns.chain2.com."},
+		{name: "www.denied.com", err: "no server of denied.com.", step: "not allowed"},
+		{name: "www.invalid.com", err: "invalid referral to org."},
+		{name: "www.example.com", queries: traceMaxQueries - 1, err: "too many queries"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := newTestTracer(t)
+			tr.queries = tc.queries
+
+			_, err := tr.resolve(tc.name, dns.TypeA, 0, true)
+			if err == nil {
+				t.Fatalf("resolve succeeds, expected an error containing %q", tc.err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("resolve fails with %q, expected an error containing %q", err.Error(), tc.err)
+			}
+
+			if tc.step != "" {
+				if len(tr.steps) == 0 || !strings.Contains(tr.steps[len(tr.steps)-1].Error, tc.step) {
+					t.Errorf("last step doesn't explain the failure with %q: %v", tc.step, tr.steps)
+				}
+			}
+
+			if tr.queries > traceMaxQueries {
+				t.Errorf("trace made %d queries, more than %d", tr.queries, traceMaxQueries)
+			}
+		})
+	}
+}
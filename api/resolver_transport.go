@@ -0,0 +1,191 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// resolverTimeout bounds each query made by the resolver tool.
+	resolverTimeout = 5 * time.Second
+
+	// dohContentType is the media type of DNS messages carried over HTTPS
+	// (RFC 8484).
+	dohContentType = "application/dns-message"
+)
+
+// resolverExchanger sends a DNS query to a resolver, over a given transport.
+type resolverExchanger interface {
+	Exchange(m *dns.Msg) (*dns.Msg, error)
+}
+
+// clientExchanger uses a dns.Client, for UDP, TCP and TLS transports.
+type clientExchanger struct {
+	client  *dns.Client
+	address string
+}
+
+func (e *clientExchanger) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	r, _, err := e.client.Exchange(m, e.address)
+	return r, err
+}
+
+// dohExchanger sends DNS-over-HTTPS queries, as described in RFC 8484.
+type dohExchanger struct {
+	client *http.Client
+	url    string
+	method string
+}
+
+func (e *dohExchanger) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	// A zero ID makes GET requests cacheable
+	q := m.Copy()
+	q.Id = 0
+
+	wire, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	if e.method == http.MethodGet {
+		u, err := url.Parse(e.url)
+		if err != nil {
+			return nil, err
+		}
+		values := u.Query()
+		values.Set("dns", base64.RawURLEncoding.EncodeToString(wire))
+		u.RawQuery = values.Encode()
+
+		req, err = http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		req, err = http.NewRequest(http.MethodPost, e.url, bytes.NewReader(wire))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", dohContentType)
+	}
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returns %s", resp.Status)
+	} else if ct := resp.Header.Get("Content-Type"); ct != dohContentType {
+		return nil, fmt.Errorf("DoH server returns unexpected content type %q", ct)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(dns.Msg)
+	if err = r.Unpack(body); err != nil {
+		return nil, err
+	}
+	r.Id = m.Id
+
+	return r, nil
+}
+
+// newResolverExchanger prepares the transport to the given resolver. The
+// port is the default one of the transport when 0.
+func newResolverExchanger(transport string, address string, tlsName string, dohURL string, port uint16, method string) (resolverExchanger, error) {
+	switch transport {
+	case "", "udp", "tcp":
+		if port == 0 {
+			port = 53
+		}
+		return &clientExchanger{
+			client:  &dns.Client{Net: transport, Timeout: resolverTimeout},
+			address: net.JoinHostPort(address, strconv.Itoa(int(port))),
+		}, nil
+	case "tls":
+		if tlsName == "" {
+			return nil, fmt.Errorf("this resolver doesn't support DNS-over-TLS")
+		}
+		if port == 0 {
+			port = 853
+		}
+		return &clientExchanger{
+			client: &dns.Client{
+				Net:       "tcp-tls",
+				Timeout:   resolverTimeout,
+				TLSConfig: &tls.Config{ServerName: tlsName},
+			},
+			address: net.JoinHostPort(address, strconv.Itoa(int(port))),
+		}, nil
+	case "https":
+		if dohURL == "" {
+			return nil, fmt.Errorf("this resolver doesn't support DNS-over-HTTPS")
+		}
+		if method != "" && method != http.MethodGet && method != http.MethodPost {
+			return nil, fmt.Errorf("unsupported DNS-over-HTTPS method %q", method)
+		}
+
+		u, err := url.Parse(dohURL)
+		if err != nil {
+			return nil, err
+		}
+		if port != 0 {
+			u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(int(port)))
+		}
+
+		return &dohExchanger{
+			client: &http.Client{Timeout: resolverTimeout},
+			url:    u.String(),
+			method: method,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q, expected udp, tcp, tls or https", transport)
+	}
+}
@@ -0,0 +1,435 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/services/abstract"
+	"git.happydns.org/happydomain/storage"
+	"git.happydns.org/happydomain/utils"
+)
+
+// reverseZone is a reverse zone managed by the user, with the revision being
+// edited.
+type reverseZone struct {
+	domain  *happydns.Domain
+	network *net.IPNet
+	zone    *happydns.Zone
+	changed bool
+}
+
+// loadReverseZones returns the user's reverse zones which have been imported.
+func loadReverseZones(user *happydns.User) (zones []*reverseZone, err error) {
+	domains, err := storage.MainStore.GetDomains(user)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, domain := range domains {
+		if !utils.IsReverseZone(domain.DomainName) || len(domain.ZoneHistory) == 0 {
+			continue
+		}
+
+		network, err := utils.ReverseZoneNetwork(domain.DomainName)
+		if err != nil {
+			continue
+		}
+
+		zone, err := storage.MainStore.GetZone(domain.ZoneHistory[0])
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve the zone of %s: %w", domain.DomainName, err)
+		}
+
+		zones = append(zones, &reverseZone{domain: domain, network: network, zone: zone})
+	}
+
+	return
+}
+
+// findReverseZone returns the most specific zone covering ip.
+func findReverseZone(zones []*reverseZone, ip net.IP) (found *reverseZone) {
+	var best int
+	for _, rz := range zones {
+		if ones, _ := rz.network.Mask.Size(); rz.network.Contains(ip) && (found == nil || ones > best) {
+			found, best = rz, ones
+		}
+	}
+	return
+}
+
+// pointer returns the name of the PTR record of ip in the zone, relative to
+// it.
+func (rz *reverseZone) pointer(ip net.IP) (string, error) {
+	owner, err := utils.ReverseOwner(ip, rz.domain.DomainName)
+	if err != nil {
+		return "", err
+	}
+	return relativeSubdomain(owner, rz.domain), nil
+}
+
+// serverAddresses returns the addresses of a Server service.
+func serverAddresses(svc happydns.Service) (ips []net.IP) {
+	if s, ok := svc.(*abstract.Server); ok && s != nil {
+		if s.A != nil && len(*s.A) != 0 {
+			ips = append(ips, *s.A)
+		}
+		if s.AAAA != nil && len(*s.AAAA) != 0 {
+			ips = append(ips, *s.AAAA)
+		}
+	}
+	return
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncReversePointers follows the change of a Server service, from old to
+// new (any of them being nil on creation or removal), in the reverse zones
+// managed by the user: PTR records pointing to the server are added for its
+// new addresses, and removed from the addresses it left. Existing PTR records
+// pointing elsewhere are kept; they appear in the mismatches report. The
+// reverse zones are changed in the revision being edited, so that the records
+// are only proposed until the reverse zone is published. The reverse zones
+// that could not be updated are reported in a *reverseSyncError.
+func syncReversePointers(c *gin.Context, domain *happydns.Domain, subdomain string, old, new happydns.Service) error {
+	oldIPs := serverAddresses(old)
+	newIPs := serverAddresses(new)
+
+	user := myUser(c)
+	if user == nil || utils.IsReverseZone(domain.DomainName) || (len(oldIPs) == 0 && len(newIPs) == 0) {
+		return nil
+	}
+
+	zones, err := loadReverseZones(user)
+	if err != nil {
+		return &reverseSyncError{err: err}
+	} else if len(zones) == 0 {
+		return nil
+	}
+
+	target := dns.Fqdn(strings.ToLower(utils.DomainJoin(subdomain, domain.DomainName)))
+
+	for _, ip := range oldIPs {
+		if containsIP(newIPs, ip) {
+			continue
+		}
+
+		rz := findReverseZone(zones, ip)
+		if rz == nil {
+			continue
+		}
+
+		sub, err := rz.pointer(ip)
+		if err != nil {
+			continue
+		}
+
+		for _, svc := range rz.zone.Services[sub] {
+			if ptr, ok := svc.Service.(*abstract.PTR); ok && strings.EqualFold(dns.Fqdn(ptr.Target), target) {
+				if err := rz.zone.EraseService(sub, rz.domain.DomainName, svc.Id, nil); err == nil {
+					rz.changed = true
+				}
+				break
+			}
+		}
+	}
+
+	for _, ip := range newIPs {
+		if containsIP(oldIPs, ip) {
+			continue
+		}
+
+		rz := findReverseZone(zones, ip)
+		if rz == nil {
+			continue
+		}
+
+		sub, err := rz.pointer(ip)
+		if err != nil || len(rz.zone.Services[sub]) > 0 {
+			continue
+		}
+
+		err = rz.zone.AppendService(sub, rz.domain.DomainName, &happydns.ServiceCombined{
+			Service: &abstract.PTR{Target: target},
+			ServiceMeta: happydns.ServiceMeta{
+				Type:    "abstract.PTR",
+				OwnerId: user.Id,
+			},
+		})
+		if err != nil {
+			logging.FromContext(c).WithError(err).WithField("address", ip.String()).Warn("unable to add PTR record")
+			continue
+		}
+		rz.changed = true
+	}
+
+	var failed *reverseSyncError
+	for _, rz := range zones {
+		if !rz.changed {
+			continue
+		}
+
+		rz.zone.LastModified = time.Now()
+		recordImpersonation(c, &rz.zone.ZoneMeta)
+		if err := storage.MainStore.UpdateZone(rz.zone); err != nil {
+			if failed == nil {
+				failed = &reverseSyncError{err: err}
+			}
+			failed.Domains = append(failed.Domains, rz.domain.DomainName)
+		}
+	}
+
+	if failed != nil {
+		return failed
+	}
+	return nil
+}
+
+// reverseSyncError is returned by syncReversePointers when some reverse zones
+// could not be updated. Domains is empty when the reverse zones could not even
+// be loaded.
+type reverseSyncError struct {
+	Domains []string
+	err     error
+}
+
+func (e *reverseSyncError) Error() string {
+	if len(e.Domains) == 0 {
+		return fmt.Sprintf("unable to load the reverse zones: %s", e.err.Error())
+	}
+	return fmt.Sprintf("unable to update the reverse zones %s: %s", strings.Join(e.Domains, ", "), e.err.Error())
+}
+
+func (e *reverseSyncError) Unwrap() error {
+	return e.err
+}
+
+// reverseSyncWarning tells that the reverse zones have not followed a change
+// of the forward zone.
+type reverseSyncWarning struct {
+	Message string `json:"message"`
+
+	// Domains are the reverse zones that could not be updated; it is empty
+	// when the reverse zones could not even be loaded.
+	Domains []string `json:"domains,omitempty"`
+}
+
+// zoneWithWarning is a zone sent back along with a warning.
+type zoneWithWarning struct {
+	*happydns.Zone
+	Warning *reverseSyncWarning `json:"warning"`
+}
+
+// reverseSyncFailed answers, once the forward zone has been saved, with the
+// zone and a warning listing the reverse zones that have not followed the
+// change.
+func reverseSyncFailed(c *gin.Context, zone *happydns.Zone, err error) {
+	logging.FromContext(c).WithError(err).Error("unable to sync the reverse pointers")
+
+	warning := &reverseSyncWarning{
+		Message: "Your zone has been updated, but we were unable to update your reverse zones accordingly. Please check the reverse mismatches report.",
+	}
+	if e, ok := err.(*reverseSyncError); ok && len(e.Domains) > 0 {
+		warning.Message = fmt.Sprintf("Your zone has been updated, but we were unable to update the PTR records of %s accordingly. Please check the reverse mismatches report.", strings.Join(e.Domains, ", "))
+		warning.Domains = e.Domains
+	}
+
+	c.JSON(http.StatusOK, zoneWithWarning{Zone: zone, Warning: warning})
+}
+
+type reverseMismatch struct {
+	// Address is the IP address concerned.
+	Address string `json:"address"`
+
+	// Name is the forward name involved, if any.
+	Name string `json:"name,omitempty"`
+
+	// Pointer is the name of the PTR record of the address.
+	Pointer string `json:"pointer"`
+
+	// Domain is the domain to change to fix the mismatch.
+	Domain string `json:"domain"`
+
+	Message string `json:"message"`
+}
+
+// getReverseMismatches compares, in the zones being edited, the addresses of
+// the Server services of the user's domains with the PTR records of their
+// reverse zones. Names outside of the user's domains cannot be checked.
+func getReverseMismatches(c *gin.Context) {
+	user := c.MustGet("LoggedUser").(*happydns.User)
+
+	domains, err := storage.MainStore.GetDomains(user)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to GetDomains")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to perform this action. Please retry later."})
+		return
+	}
+
+	zones, err := loadReverseZones(user)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to load the reverse zones")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to perform this action. Please retry later."})
+		return
+	}
+
+	// Addresses of each forward name, and the domains they belong to
+	forward := map[string][]net.IP{}
+	var origins []string
+	for _, domain := range domains {
+		if utils.IsReverseZone(domain.DomainName) || len(domain.ZoneHistory) == 0 {
+			continue
+		}
+
+		zone, err := storage.MainStore.GetZone(domain.ZoneHistory[0])
+		if err != nil {
+			logging.FromContext(c).WithError(err).WithField("domain", domain.DomainName).Error("unable to GetZone")
+			continue
+		}
+
+		origins = append(origins, dns.Fqdn(strings.ToLower(domain.DomainName)))
+		for subdomain, svcs := range zone.Services {
+			name := dns.Fqdn(strings.ToLower(utils.DomainJoin(subdomain, domain.DomainName)))
+			for _, svc := range svcs {
+				forward[name] = append(forward[name], serverAddresses(svc.Service)...)
+			}
+		}
+	}
+
+	managed := func(name string) bool {
+		for _, origin := range origins {
+			if dns.IsSubDomain(origin, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ret := []reverseMismatch{}
+
+	// Addresses of managed names without PTR record
+	for name, ips := range forward {
+		for _, ip := range ips {
+			rz := findReverseZone(zones, ip)
+			if rz == nil {
+				continue
+			}
+
+			sub, err := rz.pointer(ip)
+			if err != nil {
+				continue
+			}
+
+			var found bool
+			for _, svc := range rz.zone.Services[sub] {
+				if _, ok := svc.Service.(*abstract.PTR); ok {
+					found = true
+				}
+			}
+
+			if !found {
+				ret = append(ret, reverseMismatch{
+					Address: ip.String(),
+					Name:    name,
+					Pointer: utils.DomainJoin(sub, rz.domain.DomainName),
+					Domain:  rz.domain.DomainName,
+					Message: fmt.Sprintf("%s has no PTR record.", ip.String()),
+				})
+			}
+		}
+	}
+
+	// PTR records pointing to managed names not resolving to the address
+	for _, rz := range zones {
+		for sub, svcs := range rz.zone.Services {
+			for _, svc := range svcs {
+				ptr, ok := svc.Service.(*abstract.PTR)
+				if !ok {
+					continue
+				}
+
+				owner := utils.DomainJoin(sub, rz.domain.DomainName)
+				ip, err := utils.ReverseAddress(owner, rz.domain.DomainName)
+				if err != nil {
+					continue
+				}
+
+				target := dns.Fqdn(strings.ToLower(ptr.Target))
+				if !managed(target) || containsIP(forward[target], ip) {
+					continue
+				}
+
+				m := reverseMismatch{
+					Address: ip.String(),
+					Name:    target,
+					Pointer: owner,
+					Domain:  rz.domain.DomainName,
+				}
+				if len(forward[target]) == 0 {
+					m.Message = fmt.Sprintf("%s points to %s, which has no address.", ip.String(), target)
+				} else {
+					m.Message = fmt.Sprintf("%s points to %s, which does not resolve to it.", ip.String(), target)
+				}
+				ret = append(ret, m)
+			}
+		}
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Domain != ret[j].Domain {
+			return ret[i].Domain < ret[j].Domain
+		}
+		if ret[i].Pointer != ret[j].Pointer {
+			return ret[i].Pointer < ret[j].Pointer
+		}
+		return ret[i].Name < ret[j].Name
+	})
+
+	c.JSON(http.StatusOK, ret)
+}
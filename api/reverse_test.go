@@ -0,0 +1,252 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/services/abstract"
+	"git.happydns.org/happydomain/storage"
+	database "git.happydns.org/happydomain/storage/memory"
+)
+
+// failingZoneStore refuses to update one zone.
+type failingZoneStore struct {
+	storage.Storage
+	zone happydns.Identifier
+}
+
+func (s *failingZoneStore) UpdateZone(z *happydns.Zone) error {
+	if bytes.Equal(z.Id, s.zone) {
+		return errors.New("storage unavailable")
+	}
+	return s.Storage.UpdateZone(z)
+}
+
+// newTestDomain stores a domain of user with an empty zone.
+func newTestDomain(t *testing.T, user *happydns.User, name string) *happydns.Domain {
+	zone := &happydns.Zone{
+		ZoneMeta: happydns.ZoneMeta{DefaultTTL: 3600},
+		Services: map[string][]*happydns.ServiceCombined{},
+	}
+	if err := storage.MainStore.CreateZone(zone); err != nil {
+		t.Fatalf("unable to create the zone of %s: %s", name, err.Error())
+	}
+
+	domain := &happydns.Domain{
+		DomainName:  name,
+		ZoneHistory: []happydns.Identifier{zone.Id},
+	}
+	if err := storage.MainStore.CreateDomain(user, domain); err != nil {
+		t.Fatalf("unable to create %s: %s", name, err.Error())
+	}
+
+	return domain
+}
+
+// pointers returns the PTR targets of the zone being edited of domain, by
+// subdomain.
+func pointers(t *testing.T, domain *happydns.Domain) map[string][]string {
+	zone, err := storage.MainStore.GetZone(domain.ZoneHistory[0])
+	if err != nil {
+		t.Fatalf("unable to retrieve the zone of %s: %s", domain.DomainName, err.Error())
+	}
+
+	ret := map[string][]string{}
+	for sub, svcs := range zone.Services {
+		for _, svc := range svcs {
+			if ptr, ok := svc.Service.(*abstract.PTR); ok {
+				ret[sub] = append(ret[sub], ptr.Target)
+			}
+		}
+	}
+	return ret
+}
+
+func checkPointers(t *testing.T, step string, domain *happydns.Domain, expected map[string]string) {
+	got := pointers(t, domain)
+	if len(got) != len(expected) {
+		t.Errorf("%s: got PTR records %v, expected %v", step, got, expected)
+		return
+	}
+	for sub, target := range expected {
+		if len(got[sub]) != 1 || got[sub][0] != target {
+			t.Errorf("%s: got PTR records %v at %q, expected %s", step, got[sub], sub, target)
+		}
+	}
+}
+
+func testServer(ip string) *abstract.Server {
+	addr := net.ParseIP(ip)
+	if addr.To4() != nil {
+		return &abstract.Server{A: &addr}
+	}
+	return &abstract.Server{AAAA: &addr}
+}
+
+func TestSyncReversePointers(t *testing.T) {
+	storage.MainStore = database.NewMemoryStorage()
+
+	user := &happydns.User{Email: "user@example.com"}
+	if err := storage.MainStore.CreateUser(user); err != nil {
+		t.Fatalf("unable to create the user: %s", err.Error())
+	}
+
+	domain := newTestDomain(t, user, "example.com.")
+	reverse := newTestDomain(t, user, "2.0.192.in-addr.arpa.")
+	reverse6 := newTestDomain(t, user, "8.b.d.0.1.0.0.2.ip6.arpa.")
+
+	// A PTR record pointing outside of the user's domains
+	zone, _ := storage.MainStore.GetZone(reverse.ZoneHistory[0])
+	if err := zone.AppendService("10", reverse.DomainName, &happydns.ServiceCombined{
+		Service:     &abstract.PTR{Target: "other.example.org."},
+		ServiceMeta: happydns.ServiceMeta{Type: "abstract.PTR"},
+	}); err != nil {
+		t.Fatalf("unable to add the foreign PTR record: %s", err.Error())
+	}
+	if err := storage.MainStore.UpdateZone(zone); err != nil {
+		t.Fatalf("unable to save the reverse zone: %s", err.Error())
+	}
+	foreign := map[string]string{"10": "other.example.org."}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("PUT", "/", nil)
+	c.Set("LoggedUser", user)
+
+	steps := []struct {
+		name     string
+		old, new happydns.Service
+		expected map[string]string
+	}{
+		{"add", nil, testServer("192.0.2.1"), map[string]string{"1": "www.example.com.", "10": "other.example.org."}},
+		{"move", testServer("192.0.2.1"), testServer("192.0.2.2"), map[string]string{"2": "www.example.com.", "10": "other.example.org."}},
+		{"move onto a foreign PTR", testServer("192.0.2.2"), testServer("192.0.2.10"), foreign},
+		{"remove from a foreign PTR", testServer("192.0.2.10"), nil, foreign},
+		{"add again", nil, testServer("192.0.2.3"), map[string]string{"3": "www.example.com.", "10": "other.example.org."}},
+		{"remove", testServer("192.0.2.3"), nil, foreign},
+	}
+
+	for _, step := range steps {
+		if err := syncReversePointers(c, domain, "www", step.old, step.new); err != nil {
+			t.Fatalf("%s: unexpected error: %s", step.name, err.Error())
+		}
+		checkPointers(t, step.name, reverse, step.expected)
+		checkPointers(t, step.name, reverse6, nil)
+	}
+
+	// IPv6 addresses go to their own reverse zone
+	if err := syncReversePointers(c, domain, "", nil, testServer("2001:db8::1")); err != nil {
+		t.Fatalf("add IPv6: unexpected error: %s", err.Error())
+	}
+	checkPointers(t, "add IPv6", reverse6, map[string]string{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0": "example.com."})
+	checkPointers(t, "add IPv6", reverse, foreign)
+
+	// Addresses outside of the reverse zones are ignored
+	if err := syncReversePointers(c, domain, "www", nil, testServer("198.51.100.1")); err != nil {
+		t.Fatalf("add outside: unexpected error: %s", err.Error())
+	}
+	checkPointers(t, "add outside", reverse, foreign)
+}
+
+func TestSyncReversePointersFailure(t *testing.T) {
+	store := database.NewMemoryStorage()
+	storage.MainStore = store
+
+	user := &happydns.User{Email: "user@example.com"}
+	if err := storage.MainStore.CreateUser(user); err != nil {
+		t.Fatalf("unable to create the user: %s", err.Error())
+	}
+
+	domain := newTestDomain(t, user, "example.com.")
+	reverse := newTestDomain(t, user, "2.0.192.in-addr.arpa.")
+	reverse6 := newTestDomain(t, user, "8.b.d.0.1.0.0.2.ip6.arpa.")
+
+	storage.MainStore = &failingZoneStore{Storage: store, zone: reverse.ZoneHistory[0]}
+	defer func() { storage.MainStore = store }()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("PUT", "/", nil)
+	c.Set("LoggedUser", user)
+
+	srv := testServer("192.0.2.1")
+	addr6 := net.ParseIP("2001:db8::1")
+	srv.AAAA = &addr6
+
+	err := syncReversePointers(c, domain, "www", nil, srv)
+
+	var serr *reverseSyncError
+	if !errors.As(err, &serr) {
+		t.Fatalf("got %v, expected a reverseSyncError", err)
+	}
+	if len(serr.Domains) != 1 || serr.Domains[0] != reverse.DomainName {
+		t.Errorf("got failed reverse zones %v, expected %s", serr.Domains, reverse.DomainName)
+	}
+
+	// The other reverse zone is still updated
+	checkPointers(t, "failure", reverse, nil)
+	checkPointers(t, "failure", reverse6, map[string]string{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0": "www.example.com."})
+
+	// The forward zone is saved, so the request succeeds with a warning
+	zone, err := store.GetZone(domain.ZoneHistory[0])
+	if err != nil {
+		t.Fatalf("unable to retrieve the zone: %s", err.Error())
+	}
+	reverseSyncFailed(c, zone, serr)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, expected %d", w.Code, http.StatusOK)
+	}
+
+	var resp struct {
+		Id      happydns.Identifier `json:"id"`
+		Warning *reverseSyncWarning `json:"warning"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unable to decode the response: %s", err.Error())
+	}
+	if !resp.Id.Equals(zone.Id) {
+		t.Errorf("got zone %s, expected %s", resp.Id.String(), zone.Id.String())
+	}
+	if resp.Warning == nil || resp.Warning.Message == "" || len(resp.Warning.Domains) != 1 || resp.Warning.Domains[0] != reverse.DomainName {
+		t.Errorf("got warning %+v, expected one naming %s", resp.Warning, reverse.DomainName)
+	}
+}
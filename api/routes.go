@@ -40,12 +40,16 @@ import (
 func DeclareRoutes(cfg *config.Options, router *gin.Engine) {
 	apiRoutes := router.Group("/api")
 
+	declareACMERoutes(apiRoutes)
 	declareAuthenticationRoutes(cfg, apiRoutes)
 	declareProviderSpecsRoutes(apiRoutes)
-	declareResolverRoutes(apiRoutes)
+	declareResolverRoutes(cfg, apiRoutes)
 	declareServiceSpecsRoutes(apiRoutes)
 	declareUsersRoutes(cfg, apiRoutes)
 	DeclareVersionRoutes(apiRoutes)
+	declareOpenAPIRoutes(router, apiRoutes)
+
+	declareDynDNSRoutes(cfg, router)
 
 	apiAuthRoutes := router.Group("/api")
 	apiAuthRoutes.Use(authMiddleware(cfg, false))
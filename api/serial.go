@@ -0,0 +1,113 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"sort"
+
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/services/abstract"
+	"git.happydns.org/happydomain/storage"
+)
+
+// zoneOrigin returns the Origin service of zone, if any.
+func zoneOrigin(zone *happydns.Zone) *abstract.Origin {
+	for _, svc := range zone.Services[""] {
+		if o, ok := svc.Service.(*abstract.Origin); ok {
+			return o
+		}
+	}
+	return nil
+}
+
+// servedZone returns the revision of the zone currently served: the last
+// published one, or the one before zone when nothing has been published yet.
+func servedZone(domain *happydns.Domain, zone *happydns.Zone) *happydns.Zone {
+	for _, id := range domain.ZoneHistory {
+		if id.Equals(zone.Id) {
+			continue
+		}
+		if z, err := storage.MainStore.GetZoneMeta(id); err == nil && z.Published != nil {
+			if served, err := storage.MainStore.GetZone(id); err == nil {
+				return served
+			}
+		}
+	}
+	return previousZone(domain, zone)
+}
+
+// zoneRecords lists the records of the zone, in a comparable form, leaving
+// apart the serial.
+func zoneRecords(zone *happydns.Zone, origin string) (records []string) {
+	for _, rr := range zone.GenerateRRs(origin) {
+		if soa, ok := rr.(*dns.SOA); ok {
+			s := *soa
+			s.Serial = 0
+			rr = &s
+		}
+		records = append(records, rr.String())
+	}
+	sort.Strings(records)
+	return
+}
+
+// updateZoneSerial sets the serial of the Origin service of zone, following
+// the serial policy of the domain. As the serial only depends on the served
+// revision and on the last modification of zone, the diff and the apply
+// agree on it. The serial is left untouched when no record has changed, or
+// when it has been set beyond the policy.
+func updateZoneSerial(domain *happydns.Domain, zone *happydns.Zone) {
+	if domain.SerialPolicy == happydns.SerialKeep {
+		return
+	}
+
+	origin := zoneOrigin(zone)
+	if origin == nil {
+		return
+	}
+
+	last := origin.Serial
+	if served := servedZone(domain, zone); served != nil {
+		if sorigin := zoneOrigin(served); sorigin != nil {
+			if sameRecords(zoneRecords(zone, domain.DomainName), zoneRecords(served, domain.DomainName)) {
+				return
+			}
+			last = sorigin.Serial
+		}
+	}
+
+	if next := happydns.NextSerial(domain.SerialPolicy, last, zone.LastModified); !happydns.SerialGreater(origin.Serial, next) {
+		origin.Serial = next
+	}
+}
@@ -33,13 +33,13 @@ package api
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 
 	"git.happydns.org/happydomain/config"
 	"git.happydns.org/happydomain/forms"
+	"git.happydns.org/happydomain/internal/logging"
 	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/services"
 	"git.happydns.org/happydomain/storage"
@@ -81,7 +81,7 @@ func getServiceSettingsState(cfg *config.Options, c *gin.Context) {
 	ups.Service = pvr
 	err = c.ShouldBindJSON(&ups)
 	if err != nil {
-		log.Printf("%s sends invalid ServiceSettingsState JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid ServiceSettingsState JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
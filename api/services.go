@@ -34,9 +34,11 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"git.happydns.org/happydomain/internal/metrics"
 	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/services"
 	"git.happydns.org/happydomain/storage"
@@ -73,7 +75,9 @@ func analyzeDomain(c *gin.Context) {
 		return
 	}
 
+	start := time.Now()
 	zone, err := provider.ImportZone(domain)
+	observeProviderCall(c, provider, metrics.ProviderImportZone, start, err)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unable to import zone: %s", err.Error())})
 		return
@@ -0,0 +1,107 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/storage"
+)
+
+type ttlPlanRequest struct {
+	// TTL is the temporary TTL to set.
+	TTL uint32 `json:"ttl"`
+
+	// Services are the identifiers of the Services to lower, all of them
+	// when empty.
+	Services []happydns.Identifier `json:"services,omitempty"`
+}
+
+// lowerZoneTTLs lowers the TTLs of the zone ahead of a migration.
+func lowerZoneTTLs(c *gin.Context) {
+	zone := c.MustGet("zone").(*happydns.Zone)
+
+	var req ttlPlanRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Info("sends invalid TTL plan JSON")
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
+		return
+	}
+
+	err = zone.LowerTTLs(req.TTL, req.Services)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
+		return
+	}
+
+	zone.LastModified = time.Now()
+	recordImpersonation(c, &zone.ZoneMeta)
+
+	err = storage.MainStore.UpdateZone(zone)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to UpdateZone in lowerZoneTTLs")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your zone. Please retry later."})
+		return
+	}
+
+	c.JSON(http.StatusOK, zone)
+}
+
+// restoreZoneTTLs sets back the TTLs lowered by lowerZoneTTLs.
+func restoreZoneTTLs(c *gin.Context) {
+	zone := c.MustGet("zone").(*happydns.Zone)
+
+	err := zone.RestoreTTLs()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
+		return
+	}
+
+	zone.LastModified = time.Now()
+	recordImpersonation(c, &zone.ZoneMeta)
+
+	err = storage.MainStore.UpdateZone(zone)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to UpdateZone in restoreZoneTTLs")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your zone. Please retry later."})
+		return
+	}
+
+	c.JSON(http.StatusOK, zone)
+}
@@ -35,15 +35,16 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	log "github.com/sirupsen/logrus"
 
 	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
 	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/storage"
 )
@@ -75,6 +76,9 @@ type DisplayUser struct {
 	Email     string                `json:"email"`
 	CreatedAt time.Time             `json:"created_at,omitempty"`
 	Settings  happydns.UserSettings `json:"settings,omitempty"`
+
+	// Impersonator is the administrator currently acting as the user, if any.
+	Impersonator string `json:"impersonator,omitempty"`
 }
 
 func currentUser(u *happydns.User) *DisplayUser {
@@ -89,7 +93,10 @@ func currentUser(u *happydns.User) *DisplayUser {
 func displayAuthToken(c *gin.Context) {
 	user := c.MustGet("LoggedUser").(*happydns.User)
 
-	c.JSON(http.StatusOK, currentUser(user))
+	du := currentUser(user)
+	du.Impersonator = impersonator(c)
+
+	c.JSON(http.StatusOK, du)
 }
 
 func displayNotAuthToken(opts *config.Options, c *gin.Context) {
@@ -104,7 +111,7 @@ func displayNotAuthToken(opts *config.Options, c *gin.Context) {
 		EmailVerified: true,
 	})
 	if err != nil {
-		log.Printf("%s %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to complete authentication")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Something went wrong during your authentication. Please retry in a few minutes"})
 		return
 	}
@@ -138,26 +145,26 @@ type loginForm struct {
 func checkAuth(opts *config.Options, c *gin.Context) {
 	var lf loginForm
 	if err := c.ShouldBindJSON(&lf); err != nil {
-		log.Printf("%s sends invalid LoginForm JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid LoginForm JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
 
 	user, err := storage.MainStore.GetAuthUserByEmail(lf.Email)
 	if err != nil {
-		log.Printf("%s user's email (%s) not found: %s", c.ClientIP(), lf.Email, err.Error())
+		logging.FromContext(c).WithError(err).WithField("email", lf.Email).Info("login attempt with an unknown email")
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"errmsg": "Invalid username or password."})
 		return
 	}
 
 	if !user.CheckAuth(lf.Password) {
-		log.Printf("%s tries to login as %q, but sent an invalid password", c.ClientIP(), lf.Email)
+		logging.FromContext(c).WithField("email", lf.Email).Info("login attempt with an invalid password")
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"errmsg": "Invalid username or password."})
 		return
 	}
 
 	if user.EmailVerification == nil {
-		log.Printf("%s tries to login as %q, but sent an invalid password", c.ClientIP(), lf.Email)
+		logging.FromContext(c).WithField("email", lf.Email).Info("login attempt with an unverified email")
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"errmsg": "Please validate your e-mail address before your first login.", "href": "/email-validation"})
 		return
 	}
@@ -169,12 +176,12 @@ func checkAuth(opts *config.Options, c *gin.Context) {
 		CreatedAt:     user.CreatedAt,
 	})
 	if err != nil {
-		log.Printf("%s %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to complete authentication")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Something went wrong during your authentication. Please retry in a few minutes"})
 		return
 	}
 
-	log.Printf("%s now logged as %q\n", c.ClientIP(), user.Email)
+	logging.FromContext(c).WithFields(log.Fields{"user_id": user.Id.String(), "email": user.Email}).Info("user logged in")
 
 	realUser, err := retrieveUserFromClaims(claims)
 	if err != nil {
@@ -184,8 +191,9 @@ func checkAuth(opts *config.Options, c *gin.Context) {
 	}
 }
 
-func completeAuth(opts *config.Options, c *gin.Context, userprofile UserProfile) (*UserClaims, error) {
-	// Issue a new JWT token
+// newUserClaims creates claims for the given profile, with a fresh token
+// identifier.
+func newUserClaims(userprofile UserProfile) (*UserClaims, error) {
 	jti := make([]byte, 16)
 	_, err := rand.Read(jti)
 	if err != nil {
@@ -193,19 +201,37 @@ func completeAuth(opts *config.Options, c *gin.Context, userprofile UserProfile)
 	}
 
 	iat := jwt.NumericDate{time.Now()}
-	claims := &UserClaims{
+	return &UserClaims{
 		userprofile,
 		jwt.RegisteredClaims{
 			IssuedAt: &iat,
 			ID:       base64.StdEncoding.EncodeToString(jti),
 		},
-	}
+	}, nil
+}
+
+func signUserClaims(opts *config.Options, claims *UserClaims) (string, error) {
 	jwtToken := jwt.NewWithClaims(signingMethod, claims)
 	jwtToken.Header["kid"] = "1"
 
 	token, err := jwtToken.SignedString([]byte(opts.JWTSecretKey))
 	if err != nil {
-		return nil, fmt.Errorf("unable to sign user claims: %w", err)
+		return "", fmt.Errorf("unable to sign user claims: %w", err)
+	}
+
+	return token, nil
+}
+
+func completeAuth(opts *config.Options, c *gin.Context, userprofile UserProfile) (*UserClaims, error) {
+	// Issue a new JWT token
+	claims, err := newUserClaims(userprofile)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := signUserClaims(opts, claims)
+	if err != nil {
+		return nil, err
 	}
 
 	c.SetCookie(
@@ -35,14 +35,15 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 
 	"git.happydns.org/happydomain/actions"
 	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
 	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/storage"
 )
@@ -73,8 +74,12 @@ func declareUsersAuthRoutes(opts *config.Options, router *gin.RouterGroup) {
 	apiUserRoutes.GET("", getUser)
 	apiUserRoutes.GET("/settings", getUserSettings)
 	apiUserRoutes.POST("/settings", changeUserSettings)
+	apiUserRoutes.GET("/quota", func(c *gin.Context) {
+		getUserQuota(opts, c)
+	})
 
 	apiUserAuthRoutes := router.Group("/users/:uid")
+	apiUserAuthRoutes.Use(forbidImpersonation)
 	apiUserAuthRoutes.Use(userAuthHandler)
 	apiUserAuthRoutes.POST("/delete", func(c *gin.Context) {
 		deleteUser(opts, c)
@@ -105,7 +110,7 @@ func registerUser(opts *config.Options, c *gin.Context) {
 	var uu UploadedUser
 	err := c.ShouldBindJSON(&uu)
 	if err != nil {
-		log.Printf("%s sends invalid User JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid User JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
@@ -134,18 +139,18 @@ func registerUser(opts *config.Options, c *gin.Context) {
 	user.AllowCommercials = uu.Newsletter
 
 	if err := storage.MainStore.CreateAuthUser(user); err != nil {
-		log.Printf("%s: unable to CreateUser in registerUser: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to CreateUser in registerUser")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to create your account. Please try again later."})
 		return
 	}
 
 	if actions.SendValidationLink(opts, user); err != nil {
-		log.Printf("%s: unable to SendValidationLink in registerUser: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to SendValidationLink in registerUser")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to sent email validation link. Please try again later."})
 		return
 	}
 
-	log.Printf("%s: registers new user: %s", c.ClientIP(), user.Email)
+	logging.FromContext(c).WithFields(log.Fields{"user_id": user.Id.String(), "email": user.Email}).Info("new user registered")
 
 	c.JSON(http.StatusOK, user)
 }
@@ -154,7 +159,7 @@ func specialUserOperations(opts *config.Options, c *gin.Context) {
 	var uu UploadedUser
 	err := c.ShouldBindJSON(&uu)
 	if err != nil {
-		log.Printf("%s sends invalid User JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid User JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
@@ -162,33 +167,33 @@ func specialUserOperations(opts *config.Options, c *gin.Context) {
 	res := gin.H{"errmsg": "If this address exists in our database, you'll receive a new e-mail."}
 
 	if user, err := storage.MainStore.GetAuthUserByEmail(uu.Email); err != nil {
-		log.Printf("%s: unable to retrieve user %q: %s", c.ClientIP(), uu.Email, err.Error())
+		logging.FromContext(c).WithError(err).WithField("email", uu.Email).Info("unable to retrieve user")
 		c.JSON(http.StatusOK, res)
 		return
 	} else {
 		if uu.Kind == "recovery" {
 			if user.EmailVerification == nil {
 				if err = actions.SendValidationLink(opts, user); err != nil {
-					log.Printf("%s: unable to SendValidationLink in specialUserOperations: %s", c.ClientIP(), err.Error())
+					logging.FromContext(c).WithError(err).Error("unable to SendValidationLink in specialUserOperations")
 					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to sent email validation link. Please try again later."})
 					return
 				}
 
-				log.Printf("%s: Sent validation link to: %s", c.ClientIP(), user.Email)
+				logging.FromContext(c).WithFields(log.Fields{"user_id": user.Id.String(), "email": user.Email}).Info("validation link sent")
 			} else {
 				if err = actions.SendRecoveryLink(opts, user); err != nil {
-					log.Printf("%s: unable to SendRecoveryLink in specialUserOperations: %s", c.ClientIP(), err.Error())
+					logging.FromContext(c).WithError(err).Error("unable to SendRecoveryLink in specialUserOperations")
 					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to sent accont recovery link. Please try again later."})
 					return
 				}
 
 				if err := storage.MainStore.UpdateAuthUser(user); err != nil {
-					log.Printf("%s: unable to UpdateUser in specialUserOperations: %s", c.ClientIP(), err.Error())
+					logging.FromContext(c).WithError(err).Error("unable to UpdateUser in specialUserOperations")
 					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your profile. Please try again later."})
 					return
 				}
 
-				log.Printf("%s: Sent recovery link to: %s", c.ClientIP(), user.Email)
+				logging.FromContext(c).WithFields(log.Fields{"user_id": user.Id.String(), "email": user.Email}).Info("recovery link sent")
 			}
 		} else if uu.Kind == "validation" {
 			// Email have already been validated, do nothing
@@ -198,12 +203,12 @@ func specialUserOperations(opts *config.Options, c *gin.Context) {
 			}
 
 			if err = actions.SendValidationLink(opts, user); err != nil {
-				log.Printf("%s: unable to SendValidationLink 2 in specialUserOperations: %s", c.ClientIP(), err.Error())
+				logging.FromContext(c).WithError(err).Error("unable to SendValidationLink 2 in specialUserOperations")
 				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to sent email validation link. Please try again later."})
 				return
 			}
 
-			log.Printf("%s: Sent validation link to: %s", c.ClientIP(), user.Email)
+			logging.FromContext(c).WithFields(log.Fields{"user_id": user.Id.String(), "email": user.Email}).Info("validation link sent")
 		}
 	}
 
@@ -215,7 +220,7 @@ func SameUserHandler(c *gin.Context) {
 	user := c.MustGet("user").(*happydns.User)
 
 	if !bytes.Equal(user.Id, myuser.Id) {
-		log.Printf("%s: tries to do action as %s (logged %s)", c.ClientIP(), myuser, user)
+		logging.FromContext(c).WithField("target_user_id", user.Id.String()).Warn("tries to do action as another user")
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"errmsg": "Not authorized"})
 		return
 	}
@@ -240,7 +245,7 @@ func changeUserSettings(c *gin.Context) {
 
 	var us happydns.UserSettings
 	if err := c.ShouldBindJSON(&us); err != nil {
-		log.Printf("%s sends invalid UserSettings JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid UserSettings JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
@@ -248,7 +253,7 @@ func changeUserSettings(c *gin.Context) {
 	user.Settings = us
 
 	if err := storage.MainStore.UpdateUser(user); err != nil {
-		log.Printf("%s: unable to UpdateUser in changeUserSettings: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to UpdateUser in changeUserSettings")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your profile. Please try again later."})
 		return
 	}
@@ -267,7 +272,7 @@ func changePassword(opts *config.Options, c *gin.Context) {
 
 	var lf passwordForm
 	if err := c.ShouldBindJSON(&lf); err != nil {
-		log.Printf("%s sends invalid passwordForm JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid passwordForm JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
@@ -283,7 +288,7 @@ func changePassword(opts *config.Options, c *gin.Context) {
 	}
 
 	if err := user.DefinePassword(lf.Password); err != nil {
-		log.Printf("%s: unable to DefinePassword in changePassword: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to DefinePassword in changePassword")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your profile. Please try again later."})
 		return
 	}
@@ -291,23 +296,23 @@ func changePassword(opts *config.Options, c *gin.Context) {
 	// Retrieve all user's sessions to disconnect them
 	sessions, err := storage.MainStore.GetAuthUserSessions(user)
 	if err != nil {
-		log.Printf("%s: unable to GetUserSessions in changePassword: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to GetUserSessions in changePassword")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your profile. Please try again later."})
 		return
 	}
 
 	if err = storage.MainStore.UpdateAuthUser(user); err != nil {
-		log.Printf("%s: unable to DefinePassword in changePassword: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to DefinePassword in changePassword")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your profile. Please try again later."})
 		return
 	}
 
-	log.Printf("%s changes password for user %s", c.ClientIP(), user.Email)
+	logging.FromContext(c).WithFields(log.Fields{"user_id": user.Id.String(), "email": user.Email}).Info("password changed")
 
 	for _, session := range sessions {
 		err = storage.MainStore.DeleteSession(session)
 		if err != nil {
-			log.Println("%s: unable to delete session (password changed): %s", c.ClientIP(), err.Error())
+			logging.FromContext(c).WithError(err).Error("unable to delete session (password changed)")
 		}
 	}
 
@@ -319,7 +324,7 @@ func deleteUser(opts *config.Options, c *gin.Context) {
 
 	var lf passwordForm
 	if err := c.ShouldBindJSON(&lf); err != nil {
-		log.Printf("%s sends invalid passwordForm JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid passwordForm JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
@@ -332,23 +337,23 @@ func deleteUser(opts *config.Options, c *gin.Context) {
 	// Retrieve all user's sessions to disconnect them
 	sessions, err := storage.MainStore.GetAuthUserSessions(user)
 	if err != nil {
-		log.Printf("%s: unable to GetUserSessions in deleteUser: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to GetUserSessions in deleteUser")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your profile. Please try again later."})
 		return
 	}
 
 	if err = storage.MainStore.DeleteAuthUser(user); err != nil {
-		log.Printf("%s: unable to DefinePassword in deleteuser: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to DefinePassword in deleteuser")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your profile. Please try again later."})
 		return
 	}
 
-	log.Printf("%s: deletes user: %s", c.ClientIP(), user.Email)
+	logging.FromContext(c).WithFields(log.Fields{"user_id": user.Id.String(), "email": user.Email}).Info("user deleted")
 
 	for _, session := range sessions {
 		err = storage.MainStore.DeleteSession(session)
 		if err != nil {
-			log.Println("%s: unable to delete session (drop account): %s", c.ClientIP(), err.Error())
+			logging.FromContext(c).WithError(err).Error("unable to delete session (drop account)")
 		}
 	}
 
@@ -417,19 +422,19 @@ func validateUserAddress(c *gin.Context) {
 	var uav UploadedAddressValidation
 	err := c.ShouldBindJSON(&uav)
 	if err != nil {
-		log.Printf("%s sends invalid AddressValidation JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid AddressValidation JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
 
 	if err := user.ValidateEmail(uav.Key); err != nil {
-		log.Printf("%s bad email validation key: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("bad email validation key")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Bad validation key: %s", err.Error())})
 		return
 	}
 
 	if err := storage.MainStore.UpdateAuthUser(user); err != nil {
-		log.Printf("%s: unable to UpdateUser in ValidateUserAddress: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to UpdateUser in ValidateUserAddress")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your profile. Please try again later."})
 		return
 	}
@@ -448,7 +453,7 @@ func recoverUserAccount(c *gin.Context) {
 	var uar UploadedAccountRecovery
 	err := c.ShouldBindJSON(&uar)
 	if err != nil {
-		log.Printf("%s sends invalid AccountRecovey JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid AccountRecovey JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
@@ -469,12 +474,12 @@ func recoverUserAccount(c *gin.Context) {
 	}
 
 	if err := storage.MainStore.UpdateAuthUser(user); err != nil {
-		log.Printf("%s: unable to UpdateUser in recoverUserAccount: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to UpdateUser in recoverUserAccount")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your profile. Please try again later."})
 		return
 	}
 
-	log.Printf("%s: User recovered: %s", c.ClientIP(), user.Email)
+	logging.FromContext(c).WithFields(log.Fields{"user_id": user.Id.String(), "email": user.Email}).Info("user account recovered")
 	c.JSON(http.StatusNoContent, true)
 }
 
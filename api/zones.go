@@ -33,7 +33,6 @@ package api
 
 import (
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -43,20 +42,35 @@ import (
 	"github.com/miekg/dns"
 
 	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/internal/metrics"
 	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/services"
 	"git.happydns.org/happydomain/storage"
 )
 
 func declareZonesRoutes(cfg *config.Options, router *gin.RouterGroup) {
-	router.POST("/import_zone", importZone)
+	router.POST("/import_zone", func(c *gin.Context) {
+		importZone(cfg, c)
+	})
 	router.POST("/diff_zones/:zoneid1/:zoneid2", diffZones)
 
 	apiZonesRoutes := router.Group("/zone/:zoneid")
 	apiZonesRoutes.Use(ZoneHandler)
 
 	apiZonesRoutes.POST("/view", viewZone)
-	apiZonesRoutes.POST("/apply_changes", applyZone)
+	apiZonesRoutes.POST("/apply_changes", func(c *gin.Context) {
+		applyZone(cfg, c)
+	})
+
+	apiZonesRoutes.GET("/delegations", func(c *gin.Context) {
+		getZoneDelegations(cfg, c)
+	})
+	apiZonesRoutes.GET("/propagation", func(c *gin.Context) {
+		getZonePropagation(cfg, c)
+	})
+	apiZonesRoutes.POST("/ttl_plan", lowerZoneTTLs)
+	apiZonesRoutes.DELETE("/ttl_plan", restoreZoneTTLs)
 
 	apiZonesRoutes.GET("", GetZone)
 	apiZonesRoutes.PATCH("", UpdateZoneService)
@@ -64,7 +78,9 @@ func declareZonesRoutes(cfg *config.Options, router *gin.RouterGroup) {
 	apiZonesSubdomainRoutes := apiZonesRoutes.Group("/:subdomain")
 	apiZonesSubdomainRoutes.Use(subdomainHandler)
 	apiZonesSubdomainRoutes.GET("", getZoneSubdomain)
-	apiZonesSubdomainRoutes.POST("/services", addZoneService)
+	apiZonesSubdomainRoutes.POST("/services", func(c *gin.Context) {
+		addZoneService(cfg, c)
+	})
 
 	declareServiceSettingsRoutes(cfg, apiZonesSubdomainRoutes)
 
@@ -131,7 +147,7 @@ func getZoneSubdomain(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"services": zone.Services[subdomain]})
 }
 
-func addZoneService(c *gin.Context) {
+func addZoneService(opts *config.Options, c *gin.Context) {
 	domain := c.MustGet("domain").(*happydns.Domain)
 	zone := c.MustGet("zone").(*happydns.Zone)
 	subdomain := c.MustGet("subdomain").(string)
@@ -139,7 +155,7 @@ func addZoneService(c *gin.Context) {
 	usc := &happydns.ServiceCombined{}
 	err := c.ShouldBindJSON(&usc)
 	if err != nil {
-		log.Printf("%s sends invalid service JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid service JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
@@ -149,19 +165,34 @@ func addZoneService(c *gin.Context) {
 		return
 	}
 
+	if user := myUser(c); user != nil {
+		if limit := userQuota(opts, user).MaxServicesPerZone; happydns.Reached(limit, countServices(zone)) {
+			quotaExceeded(c, limit, "services in this zone")
+			return
+		}
+	}
+
 	err = zone.AppendService(subdomain, domain.DomainName, usc)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unable to add service: %s", err.Error())})
 		return
 	}
 
+	zone.LastModified = time.Now()
+	recordImpersonation(c, &zone.ZoneMeta)
+
 	err = storage.MainStore.UpdateZone(zone)
 	if err != nil {
-		log.Printf("%s: Unable to UpdateZone in updateZoneService: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to UpdateZone in updateZoneService")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your zone. Please retry later."})
 		return
 	}
 
+	if err = syncReversePointers(c, domain, subdomain, nil, usc.Service); err != nil {
+		reverseSyncFailed(c, zone, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, zone)
 }
 
@@ -185,7 +216,7 @@ func getZoneService(c *gin.Context) {
 	c.JSON(http.StatusOK, zone.FindSubdomainService(subdomain, serviceid))
 }
 
-func importZone(c *gin.Context) {
+func importZone(opts *config.Options, c *gin.Context) {
 	user := c.MustGet("LoggedUser").(*happydns.User)
 	domain := c.MustGet("domain").(*happydns.Domain)
 
@@ -195,7 +226,9 @@ func importZone(c *gin.Context) {
 		return
 	}
 
+	start := time.Now()
 	zone, err := provider.ImportZone(domain)
+	observeProviderCall(c, provider, metrics.ProviderImportZone, start, err)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
 		return
@@ -215,11 +248,12 @@ func importZone(c *gin.Context) {
 		},
 		Services: services,
 	}
+	recordImpersonation(c, &myZone.ZoneMeta)
 
 	// Create history zone
 	err = storage.MainStore.CreateZone(myZone)
 	if err != nil {
-		log.Printf("%s: unable to CreateZone in importZone: %s\n", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to CreateZone in importZone")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create your zone."})
 		return
 	}
@@ -229,20 +263,27 @@ func importZone(c *gin.Context) {
 	// Create wip zone
 	err = storage.MainStore.CreateZone(myZone)
 	if err != nil {
-		log.Printf("%s: unable to CreateZone2 in importZone: %s\n", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to CreateZone2 in importZone")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create your zone."})
 		return
 	}
 	domain.ZoneHistory = append(
 		[]happydns.Identifier{myZone.Id}, domain.ZoneHistory...)
 
+	trimmed := trimZoneHistory(opts, user, domain, nil)
+
 	err = storage.MainStore.UpdateDomain(domain)
 	if err != nil {
-		log.Printf("%s: unable to UpdateDomain in importZone: %s\n", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to UpdateDomain in importZone")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create your zone."})
 		return
 	}
 
+	err = deleteZoneRevisions(trimmed)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to trim zone history in importZone")
+	}
+
 	c.JSON(http.StatusOK, &myZone.ZoneMeta)
 }
 
@@ -267,6 +308,8 @@ func diffZones(c *gin.Context) {
 		return
 	}
 
+	updateZoneSerial(domain, zone)
+
 	records, err := models.RRstoRCs(zone.GenerateRRs(domain.DomainName), strings.TrimSuffix(domain.DomainName, "."))
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
@@ -278,7 +321,9 @@ func diffZones(c *gin.Context) {
 		Records: records,
 	}
 
+	start := time.Now()
 	corrections, err := provider.GetDomainCorrections(dc)
+	observeProviderCall(c, provider, metrics.ProviderGetDomainCorrections, start, err)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
 		return
@@ -292,7 +337,7 @@ func diffZones(c *gin.Context) {
 	c.JSON(http.StatusOK, rrCorected)
 }
 
-func applyZone(c *gin.Context) {
+func applyZone(opts *config.Options, c *gin.Context) {
 	user := c.MustGet("LoggedUser").(*happydns.User)
 	domain := c.MustGet("domain").(*happydns.Domain)
 	zone := c.MustGet("zone").(*happydns.Zone)
@@ -303,6 +348,9 @@ func applyZone(c *gin.Context) {
 		return
 	}
 
+	// The serial is kept in the published revision and in the next one
+	updateZoneSerial(domain, zone)
+
 	records, err := models.RRstoRCs(zone.GenerateRRs(domain.DomainName), strings.TrimSuffix(domain.DomainName, "."))
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": err.Error()})
@@ -317,17 +365,21 @@ func applyZone(c *gin.Context) {
 	var wantedCorrections []string
 	err = c.ShouldBindJSON(&wantedCorrections)
 	if err != nil {
-		log.Printf("%s sends invalid string array JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid string array JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
 
+	start := time.Now()
 	corrections, err := provider.GetDomainCorrections(dc)
+	observeProviderCall(c, provider, metrics.ProviderGetDomainCorrections, start, err)
 	for _, cr := range corrections {
 		for ic, wc := range wantedCorrections {
 			if wc == cr.Msg {
-				log.Printf("%s: apply correction: %s", domain.DomainName, cr.Msg)
+				logging.FromContext(c).WithField("provider", provider.DNSControlName()).WithField("correction", cr.Msg).Info("applying correction")
+				start := time.Now()
 				err := cr.F()
+				observeProviderCall(c, provider, metrics.ProviderApplyCorrection, start, err)
 
 				if err != nil {
 					c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unable to update the zone: %s", err.Error())})
@@ -346,12 +398,17 @@ func applyZone(c *gin.Context) {
 		return
 	}
 
+	// Lowered TTLs are now counting down in caches
+	if zone.TTLPlan != nil {
+		zone.TTLPlan.MarkPublished(time.Now())
+	}
+
 	// Create a new zone in history for futher updates
 	newZone := zone.DerivateNew()
 	//newZone.IdAuthor = //TODO get current user id
 	err = storage.MainStore.CreateZone(newZone)
 	if err != nil {
-		log.Printf("%s was unable to CreateZone", c.ClientIP(), err)
+		logging.FromContext(c).WithError(err).Error("unable to CreateZone")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create the zone now."})
 		return
 	}
@@ -359,23 +416,31 @@ func applyZone(c *gin.Context) {
 	domain.ZoneHistory = append(
 		[]happydns.Identifier{newZone.Id}, domain.ZoneHistory...)
 
+	trimmed := trimZoneHistory(opts, user, domain, zone.Id)
+
 	err = storage.MainStore.UpdateDomain(domain)
 	if err != nil {
-		log.Printf("%s was unable to UpdateDomain", c.ClientIP(), err)
+		logging.FromContext(c).WithError(err).Error("unable to UpdateDomain")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create the zone now."})
 		return
 	}
 
+	err = deleteZoneRevisions(trimmed)
+	if err != nil {
+		logging.FromContext(c).WithError(err).Error("unable to trim zone history in applyZone")
+	}
+
 	// Commit changes in previous zone
 	now := time.Now()
 	// zone.ZoneMeta.IdAuthor = // TODO get current user id
 	zone.ZoneMeta.Published = &now
 
 	zone.LastModified = time.Now()
+	recordImpersonation(c, &zone.ZoneMeta)
 
 	err = storage.MainStore.UpdateZone(zone)
 	if err != nil {
-		log.Printf("%s was unable to UpdateZone", c.ClientIP(), err)
+		logging.FromContext(c).WithError(err).Error("unable to UpdateZone")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are unable to create the zone now."})
 		return
 	}
@@ -403,11 +468,16 @@ func UpdateZoneService(c *gin.Context) {
 	usc := &happydns.ServiceCombined{}
 	err := c.ShouldBindJSON(&usc)
 	if err != nil {
-		log.Printf("%s sends invalid domain JSON: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Info("sends invalid domain JSON")
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Something is wrong in received data: %s", err.Error())})
 		return
 	}
 
+	var oldService happydns.Service
+	if svc := zone.FindSubdomainService(usc.Domain, usc.Id); svc != nil {
+		oldService = svc.Service
+	}
+
 	err = zone.EraseService(usc.Domain, domain.DomainName, usc.Id, usc)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unable to delete service: %s", err.Error())})
@@ -415,14 +485,20 @@ func UpdateZoneService(c *gin.Context) {
 	}
 
 	zone.LastModified = time.Now()
+	recordImpersonation(c, &zone.ZoneMeta)
 
 	err = storage.MainStore.UpdateZone(zone)
 	if err != nil {
-		log.Printf("%s: Unable to UpdateZone in updateZoneService: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to UpdateZone in updateZoneService")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your zone. Please retry later."})
 		return
 	}
 
+	if err = syncReversePointers(c, domain, usc.Domain, oldService, usc.Service); err != nil {
+		reverseSyncFailed(c, zone, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, zone)
 }
 
@@ -432,6 +508,11 @@ func deleteZoneService(c *gin.Context) {
 	serviceid := c.MustGet("serviceid").(happydns.Identifier)
 	subdomain := c.MustGet("subdomain").(string)
 
+	var oldService happydns.Service
+	if svc := zone.FindSubdomainService(subdomain, serviceid); svc != nil {
+		oldService = svc.Service
+	}
+
 	err := zone.EraseService(subdomain, domain.DomainName, serviceid, nil)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errmsg": fmt.Sprintf("Unable to delete service: %s", err.Error())})
@@ -439,14 +520,20 @@ func deleteZoneService(c *gin.Context) {
 	}
 
 	zone.LastModified = time.Now()
+	recordImpersonation(c, &zone.ZoneMeta)
 
 	err = storage.MainStore.UpdateZone(zone)
 	if err != nil {
-		log.Printf("%s: Unable to UpdateZone in deleteZoneService: %s", c.ClientIP(), err.Error())
+		logging.FromContext(c).WithError(err).Error("unable to UpdateZone in deleteZoneService")
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errmsg": "Sorry, we are currently unable to update your zone. Please retry later."})
 		return
 	}
 
+	if err = syncReversePointers(c, domain, subdomain, oldService, nil); err != nil {
+		reverseSyncFailed(c, zone, err)
+		return
+	}
+
 	c.JSON(http.StatusOK, zone)
 }
 
@@ -0,0 +1,285 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"git.happydns.org/happydomain/internal/preview"
+	"git.happydns.org/happydomain/model"
+)
+
+func runLogin(e *env, args []string) error {
+	fs := newFlagSet("login", "EMAIL")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	password, ok := os.LookupEnv("HAPPYDOMAIN_PASSWORD")
+	if !ok {
+		fmt.Fprint(os.Stderr, "Password: ")
+		password, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("unable to read password: %w", err)
+		}
+		password = strings.TrimRight(password, "\r\n")
+	}
+
+	resp, err := e.client.request("POST", "/api/auth", map[string]string{"Email": args[0], "Password": password})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "happydomain_session" {
+			return e.out.lines(map[string]string{"token": cookie.Value}, []string{cookie.Value})
+		}
+	}
+
+	return fmt.Errorf("no token returned by the server")
+}
+
+// findDomain retrieves the domain designated by name, either its FQDN or its
+// identifier.
+func findDomain(e *env, name string) (*happydns.Domain, error) {
+	var domains happydns.Domains
+	if err := e.client.do("GET", "/api/domains", nil, &domains); err != nil {
+		return nil, err
+	}
+
+	fqdn := strings.TrimSuffix(name, ".") + "."
+	for _, domain := range domains {
+		if domain.DomainName == fqdn || domain.Id.String() == name {
+			return domain, nil
+		}
+	}
+
+	return nil, fmt.Errorf("domain %q not found", name)
+}
+
+// currentZone returns the identifier of the zone being edited, the first
+// one of the history.
+func currentZone(domain *happydns.Domain) (string, error) {
+	if len(domain.ZoneHistory) == 0 {
+		return "", fmt.Errorf("no zone for %s yet, run import first", domain.DomainName)
+	}
+	return domain.ZoneHistory[0].String(), nil
+}
+
+func domainPath(domain *happydns.Domain) string {
+	return "/api/domains/" + domain.Id.String()
+}
+
+func runDomains(e *env, args []string) error {
+	fs := newFlagSet("domains", "")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	var domains happydns.Domains
+	if err := e.client.do("GET", "/api/domains", nil, &domains); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, domain := range domains {
+		rows = append(rows, []string{domain.Id.String(), domain.DomainName, domain.IdProvider.String(), domain.Group, fmt.Sprintf("%d", len(domain.ZoneHistory))})
+	}
+
+	return e.out.table(domains, []string{"ID", "DOMAIN", "PROVIDER", "GROUP", "ZONES"}, rows)
+}
+
+func runZones(e *env, args []string) error {
+	fs := newFlagSet("zones", "DOMAIN")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	domain, err := findDomain(e, args[0])
+	if err != nil {
+		return err
+	}
+
+	var history struct {
+		ZoneHistory []happydns.ZoneMeta `json:"zone_history"`
+	}
+	if err = e.client.do("GET", domainPath(domain), nil, &history); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, zm := range history.ZoneHistory {
+		msg := "-"
+		if zm.CommitMsg != nil {
+			msg = *zm.CommitMsg
+		}
+		rows = append(rows, []string{zm.Id.String(), preview.ViewLabel(zm.Id), formatTime(&zm.LastModified), formatTime(zm.Published), msg})
+	}
+
+	return e.out.table(history.ZoneHistory, []string{"ID", "PREVIEW", "LAST MODIFIED", "PUBLISHED", "MESSAGE"}, rows)
+}
+
+func runImport(e *env, args []string) error {
+	fs := newFlagSet("import", "DOMAIN")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	domain, err := findDomain(e, args[0])
+	if err != nil {
+		return err
+	}
+
+	var zm happydns.ZoneMeta
+	if err = e.client.do("POST", domainPath(domain)+"/import_zone", nil, &zm); err != nil {
+		return err
+	}
+
+	return e.out.lines(zm, []string{fmt.Sprintf("Zone imported as %s", zm.Id.String())})
+}
+
+// getCorrections retrieves the corrections the provider would apply to
+// publish the current zone.
+func getCorrections(e *env, domain *happydns.Domain) ([]string, error) {
+	zoneid, err := currentZone(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	var corrections []string
+	err = e.client.do("POST", domainPath(domain)+"/diff_zones/@/"+zoneid, nil, &corrections)
+	return corrections, err
+}
+
+func runDiff(e *env, args []string) error {
+	fs := newFlagSet("diff", "DOMAIN")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	domain, err := findDomain(e, args[0])
+	if err != nil {
+		return err
+	}
+
+	corrections, err := getCorrections(e, domain)
+	if err != nil {
+		return err
+	}
+	if corrections == nil {
+		corrections = []string{}
+	}
+
+	return e.out.lines(corrections, corrections)
+}
+
+func runApply(e *env, args []string) error {
+	fs := newFlagSet("apply", "[-all] DOMAIN [CORRECTION...]")
+	all := fs.Bool("all", false, "Apply all the corrections listed by diff")
+	args, err := parseArgs(fs, args, 1, -1)
+	if err != nil {
+		return err
+	}
+
+	domain, err := findDomain(e, args[0])
+	if err != nil {
+		return err
+	}
+
+	zoneid, err := currentZone(domain)
+	if err != nil {
+		return err
+	}
+
+	corrections := args[1:]
+	if *all {
+		if len(corrections) > 0 {
+			return fmt.Errorf("-all cannot be used along with a list of corrections")
+		}
+
+		corrections, err = getCorrections(e, domain)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(corrections) == 0 {
+		return fmt.Errorf("no correction to apply")
+	}
+
+	var zm happydns.ZoneMeta
+	if err = e.client.do("POST", domainPath(domain)+"/zone/"+zoneid+"/apply_changes", corrections, &zm); err != nil {
+		return err
+	}
+
+	return e.out.lines(zm, []string{fmt.Sprintf("%d correction(s) applied, new zone is %s", len(corrections), zm.Id.String())})
+}
+
+func runExport(e *env, args []string) error {
+	fs := newFlagSet("export", "DOMAIN [ZONEID]")
+	args, err := parseArgs(fs, args, 1, 2)
+	if err != nil {
+		return err
+	}
+
+	domain, err := findDomain(e, args[0])
+	if err != nil {
+		return err
+	}
+
+	var zoneid string
+	if len(args) > 1 {
+		zoneid = args[1]
+	} else if zoneid, err = currentZone(domain); err != nil {
+		return err
+	}
+
+	var zonefile string
+	if err = e.client.do("POST", domainPath(domain)+"/zone/"+zoneid+"/view", nil, &zonefile); err != nil {
+		return err
+	}
+
+	if e.out.json {
+		return e.out.raw(zonefile)
+	}
+
+	_, err = fmt.Fprint(e.out.w, zonefile)
+	return err
+}
@@ -0,0 +1,173 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+// happydomain-cli is a command-line client for happyDomain. It manages
+// domains and zones through the user API, authenticated with a token, and
+// users through the administration socket.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// env holds what commands need to run.
+type env struct {
+	flags  *globalFlags
+	out    *printer
+	client *client
+}
+
+type globalFlags struct {
+	server string
+	token  string
+	socket string
+	output string
+}
+
+type command struct {
+	name  string
+	args  string
+	help  string
+	admin bool
+	run   func(e *env, args []string) error
+}
+
+var commands = []command{
+	{"login", "EMAIL", "Log in and print an authentication token (password is read from HAPPYDOMAIN_PASSWORD or stdin)", false, runLogin},
+	{"domains", "", "List your domains", false, runDomains},
+	{"zones", "DOMAIN", "List the zone history of a domain", false, runZones},
+	{"import", "DOMAIN", "Import the zone from the provider", false, runImport},
+	{"diff", "DOMAIN", "Show the corrections needed to publish the current zone", false, runDiff},
+	{"apply", "[-all] DOMAIN [CORRECTION...]", "Apply the given corrections, or all of them", false, runApply},
+	{"export", "DOMAIN [ZONEID]", "Print a zone in zone file format (current zone by default)", false, runExport},
+	{"users", "list|show|create|delete|reset-password|validate-email|impersonate [USER]", "Manage users (administration socket)", true, runUsers},
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options] COMMAND [arguments]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s %s\n    \t%s\n", cmd.name, cmd.args, cmd.help)
+	}
+	fmt.Fprintf(flag.CommandLine.Output(), "\nOptions:\n")
+	flag.PrintDefaults()
+}
+
+func getenv(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
+
+func main() {
+	f := &globalFlags{}
+	flag.StringVar(&f.server, "server", getenv("HAPPYDOMAIN_SERVER", "http://localhost:8081"), "URL of the happyDomain instance (env HAPPYDOMAIN_SERVER)")
+	flag.StringVar(&f.token, "token", os.Getenv("HAPPYDOMAIN_TOKEN"), "Authentication token for the user API, as given by the login command (env HAPPYDOMAIN_TOKEN)")
+	flag.StringVar(&f.socket, "socket", getenv("HAPPYDOMAIN_SOCKET", "./happydomain.sock"), "Path to the administration socket (env HAPPYDOMAIN_SOCKET)")
+	flag.StringVar(&f.output, "output", "table", "Output format: table or json")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	if f.output != "table" && f.output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q: expected table or json\n", f.output)
+		os.Exit(2)
+	}
+
+	e := &env{
+		flags: f,
+		out:   &printer{w: os.Stdout, json: f.output == "json"},
+	}
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		if cmd.admin {
+			e.client = newAdminClient(f.socket)
+		} else {
+			e.client = newUserClient(f.server, f.token)
+		}
+
+		if err := cmd.run(e, flag.Args()[1:]); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Unknown command %q. Available commands: %s\n", name, commandNames())
+	os.Exit(2)
+}
+
+func commandNames() string {
+	var names []string
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseArgs parses the flags of a command and checks the number of
+// remaining positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return nil, fmt.Errorf("unexpected number of arguments, see -help")
+	}
+
+	return fs.Args(), nil
+}
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [options] %s %s\n", os.Args[0], name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
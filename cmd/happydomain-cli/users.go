@@ -0,0 +1,237 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"git.happydns.org/happydomain/model"
+)
+
+// runUsers manages users through the administration socket. Users are
+// designated either by their identifier or by their email address.
+func runUsers(e *env, args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+
+	switch args[0] {
+	case "list":
+		return runUsersList(e, args[1:])
+	case "show":
+		return runUsersShow(e, args[1:])
+	case "create":
+		return runUsersCreate(e, args[1:])
+	case "delete":
+		return runUsersDelete(e, args[1:])
+	case "reset-password":
+		return runUsersResetPassword(e, args[1:])
+	case "validate-email":
+		return runUsersValidateEmail(e, args[1:])
+	case "impersonate":
+		return runUsersImpersonate(e, args[1:])
+	default:
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
+}
+
+func authUserPath(user string) string {
+	return "/api/auth/" + url.PathEscape(user)
+}
+
+func authUserRow(u *happydns.UserAuth) []string {
+	return []string{u.Id.String(), u.Email, formatTime(u.EmailVerification), formatTime(&u.CreatedAt), formatTime(u.LastLoggedIn)}
+}
+
+var authUserHeaders = []string{"ID", "EMAIL", "VERIFIED", "CREATED", "LAST LOGIN"}
+
+func runUsersList(e *env, args []string) error {
+	fs := newFlagSet("users list", "")
+	if _, err := parseArgs(fs, args, 0, 0); err != nil {
+		return err
+	}
+
+	var users happydns.UserAuths
+	if err := e.client.do("GET", "/api/auth", nil, &users); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	for _, u := range users {
+		rows = append(rows, authUserRow(u))
+	}
+
+	return e.out.table(users, authUserHeaders, rows)
+}
+
+func runUsersShow(e *env, args []string) error {
+	fs := newFlagSet("users show", "USER")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var user happydns.UserAuth
+	if err = e.client.do("GET", authUserPath(args[0]), nil, &user); err != nil {
+		return err
+	}
+
+	return e.out.table(user, authUserHeaders, [][]string{authUserRow(&user)})
+}
+
+// resetPassword defines the password of the given user, or a random one when
+// password is empty, and returns it.
+func resetPassword(e *env, user string, password string) (string, error) {
+	var ret struct {
+		Password string
+	}
+	err := e.client.do("POST", authUserPath(user)+"/reset_password", map[string]string{"Password": password}, &ret)
+	return ret.Password, err
+}
+
+func runUsersCreate(e *env, args []string) error {
+	fs := newFlagSet("users create", "[-password PASSWORD] EMAIL")
+	password := fs.String("password", "", "Password of the new user (generated when empty)")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	user := &happydns.UserAuth{
+		Email:     args[0],
+		CreatedAt: time.Now(),
+	}
+	if err = e.client.do("POST", "/api/auth", user, user); err != nil {
+		return err
+	}
+
+	*password, err = resetPassword(e, user.Id.String(), *password)
+	if err != nil {
+		// Don't keep a user without password
+		e.client.do("DELETE", authUserPath(user.Id.String()), nil, nil)
+		return err
+	}
+
+	return e.out.lines(map[string]string{"id": user.Id.String(), "email": user.Email, "password": *password}, []string{
+		fmt.Sprintf("User %s created with id %s", user.Email, user.Id.String()),
+		fmt.Sprintf("Password: %s", *password),
+	})
+}
+
+func runUsersDelete(e *env, args []string) error {
+	fs := newFlagSet("users delete", "USER")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var user happydns.UserAuth
+	if err = e.client.do("GET", authUserPath(args[0]), nil, &user); err != nil {
+		return err
+	}
+
+	// Remove the user's data, if the user ever logged in
+	err = e.client.do("DELETE", "/api/users/"+user.Id.String(), nil, nil)
+	var apiErr *apiError
+	if err != nil && !(errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound) {
+		return err
+	}
+
+	if err = e.client.do("DELETE", authUserPath(user.Id.String()), nil, nil); err != nil {
+		return err
+	}
+
+	return e.out.lines(true, []string{fmt.Sprintf("User %s deleted", user.Email)})
+}
+
+func runUsersResetPassword(e *env, args []string) error {
+	fs := newFlagSet("users reset-password", "[-password PASSWORD] USER")
+	password := fs.String("password", "", "New password (generated when empty)")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	*password, err = resetPassword(e, args[0], *password)
+	if err != nil {
+		return err
+	}
+
+	return e.out.lines(map[string]string{"password": *password}, []string{fmt.Sprintf("Password: %s", *password)})
+}
+
+func runUsersValidateEmail(e *env, args []string) error {
+	fs := newFlagSet("users validate-email", "USER")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var user happydns.UserAuth
+	if err = e.client.do("POST", authUserPath(args[0])+"/validate_email", nil, &user); err != nil {
+		return err
+	}
+
+	return e.out.table(user, authUserHeaders, [][]string{authUserRow(&user)})
+}
+
+func runUsersImpersonate(e *env, args []string) error {
+	fs := newFlagSet("users impersonate", "-as NAME -reason REASON [-duration DURATION] USER")
+	as := fs.String("as", os.Getenv("USER"), "Name of the person impersonating the user")
+	reason := fs.String("reason", "", "Why the user is impersonated")
+	duration := fs.String("duration", "15m", "Lifetime of the token (1h max)")
+	args, err := parseArgs(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+
+	var ret struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+		Cookie    string    `json:"cookie"`
+	}
+	err = e.client.do("POST", "/api/users/"+url.PathEscape(args[0])+"/impersonate", map[string]string{
+		"impersonator": *as,
+		"reason":       *reason,
+		"duration":     *duration,
+	}, &ret)
+	if err != nil {
+		return err
+	}
+
+	return e.out.lines(ret, []string{ret.Token})
+}
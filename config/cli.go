@@ -43,6 +43,17 @@ func (o *Options) declareFlags() {
 	flag.StringVar(&o.DevProxy, "dev", o.DevProxy, "Proxify traffic to this host for static assets")
 	flag.StringVar(&o.AdminBind, "admin-bind", o.AdminBind, "Bind port/socket for administration interface")
 	flag.StringVar(&o.Bind, "bind", ":8081", "Bind port/socket")
+	flag.StringVar(&o.MetricsBind, "metrics-bind", o.MetricsBind, "Bind port for a dedicated Prometheus metrics endpoint (always available on the administration interface)")
+	flag.StringVar(&o.PreviewDNSBind, "preview-dns-bind", o.PreviewDNSBind, "Bind address:port for a DNS server answering from zone revisions, queried as <name>.<zone id>. (disabled if empty)")
+	flag.StringVar(&o.ResolversFile, "resolvers-file", o.ResolversFile, "Path to a JSON file listing the resolvers proposed in the resolver tool")
+	flag.StringVar(&o.RootHintsFile, "root-hints", o.RootHintsFile, "Path to a named.root file listing the root servers used by the resolver trace (the Internet ones by default)")
+	flag.StringVar(&o.TrustAnchorsFile, "trust-anchors", o.TrustAnchorsFile, "Path to a file with the DS or DNSKEY records DNSSEC validation starts from (the root zone KSKs by default)")
+	flag.BoolVar(&o.ResolverRequireAuth, "resolver-require-auth", o.ResolverRequireAuth, "Restrict the resolver tool to logged users")
+	flag.Var(&o.ResolverAllowedNetworks, "resolver-allowed-networks", "Comma-separated networks the resolver tool can query despite the denied ones; when set, any other network is denied")
+	flag.Var(&o.ResolverDeniedNetworks, "resolver-denied-networks", "Comma-separated networks the resolver tool can't query (loopback, private, carrier-grade NAT, NAT64 and link-local networks by default)")
+	flag.IntVar(&o.ResolverRateLimit, "resolver-rate-limit", o.ResolverRateLimit, "Number of queries per minute each IP can make through the resolver tool (0 for unlimited)")
+	flag.DurationVar(&o.ResolverCacheTTL, "resolver-cache-ttl", o.ResolverCacheTTL, "Maximal time a response of the resolver tool is cached (0 to disable)")
+	flag.Var(&o.PropagationResolvers, "propagation-resolvers", "Comma-separated addresses of the resolvers asked when checking the propagation of a zone (1.1.1.1, 8.8.8.8 and 9.9.9.9 by default)")
 	flag.StringVar(&o.ExternalURL, "externalurl", o.ExternalURL, "Begining of the URL, before the base, that should be used eg. in mails")
 	flag.StringVar(&o.BaseURL, "baseurl", o.BaseURL, "URL prepended to each URL")
 	flag.StringVar(&o.DefaultNameServer, "default-ns", o.DefaultNameServer, "Adress to the default name server")
@@ -50,6 +61,12 @@ func (o *Options) declareFlags() {
 	flag.BoolVar(&o.NoAuth, "no-auth", false, "Disable user access control, use default account")
 	flag.Var(&o.JWTSecretKey, "jwt-secret-key", "Secret key used to verify JWT authentication tokens (a random secret is used if undefined)")
 	flag.Var(&o.ExternalAuth, "external-auth", "Base URL to use for login and registration (use embedded forms if left empty)")
+	flag.StringVar(&o.LogFormat, "log-format", o.LogFormat, "Format of the logs: logfmt or json")
+	flag.StringVar(&o.LogLevel, "log-level", o.LogLevel, "Minimal level of the messages to log: debug, info, warning, error")
+	flag.IntVar(&o.Quota.MaxDomains, "quota-domains", o.Quota.MaxDomains, "Maximum number of domains per user (0 for unlimited)")
+	flag.IntVar(&o.Quota.MaxProviders, "quota-providers", o.Quota.MaxProviders, "Maximum number of providers per user (0 for unlimited)")
+	flag.IntVar(&o.Quota.MaxServicesPerZone, "quota-services-per-zone", o.Quota.MaxServicesPerZone, "Maximum number of services in a zone (0 for unlimited)")
+	flag.IntVar(&o.Quota.MaxZoneHistory, "quota-zone-history", o.Quota.MaxZoneHistory, "Number of zone revisions retained per domain, older ones are deleted (0 for unlimited)")
 
 	// Others flags are declared in some other files likes sources, storages, ... when they need specials configurations
 }
@@ -35,11 +35,16 @@ import (
 	"crypto/rand"
 	"flag"
 	"fmt"
-	"log"
+	"net"
 	"os"
 	"path"
 	"strings"
+	"time"
 
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
 	"git.happydns.org/happydomain/storage"
 )
 
@@ -52,6 +57,60 @@ type Options struct {
 	// API.
 	AdminBind string
 
+	// MetricsBind is the address:port used to serve the Prometheus metrics,
+	// in addition to the admin interface.
+	MetricsBind string
+
+	// PreviewDNSBind is the address:port where zone revisions are served
+	// over DNS, for testing purpose. The preview server is disabled when
+	// empty.
+	PreviewDNSBind string
+
+	// ResolversFile is the path to a JSON file listing the resolvers
+	// proposed in the resolver tool.
+	ResolversFile string
+
+	// Resolvers are the resolvers users can query with the resolver tool.
+	Resolvers []happydns.Resolver
+
+	// RootHintsFile is the path to a named.root file, to trace resolutions
+	// from other root servers than the Internet ones.
+	RootHintsFile string
+
+	// RootHints are the root servers used to trace resolutions.
+	RootHints []dns.RR
+
+	// TrustAnchorsFile is the path to a file holding the DS or DNSKEY
+	// records DNSSEC validation starts from.
+	TrustAnchorsFile string
+
+	// TrustAnchors are the DS records DNSSEC validation starts from.
+	TrustAnchors []*dns.DS
+
+	// ResolverRequireAuth restricts the resolver tool to logged users.
+	ResolverRequireAuth bool
+
+	// ResolverAllowedNetworks are the networks users can send queries
+	// to, through custom resolvers or traces, despite
+	// ResolverDeniedNetworks. When not empty, any other network is denied.
+	ResolverAllowedNetworks Networks
+
+	// ResolverDeniedNetworks are the networks users can't send queries
+	// to, through custom resolvers or traces.
+	ResolverDeniedNetworks Networks
+
+	// ResolverRateLimit is the number of queries per minute each IP can
+	// make through the resolver tool (0 for unlimited).
+	ResolverRateLimit int
+
+	// ResolverCacheTTL is the maximal time a response of the resolver
+	// tool is kept in cache (0 to disable the cache).
+	ResolverCacheTTL time.Duration
+
+	// PropagationResolvers are the addresses of the resolvers asked when
+	// checking the propagation of a zone, besides its name servers.
+	PropagationResolvers StringList
+
 	// ExternalURL keeps the URL used in communications (such as email,
 	// ...), when it needs to use complete URL, not only relative parts.
 	ExternalURL string
@@ -76,6 +135,15 @@ type Options struct {
 
 	// JWTSecretKey stores the private key to sign and verify JWT tokens.
 	JWTSecretKey JWTSecretKey
+
+	// LogFormat is the output format of logs: logfmt or json.
+	LogFormat string
+
+	// LogLevel is the minimal level of messages to log.
+	LogLevel string
+
+	// Quota holds the default limits applied to each user.
+	Quota happydns.UserQuota
 }
 
 // BuildURL appends the given url to the absolute ExternalURL.
@@ -89,6 +157,33 @@ func (o *Options) BuildURL_noescape(url string, args ...interface{}) string {
 	return fmt.Sprintf("%s%s"+url, args...)
 }
 
+// defaultResolverDeniedNetworks are the networks the resolver tool can't
+// query by default: those of the server and its internal network.
+func defaultResolverDeniedNetworks() Networks {
+	return Networks{
+		// Loopback
+		{IP: net.IP{127, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+		{IP: net.IPv6loopback, Mask: net.CIDRMask(128, 128)},
+		// RFC 1918
+		{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+		{IP: net.IP{172, 16, 0, 0}, Mask: net.CIDRMask(12, 32)},
+		{IP: net.IP{192, 168, 0, 0}, Mask: net.CIDRMask(16, 32)},
+		// Shared address space of carrier-grade NAT (RFC 6598)
+		{IP: net.IP{100, 64, 0, 0}, Mask: net.CIDRMask(10, 32)},
+		// IETF protocol assignments (RFC 6890)
+		{IP: net.IP{192, 0, 0, 0}, Mask: net.CIDRMask(24, 32)},
+		// NAT64, embedding any IPv4 address (RFC 6052)
+		{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)},
+		// Link-local and unique local
+		{IP: net.IP{169, 254, 0, 0}, Mask: net.CIDRMask(16, 32)},
+		{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(10, 128)},
+		{IP: net.ParseIP("fc00::"), Mask: net.CIDRMask(7, 128)},
+		// This host
+		{IP: net.IP{0, 0, 0, 0}, Mask: net.CIDRMask(8, 32)},
+		{IP: net.IPv6unspecified, Mask: net.CIDRMask(128, 128)},
+	}
+}
+
 // ConsolidateConfig fills an Options struct by reading configuration from
 // config files, environment, then command line.
 //
@@ -96,12 +191,18 @@ func (o *Options) BuildURL_noescape(url string, args ...interface{}) string {
 func ConsolidateConfig() (opts *Options, err error) {
 	// Define defaults options
 	opts = &Options{
-		Bind:              ":8081",
-		AdminBind:         "./happydomain.sock",
-		ExternalURL:       "http://localhost:8081",
-		BaseURL:           "/",
-		DefaultNameServer: "127.0.0.1:53",
-		StorageEngine:     storage.StorageEngine("leveldb"),
+		Bind:                   ":8081",
+		AdminBind:              "./happydomain.sock",
+		ExternalURL:            "http://localhost:8081",
+		BaseURL:                "/",
+		DefaultNameServer:      "127.0.0.1:53",
+		StorageEngine:          storage.StorageEngine("leveldb"),
+		LogFormat:              "logfmt",
+		LogLevel:               "info",
+		ResolverDeniedNetworks: defaultResolverDeniedNetworks(),
+		ResolverRateLimit:      120,
+		ResolverCacheTTL:       time.Minute,
+		PropagationResolvers:   StringList{"1.1.1.1", "8.8.8.8", "9.9.9.9"},
 	}
 
 	opts.declareFlags()
@@ -148,6 +249,24 @@ func ConsolidateConfig() (opts *Options, err error) {
 		opts.BaseURL = ""
 	}
 
+	opts.Resolvers = DefaultResolvers
+	if opts.ResolversFile != "" {
+		opts.Resolvers, err = loadResolvers(opts.ResolversFile)
+		if err != nil {
+			return
+		}
+	}
+
+	opts.RootHints, err = loadRootHints(opts.RootHintsFile)
+	if err != nil {
+		return
+	}
+
+	opts.TrustAnchors, err = loadTrustAnchors(opts.TrustAnchorsFile)
+	if err != nil {
+		return
+	}
+
 	if len(opts.JWTSecretKey) == 0 {
 		opts.JWTSecretKey = make([]byte, 32)
 		_, err = rand.Read(opts.JWTSecretKey)
@@ -33,7 +33,9 @@ package config // import "happydns.org/config"
 
 import (
 	"encoding/base64"
+	"net"
 	"net/url"
+	"strings"
 )
 
 type JWTSecretKey []byte
@@ -73,3 +75,66 @@ func (i *URL) Set(value string) error {
 	i.URL = u
 	return nil
 }
+
+// StringList is a list of values, given comma-separated.
+type StringList []string
+
+func (l *StringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set replaces the list by the given values; an empty value clears it.
+func (l *StringList) Set(value string) error {
+	var list StringList
+
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	*l = list
+	return nil
+}
+
+// Networks is a list of IP networks, given as comma-separated CIDR.
+type Networks []*net.IPNet
+
+func (n *Networks) String() string {
+	var s []string
+	for _, network := range *n {
+		s = append(s, network.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// Set replaces the list by the given networks; an empty value clears it.
+func (n *Networks) Set(value string) error {
+	var networks Networks
+
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, network)
+	}
+
+	*n = networks
+	return nil
+}
+
+// Contains checks if ip belongs to one of the networks.
+func (n Networks) Contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
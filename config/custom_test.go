@@ -0,0 +1,117 @@
+// Copyright or © or Copr. happyDNS (2021)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNetworksSet(t *testing.T) {
+	var n Networks
+	if err := n.Set(" 192.0.2.0/24, 2001:db8::/32,,"); err != nil {
+		t.Fatalf("Set: %s", err.Error())
+	}
+	if n.String() != "192.0.2.0/24,2001:db8::/32" {
+		t.Errorf("got %q, expected 192.0.2.0/24,2001:db8::/32", n.String())
+	}
+
+	for _, ip := range []string{"192.0.2.1", "2001:db8::1", "::ffff:192.0.2.1"} {
+		if !n.Contains(net.ParseIP(ip)) {
+			t.Errorf("%s is not contained", ip)
+		}
+	}
+	for _, ip := range []string{"198.51.100.1", "2001:db9::1"} {
+		if n.Contains(net.ParseIP(ip)) {
+			t.Errorf("%s is contained", ip)
+		}
+	}
+
+	// Invalid values leave the list unchanged
+	for _, value := range []string{"192.0.2.1", "192.0.2.0/33", "example.com"} {
+		if err := n.Set(value); err == nil {
+			t.Errorf("Set(%q) succeeded, expected an error", value)
+		}
+	}
+	if len(n) != 2 {
+		t.Errorf("got %d networks after invalid values, expected 2", len(n))
+	}
+
+	if err := n.Set(""); err != nil || len(n) != 0 {
+		t.Errorf("an empty value gave %v (%v), expected no network", n, err)
+	}
+}
+
+func TestDefaultResolverDeniedNetworks(t *testing.T) {
+	denied := defaultResolverDeniedNetworks()
+
+	for _, ip := range []string{
+		// Loopback
+		"127.0.0.1", "127.0.0.53", "::1",
+		// RFC 1918
+		"10.1.2.3", "172.16.0.1", "172.31.255.255", "192.168.1.1",
+		// Carrier-grade NAT
+		"100.64.0.1",
+		// NAT64
+		"64:ff9b::7f00:1", "64:ff9b::a00:1",
+		// IPv4-mapped
+		"::ffff:127.0.0.1", "::ffff:10.0.0.1", "::ffff:169.254.169.254",
+		// Link-local and unique local
+		"169.254.169.254", "fe80::1", "fd00::1",
+		// This host
+		"0.0.0.0", "::",
+	} {
+		if !denied.Contains(net.ParseIP(ip)) {
+			t.Errorf("%s is not denied", ip)
+		}
+	}
+
+	for _, ip := range []string{"9.9.9.9", "1.1.1.1", "172.32.0.1", "2620:fe::fe", "::ffff:9.9.9.9"} {
+		if denied.Contains(net.ParseIP(ip)) {
+			t.Errorf("%s is denied", ip)
+		}
+	}
+}
+
+func TestStringListSet(t *testing.T) {
+	var l StringList
+	if err := l.Set(" 1.1.1.1, ,9.9.9.9,"); err != nil {
+		t.Fatalf("Set: %s", err.Error())
+	}
+	if l.String() != "1.1.1.1,9.9.9.9" {
+		t.Errorf("got %q, expected 1.1.1.1,9.9.9.9", l.String())
+	}
+
+	if err := l.Set(""); err != nil || len(l) != 0 {
+		t.Errorf("an empty value gave %v (%v), expected an empty list", l, err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package config // import "happydns.org/config"
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"git.happydns.org/happydomain/model"
+)
+
+// DefaultResolvers is the list of resolvers proposed in the resolver tool,
+// unless a file is given with -resolvers-file.
+var DefaultResolvers = []happydns.Resolver{
+	{Address: "1.1.1.1", Name: "Cloudflare DNS resolver", TLSName: "cloudflare-dns.com", DoHURL: "https://cloudflare-dns.com/dns-query"},
+	{Address: "4.2.2.1", Name: "Level3 resolver"},
+	{Address: "8.8.8.8", Name: "Google Public DNS resolver", TLSName: "dns.google", DoHURL: "https://dns.google/dns-query"},
+	{Address: "9.9.9.10", Name: "Quad9 DNS resolver without security blocklist", TLSName: "dns10.quad9.net", DoHURL: "https://dns10.quad9.net/dns-query"},
+	{Address: "64.6.64.6", Name: "Verisign DNS resolver"},
+	{Address: "74.82.42.42", Name: "Hurricane Electric DNS resolver", TLSName: "ordns.he.net", DoHURL: "https://ordns.he.net/dns-query"},
+	{Address: "208.67.222.222", Name: "OpenDNS resolver", TLSName: "dns.opendns.com", DoHURL: "https://doh.opendns.com/dns-query"},
+	{Address: "8.26.56.26", Name: "Comodo Secure DNS resolver"},
+	{Address: "199.85.126.10", Name: "Norton ConnectSafe DNS resolver"},
+	{Address: "198.54.117.10", Name: "SafeServe DNS resolver"},
+	{Address: "84.200.69.80", Name: "DNS.WATCH resolver"},
+	{Address: "185.121.177.177", Name: "OpenNIC DNS resolver"},
+	{Address: "37.235.1.174", Name: "FreeDNS resolver"},
+	{Address: "80.80.80.80", Name: "Freenom World DNS resolver"},
+	{Address: "216.131.65.63", Name: "StrongDNS resolver"},
+	{Address: "94.140.14.140", Name: "AdGuard non-filtering DNS resolver", TLSName: "unfiltered.adguard-dns.com", DoHURL: "https://unfiltered.adguard-dns.com/dns-query"},
+	{Address: "91.239.100.100", Name: "Uncensored DNS resolver", TLSName: "anycast.uncensoreddns.org", DoHURL: "https://anycast.uncensoreddns.org/dns-query"},
+	{Address: "216.146.35.35", Name: "Dyn DNS resolver"},
+	{Address: "77.88.8.8", Name: "Yandex.DNS resolver"},
+	{Address: "129.250.35.250", Name: "NTT DNS resolver"},
+	{Address: "223.5.5.5", Name: "AliDNS resolver", TLSName: "dns.alidns.com", DoHURL: "https://dns.alidns.com/dns-query"},
+	{Address: "1.2.4.8", Name: "CNNIC SDNS resolver"},
+	{Address: "119.29.29.29", Name: "DNSPod resolver", TLSName: "dot.pub", DoHURL: "https://doh.pub/dns-query"},
+	{Address: "114.215.126.16", Name: "oneDNS resolver"},
+	{Address: "124.251.124.251", Name: "cloudxns resolver"},
+	{Address: "114.114.114.114", Name: "114DNS resolver"},
+	{Address: "156.154.70.1", Name: "Neustar DNS resolver"},
+	{Address: "87.118.111.215", Name: "FoolDNS resolver"},
+	{Address: "101.101.101.101", Name: "Quad 101 DNS resolver", TLSName: "dns.twnic.tw", DoHURL: "https://dns.twnic.tw/dns-query"},
+	{Address: "168.95.1.1", Name: "HiNet DNS resolver"},
+	{Address: "80.67.169.12", Name: "French Data Network DNS resolver"},
+	{Address: "81.218.119.11", Name: "GreenTeamDNS resolver"},
+	{Address: "208.76.50.50", Name: "SmartViper DNS resolver"},
+	{Address: "23.253.163.53", Name: "Alternate DNS resolver"},
+	{Address: "109.69.8.51", Name: "puntCAT DNS resolver"},
+	{Address: "101.226.4.6", Name: "DNSpai resolver"},
+
+	{Address: "1.1.1.2", Name: "Cloudflare Malware Blocking Only DNS resolver", Filtered: true, TLSName: "security.cloudflare-dns.com", DoHURL: "https://security.cloudflare-dns.com/dns-query"},
+	{Address: "1.1.1.3", Name: "Cloudflare Malware and Adult Content Blocking Only DNS resolver", Filtered: true, TLSName: "family.cloudflare-dns.com", DoHURL: "https://family.cloudflare-dns.com/dns-query"},
+	{Address: "9.9.9.9", Name: "Quad9 DNS resolver", Filtered: true, TLSName: "dns.quad9.net", DoHURL: "https://dns.quad9.net/dns-query"},
+	{Address: "94.140.14.14", Name: "AdGuard default DNS resolver", Filtered: true, TLSName: "dns.adguard-dns.com", DoHURL: "https://dns.adguard-dns.com/dns-query"},
+	{Address: "94.140.14.15", Name: "AdGuard family protection DNS resolver", Filtered: true, TLSName: "family.adguard-dns.com", DoHURL: "https://family.adguard-dns.com/dns-query"},
+	{Address: "77.88.8.2", Name: "Yandex.DNS Safe resolver", Filtered: true},
+	{Address: "77.88.8.3", Name: "Yandex.DNS Family resolver", Filtered: true},
+	{Address: "156.154.70.2", Name: "DNS Advantage Threat Protection resolver", Filtered: true},
+	{Address: "156.154.70.3", Name: "DNS Advantage Family Secure resolver", Filtered: true},
+	{Address: "156.154.70.4", Name: "DNS Advantage Business Secure resolver", Filtered: true},
+	{Address: "185.228.168.168", Name: "CleanBrowsing Family Filter DNS resolver", Filtered: true, TLSName: "family-filter-dns.cleanbrowsing.org", DoHURL: "https://doh.cleanbrowsing.org/doh/family-filter/"},
+	{Address: "185.228.168.10", Name: "CleanBrowsing Adult Filter DNS resolver", Filtered: true, TLSName: "adult-filter-dns.cleanbrowsing.org", DoHURL: "https://doh.cleanbrowsing.org/doh/adult-filter/"},
+}
+
+// loadResolvers reads a JSON list of resolvers, replacing the default ones.
+func loadResolvers(filename string) ([]happydns.Resolver, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var resolvers []happydns.Resolver
+	if err = json.NewDecoder(fd).Decode(&resolvers); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", filename, err)
+	}
+
+	for _, r := range resolvers {
+		if r.Address == "" {
+			return nil, fmt.Errorf("unable to parse %s: resolver %q has no address", filename, r.Name)
+		}
+	}
+
+	return resolvers, nil
+}
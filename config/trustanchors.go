@@ -0,0 +1,91 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package config // import "happydns.org/config"
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// defaultTrustAnchors are the DS records of the root zone KSKs, as published
+// by IANA in root-anchors.xml.
+const defaultTrustAnchors = `
+. IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D
+. IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16
+`
+
+// parseTrustAnchors reads DS or DNSKEY records in the zone file format.
+// DNSKEY records are turned into their SHA-256 DS.
+func parseTrustAnchors(r io.Reader, filename string) (anchors []*dns.DS, err error) {
+	zp := dns.NewZoneParser(r, ".", filename)
+
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch rr := rr.(type) {
+		case *dns.DS:
+			anchors = append(anchors, rr)
+		case *dns.DNSKEY:
+			if ds := rr.ToDS(dns.SHA256); ds != nil {
+				anchors = append(anchors, ds)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected %s record in trust anchors %s", dns.TypeToString[rr.Header().Rrtype], filename)
+		}
+	}
+
+	if err = zp.Err(); err != nil {
+		return nil, fmt.Errorf("unable to parse trust anchors %s: %w", filename, err)
+	} else if len(anchors) == 0 {
+		return nil, fmt.Errorf("no DS or DNSKEY record in trust anchors %s", filename)
+	}
+
+	return anchors, nil
+}
+
+// loadTrustAnchors reads the given trust anchors file, or the root zone
+// anchors when filename is empty.
+func loadTrustAnchors(filename string) ([]*dns.DS, error) {
+	if filename == "" {
+		return parseTrustAnchors(strings.NewReader(defaultTrustAnchors), "root-anchors")
+	}
+
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	return parseTrustAnchors(fd, filename)
+}
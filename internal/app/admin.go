@@ -33,7 +33,6 @@ package app
 
 import (
 	"context"
-	"log"
 	"net"
 	"net/http"
 	"os"
@@ -41,9 +40,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 
 	"git.happydns.org/happydomain/admin"
 	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/internal/metrics"
 )
 
 type Admin struct {
@@ -59,9 +61,10 @@ func NewAdmin(cfg *config.Options) Admin {
 
 	gin.ForceConsoleColor()
 	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery())
+	router.Use(logging.Middleware(), gin.Recovery())
 
 	admin.DeclareRoutes(cfg, router)
+	router.GET("/metrics", metrics.GinHandler())
 
 	app := Admin{
 		router: router,
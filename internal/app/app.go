@@ -33,14 +33,16 @@ package app
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
 
 	"git.happydns.org/happydomain/api"
 	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/internal/metrics"
 	"git.happydns.org/happydomain/ui"
 )
 
@@ -48,6 +50,7 @@ type App struct {
 	router *gin.Engine
 	cfg    *config.Options
 	srv    *http.Server
+	cancel context.CancelFunc
 }
 
 func NewApp(cfg *config.Options) App {
@@ -57,7 +60,7 @@ func NewApp(cfg *config.Options) App {
 
 	gin.ForceConsoleColor()
 	router := gin.New()
-	router.Use(gin.Logger(), gin.Recovery())
+	router.Use(logging.Middleware(), gin.Recovery(), metrics.HTTPMiddleware())
 
 	api.DeclareRoutes(cfg, router)
 	ui.DeclareRoutes(cfg, router)
@@ -71,6 +74,10 @@ func NewApp(cfg *config.Options) App {
 }
 
 func (app *App) Start() {
+	var ctx context.Context
+	ctx, app.cancel = context.WithCancel(context.Background())
+	go api.ExpireACMEChallenges(ctx)
+
 	app.srv = &http.Server{
 		Addr:    app.cfg.Bind,
 		Handler: app.router,
@@ -86,4 +93,8 @@ func (app *App) Stop() {
 	if err := app.srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server Shutdown:", err)
 	}
+
+	if app.cancel != nil {
+		app.cancel()
+	}
 }
@@ -0,0 +1,83 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package app
+
+import (
+	"encoding/json"
+	"os"
+
+	"git.happydns.org/happydomain/storage"
+	"git.happydns.org/happydomain/storage/backup"
+)
+
+// ExportDatabase writes the whole content of the current database to the
+// given file ("-" for the standard output).
+func ExportDatabase(path string) error {
+	archive, err := backup.Export(storage.MainStore)
+	if err != nil {
+		return err
+	}
+
+	fd := os.Stdout
+	if path != "-" {
+		fd, err = os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+	}
+
+	enc := json.NewEncoder(fd)
+	enc.SetIndent("", "  ")
+	return enc.Encode(archive)
+}
+
+// ImportDatabase restores into the current database the archive read from
+// the given file ("-" for the standard input).
+func ImportDatabase(path string) error {
+	fd := os.Stdin
+	if path != "-" {
+		var err error
+		fd, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer fd.Close()
+	}
+
+	var archive backup.Archive
+	if err := json.NewDecoder(fd).Decode(&archive); err != nil {
+		return err
+	}
+
+	return backup.Import(storage.MainStore, &archive)
+}
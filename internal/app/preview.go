@@ -0,0 +1,91 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/config"
+	"git.happydns.org/happydomain/internal/preview"
+)
+
+// PreviewDNS serves zone revisions over DNS, on UDP and TCP.
+type PreviewDNS struct {
+	cfg *config.Options
+	udp *dns.Server
+	tcp *dns.Server
+}
+
+func NewPreviewDNS(cfg *config.Options) *PreviewDNS {
+	handler := preview.NewHandler()
+
+	return &PreviewDNS{
+		cfg: cfg,
+		udp: &dns.Server{
+			Addr:    cfg.PreviewDNSBind,
+			Net:     "udp",
+			Handler: handler,
+		},
+		tcp: &dns.Server{
+			Addr:    cfg.PreviewDNSBind,
+			Net:     "tcp",
+			Handler: handler,
+		},
+	}
+}
+
+func (app *PreviewDNS) Start() {
+	go func() {
+		if err := app.tcp.ListenAndServe(); err != nil {
+			log.Fatalf("preview DNS listen: %s\n", err)
+		}
+	}()
+
+	if err := app.udp.ListenAndServe(); err != nil {
+		log.Fatalf("preview DNS listen: %s\n", err)
+	}
+}
+
+func (app *PreviewDNS) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := app.udp.ShutdownContext(ctx); err != nil {
+		log.Fatal("Preview DNS Server Shutdown:", err)
+	}
+	if err := app.tcp.ShutdownContext(ctx); err != nil {
+		log.Fatal("Preview DNS Server Shutdown:", err)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+// Package logging sets up the structured logger and attaches request scoped
+// fields to log lines.
+package logging // import "happydns.org/internal/logging"
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+)
+
+// RequestIDHeader is the HTTP header used to receive and forward the
+// request identifier.
+const RequestIDHeader = "X-Request-Id"
+
+// requestIDKey is the gin context key holding the request identifier.
+const requestIDKey = "RequestID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Setup configures the global logger with the given output format (logfmt
+// or json) and minimal level.
+func Setup(format string, level string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(lvl)
+
+	switch format {
+	case "logfmt", "":
+		log.SetFormatter(&log.TextFormatter{
+			DisableColors: true,
+			FullTimestamp: true,
+		})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q, expected logfmt or json", format)
+	}
+
+	return nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Middleware assigns an identifier to each request, reusing the one given by
+// a reverse proxy if any, then logs the request once handled.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		reqID := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(reqID) {
+			reqID = newRequestID()
+		}
+		c.Set(requestIDKey, reqID)
+		c.Header(RequestIDHeader, reqID)
+
+		c.Next()
+
+		entry := FromContext(c).WithFields(log.Fields{
+			"method":  c.Request.Method,
+			"path":    c.Request.URL.Path,
+			"status":  c.Writer.Status(),
+			"latency": time.Since(start).String(),
+		})
+
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("errors", c.Errors.String())
+		}
+
+		if c.Writer.Status() >= 500 {
+			entry.Error("request handled")
+		} else {
+			entry.Info("request handled")
+		}
+	}
+}
+
+// RequestID returns the identifier of the current request.
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// FromContext returns a logger carrying the request identifier, the client
+// IP and, when they are known at this stage of the request, the user and
+// domain identifiers.
+func FromContext(c *gin.Context) *log.Entry {
+	fields := log.Fields{
+		"client_ip": c.ClientIP(),
+	}
+
+	if reqID := RequestID(c); reqID != "" {
+		fields["request_id"] = reqID
+	}
+
+	if user, ok := c.Get("LoggedUser"); ok {
+		if u, ok := user.(*happydns.User); ok && u != nil {
+			fields["user_id"] = u.Id.String()
+		}
+	}
+
+	if impersonator := c.GetString("Impersonator"); impersonator != "" {
+		fields["impersonator"] = impersonator
+	}
+
+	if domain, ok := c.Get("domain"); ok {
+		if d, ok := domain.(*happydns.Domain); ok && d != nil {
+			fields["domain_id"] = d.Id.String()
+			fields["domain"] = d.DomainName
+		}
+	}
+
+	return log.WithFields(fields)
+}
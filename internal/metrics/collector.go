@@ -0,0 +1,88 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/storage"
+)
+
+var (
+	usersDesc   = prometheus.NewDesc(namespace+"_users", "Number of registered users.", nil, nil)
+	domainsDesc = prometheus.NewDesc(namespace+"_domains", "Number of managed domains.", nil, nil)
+	zonesDesc   = prometheus.NewDesc(namespace+"_zones", "Number of zones kept in domains history.", nil, nil)
+)
+
+// contentCollector counts the items stored in the database, at each scrape.
+type contentCollector struct{}
+
+func init() {
+	prometheus.MustRegister(contentCollector{})
+}
+
+func (contentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usersDesc
+	ch <- domainsDesc
+	ch <- zonesDesc
+}
+
+func (contentCollector) Collect(ch chan<- prometheus.Metric) {
+	if storage.MainStore == nil {
+		return
+	}
+
+	users, err := storage.MainStore.GetUsers()
+	if err != nil {
+		log.Println("Unable to collect users metrics:", err.Error())
+		return
+	}
+
+	var nbDomains, nbZones int
+	for _, user := range users {
+		domains, err := storage.MainStore.GetDomains(user)
+		if err != nil {
+			log.Printf("Unable to collect domains metrics of %s: %s", user.Id.String(), err.Error())
+			return
+		}
+
+		nbDomains += len(domains)
+		for _, domain := range domains {
+			nbZones += len(domain.ZoneHistory)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(len(users)))
+	ch <- prometheus.MustNewConstMetric(domainsDesc, prometheus.GaugeValue, float64(nbDomains))
+	ch <- prometheus.MustNewConstMetric(zonesDesc, prometheus.GaugeValue, float64(nbZones))
+}
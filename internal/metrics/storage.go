@@ -0,0 +1,339 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/storage"
+)
+
+var storageDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "storage_operation_duration_seconds",
+	Help:      "Time spent in storage operations, by operation.",
+	Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+}, []string{"operation"})
+
+func observeStorage(operation string, start time.Time) {
+	storageDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// instrumentedStorage times each call made to the underlying Storage.
+type instrumentedStorage struct {
+	storage.Storage
+}
+
+// InstrumentStorage wraps the given Storage in order to record the latency
+// of each of its operations.
+func InstrumentStorage(s storage.Storage) storage.Storage {
+	return &instrumentedStorage{s}
+}
+
+func (s *instrumentedStorage) DoMigration() error {
+	defer observeStorage("DoMigration", time.Now())
+	return s.Storage.DoMigration()
+}
+
+func (s *instrumentedStorage) Tidy() error {
+	defer observeStorage("Tidy", time.Now())
+	return s.Storage.Tidy()
+}
+
+func (s *instrumentedStorage) Close() error {
+	defer observeStorage("Close", time.Now())
+	return s.Storage.Close()
+}
+
+func (s *instrumentedStorage) GetAuthUsers() (happydns.UserAuths, error) {
+	defer observeStorage("GetAuthUsers", time.Now())
+	return s.Storage.GetAuthUsers()
+}
+
+func (s *instrumentedStorage) GetAuthUser(id happydns.Identifier) (*happydns.UserAuth, error) {
+	defer observeStorage("GetAuthUser", time.Now())
+	return s.Storage.GetAuthUser(id)
+}
+
+func (s *instrumentedStorage) GetAuthUserByEmail(email string) (*happydns.UserAuth, error) {
+	defer observeStorage("GetAuthUserByEmail", time.Now())
+	return s.Storage.GetAuthUserByEmail(email)
+}
+
+func (s *instrumentedStorage) AuthUserExists(email string) bool {
+	defer observeStorage("AuthUserExists", time.Now())
+	return s.Storage.AuthUserExists(email)
+}
+
+func (s *instrumentedStorage) CreateAuthUser(user *happydns.UserAuth) error {
+	defer observeStorage("CreateAuthUser", time.Now())
+	return s.Storage.CreateAuthUser(user)
+}
+
+func (s *instrumentedStorage) UpdateAuthUser(user *happydns.UserAuth) error {
+	defer observeStorage("UpdateAuthUser", time.Now())
+	return s.Storage.UpdateAuthUser(user)
+}
+
+func (s *instrumentedStorage) DeleteAuthUser(user *happydns.UserAuth) error {
+	defer observeStorage("DeleteAuthUser", time.Now())
+	return s.Storage.DeleteAuthUser(user)
+}
+
+func (s *instrumentedStorage) ClearAuthUsers() error {
+	defer observeStorage("ClearAuthUsers", time.Now())
+	return s.Storage.ClearAuthUsers()
+}
+
+func (s *instrumentedStorage) GetCredentials(u *happydns.User) ([]*happydns.Credential, error) {
+	defer observeStorage("GetCredentials", time.Now())
+	return s.Storage.GetCredentials(u)
+}
+
+func (s *instrumentedStorage) GetCredential(id happydns.Identifier) (*happydns.Credential, error) {
+	defer observeStorage("GetCredential", time.Now())
+	return s.Storage.GetCredential(id)
+}
+
+func (s *instrumentedStorage) CreateCredential(c *happydns.Credential) error {
+	defer observeStorage("CreateCredential", time.Now())
+	return s.Storage.CreateCredential(c)
+}
+
+func (s *instrumentedStorage) UpdateCredential(c *happydns.Credential) error {
+	defer observeStorage("UpdateCredential", time.Now())
+	return s.Storage.UpdateCredential(c)
+}
+
+func (s *instrumentedStorage) DeleteCredential(c *happydns.Credential) error {
+	defer observeStorage("DeleteCredential", time.Now())
+	return s.Storage.DeleteCredential(c)
+}
+
+func (s *instrumentedStorage) ClearCredentials() error {
+	defer observeStorage("ClearCredentials", time.Now())
+	return s.Storage.ClearCredentials()
+}
+
+func (s *instrumentedStorage) GetDomains(u *happydns.User) (happydns.Domains, error) {
+	defer observeStorage("GetDomains", time.Now())
+	return s.Storage.GetDomains(u)
+}
+
+func (s *instrumentedStorage) GetDomain(u *happydns.User, id happydns.Identifier) (*happydns.Domain, error) {
+	defer observeStorage("GetDomain", time.Now())
+	return s.Storage.GetDomain(u, id)
+}
+
+func (s *instrumentedStorage) GetDomainByDN(u *happydns.User, dn string) (*happydns.Domain, error) {
+	defer observeStorage("GetDomainByDN", time.Now())
+	return s.Storage.GetDomainByDN(u, dn)
+}
+
+func (s *instrumentedStorage) GetDomainByZone(id happydns.Identifier) (*happydns.Domain, error) {
+	defer observeStorage("GetDomainByZone", time.Now())
+	return s.Storage.GetDomainByZone(id)
+}
+
+func (s *instrumentedStorage) DomainExists(dn string) bool {
+	defer observeStorage("DomainExists", time.Now())
+	return s.Storage.DomainExists(dn)
+}
+
+func (s *instrumentedStorage) CreateDomain(u *happydns.User, z *happydns.Domain) error {
+	defer observeStorage("CreateDomain", time.Now())
+	return s.Storage.CreateDomain(u, z)
+}
+
+func (s *instrumentedStorage) UpdateDomain(z *happydns.Domain) error {
+	defer observeStorage("UpdateDomain", time.Now())
+	return s.Storage.UpdateDomain(z)
+}
+
+func (s *instrumentedStorage) UpdateDomainOwner(z *happydns.Domain, newOwner *happydns.User) error {
+	defer observeStorage("UpdateDomainOwner", time.Now())
+	return s.Storage.UpdateDomainOwner(z, newOwner)
+}
+
+func (s *instrumentedStorage) DeleteDomain(z *happydns.Domain) error {
+	defer observeStorage("DeleteDomain", time.Now())
+	return s.Storage.DeleteDomain(z)
+}
+
+func (s *instrumentedStorage) ClearDomains() error {
+	defer observeStorage("ClearDomains", time.Now())
+	return s.Storage.ClearDomains()
+}
+
+func (s *instrumentedStorage) GetProviderMetas(u *happydns.User) ([]happydns.ProviderMeta, error) {
+	defer observeStorage("GetProviderMetas", time.Now())
+	return s.Storage.GetProviderMetas(u)
+}
+
+func (s *instrumentedStorage) GetProviderMeta(u *happydns.User, id happydns.Identifier) (*happydns.ProviderMeta, error) {
+	defer observeStorage("GetProviderMeta", time.Now())
+	return s.Storage.GetProviderMeta(u, id)
+}
+
+func (s *instrumentedStorage) GetProvider(u *happydns.User, id happydns.Identifier) (*happydns.ProviderCombined, error) {
+	defer observeStorage("GetProvider", time.Now())
+	return s.Storage.GetProvider(u, id)
+}
+
+func (s *instrumentedStorage) CreateProvider(u *happydns.User, src happydns.Provider, comment string) (*happydns.ProviderCombined, error) {
+	defer observeStorage("CreateProvider", time.Now())
+	return s.Storage.CreateProvider(u, src, comment)
+}
+
+func (s *instrumentedStorage) UpdateProvider(src *happydns.ProviderCombined) error {
+	defer observeStorage("UpdateProvider", time.Now())
+	return s.Storage.UpdateProvider(src)
+}
+
+func (s *instrumentedStorage) UpdateProviderOwner(src *happydns.ProviderCombined, newOwner *happydns.User) error {
+	defer observeStorage("UpdateProviderOwner", time.Now())
+	return s.Storage.UpdateProviderOwner(src, newOwner)
+}
+
+func (s *instrumentedStorage) DeleteProvider(src *happydns.ProviderMeta) error {
+	defer observeStorage("DeleteProvider", time.Now())
+	return s.Storage.DeleteProvider(src)
+}
+
+func (s *instrumentedStorage) ClearProviders() error {
+	defer observeStorage("ClearProviders", time.Now())
+	return s.Storage.ClearProviders()
+}
+
+func (s *instrumentedStorage) GetSession(id happydns.Identifier) (*happydns.Session, error) {
+	defer observeStorage("GetSession", time.Now())
+	return s.Storage.GetSession(id)
+}
+
+func (s *instrumentedStorage) GetAuthUserSessions(user *happydns.UserAuth) ([]*happydns.Session, error) {
+	defer observeStorage("GetAuthUserSessions", time.Now())
+	return s.Storage.GetAuthUserSessions(user)
+}
+
+func (s *instrumentedStorage) GetUserSessions(user *happydns.User) ([]*happydns.Session, error) {
+	defer observeStorage("GetUserSessions", time.Now())
+	return s.Storage.GetUserSessions(user)
+}
+
+func (s *instrumentedStorage) CreateSession(session *happydns.Session) error {
+	defer observeStorage("CreateSession", time.Now())
+	return s.Storage.CreateSession(session)
+}
+
+func (s *instrumentedStorage) UpdateSession(session *happydns.Session) error {
+	defer observeStorage("UpdateSession", time.Now())
+	return s.Storage.UpdateSession(session)
+}
+
+func (s *instrumentedStorage) DeleteSession(session *happydns.Session) error {
+	defer observeStorage("DeleteSession", time.Now())
+	return s.Storage.DeleteSession(session)
+}
+
+func (s *instrumentedStorage) ClearSessions() error {
+	defer observeStorage("ClearSessions", time.Now())
+	return s.Storage.ClearSessions()
+}
+
+func (s *instrumentedStorage) GetUsers() (happydns.Users, error) {
+	defer observeStorage("GetUsers", time.Now())
+	return s.Storage.GetUsers()
+}
+
+func (s *instrumentedStorage) GetUser(id happydns.Identifier) (*happydns.User, error) {
+	defer observeStorage("GetUser", time.Now())
+	return s.Storage.GetUser(id)
+}
+
+func (s *instrumentedStorage) GetUserByEmail(email string) (*happydns.User, error) {
+	defer observeStorage("GetUserByEmail", time.Now())
+	return s.Storage.GetUserByEmail(email)
+}
+
+func (s *instrumentedStorage) CreateUser(user *happydns.User) error {
+	defer observeStorage("CreateUser", time.Now())
+	return s.Storage.CreateUser(user)
+}
+
+func (s *instrumentedStorage) UpdateUser(user *happydns.User) error {
+	defer observeStorage("UpdateUser", time.Now())
+	return s.Storage.UpdateUser(user)
+}
+
+func (s *instrumentedStorage) DeleteUser(user *happydns.User) error {
+	defer observeStorage("DeleteUser", time.Now())
+	return s.Storage.DeleteUser(user)
+}
+
+func (s *instrumentedStorage) ClearUsers() error {
+	defer observeStorage("ClearUsers", time.Now())
+	return s.Storage.ClearUsers()
+}
+
+func (s *instrumentedStorage) GetZoneMeta(id happydns.Identifier) (*happydns.ZoneMeta, error) {
+	defer observeStorage("GetZoneMeta", time.Now())
+	return s.Storage.GetZoneMeta(id)
+}
+
+func (s *instrumentedStorage) GetZone(id happydns.Identifier) (*happydns.Zone, error) {
+	defer observeStorage("GetZone", time.Now())
+	return s.Storage.GetZone(id)
+}
+
+func (s *instrumentedStorage) CreateZone(zone *happydns.Zone) error {
+	defer observeStorage("CreateZone", time.Now())
+	return s.Storage.CreateZone(zone)
+}
+
+func (s *instrumentedStorage) UpdateZone(zone *happydns.Zone) error {
+	defer observeStorage("UpdateZone", time.Now())
+	return s.Storage.UpdateZone(zone)
+}
+
+func (s *instrumentedStorage) DeleteZone(zone *happydns.Zone) error {
+	defer observeStorage("DeleteZone", time.Now())
+	return s.Storage.DeleteZone(zone)
+}
+
+func (s *instrumentedStorage) ClearZones() error {
+	defer observeStorage("ClearZones", time.Now())
+	return s.Storage.ClearZones()
+}
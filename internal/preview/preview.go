@@ -0,0 +1,412 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+// Package preview serves zone revisions over DNS, so that they can be tested
+// with real clients before being published.
+package preview
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/storage"
+)
+
+const (
+	// maxCNAMEChain is the number of CNAME followed inside the zone.
+	maxCNAMEChain = 8
+
+	// missTTL is the time during which an unknown revision is not looked
+	// up again.
+	missTTL = time.Minute
+
+	// maxMisses is the number of unknown revisions remembered.
+	maxMisses = 4096
+
+	// lookupRate and lookupBurst limit the searches of the domain owning
+	// a revision not in cache, as each one scans the domains.
+	lookupRate  = 20
+	lookupBurst = 40
+)
+
+var (
+	errUnknownRevision = errors.New("unknown zone revision")
+	errLookupThrottled = errors.New("too many zone revisions looked up")
+)
+
+// cachedOrigin is the domain a zone revision belongs to.
+type cachedOrigin struct {
+	domain happydns.Identifier
+	owner  happydns.Identifier
+	name   string
+}
+
+// Handler answers the DNS queries for names below "<domain>.<view>.", with
+// the records the zone revision would publish: the last label, built by
+// ViewLabel, selects the revision, the others are the name looked for in it.
+type Handler struct {
+	mu      sync.Mutex
+	origins map[string]cachedOrigin
+	misses  map[string]time.Time
+	lookups *rate.Limiter
+}
+
+// NewHandler creates a Handler reading zones from the main storage.
+func NewHandler() *Handler {
+	return &Handler{
+		origins: map[string]cachedOrigin{},
+		misses:  map[string]time.Time{},
+		lookups: rate.NewLimiter(lookupRate, lookupBurst),
+	}
+}
+
+// ViewLabel returns the label designating the zone revision in the queried
+// names. As resolvers and clients may change the case of names, it is the
+// zone identifier in lowercase hexadecimal.
+func ViewLabel(id happydns.Identifier) string {
+	return hex.EncodeToString(id)
+}
+
+// parseViewLabel returns the zone identifier designated by the label, whatever
+// its case.
+func parseViewLabel(label string) (happydns.Identifier, error) {
+	return hex.DecodeString(strings.ToLower(label))
+}
+
+// origin returns the name of the domain the zone revision belongs to.
+func (h *Handler) origin(id happydns.Identifier) (string, error) {
+	key := id.String()
+
+	h.mu.Lock()
+	cached, found := h.origins[key]
+	missed, isMiss := h.misses[key]
+	h.mu.Unlock()
+
+	if found {
+		// The domain may have been deleted, or the revision trimmed from
+		// its history, since it was cached
+		if domain, err := storage.MainStore.GetDomain(&happydns.User{Id: cached.owner}, cached.domain); err == nil && hasRevision(domain, id) {
+			return cached.name, nil
+		}
+
+		h.mu.Lock()
+		delete(h.origins, key)
+		h.mu.Unlock()
+	} else if isMiss && time.Since(missed) < missTTL {
+		return "", errUnknownRevision
+	}
+
+	if !h.lookups.Allow() {
+		return "", errLookupThrottled
+	}
+
+	domain, err := storage.MainStore.GetDomainByZone(id)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		h.addMiss(key)
+		return "", err
+	}
+
+	delete(h.misses, key)
+	h.origins[key] = cachedOrigin{
+		domain: domain.Id,
+		owner:  domain.IdUser,
+		name:   domain.DomainName,
+	}
+
+	return domain.DomainName, nil
+}
+
+// addMiss remembers an unknown revision. The caller has to hold h.mu.
+func (h *Handler) addMiss(key string) {
+	if len(h.misses) >= maxMisses {
+		now := time.Now()
+		for k, t := range h.misses {
+			if now.Sub(t) >= missTTL {
+				delete(h.misses, k)
+			}
+		}
+
+		if len(h.misses) >= maxMisses {
+			h.misses = map[string]time.Time{}
+		}
+	}
+
+	h.misses[key] = time.Now()
+}
+
+func hasRevision(domain *happydns.Domain, id happydns.Identifier) bool {
+	for _, zid := range domain.ZoneHistory {
+		if zid.Equals(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	if len(r.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		w.WriteMsg(m)
+		return
+	}
+	q := r.Question[0]
+
+	labels := dns.SplitDomainName(q.Name)
+	if len(labels) == 0 {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	view := labels[len(labels)-1] + "."
+	owner := dns.Fqdn(strings.Join(labels[:len(labels)-1], "."))
+
+	zone, origin, err := h.zone(view)
+	if err != nil || !dns.IsSubDomain(origin, owner) {
+		log.WithError(err).WithField("qname", q.Name).Debug("preview query refused")
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	rrs := zone.GenerateRRs(origin)
+
+	if q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR {
+		h.transfer(w, r, m, origin, owner, view, rrs)
+		return
+	}
+
+	answer(m, rrs, origin, owner, q.Qtype)
+	rename(m, view)
+
+	m.Compress = true
+	w.WriteMsg(m)
+}
+
+// zone retrieves the zone revision designated by the view label.
+func (h *Handler) zone(view string) (*happydns.Zone, string, error) {
+	id, err := parseViewLabel(strings.TrimSuffix(view, "."))
+	if err != nil {
+		return nil, "", err
+	}
+
+	origin, err := h.origin(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	zone, err := storage.MainStore.GetZone(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return zone, origin, nil
+}
+
+// transfer sends the whole zone, over TCP only.
+func (h *Handler) transfer(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, origin, owner, view string, rrs []dns.RR) {
+	soa := findRRs(rrs, origin, dns.TypeSOA)
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); !ok || !strings.EqualFold(owner, origin) || len(soa) == 0 {
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	records := []dns.RR{soa[0]}
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != dns.TypeSOA {
+			records = append(records, rr)
+		}
+	}
+	records = append(records, soa[0])
+
+	m.Answer = records
+	rename(m, view)
+
+	ch := make(chan *dns.Envelope)
+	tr := new(dns.Transfer)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- tr.Out(w, r, ch)
+	}()
+
+	for len(m.Answer) > 0 {
+		n := len(m.Answer)
+		if n > 100 {
+			n = 100
+		}
+		ch <- &dns.Envelope{RR: m.Answer[:n]}
+		m.Answer = m.Answer[n:]
+	}
+	close(ch)
+
+	if err := <-errs; err != nil {
+		log.WithError(err).Debug("preview zone transfer failed")
+	}
+	w.Close()
+}
+
+// findRRs returns the records of the given type at the given name,
+// dns.TypeANY matching any type.
+func findRRs(rrs []dns.RR, name string, qtype uint16) (ret []dns.RR) {
+	for _, rr := range rrs {
+		if strings.EqualFold(rr.Header().Name, name) && (qtype == dns.TypeANY || rr.Header().Rrtype == qtype) {
+			ret = append(ret, rr)
+		}
+	}
+	return
+}
+
+// nameExists tells whether the name owns records or is an empty
+// non-terminal.
+func nameExists(rrs []dns.RR, name string) bool {
+	for _, rr := range rrs {
+		if dns.IsSubDomain(name, rr.Header().Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// answer fills the response to a query about owner, as an authoritative
+// server of origin would.
+func answer(m *dns.Msg, rrs []dns.RR, origin, owner string, qtype uint16) {
+	soa := findRRs(rrs, origin, dns.TypeSOA)
+
+	// Look for a zone cut above or at the name
+	labels := dns.SplitDomainName(owner)
+	for i := range labels {
+		cut := dns.Fqdn(strings.Join(labels[i:], "."))
+		if strings.EqualFold(cut, origin) {
+			break
+		}
+		if qtype == dns.TypeDS && i == 0 {
+			continue
+		}
+
+		if ns := findRRs(rrs, cut, dns.TypeNS); len(ns) > 0 {
+			m.Ns = ns
+			for _, rr := range ns {
+				target := rr.(*dns.NS).Ns
+				m.Extra = append(m.Extra, findRRs(rrs, target, dns.TypeA)...)
+				m.Extra = append(m.Extra, findRRs(rrs, target, dns.TypeAAAA)...)
+			}
+			return
+		}
+	}
+
+	m.Authoritative = true
+
+	name := owner
+	for i := 0; i < maxCNAMEChain; i++ {
+		records := findRRs(rrs, name, dns.TypeANY)
+
+		// Wildcard synthesis, from the closest encloser
+		if len(records) == 0 && !nameExists(rrs, name) {
+			for encloser := name; !strings.EqualFold(encloser, origin); {
+				off, end := dns.NextLabel(encloser, 0)
+				if end {
+					break
+				}
+				encloser = encloser[off:]
+				if !nameExists(rrs, encloser) {
+					continue
+				}
+
+				for _, rr := range findRRs(rrs, "*."+encloser, dns.TypeANY) {
+					rr = dns.Copy(rr)
+					rr.Header().Name = name
+					records = append(records, rr)
+				}
+				break
+			}
+		}
+
+		if len(records) == 0 {
+			if !nameExists(rrs, name) && len(m.Answer) == 0 {
+				m.Rcode = dns.RcodeNameError
+			}
+			m.Ns = soa
+			return
+		}
+
+		var matching, cnames []dns.RR
+		for _, rr := range records {
+			if qtype == dns.TypeANY || rr.Header().Rrtype == qtype {
+				matching = append(matching, rr)
+			} else if rr.Header().Rrtype == dns.TypeCNAME {
+				cnames = append(cnames, rr)
+			}
+		}
+
+		if len(matching) > 0 {
+			m.Answer = append(m.Answer, matching...)
+			return
+		} else if len(cnames) == 0 {
+			m.Ns = soa
+			return
+		}
+
+		m.Answer = append(m.Answer, cnames[0])
+		name = cnames[0].(*dns.CNAME).Target
+		if !dns.IsSubDomain(origin, name) {
+			return
+		}
+	}
+}
+
+// rename appends the view label to the records owned by the zone, to match
+// the names queried.
+func rename(m *dns.Msg, view string) {
+	for _, section := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for i, rr := range section {
+			rr = dns.Copy(rr)
+			rr.Header().Name = dns.Fqdn(rr.Header().Name) + view
+			section[i] = rr
+		}
+	}
+}
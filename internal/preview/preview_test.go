@@ -0,0 +1,347 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package preview
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"golang.org/x/time/rate"
+
+	"git.happydns.org/happydomain/model"
+	svcs "git.happydns.org/happydomain/services"
+	"git.happydns.org/happydomain/storage"
+	database "git.happydns.org/happydomain/storage/memory"
+)
+
+// testRecords is the content of the zone example.com. used by the tests.
+var testRecords = map[string][]string{
+	"": {
+		"SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 300",
+		"NS ns.example.com.",
+	},
+	"ns":              {"A 192.0.2.1"},
+	"www":             {"A 192.0.2.2"},
+	"alias":           {"CNAME www.example.com."},
+	"chain":           {"CNAME alias.example.com."},
+	"outside":         {"CNAME www.example.org."},
+	"loop":            {"CNAME loop.example.com."},
+	"*.wild":          {"A 192.0.2.3"},
+	"exists.wild":     {"TXT \"here\""},
+	"deep.ent":        {"A 192.0.2.4"},
+	"sub":             {"NS ns.sub.example.com.", "NS ns.example.org.", "DS 12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF"},
+	"ns.sub":          {"A 192.0.2.5"},
+	"withds":          {"DS 12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF"},
+	"host.sub.nested": {"A 192.0.2.6"},
+}
+
+func newTestZone() *happydns.Zone {
+	zone := &happydns.Zone{
+		ZoneMeta: happydns.ZoneMeta{DefaultTTL: 3600},
+		Services: map[string][]*happydns.ServiceCombined{},
+	}
+
+	for sub, records := range testRecords {
+		for _, rr := range records {
+			zone.Services[sub] = append(zone.Services[sub], &happydns.ServiceCombined{
+				Service:     &svcs.Orphan{RR: rr},
+				ServiceMeta: happydns.ServiceMeta{Type: "svcs.Orphan", Domain: sub},
+			})
+		}
+	}
+
+	return zone
+}
+
+// records returns the records of the section as strings, sorted.
+func records(rrs []dns.RR) (ret []string) {
+	for _, rr := range rrs {
+		ret = append(ret, strings.Replace(rr.String(), "\t", " ", -1))
+	}
+	sort.Strings(ret)
+	return
+}
+
+func checkSection(t *testing.T, what, section string, got []dns.RR, expected []string) {
+	r := records(got)
+	sort.Strings(expected)
+	if strings.Join(r, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("%s: %s section is:\n%s\nexpected:\n%s", what, section, strings.Join(r, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	rrs := newTestZone().GenerateRRs("example.com.")
+
+	soa := "example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 1 3600 600 86400 300"
+	ds := "12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF"
+
+	tests := []struct {
+		name          string
+		qtype         uint16
+		rcode         int
+		authoritative bool
+		answer        []string
+		ns            []string
+		extra         []string
+	}{
+		{"www.example.com.", dns.TypeA, dns.RcodeSuccess, true, []string{"www.example.com. 3600 IN A 192.0.2.2"}, nil, nil},
+		{"WWW.Example.COM.", dns.TypeA, dns.RcodeSuccess, true, []string{"www.example.com. 3600 IN A 192.0.2.2"}, nil, nil},
+		// NODATA
+		{"www.example.com.", dns.TypeAAAA, dns.RcodeSuccess, true, nil, []string{soa}, nil},
+		// NXDOMAIN
+		{"nowhere.example.com.", dns.TypeA, dns.RcodeNameError, true, nil, []string{soa}, nil},
+		// Empty non-terminal
+		{"ent.example.com.", dns.TypeA, dns.RcodeSuccess, true, nil, []string{soa}, nil},
+		// CNAME chains inside the zone
+		{"alias.example.com.", dns.TypeA, dns.RcodeSuccess, true, []string{
+			"alias.example.com. 3600 IN CNAME www.example.com.",
+			"www.example.com. 3600 IN A 192.0.2.2",
+		}, nil, nil},
+		{"chain.example.com.", dns.TypeA, dns.RcodeSuccess, true, []string{
+			"chain.example.com. 3600 IN CNAME alias.example.com.",
+			"alias.example.com. 3600 IN CNAME www.example.com.",
+			"www.example.com. 3600 IN A 192.0.2.2",
+		}, nil, nil},
+		{"alias.example.com.", dns.TypeCNAME, dns.RcodeSuccess, true, []string{"alias.example.com. 3600 IN CNAME www.example.com."}, nil, nil},
+		// CNAME to a name without the record
+		{"alias.example.com.", dns.TypeAAAA, dns.RcodeSuccess, true, []string{"alias.example.com. 3600 IN CNAME www.example.com."}, []string{soa}, nil},
+		// CNAME leaving the zone
+		{"outside.example.com.", dns.TypeA, dns.RcodeSuccess, true, []string{"outside.example.com. 3600 IN CNAME www.example.org."}, nil, nil},
+		// CNAME loop, stopped after maxCNAMEChain
+		{"loop.example.com.", dns.TypeA, dns.RcodeSuccess, true, []string{
+			"loop.example.com. 3600 IN CNAME loop.example.com.",
+			"loop.example.com. 3600 IN CNAME loop.example.com.",
+			"loop.example.com. 3600 IN CNAME loop.example.com.",
+			"loop.example.com. 3600 IN CNAME loop.example.com.",
+			"loop.example.com. 3600 IN CNAME loop.example.com.",
+			"loop.example.com. 3600 IN CNAME loop.example.com.",
+			"loop.example.com. 3600 IN CNAME loop.example.com.",
+			"loop.example.com. 3600 IN CNAME loop.example.com.",
+		}, nil, nil},
+		// Wildcard synthesis
+		{"any.wild.example.com.", dns.TypeA, dns.RcodeSuccess, true, []string{"any.wild.example.com. 3600 IN A 192.0.2.3"}, nil, nil},
+		{"any.wild.example.com.", dns.TypeAAAA, dns.RcodeSuccess, true, nil, []string{soa}, nil},
+		// The wildcard only applies from the closest encloser
+		{"deeper.any.wild.example.com.", dns.TypeA, dns.RcodeSuccess, true, []string{"deeper.any.wild.example.com. 3600 IN A 192.0.2.3"}, nil, nil},
+		{"exists.wild.example.com.", dns.TypeA, dns.RcodeSuccess, true, nil, []string{soa}, nil},
+		{"below.exists.wild.example.com.", dns.TypeA, dns.RcodeNameError, true, nil, []string{soa}, nil},
+		// Referrals, with glue
+		{"sub.example.com.", dns.TypeA, dns.RcodeSuccess, false, nil, []string{
+			"sub.example.com. 3600 IN NS ns.sub.example.com.",
+			"sub.example.com. 3600 IN NS ns.example.org.",
+		}, []string{"ns.sub.example.com. 3600 IN A 192.0.2.5"}},
+		{"host.sub.example.com.", dns.TypeA, dns.RcodeSuccess, false, nil, []string{
+			"sub.example.com. 3600 IN NS ns.sub.example.com.",
+			"sub.example.com. 3600 IN NS ns.example.org.",
+		}, []string{"ns.sub.example.com. 3600 IN A 192.0.2.5"}},
+		{"ns.sub.example.com.", dns.TypeA, dns.RcodeSuccess, false, nil, []string{
+			"sub.example.com. 3600 IN NS ns.sub.example.com.",
+			"sub.example.com. 3600 IN NS ns.example.org.",
+		}, []string{"ns.sub.example.com. 3600 IN A 192.0.2.5"}},
+		// The DS at the cut is answered by the parent
+		{"sub.example.com.", dns.TypeDS, dns.RcodeSuccess, true, []string{"sub.example.com. 3600 IN DS " + ds}, nil, nil},
+		{"withds.example.com.", dns.TypeDS, dns.RcodeSuccess, true, []string{"withds.example.com. 3600 IN DS " + ds}, nil, nil},
+		// but not below it
+		{"host.sub.example.com.", dns.TypeDS, dns.RcodeSuccess, false, nil, []string{
+			"sub.example.com. 3600 IN NS ns.sub.example.com.",
+			"sub.example.com. 3600 IN NS ns.example.org.",
+		}, []string{"ns.sub.example.com. 3600 IN A 192.0.2.5"}},
+		// The apex is no zone cut
+		{"example.com.", dns.TypeNS, dns.RcodeSuccess, true, []string{"example.com. 3600 IN NS ns.example.com."}, nil, nil},
+	}
+
+	for _, test := range tests {
+		what := test.name + " " + dns.TypeToString[test.qtype]
+
+		m := new(dns.Msg)
+		answer(m, rrs, "example.com.", test.name, test.qtype)
+
+		if m.Rcode != test.rcode {
+			t.Errorf("%s: rcode is %s, expected %s", what, dns.RcodeToString[m.Rcode], dns.RcodeToString[test.rcode])
+		}
+		if m.Authoritative != test.authoritative {
+			t.Errorf("%s: authoritative is %t, expected %t", what, m.Authoritative, test.authoritative)
+		}
+		checkSection(t, what, "answer", m.Answer, test.answer)
+		checkSection(t, what, "authority", m.Ns, test.ns)
+		checkSection(t, what, "additional", m.Extra, test.extra)
+	}
+}
+
+// testWriter records the message written by the handler.
+type testWriter struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (w *testWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 42), Port: 53}
+}
+
+func (w *testWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func query(h *Handler, name string, qtype uint16) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetQuestion(name, qtype)
+
+	w := &testWriter{}
+	h.ServeDNS(w, r)
+	return w.msg
+}
+
+func newTestDomain(t *testing.T) *happydns.Domain {
+	storage.MainStore = database.NewMemoryStorage()
+
+	user := &happydns.User{Email: "preview@example.com"}
+	if err := storage.MainStore.CreateUser(user); err != nil {
+		t.Fatalf("unable to create the user: %s", err.Error())
+	}
+
+	zone := newTestZone()
+	if err := storage.MainStore.CreateZone(zone); err != nil {
+		t.Fatalf("unable to create the zone: %s", err.Error())
+	}
+
+	domain := &happydns.Domain{
+		DomainName:  "example.com.",
+		ZoneHistory: []happydns.Identifier{zone.Id},
+	}
+	if err := storage.MainStore.CreateDomain(user, domain); err != nil {
+		t.Fatalf("unable to create the domain: %s", err.Error())
+	}
+
+	return domain
+}
+
+func TestServeDNS(t *testing.T) {
+	domain := newTestDomain(t)
+	view := ViewLabel(domain.ZoneHistory[0])
+
+	h := NewHandler()
+
+	m := query(h, "alias.example.com."+view+".", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess {
+		t.Fatalf("alias: rcode is %s", dns.RcodeToString[m.Rcode])
+	}
+	checkSection(t, "alias", "answer", m.Answer, []string{
+		"alias.example.com." + view + ". 3600 IN CNAME www.example.com.",
+		"www.example.com." + view + ". 3600 IN A 192.0.2.2",
+	})
+
+	// Resolvers may change the case of the view label
+	if m := query(h, "www.example.com."+strings.ToUpper(view)+".", dns.TypeA); m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Errorf("uppercase view: rcode is %s with %d answers", dns.RcodeToString[m.Rcode], len(m.Answer))
+	}
+
+	if m := query(h, "www.example.org."+view+".", dns.TypeA); m.Rcode != dns.RcodeRefused {
+		t.Errorf("name outside the domain: rcode is %s, expected REFUSED", dns.RcodeToString[m.Rcode])
+	}
+
+	if m := query(h, "www.example.com.0123.", dns.TypeA); m.Rcode != dns.RcodeRefused {
+		t.Errorf("unknown view: rcode is %s, expected REFUSED", dns.RcodeToString[m.Rcode])
+	}
+
+	if m := query(h, "example.com."+view+".", dns.TypeAXFR); m.Rcode != dns.RcodeRefused {
+		t.Errorf("transfer over UDP: rcode is %s, expected REFUSED", dns.RcodeToString[m.Rcode])
+	}
+
+	// Revisions of deleted domains are no more served
+	if err := storage.MainStore.DeleteDomain(domain); err != nil {
+		t.Fatalf("unable to delete the domain: %s", err.Error())
+	}
+	if m := query(h, "www.example.com."+view+".", dns.TypeA); m.Rcode != dns.RcodeRefused {
+		t.Errorf("deleted domain: rcode is %s, expected REFUSED", dns.RcodeToString[m.Rcode])
+	}
+	if _, ok := h.origins[domain.ZoneHistory[0].String()]; ok {
+		t.Errorf("the revision of the deleted domain is still cached")
+	}
+}
+
+func TestOriginCache(t *testing.T) {
+	domain := newTestDomain(t)
+	id := domain.ZoneHistory[0]
+
+	h := NewHandler()
+	h.lookups = rate.NewLimiter(0, 2)
+
+	if origin, err := h.origin(id); err != nil || origin != "example.com." {
+		t.Fatalf("origin is %q (%v), expected example.com.", origin, err)
+	}
+
+	// Cached revisions are not looked up again
+	if origin, err := h.origin(id); err != nil || origin != "example.com." {
+		t.Fatalf("cached origin is %q (%v), expected example.com.", origin, err)
+	}
+
+	// nor are unknown ones
+	unknown := happydns.Identifier{0x01, 0x23}
+	if _, err := h.origin(unknown); err == nil || err == errLookupThrottled {
+		t.Fatalf("unknown revision: got %v, expected a lookup failure", err)
+	}
+	if _, err := h.origin(unknown); err != errUnknownRevision {
+		t.Fatalf("unknown revision again: got %v, expected %v", err, errUnknownRevision)
+	}
+
+	// The lookups are limited
+	if _, err := h.origin(happydns.Identifier{0x45, 0x67}); err != errLookupThrottled {
+		t.Fatalf("third lookup: got %v, expected %v", err, errLookupThrottled)
+	}
+
+	// Revisions trimmed from the history are forgotten
+	domain.ZoneHistory = []happydns.Identifier{{0x89}}
+	if err := storage.MainStore.UpdateDomain(domain); err != nil {
+		t.Fatalf("unable to update the domain: %s", err.Error())
+	}
+	if _, err := h.origin(id); err == nil {
+		t.Errorf("trimmed revision is still served")
+	}
+	if len(h.origins) != 0 {
+		t.Errorf("the trimmed revision is still cached")
+	}
+
+	// The negative cache is bounded
+	for i := 0; i < maxMisses+10; i++ {
+		h.mu.Lock()
+		h.addMiss(string(rune(i)))
+		h.mu.Unlock()
+	}
+	if len(h.misses) > maxMisses {
+		t.Errorf("%d misses are remembered, expected at most %d", len(h.misses), maxMisses)
+	}
+}
@@ -32,25 +32,34 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
 	"math/rand"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	"git.happydns.org/happydomain/config"
 	"git.happydns.org/happydomain/internal/app"
+	"git.happydns.org/happydomain/internal/logging"
+	"git.happydns.org/happydomain/internal/metrics"
 	"git.happydns.org/happydomain/storage"
 
 	_ "git.happydns.org/happydomain/services/providers/google"
 
 	_ "git.happydns.org/happydomain/storage/leveldb"
+	_ "git.happydns.org/happydomain/storage/memory"
+	_ "git.happydns.org/happydomain/storage/postgresql"
 )
 
 var (
 	Version = "custom-build"
+
+	exportPath = flag.String("export", "", "Export the whole database to the given file (- for stdout), then exit")
+	importPath = flag.String("import", "", "Import into the database the archive from the given file (- for stdin), then exit")
 )
 
 func main() {
@@ -65,6 +74,10 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if err = logging.Setup(opts.LogFormat, opts.LogLevel); err != nil {
+		log.Fatal(err)
+	}
+
 	// Initialize storage
 	if s, ok := storage.StorageEngines[opts.StorageEngine]; !ok {
 		log.Fatal(fmt.Sprintf("Unexistant storage engine: %q, please select one between: %v", opts.StorageEngine, storage.GetStorageEngines()))
@@ -74,7 +87,7 @@ func main() {
 			log.Fatal("Cannot open the database: ", err)
 		} else {
 			defer store.Close()
-			storage.MainStore = store
+			storage.MainStore = metrics.InstrumentStorage(store)
 		}
 	}
 
@@ -87,6 +100,22 @@ func main() {
 		log.Fatal("Cannot migrate database: ", err)
 	}
 
+	if *exportPath != "" {
+		log.Println("Exporting database...")
+		if err = app.ExportDatabase(*exportPath); err != nil {
+			log.Fatal("Unable to export the database: ", err)
+		}
+		return
+	}
+
+	if *importPath != "" {
+		log.Println("Importing database...")
+		if err = app.ImportDatabase(*importPath); err != nil {
+			log.Fatal("Unable to import the database: ", err)
+		}
+		return
+	}
+
 	// Prepare graceful shutdown
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
@@ -97,6 +126,18 @@ func main() {
 		go adminSrv.Start()
 	}
 
+	var metricsSrv *app.Metrics
+	if opts.MetricsBind != "" {
+		metricsSrv = app.NewMetrics(opts)
+		go metricsSrv.Start()
+	}
+
+	var previewSrv *app.PreviewDNS
+	if opts.PreviewDNSBind != "" {
+		previewSrv = app.NewPreviewDNS(opts)
+		go previewSrv.Start()
+	}
+
 	a := app.NewApp(opts)
 	go a.Start()
 
@@ -108,5 +149,11 @@ func main() {
 	if adminSrv != nil {
 		adminSrv.Stop()
 	}
+	if metricsSrv != nil {
+		metricsSrv.Stop()
+	}
+	if previewSrv != nil {
+		previewSrv.Stop()
+	}
 	log.Println("Stopped")
 }
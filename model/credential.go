@@ -0,0 +1,140 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package happydns
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"time"
+)
+
+// CredentialScope restricts what a Credential gives access to.
+type CredentialScope string
+
+const (
+	// CredentialDynDNS allows to update the addresses of the Server
+	// service at the Credential's subdomain.
+	CredentialDynDNS CredentialScope = "dyndns"
+
+	// CredentialACME allows to publish ACME DNS-01 challenges for the
+	// Credential's subdomain.
+	CredentialACME CredentialScope = "acme"
+)
+
+// CredentialChallenge is a value published on behalf of a Credential, until
+// it expires.
+type CredentialChallenge struct {
+	// Value is the content of the published record.
+	Value string `json:"value"`
+
+	// ExpiresAt is the time after which the record is removed.
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Credential grants an automated client (home router, certificate
+// client, ...) a restricted access to a subdomain of a Domain.
+type Credential struct {
+	// Id is the Credential's identifier, used as login by clients.
+	Id Identifier `json:"id"`
+
+	// IdUser is the identifier of the Credential's owner.
+	IdUser Identifier `json:"id_owner"`
+
+	// IdDomain is the identifier of the Domain the Credential gives
+	// access to.
+	IdDomain Identifier `json:"id_domain"`
+
+	// Scope restricts the actions allowed with this Credential.
+	Scope CredentialScope `json:"scope"`
+
+	// Subdomain is the only subdomain, relative to the Domain, that can be
+	// changed.
+	Subdomain string `json:"subdomain"`
+
+	// Comment helps the User to distinguish the Credential.
+	Comment string `json:"comment,omitempty"`
+
+	// SecretHash is the hash of the secret given to the client.
+	SecretHash []byte `json:"secret_hash"`
+
+	// CreatedAt is the time when the Credential has been created.
+	CreatedAt time.Time `json:"created_at"`
+
+	// LastUsed is the time when the Credential has been used for the last
+	// time.
+	LastUsed *time.Time `json:"last_used,omitempty"`
+
+	// Challenges are the values currently published with an ACME
+	// Credential.
+	Challenges []CredentialChallenge `json:"challenges,omitempty"`
+}
+
+func hashCredentialSecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+// GenerateSecret defines a new random secret for the Credential and returns
+// it. Only its hash is kept.
+func (c *Credential) GenerateSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	c.SecretHash = hashCredentialSecret(secret)
+
+	return secret, nil
+}
+
+// CheckSecret tells whether the given secret is the Credential's one.
+func (c *Credential) CheckSecret(secret string) bool {
+	return len(c.SecretHash) > 0 && subtle.ConstantTimeCompare(c.SecretHash, hashCredentialSecret(secret)) == 1
+}
+
+// PruneChallenges removes the Challenges expired at the given time. It
+// returns true if any has been removed.
+func (c *Credential) PruneChallenges(now time.Time) bool {
+	var kept []CredentialChallenge
+	for _, ch := range c.Challenges {
+		if ch.ExpiresAt.After(now) {
+			kept = append(kept, ch)
+		}
+	}
+
+	pruned := len(kept) != len(c.Challenges)
+	c.Challenges = kept
+	return pruned
+}
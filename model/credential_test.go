@@ -0,0 +1,89 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package happydns
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCredentialSecret(t *testing.T) {
+	var c Credential
+	if c.CheckSecret("") {
+		t.Errorf("a Credential without secret accepts the empty secret")
+	}
+
+	secret, err := c.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %s", err.Error())
+	}
+	if len(secret) < 32 {
+		t.Errorf("secret %q is too short", secret)
+	}
+	if bytes.Contains(c.SecretHash, []byte(secret)) {
+		t.Errorf("the secret is stored in clear")
+	}
+
+	if !c.CheckSecret(secret) {
+		t.Errorf("CheckSecret rejects the generated secret")
+	}
+	for _, wrong := range []string{"", secret[1:], secret + "x", string(c.SecretHash)} {
+		if c.CheckSecret(wrong) {
+			t.Errorf("CheckSecret accepts %q", wrong)
+		}
+	}
+
+	// A new secret revokes the previous one
+	other, err := c.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %s", err.Error())
+	}
+	if other == secret || c.CheckSecret(secret) || !c.CheckSecret(other) {
+		t.Errorf("GenerateSecret doesn't replace the previous secret")
+	}
+}
+
+func TestCredentialPruneChallenges(t *testing.T) {
+	now := time.Now()
+	c := Credential{Challenges: []CredentialChallenge{
+		{Value: "expired", ExpiresAt: now.Add(-time.Minute)},
+		{Value: "valid", ExpiresAt: now.Add(time.Minute)},
+	}}
+
+	if !c.PruneChallenges(now) || len(c.Challenges) != 1 || c.Challenges[0].Value != "valid" {
+		t.Errorf("got challenges %v, expected only the valid one", c.Challenges)
+	}
+	if c.PruneChallenges(now) {
+		t.Errorf("PruneChallenges reports a removal when nothing expired")
+	}
+}
@@ -56,6 +56,14 @@ type Domain struct {
 	// ZoneHistory are the identifiers to the Zone attached to the current
 	// Domain.
 	ZoneHistory []Identifier `json:"zone_history"`
+
+	// SerialPolicy is the way the SOA serial is updated when a Zone is
+	// published (see SerialKeep, SerialIncrement, SerialDate, SerialUnix).
+	SerialPolicy string `json:"serial_policy,omitempty"`
+
+	// Impersonator is the administrator who created the Domain while
+	// impersonating its owner, if any.
+	Impersonator string `json:"impersonator,omitempty"`
 }
 
 // Domains is an array of Domain.
@@ -0,0 +1,77 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package happydns
+
+// UserQuota holds the limits on the resources a User can create.
+//
+// In the instance defaults, a limit of 0 means unlimited. In a User's
+// overrides, 0 means to use the instance default and a negative value means
+// unlimited.
+type UserQuota struct {
+	// MaxDomains is the number of domains the User can manage.
+	MaxDomains int `json:"max_domains,omitempty"`
+
+	// MaxProviders is the number of providers the User can register.
+	MaxProviders int `json:"max_providers,omitempty"`
+
+	// MaxServicesPerZone is the number of services a zone can contain.
+	MaxServicesPerZone int `json:"max_services_per_zone,omitempty"`
+
+	// MaxZoneHistory is the number of zone revisions retained for each
+	// domain. Older revisions are deleted.
+	MaxZoneHistory int `json:"max_zone_history,omitempty"`
+}
+
+func overrideLimit(def, override int) int {
+	if override < 0 {
+		return 0
+	} else if override > 0 {
+		return override
+	}
+	return def
+}
+
+// Override returns the limits to apply, after applying the given User's
+// overrides on q.
+func (q UserQuota) Override(o UserQuota) UserQuota {
+	return UserQuota{
+		MaxDomains:         overrideLimit(q.MaxDomains, o.MaxDomains),
+		MaxProviders:       overrideLimit(q.MaxProviders, o.MaxProviders),
+		MaxServicesPerZone: overrideLimit(q.MaxServicesPerZone, o.MaxServicesPerZone),
+		MaxZoneHistory:     overrideLimit(q.MaxZoneHistory, o.MaxZoneHistory),
+	}
+}
+
+// Reached tells whether a count of resources has reached the given limit.
+func Reached(limit, count int) bool {
+	return limit > 0 && count >= limit
+}
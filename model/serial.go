@@ -0,0 +1,98 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package happydns
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// SerialKeep leaves the serial as set in the Origin service.
+	SerialKeep = ""
+
+	// SerialIncrement adds one to the last published serial.
+	SerialIncrement = "increment"
+
+	// SerialDate uses the YYYYMMDDnn format, nn counting the changes
+	// of the day.
+	SerialDate = "date"
+
+	// SerialUnix uses the Unix timestamp of the last modification.
+	SerialUnix = "unix"
+)
+
+// CheckSerialPolicy returns an error if policy is not a known serial policy.
+func CheckSerialPolicy(policy string) error {
+	switch policy {
+	case SerialKeep, SerialIncrement, SerialDate, SerialUnix:
+		return nil
+	}
+	return fmt.Errorf("unknown serial policy %q", policy)
+}
+
+// SerialGreater tells whether a comes after b, using the sequence space
+// arithmetic of RFC 1982.
+func SerialGreater(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// NextSerial returns the serial to publish after last, following policy, for
+// a zone modified at the given time. The returned serial always comes after
+// last, except for SerialKeep that returns last.
+func NextSerial(policy string, last uint32, modified time.Time) uint32 {
+	var next uint32
+
+	switch policy {
+	case SerialIncrement:
+		next = last + 1
+	case SerialDate:
+		date, _ := strconv.ParseUint(modified.UTC().Format("20060102"), 10, 32)
+		next = uint32(date) * 100
+	case SerialUnix:
+		next = uint32(modified.Unix())
+	default:
+		return last
+	}
+
+	if !SerialGreater(next, last) {
+		next = last + 1
+	}
+
+	// 0 is avoided as some software consider it as unset
+	if next == 0 {
+		next = 1
+	}
+
+	return next
+}
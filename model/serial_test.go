@@ -0,0 +1,124 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package happydns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSerialGreater(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     uint32
+		expected bool
+	}{
+		{1, 0, true},
+		{0, 1, false},
+		{42, 42, false},
+		// Wrap around 2^32
+		{0, 0xFFFFFFFF, true},
+		{5, 0xFFFFFFF0, true},
+		{0xFFFFFFF0, 5, false},
+		// Around the 2^31 boundary
+		{0x7FFFFFFF, 0, true},
+		{0, 0x7FFFFFFF, false},
+		{0x80000000, 1, true},
+		{0x80000001, 1, false},
+		{1, 0x80000001, false},
+		// At exactly 2^31, the comparison is undefined: neither is greater
+		{0x80000000, 0, false},
+		{0, 0x80000000, false},
+	} {
+		if got := SerialGreater(tc.a, tc.b); got != tc.expected {
+			t.Errorf("SerialGreater(%d, %d) = %t, expected %t", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestNextSerial(t *testing.T) {
+	day := time.Date(2024, time.June, 15, 13, 37, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		test     string
+		policy   string
+		last     uint32
+		modified time.Time
+		expected uint32
+	}{
+		{"keep", SerialKeep, 42, day, 42},
+		{"increment", SerialIncrement, 42, day, 43},
+		{"increment before wrap", SerialIncrement, 0xFFFFFFFE, day, 0xFFFFFFFF},
+		{"increment wraps over 0", SerialIncrement, 0xFFFFFFFF, day, 1},
+		{"date from an old serial", SerialDate, 2024010203, day, 2024061500},
+		{"date from a small serial", SerialDate, 1, day, 2024061500},
+		{"date same day", SerialDate, 2024061500, day, 2024061501},
+		{"date past the 99th change of the day", SerialDate, 2024061599, day, 2024061600},
+		{"date ahead of today", SerialDate, 2030010100, day, 2030010101},
+		{"date in another timezone", SerialDate, 1, time.Date(2024, time.June, 16, 1, 0, 0, 0, time.FixedZone("UTC+2", 2*3600)), 2024061500},
+		{"unix", SerialUnix, 1, day, uint32(day.Unix())},
+		{"unix with a clock going backward", SerialUnix, uint32(day.Unix()) + 10, day, uint32(day.Unix()) + 11},
+		{"date after wrap", SerialDate, 0xFFFFFFFF, day, 2024061500},
+	} {
+		if got := NextSerial(tc.policy, tc.last, tc.modified); got != tc.expected {
+			t.Errorf("%s: NextSerial(%q, %d) = %d, expected %d", tc.test, tc.policy, tc.last, got, tc.expected)
+		}
+	}
+}
+
+func TestNextSerialSameDayBumps(t *testing.T) {
+	day := time.Date(2024, time.June, 15, 8, 0, 0, 0, time.UTC)
+
+	var serial uint32 = 2024061400
+	for i := 0; i < 150; i++ {
+		next := NextSerial(SerialDate, serial, day.Add(time.Duration(i)*time.Minute))
+		if !SerialGreater(next, serial) {
+			t.Fatalf("bump #%d gives %d, not greater than %d", i, next, serial)
+		}
+		serial = next
+	}
+
+	// 100 changes fit in the day, then the serial goes on in the next day
+	if serial != 2024061649 {
+		t.Errorf("after 150 changes on the same day, serial is %d, expected 2024061649", serial)
+	}
+
+	// The next day continues from there, as its own counter is already
+	// used
+	if next := NextSerial(SerialDate, serial, day.Add(24*time.Hour)); next != 2024061650 {
+		t.Errorf("first change of the next day gives %d, expected 2024061650", next)
+	}
+
+	// Later days start again at 00
+	if next := NextSerial(SerialDate, serial, day.Add(48*time.Hour)); next != 2024061700 {
+		t.Errorf("first change two days later gives %d, expected 2024061700", next)
+	}
+}
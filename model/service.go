@@ -51,6 +51,13 @@ type Service interface {
 	GenRRs(domain string, ttl uint32, origin string) []dns.RR
 }
 
+// ServiceValidator is implemented by Services whose content has to be checked
+// before being saved in a Zone.
+type ServiceValidator interface {
+	// Validate returns an error describing the first invalid value found.
+	Validate() error
+}
+
 // ServiceMeta holds the metadata associated to a Service.
 type ServiceMeta struct {
 	// Type is the string representation of the Service's type.
@@ -99,6 +106,12 @@ func (svc *ServiceCombined) UnmarshalJSON(b []byte) error {
 }
 
 func ValidateService(svc Service, subdomain, origin string) ([]byte, error) {
+	if v, ok := svc.(ServiceValidator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	records := svc.GenRRs(subdomain, 0, origin)
 	if len(records) == 0 {
 		return nil, fmt.Errorf("No record can be generated from your service.")
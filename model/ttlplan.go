@@ -0,0 +1,173 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package happydns
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TTLPlan keeps track of the TTLs lowered ahead of a migration, so that they
+// can be restored once the migration is done.
+type TTLPlan struct {
+	// TTL is the temporary TTL set on the Services.
+	TTL uint32 `json:"ttl"`
+
+	// DefaultTTL is the original DefaultTTL of the Zone, when it has been
+	// lowered.
+	DefaultTTL *uint32 `json:"default_ttl,omitempty"`
+
+	// Services holds the original TTL of each lowered Service, indexed by
+	// Service's identifier.
+	Services map[string]uint32 `json:"services"`
+
+	// MaxTTL is the largest TTL that has been lowered.
+	MaxTTL uint32 `json:"max_ttl"`
+
+	// Created is the time when the TTLs have been lowered.
+	Created time.Time `json:"created"`
+
+	// Published is the time when the lowered TTLs have been published
+	// for the first time.
+	Published *time.Time `json:"published,omitempty"`
+
+	// ExpiresAt is the earliest time at which the records with the
+	// original TTLs are gone from caches.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// MarkPublished records the first publication of the lowered TTLs.
+func (p *TTLPlan) MarkPublished(t time.Time) {
+	if p.Published != nil {
+		return
+	}
+
+	expires := t.Add(time.Duration(p.MaxTTL) * time.Second)
+	p.Published = &t
+	p.ExpiresAt = &expires
+}
+
+// LowerTTLs sets ttl on the Services with the given identifiers, or on all of
+// them, along with the DefaultTTL, when ids is empty. Only TTLs greater than
+// ttl are changed; their original values are kept in the Zone's TTLPlan.
+func (z *Zone) LowerTTLs(ttl uint32, ids []Identifier) error {
+	if z.TTLPlan != nil {
+		return errors.New("TTLs are already lowered on this zone, restore them first.")
+	}
+	if ttl == 0 {
+		return errors.New("The temporary TTL should be greater than 0.")
+	}
+
+	plan := &TTLPlan{
+		TTL:      ttl,
+		Services: map[string]uint32{},
+		Created:  time.Now(),
+	}
+
+	selected := map[string]bool{}
+	for _, id := range ids {
+		if _, svc := z.FindService(id); svc == nil {
+			return fmt.Errorf("Service %s not found.", id.String())
+		}
+		selected[id.String()] = true
+	}
+
+	if len(ids) == 0 && z.DefaultTTL > ttl {
+		defaultTTL := z.DefaultTTL
+		plan.DefaultTTL = &defaultTTL
+		plan.MaxTTL = defaultTTL
+	}
+
+	for _, svcs := range z.Services {
+		for _, svc := range svcs {
+			if len(ids) > 0 && !selected[svc.Id.String()] {
+				continue
+			}
+
+			// Services using the DefaultTTL follow it
+			if svc.Ttl == 0 && plan.DefaultTTL != nil {
+				continue
+			}
+
+			current := svc.Ttl
+			if current == 0 {
+				current = z.DefaultTTL
+			}
+			if current <= ttl {
+				continue
+			}
+
+			plan.Services[svc.Id.String()] = svc.Ttl
+			if current > plan.MaxTTL {
+				plan.MaxTTL = current
+			}
+			svc.Ttl = ttl
+		}
+	}
+
+	if plan.DefaultTTL == nil && len(plan.Services) == 0 {
+		return fmt.Errorf("No TTL is greater than %d, there is nothing to lower.", ttl)
+	}
+
+	if plan.DefaultTTL != nil {
+		z.DefaultTTL = ttl
+	}
+	z.TTLPlan = plan
+
+	return nil
+}
+
+// RestoreTTLs sets back the TTLs lowered by LowerTTLs. TTLs modified since
+// are left untouched.
+func (z *Zone) RestoreTTLs() error {
+	plan := z.TTLPlan
+	if plan == nil {
+		return errors.New("There is no lowered TTL to restore on this zone.")
+	}
+
+	if plan.DefaultTTL != nil && z.DefaultTTL == plan.TTL {
+		z.DefaultTTL = *plan.DefaultTTL
+	}
+
+	for _, svcs := range z.Services {
+		for _, svc := range svcs {
+			if ttl, ok := plan.Services[svc.Id.String()]; ok && svc.Ttl == plan.TTL {
+				svc.Ttl = ttl
+			}
+		}
+	}
+
+	z.TTLPlan = nil
+
+	return nil
+}
@@ -0,0 +1,220 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package happydns
+
+import (
+	"testing"
+	"time"
+)
+
+// newTTLPlanZone returns a zone with a DefaultTTL of 3600 and four
+// Services: svc1 (86400), svc2 (300), svc3 (following the DefaultTTL) and
+// svc4 (7200).
+func newTTLPlanZone() *Zone {
+	svc := func(id string, ttl uint32) *ServiceCombined {
+		return &ServiceCombined{ServiceMeta: ServiceMeta{Id: Identifier(id), Ttl: ttl}}
+	}
+
+	return &Zone{
+		ZoneMeta: ZoneMeta{DefaultTTL: 3600},
+		Services: map[string][]*ServiceCombined{
+			"":    {svc("svc1", 86400), svc("svc2", 300)},
+			"www": {svc("svc3", 0)},
+			"mx":  {svc("svc4", 7200)},
+		},
+	}
+}
+
+// ttls returns the TTL of each Service of the zone, by identifier.
+func ttls(z *Zone) map[string]uint32 {
+	ret := map[string]uint32{}
+	for _, svcs := range z.Services {
+		for _, svc := range svcs {
+			ret[string(svc.Id)] = svc.Ttl
+		}
+	}
+	return ret
+}
+
+func checkTTLs(t *testing.T, z *Zone, defaultTTL uint32, expected map[string]uint32) {
+	t.Helper()
+
+	if z.DefaultTTL != defaultTTL {
+		t.Errorf("DefaultTTL is %d, expected %d", z.DefaultTTL, defaultTTL)
+	}
+
+	got := ttls(z)
+	if len(got) != len(expected) {
+		t.Errorf("zone has %d services, expected %d", len(got), len(expected))
+	}
+	for id, ttl := range expected {
+		if got[id] != ttl {
+			t.Errorf("TTL of %s is %d, expected %d", id, got[id], ttl)
+		}
+	}
+}
+
+func TestLowerAndRestoreTTLs(t *testing.T) {
+	z := newTTLPlanZone()
+
+	if err := z.LowerTTLs(300, nil); err != nil {
+		t.Fatalf("LowerTTLs: %s", err.Error())
+	}
+
+	// svc3 follows the DefaultTTL, svc2 is already low enough
+	checkTTLs(t, z, 300, map[string]uint32{"svc1": 300, "svc2": 300, "svc3": 0, "svc4": 300})
+	if z.TTLPlan == nil || z.TTLPlan.MaxTTL != 86400 || len(z.TTLPlan.Services) != 2 {
+		t.Fatalf("unexpected plan: %+v", z.TTLPlan)
+	}
+
+	if err := z.RestoreTTLs(); err != nil {
+		t.Fatalf("RestoreTTLs: %s", err.Error())
+	}
+
+	checkTTLs(t, z, 3600, map[string]uint32{"svc1": 86400, "svc2": 300, "svc3": 0, "svc4": 7200})
+	if z.TTLPlan != nil {
+		t.Errorf("plan is kept after RestoreTTLs")
+	}
+}
+
+func TestLowerTTLsTwice(t *testing.T) {
+	z := newTTLPlanZone()
+
+	if err := z.LowerTTLs(300, nil); err != nil {
+		t.Fatalf("LowerTTLs: %s", err.Error())
+	}
+
+	if err := z.LowerTTLs(60, nil); err == nil {
+		t.Errorf("LowerTTLs succeeds while TTLs are already lowered")
+	}
+
+	// Neither the TTLs nor the saved originals are overwritten
+	checkTTLs(t, z, 300, map[string]uint32{"svc1": 300, "svc2": 300, "svc3": 0, "svc4": 300})
+	svc1, svc4 := Identifier("svc1"), Identifier("svc4")
+	if *z.TTLPlan.DefaultTTL != 3600 || z.TTLPlan.Services[svc1.String()] != 86400 || z.TTLPlan.Services[svc4.String()] != 7200 || z.TTLPlan.TTL != 300 {
+		t.Errorf("the plan has been overwritten: %+v", z.TTLPlan)
+	}
+
+	if err := z.RestoreTTLs(); err != nil {
+		t.Fatalf("RestoreTTLs: %s", err.Error())
+	}
+	checkTTLs(t, z, 3600, map[string]uint32{"svc1": 86400, "svc2": 300, "svc3": 0, "svc4": 7200})
+}
+
+func TestRestoreTTLsAfterChanges(t *testing.T) {
+	z := newTTLPlanZone()
+
+	if err := z.LowerTTLs(300, nil); err != nil {
+		t.Fatalf("LowerTTLs: %s", err.Error())
+	}
+
+	// svc1 is deleted then re-created with a new identifier and the
+	// lowered TTL, svc4 is changed by the user
+	z.Services[""] = []*ServiceCombined{
+		z.Services[""][1],
+		{ServiceMeta: ServiceMeta{Id: Identifier("svc5"), Ttl: 300}},
+	}
+	z.Services["mx"][0].Ttl = 600
+
+	if err := z.RestoreTTLs(); err != nil {
+		t.Fatalf("RestoreTTLs: %s", err.Error())
+	}
+
+	checkTTLs(t, z, 3600, map[string]uint32{"svc2": 300, "svc3": 0, "svc4": 600, "svc5": 300})
+}
+
+func TestRestoreTTLsWithoutPlan(t *testing.T) {
+	z := newTTLPlanZone()
+
+	if err := z.RestoreTTLs(); err == nil {
+		t.Errorf("RestoreTTLs succeeds without plan")
+	}
+
+	checkTTLs(t, z, 3600, map[string]uint32{"svc1": 86400, "svc2": 300, "svc3": 0, "svc4": 7200})
+}
+
+func TestLowerSomeTTLs(t *testing.T) {
+	z := newTTLPlanZone()
+
+	if err := z.LowerTTLs(300, []Identifier{Identifier("svc1"), Identifier("svc3")}); err != nil {
+		t.Fatalf("LowerTTLs: %s", err.Error())
+	}
+
+	// The DefaultTTL is kept, so svc3 gets its own TTL
+	checkTTLs(t, z, 3600, map[string]uint32{"svc1": 300, "svc2": 300, "svc3": 300, "svc4": 7200})
+	if z.TTLPlan.DefaultTTL != nil || z.TTLPlan.MaxTTL != 86400 {
+		t.Errorf("unexpected plan: %+v", z.TTLPlan)
+	}
+
+	if err := z.RestoreTTLs(); err != nil {
+		t.Fatalf("RestoreTTLs: %s", err.Error())
+	}
+
+	// svc3 follows the DefaultTTL again
+	checkTTLs(t, z, 3600, map[string]uint32{"svc1": 86400, "svc2": 300, "svc3": 0, "svc4": 7200})
+}
+
+func TestLowerTTLsErrors(t *testing.T) {
+	for _, tc := range []struct {
+		test string
+		ttl  uint32
+		ids  []Identifier
+	}{
+		{"zero TTL", 0, nil},
+		{"nothing to lower", 86400, nil},
+		{"nothing to lower among the services", 300, []Identifier{Identifier("svc2")}},
+		{"unknown service", 300, []Identifier{Identifier("svc1"), Identifier("unknown")}},
+	} {
+		z := newTTLPlanZone()
+
+		if err := z.LowerTTLs(tc.ttl, tc.ids); err == nil {
+			t.Errorf("%s: LowerTTLs succeeds", tc.test)
+		}
+
+		if z.TTLPlan != nil {
+			t.Errorf("%s: a plan is set after a failure", tc.test)
+		}
+		checkTTLs(t, z, 3600, map[string]uint32{"svc1": 86400, "svc2": 300, "svc3": 0, "svc4": 7200})
+	}
+}
+
+func TestTTLPlanMarkPublished(t *testing.T) {
+	plan := &TTLPlan{MaxTTL: 3600}
+	first := time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)
+
+	plan.MarkPublished(first)
+	plan.MarkPublished(first.Add(time.Hour))
+
+	if !plan.Published.Equal(first) || !plan.ExpiresAt.Equal(first.Add(time.Hour)) {
+		t.Errorf("plan published at %s expiring at %s, expected %s and %s", plan.Published, plan.ExpiresAt, first, first.Add(time.Hour))
+	}
+}
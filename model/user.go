@@ -51,6 +51,9 @@ type User struct {
 
 	// Settings holds the settings for an account.
 	Settings UserSettings `json:settings,omitempty`
+
+	// Quota overrides, for this User, the default limits of the instance.
+	Quota UserQuota `json:"quota,omitempty"`
 }
 
 // Users is a group of User.
@@ -61,6 +61,13 @@ type ZoneMeta struct {
 
 	// Published indicates whether the Zone has already been published or not.
 	Published *time.Time `json:"published,omitempty"`
+
+	// TTLPlan holds the TTLs lowered ahead of a migration, if any.
+	TTLPlan *TTLPlan `json:"ttl_plan,omitempty"`
+
+	// Impersonator is the last administrator who modified the Zone while
+	// impersonating its owner, if any.
+	Impersonator string `json:"impersonator,omitempty"`
 }
 
 // Zone contains ZoneMeta + map of services by subdomains.
@@ -76,6 +83,10 @@ func (z *Zone) DerivateNew() *Zone {
 	newZone.ZoneMeta.IdAuthor = z.ZoneMeta.IdAuthor
 	newZone.ZoneMeta.DefaultTTL = z.ZoneMeta.DefaultTTL
 	newZone.ZoneMeta.LastModified = time.Now()
+	if z.ZoneMeta.TTLPlan != nil {
+		plan := *z.ZoneMeta.TTLPlan
+		newZone.ZoneMeta.TTLPlan = &plan
+	}
 	newZone.Services = map[string][]*ServiceCombined{}
 
 	for subdomain, svcs := range z.Services {
@@ -147,6 +158,12 @@ func (z *Zone) eraseService(subdomain, origin string, old *ServiceCombined, idx
 			z.Services[subdomain] = append(z.Services[subdomain][:idx], z.Services[subdomain][idx+1:]...)
 		}
 	} else {
+		if v, ok := new.Service.(ServiceValidator); ok {
+			if err := v.Validate(); err != nil {
+				return err
+			}
+		}
+
 		new.Comment = new.GenComment(origin)
 		new.NbResources = new.GetNbResources()
 		z.Services[subdomain][idx] = new
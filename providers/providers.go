@@ -33,12 +33,12 @@ package providers // import "happydns.org/providers"
 
 import (
 	"fmt"
-	"log"
 	"reflect"
 	"strings"
 
 	"github.com/StackExchange/dnscontrol/v3/providers"
 	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
 
 	"git.happydns.org/happydomain/model"
 )
@@ -101,6 +101,13 @@ func FindProvider(name string) (happydns.Provider, error) {
 	return src.Creator(), nil
 }
 
+// rawRRProviders lists the providers publishing records as they are, the
+// only ones able to host types dnscontrol has no capability for, such as SVCB
+// and HTTPS.
+var rawRRProviders = map[string]bool{
+	"AXFRDDNS": true,
+}
+
 // GetProviderCapabilities lists available capabilities for the given Provider.
 func GetProviderCapabilities(prvd happydns.Provider) (caps []string) {
 	// Features
@@ -136,6 +143,11 @@ func GetProviderCapabilities(prvd happydns.Provider) (caps []string) {
 	if providers.ProviderHasCapability(prvd.DNSControlName(), providers.CanUseTLSA) {
 		caps = append(caps, fmt.Sprintf("rr-%d-%s", dns.TypeTLSA, dns.TypeToString[dns.TypeTLSA]))
 	}
+	if rawRRProviders[prvd.DNSControlName()] {
+		for _, v := range []uint16{dns.TypeSVCB, dns.TypeHTTPS} {
+			caps = append(caps, fmt.Sprintf("rr-%d-%s", v, dns.TypeToString[v]))
+		}
+	}
 
 	return
 }
@@ -0,0 +1,360 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package abstract
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/services"
+	"git.happydns.org/happydomain/utils"
+)
+
+const caaCriticalFlag = 128
+
+var (
+	CAA_PARAMETER_KEY   = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
+	CAA_PARAMETER_VALUE = regexp.MustCompile(`^[\x21-\x3A\x3C-\x7E]*$`)
+	CAA_METHOD          = regexp.MustCompile(`^(dns-01|http-01|tls-alpn-01|ca-[a-zA-Z0-9-]+)$`)
+)
+
+// CAAIssuer is a Certification Authority allowed to issue certificates,
+// with the restrictions of RFC 8657.
+type CAAIssuer struct {
+	IssuerDomainName  string            `json:"issuer_domain_name,omitempty" happydomain:"label=Certification Authority,placeholder=letsencrypt.org,description=Domain name of the CA. Leave empty to forbid any issuance."`
+	AccountURI        string            `json:"account_uri,omitempty" happydomain:"label=Account URI,placeholder=https://acme-v02.api.letsencrypt.org/acme/acct/1234,description=Only allow issuance to this account at the CA."`
+	ValidationMethods []string          `json:"validation_methods,omitempty" happydomain:"label=Validation methods,placeholder=dns-01,description=Only allow issuance through these validation methods."`
+	Parameters        map[string]string `json:"parameters,omitempty" happydomain:"label=Other parameters,description=Parameters specific to the CA."`
+}
+
+func (i *CAAIssuer) String() string {
+	params := []string{strings.TrimSuffix(i.IssuerDomainName, ".")}
+
+	if i.AccountURI != "" {
+		params = append(params, "accounturi="+i.AccountURI)
+	}
+	if len(i.ValidationMethods) > 0 {
+		params = append(params, "validationmethods="+strings.Join(i.ValidationMethods, ","))
+	}
+
+	var keys []string
+	for k := range i.Parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params = append(params, k+"="+i.Parameters[k])
+	}
+
+	if len(params) == 1 && params[0] == "" {
+		return ";"
+	}
+	return strings.Join(params, "; ")
+}
+
+func (i *CAAIssuer) Validate() error {
+	if i.IssuerDomainName == "" {
+		if i.AccountURI != "" || len(i.ValidationMethods) > 0 || len(i.Parameters) > 0 {
+			return fmt.Errorf("Parameters need a Certification Authority.")
+		}
+		return nil
+	}
+
+	if _, ok := dns.IsDomainName(i.IssuerDomainName); !ok || strings.ContainsAny(i.IssuerDomainName, " ;=") {
+		return fmt.Errorf("%q is not a valid Certification Authority domain name.", i.IssuerDomainName)
+	}
+
+	if i.AccountURI != "" {
+		if u, err := url.Parse(i.AccountURI); err != nil || !u.IsAbs() || !CAA_PARAMETER_VALUE.MatchString(i.AccountURI) {
+			return fmt.Errorf("%q is not a valid account URI.", i.AccountURI)
+		}
+	}
+
+	for _, method := range i.ValidationMethods {
+		if !CAA_METHOD.MatchString(method) {
+			return fmt.Errorf("%q is not a validation method (dns-01, http-01, tls-alpn-01 or ca-*).", method)
+		}
+	}
+
+	for k, v := range i.Parameters {
+		if !CAA_PARAMETER_KEY.MatchString(k) {
+			return fmt.Errorf("%q is not a valid parameter name.", k)
+		} else if k == "accounturi" || k == "validationmethods" {
+			return fmt.Errorf("%q has its own field.", k)
+		} else if !CAA_PARAMETER_VALUE.MatchString(v) {
+			return fmt.Errorf("The value of %q cannot contain spaces or semicolons.", k)
+		}
+	}
+
+	return nil
+}
+
+// parseCAAIssuer reads the value of an issue or issuewild property. It fails
+// on parameters that could not be written back as they were.
+func parseCAAIssuer(value string) (*CAAIssuer, error) {
+	params := strings.Split(value, ";")
+
+	issuer := &CAAIssuer{
+		IssuerDomainName: strings.TrimSpace(params[0]),
+	}
+
+	seen := map[string]bool{}
+	for _, param := range params[1:] {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%q is not a key=value parameter.", param)
+		} else if seen[kv[0]] {
+			return nil, fmt.Errorf("%q is given more than once.", kv[0])
+		}
+		seen[kv[0]] = true
+
+		switch kv[0] {
+		case "accounturi":
+			issuer.AccountURI = kv[1]
+		case "validationmethods":
+			issuer.ValidationMethods = strings.Split(kv[1], ",")
+		default:
+			if issuer.Parameters == nil {
+				issuer.Parameters = map[string]string{}
+			}
+			issuer.Parameters[kv[0]] = kv[1]
+		}
+	}
+
+	return issuer, issuer.Validate()
+}
+
+func validateCAAIodef(iodef string) error {
+	u, err := url.Parse(iodef)
+	if err != nil || (u.Scheme != "mailto" && u.Scheme != "https" && u.Scheme != "http") || (u.Scheme == "mailto" && !strings.Contains(u.Opaque, "@")) || (u.Scheme != "mailto" && u.Host == "") {
+		return fmt.Errorf("%q is not a valid incident report URL: use mailto:address or an https: URL.", iodef)
+	}
+	return nil
+}
+
+type CAAPolicy struct {
+	Issue     []*CAAIssuer `json:"issue,omitempty" happydomain:"label=Issuers,description=Certification Authorities allowed to issue certificates for this name."`
+	IssueWild []*CAAIssuer `json:"issuewild,omitempty" happydomain:"label=Wildcard issuers,description=Certification Authorities allowed to issue wildcard certificates. Issuers apply when empty."`
+	Iodef     []string     `json:"iodef,omitempty" happydomain:"label=Incident reports,placeholder=mailto:security@example.com,description=Where Certification Authorities report the requests they refused (mailto: or https: URL)."`
+	Critical  bool         `json:"critical,omitempty" happydomain:"label=Critical,description=Forbid the issuance by Certification Authorities not understanding each property."`
+}
+
+func (s *CAAPolicy) GetNbResources() int {
+	return len(s.Issue) + len(s.IssueWild) + len(s.Iodef)
+}
+
+func caaIssuersComment(issuers []*CAAIssuer) string {
+	var names []string
+	for _, issuer := range issuers {
+		if issuer.IssuerDomainName == "" {
+			names = append(names, "none")
+		} else {
+			names = append(names, strings.TrimSuffix(issuer.IssuerDomainName, "."))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func (s *CAAPolicy) GenComment(origin string) string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(caaIssuersComment(s.Issue))
+
+	if len(s.IssueWild) > 0 {
+		if buffer.Len() > 0 {
+			buffer.WriteString("; ")
+		}
+		buffer.WriteString("wildcard: ")
+		buffer.WriteString(caaIssuersComment(s.IssueWild))
+	}
+
+	if len(s.Iodef) > 0 {
+		buffer.WriteString(" + iodef")
+	}
+
+	return buffer.String()
+}
+
+func (s *CAAPolicy) Validate() error {
+	for _, issuer := range append(append([]*CAAIssuer{}, s.Issue...), s.IssueWild...) {
+		if err := issuer.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, iodef := range s.Iodef {
+		if err := validateCAAIodef(iodef); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *CAAPolicy) GenRRs(domain string, ttl uint32, origin string) (rrs []dns.RR) {
+	var flag uint8
+	if s.Critical {
+		flag = caaCriticalFlag
+	}
+
+	newCAA := func(tag, value string) *dns.CAA {
+		return &dns.CAA{
+			Hdr: dns.RR_Header{
+				Name:   utils.DomainJoin(domain),
+				Rrtype: dns.TypeCAA,
+				Class:  dns.ClassINET,
+				Ttl:    ttl,
+			},
+			Flag:  flag,
+			Tag:   tag,
+			Value: value,
+		}
+	}
+
+	for _, issuer := range s.Issue {
+		rrs = append(rrs, newCAA("issue", issuer.String()))
+	}
+	for _, issuer := range s.IssueWild {
+		rrs = append(rrs, newCAA("issuewild", issuer.String()))
+	}
+	for _, iodef := range s.Iodef {
+		rrs = append(rrs, newCAA("iodef", iodef))
+	}
+
+	return
+}
+
+func caa_analyze(a *svcs.Analyzer) (err error) {
+	pool := map[string]*CAAPolicy{}
+
+	// Other properties, other flags, and values that could not be written
+	// back as they are, are left to the Orphan service
+	var records []*dns.CAA
+	issuers := map[*dns.CAA]*CAAIssuer{}
+	for _, record := range a.SearchRR(svcs.AnalyzerRecordFilter{Type: dns.TypeCAA}) {
+		caa, ok := record.(*dns.CAA)
+		if !ok || (caa.Flag != 0 && caa.Flag != caaCriticalFlag) {
+			continue
+		}
+
+		switch caa.Tag {
+		case "issue", "issuewild":
+			issuer, err := parseCAAIssuer(caa.Value)
+			if err != nil || issuer.String() != caa.Value {
+				continue
+			}
+			issuers[caa] = issuer
+		case "iodef":
+			if validateCAAIodef(caa.Value) != nil {
+				continue
+			}
+		default:
+			continue
+		}
+
+		records = append(records, caa)
+	}
+
+	// The critical flag is shared by the whole policy: it is set when all
+	// the records of the name have it.
+	critical := map[string]bool{}
+	for _, caa := range records {
+		dn := caa.Header().Name
+		if v, ok := critical[dn]; !ok || v {
+			critical[dn] = caa.Flag&caaCriticalFlag != 0
+		}
+	}
+
+	for _, caa := range records {
+		dn := caa.Header().Name
+		if (caa.Flag&caaCriticalFlag != 0) != critical[dn] {
+			// Keep critical records of a non-critical policy as they are
+			continue
+		}
+
+		if _, ok := pool[dn]; !ok {
+			pool[dn] = &CAAPolicy{Critical: critical[dn]}
+		}
+
+		switch caa.Tag {
+		case "issue":
+			pool[dn].Issue = append(pool[dn].Issue, issuers[caa])
+		case "issuewild":
+			pool[dn].IssueWild = append(pool[dn].IssueWild, issuers[caa])
+		case "iodef":
+			pool[dn].Iodef = append(pool[dn].Iodef, caa.Value)
+		}
+
+		err = a.UseRR(caa, dn, pool[dn])
+		if err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	svcs.RegisterService(
+		func() happydns.Service {
+			return &CAAPolicy{}
+		},
+		caa_analyze,
+		svcs.ServiceInfos{
+			Name:        "Certification Authority Authorization",
+			Description: "Choose the Certification Authorities allowed to issue certificates for your domain.",
+			Family:      svcs.Abstract,
+			Categories: []string{
+				"tls",
+			},
+			Restrictions: svcs.ServiceRestrictions{
+				Single: true,
+				NeedTypes: []uint16{
+					dns.TypeCAA,
+				},
+			},
+		},
+		100,
+	)
+}
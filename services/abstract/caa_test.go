@@ -0,0 +1,168 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package abstract
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/services"
+)
+
+func TestCAAAnalyze(t *testing.T) {
+	policy := []string{
+		`example.com. 3600 IN CAA 0 issue "letsencrypt.org"`,
+		`example.com. 3600 IN CAA 0 issue "ca.example.net; accounturi=https://ca.example.net/acct/1; validationmethods=dns-01,http-01; policy=ev"`,
+		`example.com. 3600 IN CAA 0 issuewild ";"`,
+		`example.com. 3600 IN CAA 0 iodef "mailto:security@example.com"`,
+		`crit.example.com. 3600 IN CAA 128 issue "letsencrypt.org"`,
+		`crit.example.com. 3600 IN CAA 128 iodef "https://example.com/caa"`,
+	}
+	orphans := []string{
+		// Critical record in a non-critical policy
+		`example.com. 3600 IN CAA 128 issue "critical.example.net"`,
+		// Reserved flags
+		`example.com. 3600 IN CAA 1 issue "reserved.example.net"`,
+		// Unknown properties
+		`example.com. 3600 IN CAA 0 tbs "unknown"`,
+		`example.com. 3600 IN CAA 0 contactemail "security@example.com"`,
+		`crit.example.com. 3600 IN CAA 128 tbs "unknown"`,
+		// Values that could not be written back
+		`example.com. 3600 IN CAA 0 issue "bad.example.net; novalue"`,
+		`example.com. 3600 IN CAA 0 issue "bad.example.net; key=1; key=2"`,
+		`example.com. 3600 IN CAA 0 issue "bad.example.net; key=with space"`,
+		`example.com. 3600 IN CAA 0 iodef "ftp://example.com/"`,
+		// Values that would be written back differently
+		`example.com. 3600 IN CAA 0 issue "letsencrypt.org;validationmethods=dns-01"`,
+		`example.com. 3600 IN CAA 0 issue ""`,
+		`example.com. 3600 IN CAA 0 issue "ca.example.net; policy=ev; accounturi=https://ca.example.net/acct/2"`,
+		`example.com. 3600 IN CAA 0 issue "letsencrypt.org."`,
+		`example.com. 3600 IN CAA 0 issuewild " ca.example.net"`,
+	}
+
+	var zone []dns.RR
+	for _, record := range append(append([]string{}, policy...), orphans...) {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("invalid record %q: %s", record, err.Error())
+		}
+		zone = append(zone, rr)
+	}
+
+	services, defaultTTL, err := svcs.AnalyzeZone("example.com.", zone)
+	if err != nil {
+		t.Fatalf("AnalyzeZone: %s", err.Error())
+	}
+
+	// Records left to the Orphan service
+	var gotOrphans []string
+	policies := map[string]*CAAPolicy{}
+	for subdomain, svcs := range services {
+		for _, svc := range svcs {
+			switch s := svc.Service.(type) {
+			case *CAAPolicy:
+				if _, ok := policies[subdomain]; ok {
+					t.Errorf("more than one CAA policy for %q", subdomain)
+				}
+				policies[subdomain] = s
+			default:
+				name := "example.com."
+				if subdomain != "" {
+					name = subdomain + "." + name
+				}
+				for _, rr := range s.GenRRs(name, defaultTTL, "example.com.") {
+					gotOrphans = append(gotOrphans, rr.String())
+				}
+			}
+		}
+	}
+
+	checkSameRecords(t, "orphans", gotOrphans, orphans)
+
+	expected := map[string]*CAAPolicy{
+		"": {
+			Issue: []*CAAIssuer{
+				{IssuerDomainName: "letsencrypt.org"},
+				{
+					IssuerDomainName:  "ca.example.net",
+					AccountURI:        "https://ca.example.net/acct/1",
+					ValidationMethods: []string{"dns-01", "http-01"},
+					Parameters:        map[string]string{"policy": "ev"},
+				},
+			},
+			IssueWild: []*CAAIssuer{{}},
+			Iodef:     []string{"mailto:security@example.com"},
+		},
+		"crit": {
+			Issue:    []*CAAIssuer{{IssuerDomainName: "letsencrypt.org"}},
+			Iodef:    []string{"https://example.com/caa"},
+			Critical: true,
+		},
+	}
+	if !reflect.DeepEqual(policies, expected) {
+		for subdomain, p := range policies {
+			t.Errorf("%q: got policy %+v", subdomain, *p)
+		}
+		t.Fatalf("policies differ from the expected ones")
+	}
+
+	// The whole zone is generated back as it was
+	z := &happydns.Zone{
+		ZoneMeta: happydns.ZoneMeta{DefaultTTL: defaultTTL},
+		Services: services,
+	}
+	var got []string
+	for _, rr := range z.GenerateRRs("example.com.") {
+		got = append(got, rr.String())
+	}
+
+	checkSameRecords(t, "generated zone", got, append(append([]string{}, policy...), orphans...))
+}
+
+func checkSameRecords(t *testing.T, what string, got []string, records []string) {
+	var expected []string
+	for _, record := range records {
+		rr, _ := dns.NewRR(record)
+		expected = append(expected, rr.String())
+	}
+
+	sort.Strings(got)
+	sort.Strings(expected)
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("%s: got\n%v\nexpected\n%v", what, got, expected)
+	}
+}
@@ -33,11 +33,12 @@ package svcs
 
 import (
 	"fmt"
-	"log"
 	"reflect"
 	"sort"
 	"strings"
 
+	log "github.com/sirupsen/logrus"
+
 	"git.happydns.org/happydomain/model"
 )
 
@@ -0,0 +1,462 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package svcs
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/utils"
+)
+
+// SVCB is a SVCB or HTTPS record, following RFC 9460.
+type SVCB struct {
+	Priority      uint16            `json:"priority" happydomain:"label=Priority,description=0 makes the record an alias to the target (AliasMode); otherwise endpoints with the lowest priority are preferred (ServiceMode)."`
+	Target        string            `json:"target" happydomain:"label=Target,placeholder=.,description=Name of the endpoint. In ServiceMode . stands for the owner name."`
+	Alpn          []string          `json:"alpn,omitempty" happydomain:"label=ALPN,placeholder=h2,description=Protocols supported by the endpoint."`
+	NoDefaultAlpn bool              `json:"no_default_alpn,omitempty" happydomain:"label=No default ALPN,description=The protocol implied by the scheme is not supported (http/1.1 for HTTPS)."`
+	Port          uint16            `json:"port,omitempty" happydomain:"label=Port,description=Port of the endpoint when not the default one."`
+	IPv4Hint      []net.IP          `json:"ipv4hint,omitempty" happydomain:"label=IPv4 hints,placeholder=192.0.2.1,description=Addresses of the endpoint to save a resolution."`
+	IPv6Hint      []net.IP          `json:"ipv6hint,omitempty" happydomain:"label=IPv6 hints,placeholder=2001:db8::1,description=Addresses of the endpoint to save a resolution."`
+	ECH           []byte            `json:"ech,omitempty" happydomain:"label=ECH configuration,description=Encrypted ClientHello configuration list in base64."`
+	Params        map[string]string `json:"params,omitempty" happydomain:"label=Other parameters,description=Other SvcParams such as mandatory or dohpath."`
+}
+
+// svcbOwnKeys are the SvcParams having their own field.
+var svcbOwnKeys = []dns.SVCBKey{dns.SVCB_ALPN, dns.SVCB_NO_DEFAULT_ALPN, dns.SVCB_PORT, dns.SVCB_IPV4HINT, dns.SVCB_ECHCONFIG, dns.SVCB_IPV6HINT}
+
+func (s *SVCB) hasParams() bool {
+	return len(s.Alpn) > 0 || s.NoDefaultAlpn || s.Port != 0 || len(s.IPv4Hint) > 0 || len(s.IPv6Hint) > 0 || len(s.ECH) > 0 || len(s.Params) > 0
+}
+
+// otherParams parses Params as in a zone file.
+func (s *SVCB) otherParams() ([]dns.SVCBKeyValue, error) {
+	if len(s.Params) == 0 {
+		return nil, nil
+	}
+
+	var keys []string
+	for k := range s.Params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buffer strings.Builder
+	buffer.WriteString(". 0 IN SVCB 1 .")
+	for _, k := range keys {
+		for _, own := range svcbOwnKeys {
+			if strings.ToLower(k) == own.String() {
+				return nil, fmt.Errorf("%q has its own field.", k)
+			}
+		}
+
+		buffer.WriteString(fmt.Sprintf(" %s=\"%s\"", k, s.Params[k]))
+	}
+
+	rr, err := dns.NewRR(buffer.String())
+	if err != nil {
+		return nil, fmt.Errorf("Invalid parameters: %s", err.Error())
+	}
+
+	values := rr.(*dns.SVCB).Value
+	for _, kv := range values {
+		if m, ok := kv.(*dns.SVCBMandatory); ok {
+			if err := s.checkMandatory(m.Code, values); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// checkMandatory ensures the keys listed in mandatory are known, listed
+// once, and present in the record (RFC 9460, section 8).
+func (s *SVCB) checkMandatory(codes []dns.SVCBKey, others []dns.SVCBKeyValue) error {
+	present := map[dns.SVCBKey]bool{
+		dns.SVCB_ALPN:            len(s.Alpn) > 0,
+		dns.SVCB_NO_DEFAULT_ALPN: s.NoDefaultAlpn,
+		dns.SVCB_PORT:            s.Port != 0,
+		dns.SVCB_IPV4HINT:        len(s.IPv4Hint) > 0,
+		dns.SVCB_ECHCONFIG:       len(s.ECH) > 0,
+		dns.SVCB_IPV6HINT:        len(s.IPv6Hint) > 0,
+	}
+	for _, kv := range others {
+		present[kv.Key()] = true
+	}
+
+	seen := map[dns.SVCBKey]bool{}
+	for _, code := range codes {
+		if code == dns.SVCBKey(65535) {
+			// Unknown names are parsed as the reserved key
+			return fmt.Errorf("mandatory lists an unknown key.")
+		} else if code == dns.SVCB_MANDATORY {
+			return fmt.Errorf("mandatory cannot list itself.")
+		} else if seen[code] {
+			return fmt.Errorf("mandatory lists %s more than once.", code.String())
+		} else if !present[code] {
+			return fmt.Errorf("mandatory lists %s, which is not set.", code.String())
+		}
+		seen[code] = true
+	}
+
+	if len(codes) == 0 {
+		return fmt.Errorf("mandatory cannot be empty.")
+	}
+
+	return nil
+}
+
+func (s *SVCB) Validate() error {
+	if _, ok := dns.IsDomainName(s.Target); s.Target != "" && (!ok || strings.ContainsAny(s.Target, " \t\"")) {
+		return fmt.Errorf("%q is not a valid target.", s.Target)
+	}
+
+	if s.Priority == 0 {
+		if s.hasParams() {
+			return fmt.Errorf("Records in AliasMode (priority 0) cannot have parameters.")
+		}
+		return nil
+	}
+
+	for _, alpn := range s.Alpn {
+		if len(alpn) == 0 || len(alpn) > 255 {
+			return fmt.Errorf("%q is not a valid ALPN identifier.", alpn)
+		}
+	}
+	if s.NoDefaultAlpn && len(s.Alpn) == 0 {
+		return fmt.Errorf("No default ALPN needs the supported protocols to be listed in ALPN.")
+	}
+
+	for _, ip := range s.IPv4Hint {
+		if ip.To4() == nil {
+			return fmt.Errorf("%s is not an IPv4 address.", ip.String())
+		}
+	}
+	for _, ip := range s.IPv6Hint {
+		if ip.To4() != nil || len(ip) != net.IPv6len {
+			return fmt.Errorf("%s is not an IPv6 address.", ip.String())
+		}
+	}
+
+	_, err := s.otherParams()
+	return err
+}
+
+func (s *SVCB) GenComment() string {
+	target := strings.TrimSuffix(s.Target, ".")
+
+	if s.Priority == 0 {
+		return "alias to " + target
+	}
+
+	if target == "" {
+		target = "self"
+	}
+	if s.Port != 0 {
+		target = fmt.Sprintf("%s:%d", target, s.Port)
+	}
+	if len(s.Alpn) > 0 {
+		target += " (" + strings.Join(s.Alpn, ", ") + ")"
+	}
+	return target
+}
+
+// genSVCB builds the record. It fails when Params cannot be parsed, as
+// Validate does, rather than generating a record without them.
+func (s *SVCB) genSVCB(domain string, rrtype uint16, ttl uint32, origin string) (*dns.SVCB, error) {
+	others, err := s.otherParams()
+	if err != nil {
+		return nil, err
+	}
+
+	target := "."
+	if s.Target != "" && s.Target != "." {
+		target = utils.DomainFQDN(s.Target, origin)
+	}
+
+	rr := &dns.SVCB{
+		Hdr: dns.RR_Header{
+			Name:   utils.DomainJoin(domain),
+			Rrtype: rrtype,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		},
+		Priority: s.Priority,
+		Target:   target,
+	}
+
+	if len(s.Alpn) > 0 {
+		rr.Value = append(rr.Value, &dns.SVCBAlpn{Alpn: s.Alpn})
+	}
+	if s.NoDefaultAlpn {
+		rr.Value = append(rr.Value, &dns.SVCBNoDefaultAlpn{})
+	}
+	if s.Port != 0 {
+		rr.Value = append(rr.Value, &dns.SVCBPort{Port: s.Port})
+	}
+	if len(s.IPv4Hint) > 0 {
+		rr.Value = append(rr.Value, &dns.SVCBIPv4Hint{Hint: s.IPv4Hint})
+	}
+	if len(s.ECH) > 0 {
+		rr.Value = append(rr.Value, &dns.SVCBECHConfig{ECH: s.ECH})
+	}
+	if len(s.IPv6Hint) > 0 {
+		rr.Value = append(rr.Value, &dns.SVCBIPv6Hint{Hint: s.IPv6Hint})
+	}
+	rr.Value = append(rr.Value, others...)
+
+	sort.Slice(rr.Value, func(i, j int) bool {
+		return rr.Value[i].Key() < rr.Value[j].Key()
+	})
+
+	return rr, nil
+}
+
+func newSVCB(rr *dns.SVCB) *SVCB {
+	s := &SVCB{
+		Priority: rr.Priority,
+		Target:   rr.Target,
+	}
+
+	for _, kv := range rr.Value {
+		switch v := kv.(type) {
+		case *dns.SVCBAlpn:
+			s.Alpn = v.Alpn
+		case *dns.SVCBNoDefaultAlpn:
+			s.NoDefaultAlpn = true
+		case *dns.SVCBPort:
+			s.Port = v.Port
+		case *dns.SVCBIPv4Hint:
+			s.IPv4Hint = v.Hint
+		case *dns.SVCBECHConfig:
+			s.ECH = v.ECH
+		case *dns.SVCBIPv6Hint:
+			s.IPv6Hint = v.Hint
+		default:
+			if s.Params == nil {
+				s.Params = map[string]string{}
+			}
+			s.Params[kv.Key().String()] = kv.String()
+		}
+	}
+
+	return s
+}
+
+func svcbComment(records []*SVCB) string {
+	var comments []string
+	for _, s := range records {
+		comments = append(comments, s.GenComment())
+	}
+	return strings.Join(comments, "; ")
+}
+
+func svcbValidate(records []*SVCB) error {
+	for _, s := range records {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type SVCBs struct {
+	Records []*SVCB `json:"svcb,omitempty" happydomain:"label=Endpoints"`
+}
+
+func (ss *SVCBs) GetNbResources() int {
+	return len(ss.Records)
+}
+
+func (ss *SVCBs) GenComment(origin string) string {
+	return svcbComment(ss.Records)
+}
+
+func (ss *SVCBs) Validate() error {
+	return svcbValidate(ss.Records)
+}
+
+func (ss *SVCBs) GenRRs(domain string, ttl uint32, origin string) (rrs []dns.RR) {
+	// Invalid records are reported by Validate
+	for _, s := range ss.Records {
+		if rr, err := s.genSVCB(domain, dns.TypeSVCB, ttl, origin); err == nil {
+			rrs = append(rrs, rr)
+		}
+	}
+	return
+}
+
+type HTTPSs struct {
+	Records []*SVCB `json:"https,omitempty" happydomain:"label=Endpoints"`
+}
+
+func (ss *HTTPSs) GetNbResources() int {
+	return len(ss.Records)
+}
+
+func (ss *HTTPSs) GenComment(origin string) string {
+	return svcbComment(ss.Records)
+}
+
+func (ss *HTTPSs) Validate() error {
+	return svcbValidate(ss.Records)
+}
+
+func (ss *HTTPSs) GenRRs(domain string, ttl uint32, origin string) (rrs []dns.RR) {
+	// Invalid records are reported by Validate
+	for _, s := range ss.Records {
+		if rr, err := s.genSVCB(domain, dns.TypeHTTPS, ttl, origin); err == nil {
+			rrs = append(rrs, &dns.HTTPS{SVCB: *rr})
+		}
+	}
+	return
+}
+
+// analyzeSVCB returns the SVCB handling the record, or nil when the record is
+// not valid or would not be generated back as it is: such records are left to
+// the Orphan service.
+func analyzeSVCB(rr *dns.SVCB, origin string) *SVCB {
+	s := newSVCB(rr)
+	if s.Validate() != nil {
+		return nil
+	}
+
+	gen, err := s.genSVCB(rr.Hdr.Name, rr.Hdr.Rrtype, rr.Hdr.Ttl, origin)
+	if err != nil || gen.String() != rr.String() {
+		return nil
+	}
+
+	return s
+}
+
+func svcb_analyze(a *Analyzer) (err error) {
+	pool := map[string]*SVCBs{}
+
+	for _, record := range a.SearchRR(AnalyzerRecordFilter{Type: dns.TypeSVCB}) {
+		if svcb, ok := record.(*dns.SVCB); ok {
+			s := analyzeSVCB(svcb, a.GetOrigin())
+			if s == nil {
+				continue
+			}
+
+			dn := record.Header().Name
+			if _, ok := pool[dn]; !ok {
+				pool[dn] = &SVCBs{}
+			}
+
+			pool[dn].Records = append(pool[dn].Records, s)
+
+			err = a.UseRR(record, dn, pool[dn])
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return nil
+}
+
+func https_analyze(a *Analyzer) (err error) {
+	pool := map[string]*HTTPSs{}
+
+	for _, record := range a.SearchRR(AnalyzerRecordFilter{Type: dns.TypeHTTPS}) {
+		if https, ok := record.(*dns.HTTPS); ok {
+			s := analyzeSVCB(&https.SVCB, a.GetOrigin())
+			if s == nil {
+				continue
+			}
+
+			dn := record.Header().Name
+			if _, ok := pool[dn]; !ok {
+				pool[dn] = &HTTPSs{}
+			}
+
+			pool[dn].Records = append(pool[dn].Records, s)
+
+			err = a.UseRR(record, dn, pool[dn])
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	RegisterService(
+		func() happydns.Service {
+			return &HTTPSs{}
+		},
+		https_analyze,
+		ServiceInfos{
+			Name:        "HTTPS endpoints",
+			Description: "Advertise the protocols, ports and addresses of your web servers, or alias your apex to another name.",
+			Categories: []string{
+				"service",
+			},
+			Restrictions: ServiceRestrictions{
+				Single: true,
+				NeedTypes: []uint16{
+					dns.TypeHTTPS,
+				},
+			},
+		},
+		100,
+	)
+	RegisterService(
+		func() happydns.Service {
+			return &SVCBs{}
+		},
+		svcb_analyze,
+		ServiceInfos{
+			Name:        "Service binding",
+			Description: "Advertise the endpoints of a service (SVCB records).",
+			Categories: []string{
+				"service",
+			},
+			Restrictions: ServiceRestrictions{
+				Single: true,
+				NeedTypes: []uint16{
+					dns.TypeSVCB,
+				},
+			},
+		},
+		100,
+	)
+}
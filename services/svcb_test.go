@@ -0,0 +1,225 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package svcs
+
+import (
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"git.happydns.org/happydomain/model"
+)
+
+const testECH = "AEn+DQBFKwAgACABWIHUGj4u+PIggYXcR5JF0gYk3dCRioBW8uJq9H4mKAAIAAEAAQABAANAEnB1YmxpYy50bHMtZWNoLmRldgAA"
+
+func TestSVCBRoundTrip(t *testing.T) {
+	records := []string{
+		// ServiceMode
+		`example.com. 3600 IN HTTPS 1 . alpn="h2,h3" port="8443" ipv4hint="192.0.2.1,192.0.2.2" ipv6hint="2001:db8::1"`,
+		`example.com. 3600 IN HTTPS 2 backup.example.net. alpn="h2" no-default-alpn`,
+		// AliasMode
+		`www.example.com. 3600 IN HTTPS 0 cdn.example.net.`,
+		// mandatory and ECH
+		`_dns.example.com. 3600 IN SVCB 1 dns.example.com. mandatory="alpn,port" alpn="dot" port="853"`,
+		`ech.example.com. 3600 IN HTTPS 1 . alpn="h2" ech="` + testECH + `"`,
+	}
+
+	var zone []dns.RR
+	var expected []string
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("invalid record %q: %s", record, err.Error())
+		}
+		zone = append(zone, rr)
+		expected = append(expected, rr.String())
+	}
+
+	services, defaultTTL, err := AnalyzeZone("example.com.", zone)
+	if err != nil {
+		t.Fatalf("AnalyzeZone: %s", err.Error())
+	}
+
+	for subdomain, svcs := range services {
+		for _, svc := range svcs {
+			switch s := svc.Service.(type) {
+			case *HTTPSs, *SVCBs:
+				if err := s.(happydns.ServiceValidator).Validate(); err != nil {
+					t.Errorf("%q: analyzed service is not valid: %s", subdomain, err.Error())
+				}
+			default:
+				t.Errorf("%q: unexpected %T service", subdomain, svc.Service)
+			}
+		}
+	}
+
+	apex := services[""][0].Service.(*HTTPSs).Records
+	if len(apex) != 2 || apex[0].Priority != 1 || apex[0].Port != 8443 || !reflect.DeepEqual(apex[0].Alpn, []string{"h2", "h3"}) || len(apex[0].IPv4Hint) != 2 || len(apex[0].IPv6Hint) != 1 {
+		t.Errorf("unexpected ServiceMode records: %+v", apex)
+	} else if !apex[1].NoDefaultAlpn || apex[1].Target != "backup.example.net." {
+		t.Errorf("unexpected ServiceMode record: %+v", apex[1])
+	}
+
+	if alias := services["www"][0].Service.(*HTTPSs).Records; len(alias) != 1 || alias[0].Priority != 0 || alias[0].Target != "cdn.example.net." || alias[0].hasParams() {
+		t.Errorf("unexpected AliasMode record: %+v", alias)
+	}
+
+	if dot := services["_dns"][0].Service.(*SVCBs).Records; len(dot) != 1 || !reflect.DeepEqual(dot[0].Params, map[string]string{"mandatory": "alpn,port"}) {
+		t.Errorf("unexpected mandatory record: %+v", dot)
+	}
+
+	if ech := services["ech"][0].Service.(*HTTPSs).Records; len(ech) != 1 || len(ech[0].ECH) == 0 {
+		t.Errorf("unexpected ECH record: %+v", ech)
+	}
+
+	// The records are generated back as they were
+	z := &happydns.Zone{
+		ZoneMeta: happydns.ZoneMeta{DefaultTTL: defaultTTL},
+		Services: services,
+	}
+
+	var got []string
+	for _, rr := range z.GenerateRRs("example.com.") {
+		got = append(got, rr.String())
+	}
+	sort.Strings(got)
+	sort.Strings(expected)
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got\n%v\nexpected\n%v", got, expected)
+	}
+}
+
+func TestSVCBInvalid(t *testing.T) {
+	port := &SVCB{Priority: 1, Target: ".", Params: map[string]string{"port": "443"}}
+	mandatory := &SVCB{Priority: 1, Target: ".", Params: map[string]string{"mandatory": "nokey"}}
+
+	for name, s := range map[string]*SVCB{
+		"AliasMode with a port":       {Priority: 0, Target: "cdn.example.net.", Port: 443},
+		"AliasMode with ALPN":         {Priority: 0, Target: "cdn.example.net.", Alpn: []string{"h2"}},
+		"AliasMode with ECH":          {Priority: 0, Target: "cdn.example.net.", ECH: []byte{0}},
+		"AliasMode with mandatory":    {Priority: 0, Target: "cdn.example.net.", Params: map[string]string{"mandatory": "alpn"}},
+		"own field in Params":         port,
+		"invalid mandatory":           mandatory,
+		"mandatory with an unset key": {Priority: 1, Target: ".", Params: map[string]string{"mandatory": "port"}},
+		"mandatory listing itself":    {Priority: 1, Target: ".", Alpn: []string{"h2"}, Params: map[string]string{"mandatory": "mandatory,alpn"}},
+		"mandatory listing twice":     {Priority: 1, Target: ".", Alpn: []string{"h2"}, Params: map[string]string{"mandatory": "alpn,alpn"}},
+		"no default ALPN alone":       {Priority: 1, Target: ".", NoDefaultAlpn: true},
+		"IPv6 address in IPv4 hints":  {Priority: 1, Target: ".", IPv4Hint: []net.IP{net.ParseIP("2001:db8::1")}},
+		"invalid target":              {Priority: 1, Target: "in valid"},
+	} {
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded, expected an error", name)
+		}
+		if _, err := happydns.ValidateService(&HTTPSs{Records: []*SVCB{s}}, "", "example.com."); err == nil {
+			t.Errorf("%s: ValidateService succeeded, expected an error", name)
+		}
+	}
+
+	// Records whose parameters cannot be parsed are not generated without
+	// them
+	for name, s := range map[string]*SVCB{"own field in Params": port, "invalid mandatory": mandatory} {
+		if _, err := s.genSVCB("example.com.", dns.TypeHTTPS, 3600, "example.com."); err == nil {
+			t.Errorf("%s: genSVCB succeeded, expected an error", name)
+		}
+
+		valid := &SVCB{Priority: 1, Target: "."}
+		if rrs := (&SVCBs{Records: []*SVCB{valid, s}}).GenRRs("example.com.", 3600, "example.com."); len(rrs) != 1 {
+			t.Errorf("%s: got %d records, expected only the valid one", name, len(rrs))
+		}
+	}
+}
+
+func TestSVCBAnalyzeInvalid(t *testing.T) {
+	records := []string{
+		`example.com. 3600 IN HTTPS 1 . alpn="h2"`,
+		// Invalid records, left to the Orphan service
+		`example.com. 3600 IN HTTPS 0 cdn.example.net. alpn="h2"`,
+		`example.com. 3600 IN HTTPS 2 . no-default-alpn`,
+		`_dns.example.com. 3600 IN SVCB 1 dns.example.com. mandatory="port" alpn="dot"`,
+	}
+
+	var zone []dns.RR
+	var expected []string
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("invalid record %q: %s", record, err.Error())
+		}
+		zone = append(zone, rr)
+		expected = append(expected, rr.String())
+	}
+
+	services, defaultTTL, err := AnalyzeZone("example.com.", zone)
+	if err != nil {
+		t.Fatalf("AnalyzeZone: %s", err.Error())
+	}
+
+	var orphans int
+	for subdomain, svcs := range services {
+		for _, svc := range svcs {
+			switch s := svc.Service.(type) {
+			case *HTTPSs:
+				if len(s.Records) != 1 || s.Records[0].Priority != 1 {
+					t.Errorf("%q: unexpected records %+v", subdomain, s.Records)
+				}
+			case *Orphan:
+				orphans++
+			default:
+				t.Errorf("%q: unexpected %T service", subdomain, svc.Service)
+			}
+		}
+	}
+	if orphans != 3 {
+		t.Errorf("got %d orphan records, expected 3", orphans)
+	}
+
+	// No record is lost when the zone is generated back
+	z := &happydns.Zone{
+		ZoneMeta: happydns.ZoneMeta{DefaultTTL: defaultTTL},
+		Services: services,
+	}
+
+	var got []string
+	for _, rr := range z.GenerateRRs("example.com.") {
+		got = append(got, rr.String())
+	}
+	sort.Strings(got)
+	sort.Strings(expected)
+
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got\n%v\nexpected\n%v", got, expected)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package backup // import "happydns.org/storage/backup"
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/providers"
+	"git.happydns.org/happydomain/storage"
+)
+
+// ArchiveVersion is the revision of the archive format produced by Export.
+const ArchiveVersion = 1
+
+// Archive holds the whole content of a database, in a form that doesn't
+// depend on the storage engine.
+type Archive struct {
+	Version     int                          `json:"version"`
+	CreatedAt   time.Time                    `json:"created_at"`
+	AuthUsers   happydns.UserAuths           `json:"auth_users"`
+	Users       happydns.Users               `json:"users"`
+	Sessions    []*happydns.Session          `json:"sessions"`
+	Providers   []*happydns.ProviderCombined `json:"providers"`
+	Domains     happydns.Domains             `json:"domains"`
+	Credentials []*happydns.Credential       `json:"credentials,omitempty"`
+	Zones       []*happydns.Zone             `json:"zones"`
+}
+
+// UnmarshalJSON decodes an Archive, instanciating the right Provider for
+// each stored provider.
+func (a *Archive) UnmarshalJSON(data []byte) error {
+	type archive Archive
+	aux := struct {
+		*archive
+		Providers []json.RawMessage `json:"providers"`
+	}{archive: (*archive)(a)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	a.Providers = nil
+	for _, raw := range aux.Providers {
+		var meta happydns.ProviderMeta
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return err
+		}
+
+		p, err := providers.FindProvider(meta.Type)
+		if err != nil {
+			return fmt.Errorf("provider %s: %w", meta.Id.String(), err)
+		}
+
+		src := &happydns.ProviderCombined{
+			Provider:     p,
+			ProviderMeta: meta,
+		}
+		if err = json.Unmarshal(raw, src); err != nil {
+			return fmt.Errorf("provider %s: %w", meta.Id.String(), err)
+		}
+
+		a.Providers = append(a.Providers, src)
+	}
+
+	return nil
+}
+
+// Export collects everything reachable from the given Storage.
+func Export(s storage.Storage) (*Archive, error) {
+	a := &Archive{
+		Version:   ArchiveVersion,
+		CreatedAt: time.Now(),
+	}
+
+	var err error
+	if a.AuthUsers, err = s.GetAuthUsers(); err != nil {
+		return nil, fmt.Errorf("unable to retrieve auth users: %w", err)
+	}
+
+	if a.Users, err = s.GetUsers(); err != nil {
+		return nil, fmt.Errorf("unable to retrieve users: %w", err)
+	}
+
+	seenSessions := map[string]bool{}
+	seenZones := map[string]bool{}
+	for _, user := range a.Users {
+		sessions, err := s.GetUserSessions(user)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve sessions of %s: %w", user.Email, err)
+		}
+
+		for _, session := range sessions {
+			if !seenSessions[session.Id.String()] {
+				seenSessions[session.Id.String()] = true
+				a.Sessions = append(a.Sessions, session)
+			}
+		}
+
+		metas, err := s.GetProviderMetas(user)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve providers of %s: %w", user.Email, err)
+		}
+
+		for _, meta := range metas {
+			src, err := s.GetProvider(user, meta.Id)
+			if err != nil {
+				return nil, fmt.Errorf("unable to retrieve provider %s: %w", meta.Id.String(), err)
+			}
+			a.Providers = append(a.Providers, src)
+		}
+
+		credentials, err := s.GetCredentials(user)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve credentials of %s: %w", user.Email, err)
+		}
+		a.Credentials = append(a.Credentials, credentials...)
+
+		domains, err := s.GetDomains(user)
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve domains of %s: %w", user.Email, err)
+		}
+
+		for _, domain := range domains {
+			a.Domains = append(a.Domains, domain)
+
+			for _, zid := range domain.ZoneHistory {
+				if seenZones[zid.String()] {
+					continue
+				}
+				seenZones[zid.String()] = true
+
+				zone, err := s.GetZone(zid)
+				if err != nil {
+					return nil, fmt.Errorf("unable to retrieve zone %s of %s: %w", zid.String(), domain.DomainName, err)
+				}
+				a.Zones = append(a.Zones, zone)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+// Import stores the content of the Archive into the given Storage, keeping
+// all identifiers. Existing items with the same identifiers are overwritten.
+func Import(s storage.Storage, a *Archive) error {
+	if a.Version < 1 || a.Version > ArchiveVersion {
+		return fmt.Errorf("unsupported archive version %d (this happyDomain handles up to version %d)", a.Version, ArchiveVersion)
+	}
+
+	for _, authUser := range a.AuthUsers {
+		if err := s.UpdateAuthUser(authUser); err != nil {
+			return fmt.Errorf("unable to restore auth user %s: %w", authUser.Email, err)
+		}
+	}
+
+	for _, user := range a.Users {
+		if err := s.UpdateUser(user); err != nil {
+			return fmt.Errorf("unable to restore user %s: %w", user.Email, err)
+		}
+	}
+
+	for _, session := range a.Sessions {
+		if err := s.UpdateSession(session); err != nil {
+			return fmt.Errorf("unable to restore session %s: %w", session.Id.String(), err)
+		}
+	}
+
+	for _, src := range a.Providers {
+		if err := s.UpdateProvider(src); err != nil {
+			return fmt.Errorf("unable to restore provider %s: %w", src.Id.String(), err)
+		}
+	}
+
+	for _, domain := range a.Domains {
+		if err := s.UpdateDomain(domain); err != nil {
+			return fmt.Errorf("unable to restore domain %s: %w", domain.DomainName, err)
+		}
+	}
+
+	for _, credential := range a.Credentials {
+		if err := s.UpdateCredential(credential); err != nil {
+			return fmt.Errorf("unable to restore credential %s: %w", credential.Id.String(), err)
+		}
+	}
+
+	for _, zone := range a.Zones {
+		if err := s.UpdateZone(zone); err != nil {
+			return fmt.Errorf("unable to restore zone %s: %w", zone.Id.String(), err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package backup
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/providers"
+	"git.happydns.org/happydomain/storage"
+	database "git.happydns.org/happydomain/storage/memory"
+)
+
+func must(t *testing.T, err error, what string) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %s", what, err.Error())
+	}
+}
+
+// fill stores two users, each with a provider, a domain having a zone
+// history, a session and a credential.
+func fill(t *testing.T, s storage.Storage) {
+	now := time.Now().Truncate(time.Second)
+
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		auth := &happydns.UserAuth{Email: email, EmailVerification: &now, Password: []byte("hash")}
+		must(t, s.CreateAuthUser(auth), "CreateAuthUser")
+
+		user := &happydns.User{Id: auth.Id, Email: email, CreatedAt: now}
+		must(t, s.UpdateUser(user), "UpdateUser")
+
+		must(t, s.CreateSession(&happydns.Session{IdUser: user.Id, IssuedAt: now}), "CreateSession")
+
+		p, err := providers.FindProvider("DDNSServer")
+		must(t, err, "FindProvider")
+		src, err := s.CreateProvider(user, p, email+"'s provider")
+		must(t, err, "CreateProvider")
+
+		domain := &happydns.Domain{IdProvider: src.Id, DomainName: "example.com."}
+		for i := 0; i < 3; i++ {
+			zone := &happydns.Zone{
+				ZoneMeta: happydns.ZoneMeta{IdAuthor: user.Id, DefaultTTL: uint32(300 * (i + 1)), LastModified: now},
+				Services: map[string][]*happydns.ServiceCombined{},
+			}
+			must(t, s.CreateZone(zone), "CreateZone")
+			domain.ZoneHistory = append(domain.ZoneHistory, zone.Id)
+		}
+		must(t, s.CreateDomain(user, domain), "CreateDomain")
+
+		must(t, s.CreateCredential(&happydns.Credential{IdUser: user.Id, IdDomain: domain.Id, Scope: happydns.CredentialDynDNS}), "CreateCredential")
+	}
+}
+
+// index returns the JSON form of each item of the archive, by kind and
+// identifier, to compare archives whatever the order of their items.
+func index(t *testing.T, a *Archive) map[string]string {
+	ret := map[string]string{}
+	add := func(kind string, id happydns.Identifier, v interface{}) {
+		data, err := json.Marshal(v)
+		must(t, err, "Marshal")
+		ret[kind+":"+id.String()] = string(data)
+	}
+
+	for _, v := range a.AuthUsers {
+		add("authuser", v.Id, v)
+	}
+	for _, v := range a.Users {
+		add("user", v.Id, v)
+	}
+	for _, v := range a.Sessions {
+		add("session", v.Id, v)
+	}
+	for _, v := range a.Providers {
+		add("provider", v.Id, v)
+	}
+	for _, v := range a.Domains {
+		add("domain", v.Id, v)
+	}
+	for _, v := range a.Credentials {
+		add("credential", v.Id, v)
+	}
+	for _, v := range a.Zones {
+		add("zone", v.Id, v)
+	}
+
+	return ret
+}
+
+func TestRoundTrip(t *testing.T) {
+	src := database.NewMemoryStorage()
+	fill(t, src)
+
+	exported, err := Export(src)
+	must(t, err, "Export")
+
+	if len(exported.AuthUsers) != 2 || len(exported.Users) != 2 || len(exported.Sessions) != 2 || len(exported.Providers) != 2 || len(exported.Domains) != 2 || len(exported.Credentials) != 2 || len(exported.Zones) != 6 {
+		t.Fatalf("unexpected export: %d auth users, %d users, %d sessions, %d providers, %d domains, %d credentials, %d zones", len(exported.AuthUsers), len(exported.Users), len(exported.Sessions), len(exported.Providers), len(exported.Domains), len(exported.Credentials), len(exported.Zones))
+	}
+
+	// Go through the archive file format
+	data, err := json.Marshal(exported)
+	must(t, err, "Marshal")
+
+	var archive Archive
+	must(t, json.Unmarshal(data, &archive), "Unmarshal")
+
+	for _, p := range archive.Providers {
+		if p.Provider == nil {
+			t.Fatalf("provider %s is not instanciated", p.Id.String())
+		}
+	}
+
+	dst := database.NewMemoryStorage()
+	must(t, Import(dst, &archive), "Import")
+
+	imported, err := Export(dst)
+	must(t, err, "Export")
+
+	before := index(t, exported)
+	after := index(t, imported)
+
+	var keys []string
+	for k := range before {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if after[k] != before[k] {
+			t.Errorf("%s differs after import:\ngot      %s\nexpected %s", k, after[k], before[k])
+		}
+	}
+	if len(after) != len(before) {
+		t.Errorf("got %d items after import, expected %d", len(after), len(before))
+	}
+
+	// Zone histories still reference the restored zones, in order
+	for _, domain := range imported.Domains {
+		for i, zid := range domain.ZoneHistory {
+			zone, err := dst.GetZone(zid)
+			must(t, err, "GetZone")
+			if zone.DefaultTTL != uint32(300*(i+1)) {
+				t.Errorf("revision %d of %s has TTL %d, expected %d", i, domain.DomainName, zone.DefaultTTL, 300*(i+1))
+			}
+		}
+	}
+}
+
+func TestImportVersion(t *testing.T) {
+	for _, version := range []int{0, ArchiveVersion + 1} {
+		if err := Import(database.NewMemoryStorage(), &Archive{Version: version}); err == nil {
+			t.Errorf("Import of an archive of version %d succeeded, expected an error", version)
+		}
+	}
+}
@@ -71,6 +71,26 @@ type Storage interface {
 	// ClearAuthUsers deletes all AuthUsers present in the database.
 	ClearAuthUsers() error
 
+	// CREDENTIALS ------------------------------------------------
+
+	// GetCredentials retrieves all Credentials owned by the given User.
+	GetCredentials(u *happydns.User) ([]*happydns.Credential, error)
+
+	// GetCredential retrieves the Credential with the given identifier.
+	GetCredential(id happydns.Identifier) (*happydns.Credential, error)
+
+	// CreateCredential creates a record in the database for the given Credential.
+	CreateCredential(c *happydns.Credential) error
+
+	// UpdateCredential updates the fields of the given Credential.
+	UpdateCredential(c *happydns.Credential) error
+
+	// DeleteCredential removes the given Credential from the database.
+	DeleteCredential(c *happydns.Credential) error
+
+	// ClearCredentials deletes all Credentials present in the database.
+	ClearCredentials() error
+
 	// DOMAINS ----------------------------------------------------
 
 	// GetDomains retrieves all Domains associated to the given User.
@@ -82,6 +102,9 @@ type Storage interface {
 	// GetDomainByDN is like GetDomain but look for the domain name instead of identifier.
 	GetDomainByDN(u *happydns.User, dn string) (*happydns.Domain, error)
 
+	// GetDomainByZone retrieves the Domain having the given Zone in its history.
+	GetDomainByZone(id happydns.Identifier) (*happydns.Domain, error)
+
 	// DomainExists looks if the given domain name alread exists in the database.
 	DomainExists(dn string) bool
 
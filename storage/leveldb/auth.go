@@ -33,10 +33,10 @@ package database
 
 import (
 	"fmt"
-	"log"
 
 	"git.happydns.org/happydomain/model"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
@@ -50,7 +50,7 @@ func (s *LevelDBStorage) GetAuthUsers() (users happydns.UserAuths, err error) {
 
 		err = decodeData(iter.Value(), &u)
 		if err != nil {
-			log.Printf("GetAuthUsers: Unable to decode user %q: %s", iter.Key(), err.Error())
+			log.WithError(err).WithField("key", string(iter.Key())).Warn("GetAuthUsers: unable to decode auth user")
 		} else {
 			users = append(users, &u)
 		}
@@ -164,13 +164,13 @@ func (s *LevelDBStorage) TidyAuthUsers() error {
 
 		if err != nil {
 			// Drop unreadable providers
-			log.Printf("Deleting unreadable authUser (%s): %v\n", err.Error(), userAuth)
+			log.WithError(err).WithField("key", string(iter.Key())).Info("deleting unreadable auth user")
 			err = tx.Delete(iter.Key(), nil)
 		} else {
 			_, err = s.GetUser(userAuth.Id)
 			if err == leveldb.ErrNotFound {
 				// Drop providers of unexistant users
-				log.Printf("Deleting orphan authuser (user %s not found): %v\n", userAuth.Id.String(), userAuth)
+				log.WithField("authuser", userAuth.Id.String()).Info("deleting orphan auth user: user not found")
 				err = tx.Delete(iter.Key(), nil)
 			}
 		}
@@ -0,0 +1,157 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"bytes"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+
+	"git.happydns.org/happydomain/model"
+)
+
+func (s *LevelDBStorage) GetCredentials(u *happydns.User) (credentials []*happydns.Credential, err error) {
+	iter := s.search("credential-")
+	defer iter.Release()
+
+	for iter.Next() {
+		var c happydns.Credential
+
+		err = decodeData(iter.Value(), &c)
+		if err != nil {
+			return
+		}
+
+		if bytes.Equal(c.IdUser, u.Id) {
+			credentials = append(credentials, &c)
+		}
+	}
+
+	return
+}
+
+func (s *LevelDBStorage) getCredential(key string) (c *happydns.Credential, err error) {
+	c = &happydns.Credential{}
+	err = s.get(key, c)
+	return
+}
+
+func (s *LevelDBStorage) GetCredential(id happydns.Identifier) (*happydns.Credential, error) {
+	return s.getCredential(fmt.Sprintf("credential-%s", id.String()))
+}
+
+func (s *LevelDBStorage) CreateCredential(c *happydns.Credential) error {
+	key, id, err := s.findIdentifierKey("credential-")
+	if err != nil {
+		return err
+	}
+
+	c.Id = id
+	return s.put(key, c)
+}
+
+func (s *LevelDBStorage) UpdateCredential(c *happydns.Credential) error {
+	return s.put(fmt.Sprintf("credential-%s", c.Id.String()), c)
+}
+
+func (s *LevelDBStorage) DeleteCredential(c *happydns.Credential) error {
+	return s.delete(fmt.Sprintf("credential-%s", c.Id.String()))
+}
+
+func (s *LevelDBStorage) ClearCredentials() error {
+	tx, err := s.db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+
+	iter := tx.NewIterator(util.BytesPrefix([]byte("credential-")), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		err = tx.Delete(iter.Key(), nil)
+		if err != nil {
+			tx.Discard()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		tx.Discard()
+		return err
+	}
+
+	return nil
+}
+
+func (s *LevelDBStorage) TidyCredentials() error {
+	tx, err := s.db.OpenTransaction()
+	if err != nil {
+		return err
+	}
+
+	iter := tx.NewIterator(util.BytesPrefix([]byte("credential-")), nil)
+	defer iter.Release()
+
+	for iter.Next() {
+		credential, err := s.getCredential(string(iter.Key()))
+
+		if err != nil {
+			// Drop unreadable credentials
+			log.WithError(err).WithField("key", string(iter.Key())).Info("deleting unreadable credential")
+			err = tx.Delete(iter.Key(), nil)
+		} else {
+			_, err = s.getDomain(fmt.Sprintf("domain-%s", credential.IdDomain.String()))
+			if err == leveldb.ErrNotFound {
+				// Drop credentials of unexistant domains
+				log.WithField("credential", credential.Id.String()).WithField("domain", credential.IdDomain.String()).Info("deleting orphan credential: domain not found")
+				err = tx.Delete(iter.Key(), nil)
+			}
+		}
+
+		if err != nil {
+			tx.Discard()
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		tx.Discard()
+		return err
+	}
+
+	return nil
+}
@@ -34,10 +34,10 @@ package database // import "happydns.org/storage/leveldb"
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 
 	"git.happydns.org/happydomain/model"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
@@ -55,12 +55,12 @@ func NewLevelDBStorage(path string) (s *LevelDBStorage, err error) {
 	db, err = leveldb.OpenFile(path, nil)
 	if err != nil {
 		if _, ok := err.(*errors.ErrCorrupted); ok {
-			log.Println("LevelDB was corrupted; attempting recovery (%s)", err.Error())
+			log.WithError(err).Warn("LevelDB was corrupted; attempting recovery")
 			_, err = leveldb.RecoverFile(path, nil)
 			if err != nil {
 				return
 			}
-			log.Println("LevelDB recovery succeeded!")
+			log.Info("LevelDB recovery succeeded")
 		} else {
 			return
 		}
@@ -71,7 +71,7 @@ func NewLevelDBStorage(path string) (s *LevelDBStorage, err error) {
 }
 
 func (s *LevelDBStorage) Tidy() error {
-	for _, tidy := range []func() error{s.TidySessions, s.TidyAuthUsers, s.TidyUsers, s.TidyProviders, s.TidyDomains, s.TidyZones} {
+	for _, tidy := range []func() error{s.TidySessions, s.TidyAuthUsers, s.TidyUsers, s.TidyProviders, s.TidyDomains, s.TidyCredentials, s.TidyZones} {
 		if err := tidy(); err != nil {
 			return err
 		}
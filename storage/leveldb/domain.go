@@ -34,10 +34,10 @@ package database
 import (
 	"bytes"
 	"fmt"
-	"log"
 
 	"git.happydns.org/happydomain/model"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
@@ -98,6 +98,28 @@ func (s *LevelDBStorage) GetDomainByDN(u *happydns.User, dn string) (*happydns.D
 	return nil, leveldb.ErrNotFound
 }
 
+func (s *LevelDBStorage) GetDomainByZone(id happydns.Identifier) (*happydns.Domain, error) {
+	iter := s.search("domain-")
+	defer iter.Release()
+
+	for iter.Next() {
+		var z happydns.Domain
+
+		err := decodeData(iter.Value(), &z)
+		if err != nil {
+			continue
+		}
+
+		for _, zid := range z.ZoneHistory {
+			if zid.Equals(id) {
+				return &z, nil
+			}
+		}
+	}
+
+	return nil, leveldb.ErrNotFound
+}
+
 func (s *LevelDBStorage) DomainExists(dn string) bool {
 	iter := s.search("domain-")
 	defer iter.Release()
@@ -191,18 +213,18 @@ func (s *LevelDBStorage) TidyDomains() error {
 			if err == leveldb.ErrNotFound {
 				// Drop domain of unexistant users
 				err = tx.Delete(iter.Key(), nil)
-				log.Printf("Deleting orphan domain (user %s not found): %v\n", domain.IdUser.String(), domain)
+				log.WithField("domain", domain.Id.String()).WithField("user", domain.IdUser.String()).Info("deleting orphan domain: user not found")
 			}
 
 			_, err = s.GetProvider(u, domain.IdProvider)
 			if err == leveldb.ErrNotFound {
 				// Drop domain of unexistant provider
 				err = tx.Delete(iter.Key(), nil)
-				log.Printf("Deleting orphan domain (provider %s not found): %v\n", domain.IdProvider.String(), domain)
+				log.WithField("domain", domain.Id.String()).WithField("provider", domain.IdProvider.String()).Info("deleting orphan domain: provider not found")
 			}
 		} else {
 			// Drop unreadable domains
-			log.Printf("Deleting unreadable domain (%s): %v\n", err.Error(), domain)
+			log.WithError(err).WithField("key", string(iter.Key())).Info("deleting unreadable domain")
 			err = tx.Delete(iter.Key(), nil)
 		}
 
@@ -34,9 +34,9 @@ package database
 import (
 	"bytes"
 	"fmt"
-	"log"
 	"reflect"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
 
@@ -112,12 +112,15 @@ func (s *LevelDBStorage) GetProvider(u *happydns.User, id happydns.Identifier) (
 	}
 
 	if !bytes.Equal(srcMeta.OwnerId, u.Id) {
-		src = nil
 		err = leveldb.ErrNotFound
+		return
 	}
 
 	var tsrc happydns.Provider
 	tsrc, err = providers.FindProvider(srcMeta.Type)
+	if err != nil {
+		return
+	}
 
 	src = &happydns.ProviderCombined{
 		tsrc,
@@ -202,13 +205,13 @@ func (s *LevelDBStorage) TidyProviders() error {
 
 		if err != nil {
 			// Drop unreadable providers
-			log.Printf("Deleting unreadable provider (%s): %v\n", err.Error(), srcMeta)
+			log.WithError(err).WithField("key", string(iter.Key())).Info("deleting unreadable provider")
 			err = tx.Delete(iter.Key(), nil)
 		} else {
 			_, err = s.GetUser(srcMeta.OwnerId)
 			if err == leveldb.ErrNotFound {
 				// Drop providers of unexistant users
-				log.Printf("Deleting orphan provider (user %s not found): %v\n", srcMeta.OwnerId.String(), srcMeta)
+				log.WithField("provider", srcMeta.Id.String()).WithField("user", srcMeta.OwnerId.String()).Info("deleting orphan provider: user not found")
 				err = tx.Delete(iter.Key(), nil)
 			}
 		}
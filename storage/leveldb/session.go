@@ -32,9 +32,10 @@
 package database
 
 import (
+	"bytes"
 	"fmt"
-	"log"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
 
@@ -62,7 +63,10 @@ func (s *LevelDBStorage) GetAuthUserSessions(user *happydns.UserAuth) (sessions
 		if err != nil {
 			return
 		}
-		sessions = append(sessions, &s)
+
+		if bytes.Equal(s.IdUser, user.Id) {
+			sessions = append(sessions, &s)
+		}
 	}
 
 	return
@@ -79,7 +83,10 @@ func (s *LevelDBStorage) GetUserSessions(user *happydns.User) (sessions []*happy
 		if err != nil {
 			return
 		}
-		sessions = append(sessions, &s)
+
+		if bytes.Equal(s.IdUser, user.Id) {
+			sessions = append(sessions, &s)
+		}
 	}
 
 	return
@@ -144,13 +151,13 @@ func (s *LevelDBStorage) TidySessions() error {
 
 		if err != nil {
 			// Drop unreadable sessions
-			log.Printf("Deleting unreadable session (%s): %v\n", err.Error(), session)
+			log.WithError(err).WithField("key", string(iter.Key())).Info("deleting unreadable session")
 			err = tx.Delete(iter.Key(), nil)
 		} else {
 			_, err = s.GetUser(session.IdUser)
 			if err == leveldb.ErrNotFound {
 				// Drop session from unexistant users
-				log.Printf("Deleting orphan session (user %s not found): %v\n", session.IdUser.String(), session)
+				log.WithField("key", string(iter.Key())).WithField("user", session.IdUser.String()).Info("deleting orphan session: user not found")
 				err = tx.Delete(iter.Key(), nil)
 			}
 		}
@@ -33,7 +33,8 @@ package database
 
 import (
 	"bytes"
-	"log"
+
+	log "github.com/sirupsen/logrus"
 )
 
 func migrateFrom0(s *LevelDBStorage) (err error) {
@@ -95,7 +96,7 @@ func migrateFrom0_sourcesProvider(s *LevelDBStorage) (err error) {
 			newType = "OVHAPI"
 		default:
 			// Keep other source type to update in future version
-			log.Printf("Migrating v0 -> v1: skip %s (%s)...", iter.Key(), srcMeta.Type)
+			log.WithField("key", string(iter.Key())).WithField("type", srcMeta.Type).Info("migrating v0 -> v1: skip")
 			continue
 		}
 
@@ -113,7 +114,7 @@ func migrateFrom0_sourcesProvider(s *LevelDBStorage) (err error) {
 
 		newKey := bytes.Replace(iter.Key(), []byte("source-"), []byte("provider-"), 1)
 
-		log.Printf("Migrating v0 -> v1: %s to %s (%s)...", iter.Key(), newKey, newType)
+		log.WithField("key", string(iter.Key())).WithField("to", newKey).WithField("type", newType).Info("migrating v0 -> v1")
 
 		err = s.db.Put(newKey, src, nil)
 		if err != nil {
@@ -138,7 +139,7 @@ func migrateFrom0_reparentDomains(s *LevelDBStorage) (err error) {
 
 		domstr = bytes.Replace(domstr, []byte("\"id_source\":"), []byte("\"id_provider\":"), 1)
 
-		log.Printf("Migrating v0 -> v1: %s...", iter.Key())
+		log.WithField("key", string(iter.Key())).Info("migrating v0 -> v1")
 
 		err = s.db.Put(iter.Key(), domstr, nil)
 		if err != nil {
@@ -35,9 +35,10 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
-	"log"
 	"time"
 
+	log "github.com/sirupsen/logrus"
+
 	"git.happydns.org/happydomain/model"
 )
 
@@ -88,10 +89,10 @@ func migrateFrom1_users_tree(s *LevelDBStorage) (err error) {
 
 		newId, idRaw, errr := genUserIdv2(user.Id)
 		if err != nil {
-			log.Printf("Migrating v1 -> v2: %s: unable to calculate new ID: %s", iter.Key(), errr.Error())
+			log.WithError(errr).WithField("key", string(iter.Key())).Warn("migrating v1 -> v2: unable to calculate new ID")
 			continue
 		} else if len(idRaw) == 0 {
-			log.Printf("Migrating v1 -> v2: %s: unable to calculate new ID: %v", iter.Key(), user)
+			log.WithField("key", string(iter.Key())).Warn("migrating v1 -> v2: unable to calculate new ID")
 			continue
 		}
 
@@ -118,7 +119,7 @@ func migrateFrom1_users_tree(s *LevelDBStorage) (err error) {
 			AllowCommercials:  user.Settings.Newsletter,
 		}
 
-		log.Printf("Migrating v1 -> v2: %s to user-%x...", iter.Key(), idRaw)
+		log.WithField("key", string(iter.Key())).WithField("to", fmt.Sprintf("user-%x", idRaw)).Info("migrating v1 -> v2")
 
 		err = s.put(fmt.Sprintf("user-%x", idRaw), newUser)
 		if err != nil {
@@ -157,7 +158,7 @@ func migrateFrom1_domains(s *LevelDBStorage, oldUserId int64, newUserId string)
 		migstr := bytes.Replace(domstr, oldIdStr, newIdStr, 1)
 
 		if !bytes.Equal(migstr, domstr) {
-			log.Printf("Migrating v1 -> v2: %s...", iter.Key())
+			log.WithField("key", string(iter.Key())).Info("migrating v1 -> v2")
 
 			err = s.db.Put(iter.Key(), migstr, nil)
 			if err != nil {
@@ -182,7 +183,7 @@ func migrateFrom1_provider(s *LevelDBStorage, oldUserId int64, newUserId string)
 		migstr := bytes.Replace(domstr, oldIdStr, newIdStr, 1)
 
 		if !bytes.Equal(migstr, domstr) {
-			log.Printf("Migrating v1 -> v2: %s...", iter.Key())
+			log.WithField("key", string(iter.Key())).Info("migrating v1 -> v2")
 
 			err = s.db.Put(iter.Key(), migstr, nil)
 			if err != nil {
@@ -207,7 +208,7 @@ func migrateFrom1_zone(s *LevelDBStorage, oldUserId int64, newUserId string) (er
 		migstr := bytes.Replace(domstr, oldIdStr, newIdStr, 1)
 
 		if !bytes.Equal(migstr, domstr) {
-			log.Printf("Migrating v1 -> v2: %s...", iter.Key())
+			log.WithField("key", string(iter.Key())).Info("migrating v1 -> v2")
 
 			err = s.db.Put(iter.Key(), migstr, nil)
 			if err != nil {
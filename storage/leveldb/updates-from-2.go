@@ -35,11 +35,11 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
-	"log"
 	"time"
 
 	"git.happydns.org/happydomain/model"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 )
 
@@ -92,7 +92,7 @@ func migrateFrom2_users_tree(s *LevelDBStorage) (err error) {
 			Settings:  user.Settings,
 		}
 
-		log.Printf("Migrating v2 -> v3: %s to user-%s...", iter.Key(), newId.String())
+		log.WithField("key", string(iter.Key())).WithField("to", "user-"+newId.String()).Info("migrating v2 -> v3")
 
 		err = s.put(fmt.Sprintf("user-%s", newId.String()), newUser)
 		if err != nil {
@@ -142,7 +142,7 @@ func migrateFrom2_auth(s *LevelDBStorage, oldUserId happydns.HexaString, newId h
 				AllowCommercials:  false,
 			}
 
-			log.Printf("Migrating v2 -> v3: auth-%s: %s not found, creating it", newId.String(), oldAuthKey)
+			log.WithField("key", oldAuthKey).WithField("to", "auth-"+newId.String()).Info("migrating v2 -> v3: auth user not found, creating it")
 
 			return s.put(fmt.Sprintf("auth-%s", newId.String()), user4auth)
 		}
@@ -155,7 +155,7 @@ func migrateFrom2_auth(s *LevelDBStorage, oldUserId happydns.HexaString, newId h
 		var newauth happydns.UserAuth
 		err = decodeData(migstr, &newauth)
 		if err != nil {
-			log.Printf("From %s to %s", usrstr, migstr)
+			log.WithField("from", string(usrstr)).WithField("to", string(migstr)).Debug("migrating v2 -> v3: invalid rewritten auth user")
 			return fmt.Errorf("unable to reconstruct a valid auth user: %w", err)
 		}
 
@@ -163,7 +163,7 @@ func migrateFrom2_auth(s *LevelDBStorage, oldUserId happydns.HexaString, newId h
 		if err != nil {
 			return fmt.Errorf("unable to write auth-%s (from %s): %w", newId.String(), oldAuthKey, err)
 		}
-		log.Printf("Migrating v2 -> v3: %s to auth-%s...", oldAuthKey, newId.String())
+		log.WithField("key", oldAuthKey).WithField("to", "auth-"+newId.String()).Info("migrating v2 -> v3")
 
 		err = s.delete(oldAuthKey)
 		if err != nil {
@@ -208,7 +208,7 @@ func migrateFrom2_session(s *LevelDBStorage, oldUserId happydns.HexaString, newU
 				if err != nil {
 					return fmt.Errorf("unable to write user.session-%s (from %s): %w", newId.String(), iter.Key(), err)
 				}
-				log.Printf("Migrating v2 -> v3: %s to user.session-%s...", iter.Key(), newId.String())
+				log.WithField("key", string(iter.Key())).WithField("to", "user.session-"+newId.String()).Info("migrating v2 -> v3")
 
 				err = s.delete(string(iter.Key()))
 				if err != nil {
@@ -259,11 +259,11 @@ func migrateFrom2_provider(s *LevelDBStorage, oldUserId happydns.HexaString, new
 				var newprv happydns.ProviderMeta
 				err = decodeData(migstr, &newprv)
 				if err != nil {
-					log.Printf("From %s to %s", domstr, migstr)
+					log.WithField("from", string(domstr)).WithField("to", string(migstr)).Debug("migrating v2 -> v3: invalid rewritten provider")
 					return fmt.Errorf("unable to reconstruct a valid provider: %w", err)
 				}
 
-				log.Printf("Migrating v2 -> v3: %s...", iter.Key())
+				log.WithField("key", string(iter.Key())).Info("migrating v2 -> v3")
 
 				err = s.db.Put([]byte(fmt.Sprintf("provider-%s", newId.String())), migstr, nil)
 				if err != nil {
@@ -350,11 +350,11 @@ func migrateFrom2_domains(s *LevelDBStorage, oldUserId happydns.HexaString, newU
 				var newdn happydns.Domain
 				err = decodeData(migstr, &newdn)
 				if err != nil {
-					log.Printf("From %s to %s", domstr, migstr)
+					log.WithField("from", string(domstr)).WithField("to", string(migstr)).Debug("migrating v2 -> v3: invalid rewritten domain")
 					return fmt.Errorf("unable to reconstruct a valid domain: %w", err)
 				}
 
-				log.Printf("Migrating v2 -> v3: %s...", iter.Key())
+				log.WithField("key", string(iter.Key())).Info("migrating v2 -> v3")
 
 				err = s.db.Put([]byte(fmt.Sprintf("domain-%s", newId.String())), migstr, nil)
 				if err != nil {
@@ -393,7 +393,7 @@ func migrateFrom2_zone(s *LevelDBStorage, oldUserId happydns.HexaString, newUser
 		if err != nil {
 			return fmt.Errorf("unable to write domain.zone-%s (from %s): %w", newZoneId, oldZoneKey, err)
 		}
-		log.Printf("Migrating v2 -> v3: %s to domain.zone-%s...", oldZoneKey, newZoneId)
+		log.WithField("key", oldZoneKey).WithField("to", fmt.Sprintf("domain.zone-%s", newZoneId)).Info("migrating v2 -> v3")
 
 		err = s.delete(oldZoneKey)
 		if err != nil {
@@ -33,7 +33,8 @@ package database
 
 import (
 	"fmt"
-	"log"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type LevelDBMigrationFunc func(s *LevelDBStorage) error
@@ -72,7 +73,7 @@ func (s *LevelDBStorage) DoMigration() (err error) {
 	}
 
 	for v, migration := range migrations[version:] {
-		log.Printf("Doing migration from %d to %d", version+v, version+v+1)
+		log.WithField("from", version+v).WithField("to", version+v+1).Info("doing migration")
 		// Do the migration
 		if err = migration(s); err != nil {
 			return
@@ -82,7 +83,7 @@ func (s *LevelDBStorage) DoMigration() (err error) {
 		if err = s.put("version", version+v+1); err != nil {
 			return
 		}
-		log.Printf("Migration from %d to %d DONE!", version+v, version+v+1)
+		log.WithField("from", version+v).WithField("to", version+v+1).Info("migration done")
 	}
 
 	return nil
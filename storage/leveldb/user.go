@@ -33,10 +33,10 @@ package database
 
 import (
 	"fmt"
-	"log"
 
 	"git.happydns.org/happydomain/model"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
@@ -50,7 +50,7 @@ func (s *LevelDBStorage) GetUsers() (users happydns.Users, err error) {
 
 		err = decodeData(iter.Value(), &u)
 		if err != nil {
-			log.Printf("GetUsers: Unable to decode user %q: %s", iter.Key(), err.Error())
+			log.WithError(err).WithField("key", string(iter.Key())).Warn("GetUsers: unable to decode user")
 		} else {
 			users = append(users, &u)
 		}
@@ -168,13 +168,13 @@ func (s *LevelDBStorage) TidyUsers() error {
 
 		if err != nil {
 			// Drop unreadable providers
-			log.Printf("Deleting unreadable user (%s): %v\n", err.Error(), user)
+			log.WithError(err).WithField("key", string(iter.Key())).Info("deleting unreadable user")
 			err = tx.Delete(iter.Key(), nil)
 		} else {
 			_, err = s.GetAuthUser(user.Id)
 			if err == leveldb.ErrNotFound {
 				// Drop providers of unexistant users
-				log.Printf("Deleting orphan user (authuser %s not found): %v\n", user.Id.String(), user)
+				log.WithField("user", user.Id.String()).Info("deleting orphan user: auth user not found")
 				err = tx.Delete(iter.Key(), nil)
 			}
 		}
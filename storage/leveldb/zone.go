@@ -33,11 +33,11 @@ package database
 
 import (
 	"fmt"
-	"log"
 	"strings"
 
 	"git.happydns.org/happydomain/model"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
@@ -129,7 +129,7 @@ func (s *LevelDBStorage) TidyZones() error {
 	for iter.Next() {
 		if zoneId, err := happydns.NewIdentifierFromString(strings.TrimPrefix(string(iter.Key()), "domain.zone-")); err != nil {
 			// Drop zones with invalid ID
-			log.Printf("Deleting unindentified zone: key=%s\n", iter.Key())
+			log.WithField("key", string(iter.Key())).Info("deleting unidentified zone")
 			err = tx.Delete(iter.Key(), nil)
 		} else {
 			foundZone := false
@@ -142,7 +142,7 @@ func (s *LevelDBStorage) TidyZones() error {
 
 			if !foundZone {
 				// Drop orphan zones
-				log.Printf("Deleting orphan zone: %s\n", zoneId.String())
+				log.WithField("zone", zoneId.String()).Info("deleting orphan zone")
 				err = tx.Delete(iter.Key(), nil)
 			}
 		}
@@ -0,0 +1,106 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+)
+
+func (s *MemoryStorage) GetAuthUsers() (users happydns.UserAuths, err error) {
+	for _, data := range s.list(tableAuthUsers) {
+		var u happydns.UserAuth
+
+		if err = decodeData(data, &u); err != nil {
+			return
+		}
+		users = append(users, &u)
+	}
+
+	return
+}
+
+func (s *MemoryStorage) GetAuthUser(id happydns.Identifier) (u *happydns.UserAuth, err error) {
+	u = &happydns.UserAuth{}
+	err = s.get(tableAuthUsers, id, u)
+	return
+}
+
+func (s *MemoryStorage) GetAuthUserByEmail(email string) (*happydns.UserAuth, error) {
+	users, err := s.GetAuthUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStorage) AuthUserExists(email string) bool {
+	_, err := s.GetAuthUserByEmail(email)
+	return err == nil
+}
+
+func (s *MemoryStorage) CreateAuthUser(u *happydns.UserAuth) error {
+	return s.insert(tableAuthUsers, u, func(id happydns.Identifier) { u.Id = id })
+}
+
+func (s *MemoryStorage) UpdateAuthUser(u *happydns.UserAuth) error {
+	return s.put(tableAuthUsers, u.Id, u)
+}
+
+func (s *MemoryStorage) DeleteAuthUser(u *happydns.UserAuth) error {
+	return s.delete(tableAuthUsers, u.Id)
+}
+
+func (s *MemoryStorage) ClearAuthUsers() error {
+	return s.clear(tableAuthUsers)
+}
+
+func (s *MemoryStorage) tidyAuthUsers() {
+	for key, data := range s.data[tableAuthUsers] {
+		var userAuth happydns.UserAuth
+		if err := decodeData(data, &userAuth); err != nil {
+			log.WithError(err).WithField("key", key).Info("deleting unreadable auth user")
+			delete(s.data[tableAuthUsers], key)
+		} else if _, ok := s.data[tableUsers][key]; !ok {
+			log.WithField("authuser", key).Info("deleting orphan auth user: user not found")
+			delete(s.data[tableAuthUsers], key)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"bytes"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+)
+
+func (s *MemoryStorage) GetCredentials(u *happydns.User) (credentials []*happydns.Credential, err error) {
+	for _, data := range s.list(tableCredentials) {
+		var c happydns.Credential
+
+		if err = decodeData(data, &c); err != nil {
+			return
+		}
+
+		if bytes.Equal(c.IdUser, u.Id) {
+			credentials = append(credentials, &c)
+		}
+	}
+
+	return
+}
+
+func (s *MemoryStorage) GetCredential(id happydns.Identifier) (c *happydns.Credential, err error) {
+	c = &happydns.Credential{}
+	err = s.get(tableCredentials, id, c)
+	return
+}
+
+func (s *MemoryStorage) CreateCredential(c *happydns.Credential) error {
+	return s.insert(tableCredentials, c, func(id happydns.Identifier) { c.Id = id })
+}
+
+func (s *MemoryStorage) UpdateCredential(c *happydns.Credential) error {
+	return s.put(tableCredentials, c.Id, c)
+}
+
+func (s *MemoryStorage) DeleteCredential(c *happydns.Credential) error {
+	return s.delete(tableCredentials, c.Id)
+}
+
+func (s *MemoryStorage) ClearCredentials() error {
+	return s.clear(tableCredentials)
+}
+
+func (s *MemoryStorage) tidyCredentials() {
+	for key, data := range s.data[tableCredentials] {
+		var c happydns.Credential
+		if err := decodeData(data, &c); err != nil {
+			log.WithError(err).WithField("key", key).Info("deleting unreadable credential")
+			delete(s.data[tableCredentials], key)
+		} else if _, ok := s.data[tableDomains][c.IdDomain.String()]; !ok {
+			log.WithField("credential", key).WithField("domain", c.IdDomain.String()).Info("deleting orphan credential: domain not found")
+			delete(s.data[tableCredentials], key)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database // import "happydns.org/storage/memory"
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"git.happydns.org/happydomain/model"
+)
+
+// ErrNotFound is returned when the requested item doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+const (
+	tableAuthUsers   = "auth_users"
+	tableUsers       = "users"
+	tableSessions    = "sessions"
+	tableProviders   = "providers"
+	tableDomains     = "domains"
+	tableCredentials = "credentials"
+	tableZones       = "zones"
+)
+
+var tables = []string{tableAuthUsers, tableUsers, tableSessions, tableProviders, tableDomains, tableCredentials, tableZones}
+
+// MemoryStorage keeps all data in memory, JSON encoded as other engines do
+// on disk, so that callers never share pointers with the store.
+type MemoryStorage struct {
+	mu   sync.RWMutex
+	data map[string]map[string][]byte
+}
+
+// NewMemoryStorage creates a new empty store.
+func NewMemoryStorage() *MemoryStorage {
+	s := &MemoryStorage{
+		data: map[string]map[string][]byte{},
+	}
+
+	for _, table := range tables {
+		s.data[table] = map[string][]byte{}
+	}
+
+	return s
+}
+
+func (s *MemoryStorage) DoMigration() error {
+	return nil
+}
+
+func (s *MemoryStorage) Tidy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, tidy := range []func(){s.tidySessions, s.tidyAuthUsers, s.tidyUsers, s.tidyProviders, s.tidyDomains, s.tidyCredentials, s.tidyZones} {
+		tidy()
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+func decodeData(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (s *MemoryStorage) get(table string, id happydns.Identifier, v interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.data[table][id.String()]
+	if !ok {
+		return ErrNotFound
+	}
+
+	return decodeData(data, v)
+}
+
+// list returns the raw content of the given table, ordered by key.
+func (s *MemoryStorage) list(table string) (values [][]byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.listLocked(table)
+}
+
+func (s *MemoryStorage) listLocked(table string) (values [][]byte) {
+	var keys []string
+	for k := range s.data[table] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		values = append(values, s.data[table][k])
+	}
+	return
+}
+
+func (s *MemoryStorage) put(table string, id happydns.Identifier, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[table][id.String()] = data
+	return nil
+}
+
+// insert stores v under a newly generated identifier, given to setId before
+// the item is encoded.
+func (s *MemoryStorage) insert(table string, v interface{}, setId func(happydns.Identifier)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var id happydns.Identifier
+	for found := true; found; {
+		var err error
+		id, err = happydns.NewRandomIdentifier()
+		if err != nil {
+			return err
+		}
+
+		_, found = s.data[table][id.String()]
+	}
+
+	setId(id)
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.data[table][id.String()] = data
+	return nil
+}
+
+func (s *MemoryStorage) delete(table string, id happydns.Identifier) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.data[table], id.String())
+	return nil
+}
+
+func (s *MemoryStorage) clear(tables ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, table := range tables {
+		s.data[table] = map[string][]byte{}
+	}
+	return nil
+}
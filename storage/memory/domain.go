@@ -0,0 +1,159 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"bytes"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+)
+
+func (s *MemoryStorage) GetDomains(u *happydns.User) (domains happydns.Domains, err error) {
+	for _, data := range s.list(tableDomains) {
+		var z happydns.Domain
+
+		if err = decodeData(data, &z); err != nil {
+			return
+		}
+
+		if bytes.Equal(z.IdUser, u.Id) {
+			domains = append(domains, &z)
+		}
+	}
+
+	return
+}
+
+func (s *MemoryStorage) GetDomain(u *happydns.User, id happydns.Identifier) (*happydns.Domain, error) {
+	z := &happydns.Domain{}
+	if err := s.get(tableDomains, id, z); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(z.IdUser, u.Id) {
+		return nil, ErrNotFound
+	}
+
+	return z, nil
+}
+
+func (s *MemoryStorage) GetDomainByDN(u *happydns.User, dn string) (*happydns.Domain, error) {
+	domains, err := s.GetDomains(u)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, domain := range domains {
+		if domain.DomainName == dn {
+			return domain, nil
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStorage) GetDomainByZone(id happydns.Identifier) (*happydns.Domain, error) {
+	for _, data := range s.list(tableDomains) {
+		var z happydns.Domain
+
+		if err := decodeData(data, &z); err != nil {
+			continue
+		}
+
+		for _, zid := range z.ZoneHistory {
+			if zid.Equals(id) {
+				return &z, nil
+			}
+		}
+	}
+
+	return nil, ErrNotFound
+}
+
+func (s *MemoryStorage) DomainExists(dn string) bool {
+	for _, data := range s.list(tableDomains) {
+		var z happydns.Domain
+
+		if err := decodeData(data, &z); err == nil && z.DomainName == dn {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *MemoryStorage) CreateDomain(u *happydns.User, z *happydns.Domain) error {
+	z.IdUser = u.Id
+	return s.insert(tableDomains, z, func(id happydns.Identifier) { z.Id = id })
+}
+
+func (s *MemoryStorage) UpdateDomain(z *happydns.Domain) error {
+	return s.put(tableDomains, z.Id, z)
+}
+
+func (s *MemoryStorage) UpdateDomainOwner(z *happydns.Domain, newOwner *happydns.User) error {
+	z.IdUser = newOwner.Id
+	return s.UpdateDomain(z)
+}
+
+func (s *MemoryStorage) DeleteDomain(z *happydns.Domain) error {
+	return s.delete(tableDomains, z.Id)
+}
+
+func (s *MemoryStorage) ClearDomains() error {
+	return s.clear(tableZones, tableDomains)
+}
+
+func (s *MemoryStorage) tidyDomains() {
+	for key, data := range s.data[tableDomains] {
+		var domain happydns.Domain
+		if err := decodeData(data, &domain); err != nil {
+			log.WithError(err).WithField("key", key).Info("deleting unreadable domain")
+			delete(s.data[tableDomains], key)
+			continue
+		}
+
+		if _, ok := s.data[tableUsers][domain.IdUser.String()]; !ok {
+			log.WithField("domain", key).WithField("user", domain.IdUser.String()).Info("deleting orphan domain: user not found")
+			delete(s.data[tableDomains], key)
+			continue
+		}
+
+		var srcMeta happydns.ProviderMeta
+		if data, ok := s.data[tableProviders][domain.IdProvider.String()]; !ok || decodeData(data, &srcMeta) != nil || !bytes.Equal(srcMeta.OwnerId, domain.IdUser) {
+			log.WithField("domain", key).WithField("provider", domain.IdProvider.String()).Info("deleting orphan domain: provider not found")
+			delete(s.data[tableDomains], key)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"git.happydns.org/happydomain/model"
+)
+
+// Fixture describes the content of a file used to seed the store. Items are
+// kept raw: they are stored as is, only their identifier is extracted.
+type Fixture struct {
+	AuthUsers   []json.RawMessage `json:"auth_users,omitempty"`
+	Users       []json.RawMessage `json:"users,omitempty"`
+	Sessions    []json.RawMessage `json:"sessions,omitempty"`
+	Providers   []json.RawMessage `json:"providers,omitempty"`
+	Domains     []json.RawMessage `json:"domains,omitempty"`
+	Credentials []json.RawMessage `json:"credentials,omitempty"`
+	Zones       []json.RawMessage `json:"zones,omitempty"`
+}
+
+// fixtureItem extracts the identifier of any stored item: providers use the
+// `_id` key, others `id`.
+type fixtureItem struct {
+	Id         happydns.Identifier `json:"id"`
+	ProviderId happydns.Identifier `json:"_id"`
+}
+
+// LoadFixture adds to the store the items read from the given JSON fixture.
+func (s *MemoryStorage) LoadFixture(r io.Reader) error {
+	var f Fixture
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return fmt.Errorf("unable to decode fixture: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for table, items := range map[string][]json.RawMessage{
+		tableAuthUsers:   f.AuthUsers,
+		tableUsers:       f.Users,
+		tableSessions:    f.Sessions,
+		tableProviders:   f.Providers,
+		tableDomains:     f.Domains,
+		tableCredentials: f.Credentials,
+		tableZones:       f.Zones,
+	} {
+		for i, item := range items {
+			var fi fixtureItem
+			if err := json.Unmarshal(item, &fi); err != nil {
+				return fmt.Errorf("invalid item %d in %s: %w", i, table, err)
+			}
+
+			id := fi.Id
+			if table == tableProviders {
+				id = fi.ProviderId
+			}
+			if len(id) == 0 {
+				return fmt.Errorf("item %d in %s has no identifier", i, table)
+			}
+
+			s.data[table][id.String()] = []byte(item)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"bytes"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/providers"
+)
+
+func (s *MemoryStorage) GetProviderMetas(u *happydns.User) (srcs []happydns.ProviderMeta, err error) {
+	for _, data := range s.list(tableProviders) {
+		var srcMeta happydns.ProviderMeta
+
+		if err = decodeData(data, &srcMeta); err != nil {
+			return
+		}
+
+		if bytes.Equal(srcMeta.OwnerId, u.Id) {
+			srcs = append(srcs, srcMeta)
+		}
+	}
+
+	return
+}
+
+func (s *MemoryStorage) GetProviderMeta(u *happydns.User, id happydns.Identifier) (*happydns.ProviderMeta, error) {
+	srcMeta := &happydns.ProviderMeta{}
+	if err := s.get(tableProviders, id, srcMeta); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(srcMeta.OwnerId, u.Id) {
+		return nil, ErrNotFound
+	}
+
+	return srcMeta, nil
+}
+
+func (s *MemoryStorage) GetProvider(u *happydns.User, id happydns.Identifier) (*happydns.ProviderCombined, error) {
+	srcMeta, err := s.GetProviderMeta(u, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tsrc, err := providers.FindProvider(srcMeta.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &happydns.ProviderCombined{
+		Provider:     tsrc,
+		ProviderMeta: *srcMeta,
+	}
+
+	return src, s.get(tableProviders, id, src)
+}
+
+func (s *MemoryStorage) CreateProvider(u *happydns.User, src happydns.Provider, comment string) (*happydns.ProviderCombined, error) {
+	sType := reflect.Indirect(reflect.ValueOf(src)).Type()
+
+	st := &happydns.ProviderCombined{
+		Provider: src,
+		ProviderMeta: happydns.ProviderMeta{
+			Type:    sType.Name(),
+			OwnerId: u.Id,
+			Comment: comment,
+		},
+	}
+
+	return st, s.insert(tableProviders, st, func(id happydns.Identifier) { st.Id = id })
+}
+
+func (s *MemoryStorage) UpdateProvider(src *happydns.ProviderCombined) error {
+	return s.put(tableProviders, src.Id, src)
+}
+
+func (s *MemoryStorage) UpdateProviderOwner(src *happydns.ProviderCombined, newOwner *happydns.User) error {
+	src.OwnerId = newOwner.Id
+	return s.UpdateProvider(src)
+}
+
+func (s *MemoryStorage) DeleteProvider(src *happydns.ProviderMeta) error {
+	return s.delete(tableProviders, src.Id)
+}
+
+func (s *MemoryStorage) ClearProviders() error {
+	return s.clear(tableProviders)
+}
+
+func (s *MemoryStorage) tidyProviders() {
+	for key, data := range s.data[tableProviders] {
+		var srcMeta happydns.ProviderMeta
+		if err := decodeData(data, &srcMeta); err != nil {
+			log.WithError(err).WithField("key", key).Info("deleting unreadable provider")
+			delete(s.data[tableProviders], key)
+		} else if _, ok := s.data[tableUsers][srcMeta.OwnerId.String()]; !ok {
+			log.WithField("provider", key).WithField("user", srcMeta.OwnerId.String()).Info("deleting orphan provider: user not found")
+			delete(s.data[tableProviders], key)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"bytes"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+)
+
+func (s *MemoryStorage) GetSession(id happydns.Identifier) (session *happydns.Session, err error) {
+	session = &happydns.Session{}
+	err = s.get(tableSessions, id, session)
+	return
+}
+
+func (s *MemoryStorage) getSessions(owner happydns.Identifier) (sessions []*happydns.Session, err error) {
+	for _, data := range s.list(tableSessions) {
+		var session happydns.Session
+
+		if err = decodeData(data, &session); err != nil {
+			return
+		}
+
+		if bytes.Equal(session.IdUser, owner) {
+			sessions = append(sessions, &session)
+		}
+	}
+
+	return
+}
+
+func (s *MemoryStorage) GetAuthUserSessions(user *happydns.UserAuth) ([]*happydns.Session, error) {
+	return s.getSessions(user.Id)
+}
+
+func (s *MemoryStorage) GetUserSessions(user *happydns.User) ([]*happydns.Session, error) {
+	return s.getSessions(user.Id)
+}
+
+func (s *MemoryStorage) CreateSession(session *happydns.Session) error {
+	return s.insert(tableSessions, session, func(id happydns.Identifier) { session.Id = id })
+}
+
+func (s *MemoryStorage) UpdateSession(session *happydns.Session) error {
+	return s.put(tableSessions, session.Id, session)
+}
+
+func (s *MemoryStorage) DeleteSession(session *happydns.Session) error {
+	return s.delete(tableSessions, session.Id)
+}
+
+func (s *MemoryStorage) ClearSessions() error {
+	return s.clear(tableSessions)
+}
+
+func (s *MemoryStorage) tidySessions() {
+	for key, data := range s.data[tableSessions] {
+		var session happydns.Session
+		if err := decodeData(data, &session); err != nil {
+			log.WithError(err).WithField("key", key).Info("deleting unreadable session")
+			delete(s.data[tableSessions], key)
+		} else if _, ok := s.data[tableUsers][session.IdUser.String()]; !ok {
+			log.WithField("key", key).WithField("user", session.IdUser.String()).Info("deleting orphan session: user not found")
+			delete(s.data[tableSessions], key)
+		}
+	}
+}
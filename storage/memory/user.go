@@ -0,0 +1,103 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+)
+
+func (s *MemoryStorage) GetUsers() (users happydns.Users, err error) {
+	for _, data := range s.list(tableUsers) {
+		var u happydns.User
+
+		if err = decodeData(data, &u); err != nil {
+			return
+		}
+		users = append(users, &u)
+	}
+
+	return
+}
+
+func (s *MemoryStorage) GetUser(id happydns.Identifier) (u *happydns.User, err error) {
+	u = &happydns.User{}
+	err = s.get(tableUsers, id, u)
+	return
+}
+
+func (s *MemoryStorage) GetUserByEmail(email string) (*happydns.User, error) {
+	users, err := s.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unable to find user with email address '%s'.", email)
+}
+
+func (s *MemoryStorage) CreateUser(u *happydns.User) error {
+	return s.insert(tableUsers, u, func(id happydns.Identifier) { u.Id = id })
+}
+
+func (s *MemoryStorage) UpdateUser(u *happydns.User) error {
+	return s.put(tableUsers, u.Id, u)
+}
+
+func (s *MemoryStorage) DeleteUser(u *happydns.User) error {
+	return s.delete(tableUsers, u.Id)
+}
+
+func (s *MemoryStorage) ClearUsers() error {
+	return s.clear(tableSessions, tableUsers)
+}
+
+func (s *MemoryStorage) tidyUsers() {
+	for key, data := range s.data[tableUsers] {
+		var user happydns.User
+		if err := decodeData(data, &user); err != nil {
+			log.WithError(err).WithField("key", key).Info("deleting unreadable user")
+			delete(s.data[tableUsers], key)
+		} else if _, ok := s.data[tableAuthUsers][key]; !ok {
+			log.WithField("user", key).Info("deleting orphan user: auth user not found")
+			delete(s.data[tableUsers], key)
+		}
+	}
+}
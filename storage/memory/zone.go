@@ -0,0 +1,85 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+)
+
+func (s *MemoryStorage) GetZoneMeta(id happydns.Identifier) (z *happydns.ZoneMeta, err error) {
+	z = &happydns.ZoneMeta{}
+	err = s.get(tableZones, id, z)
+	return
+}
+
+func (s *MemoryStorage) GetZone(id happydns.Identifier) (z *happydns.Zone, err error) {
+	z = &happydns.Zone{}
+	err = s.get(tableZones, id, z)
+	return
+}
+
+func (s *MemoryStorage) CreateZone(z *happydns.Zone) error {
+	return s.insert(tableZones, z, func(id happydns.Identifier) { z.Id = id })
+}
+
+func (s *MemoryStorage) UpdateZone(z *happydns.Zone) error {
+	return s.put(tableZones, z.Id, z)
+}
+
+func (s *MemoryStorage) DeleteZone(z *happydns.Zone) error {
+	return s.delete(tableZones, z.Id)
+}
+
+func (s *MemoryStorage) ClearZones() error {
+	return s.clear(tableZones)
+}
+
+func (s *MemoryStorage) tidyZones() {
+	referencedZones := map[string]bool{}
+	for _, data := range s.data[tableDomains] {
+		var domain happydns.Domain
+		if err := decodeData(data, &domain); err == nil {
+			for _, zh := range domain.ZoneHistory {
+				referencedZones[zh.String()] = true
+			}
+		}
+	}
+
+	for key := range s.data[tableZones] {
+		if !referencedZones[key] {
+			log.WithField("zone", key).Info("deleting orphan zone")
+			delete(s.data[tableZones], key)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"database/sql"
+
+	"git.happydns.org/happydomain/model"
+)
+
+const authUserFields = "id_auth, email, email_verification, password, password_recovery_key, created_at, last_logged_in, allow_commercials"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAuthUser(row rowScanner) (u *happydns.UserAuth, err error) {
+	u = &happydns.UserAuth{}
+	err = row.Scan(&u.Id, &u.Email, &u.EmailVerification, &u.Password, &u.PasswordRecoveryKey, &u.CreatedAt, &u.LastLoggedIn, &u.AllowCommercials)
+	return
+}
+
+func (s *PostgreSQLStorage) GetAuthUsers() (users happydns.UserAuths, err error) {
+	var rows *sql.Rows
+	rows, err = s.db.Query("SELECT " + authUserFields + " FROM auth_users")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u *happydns.UserAuth
+		if u, err = scanAuthUser(rows); err != nil {
+			return
+		}
+		users = append(users, u)
+	}
+	err = rows.Err()
+
+	return
+}
+
+func (s *PostgreSQLStorage) GetAuthUser(id happydns.Identifier) (*happydns.UserAuth, error) {
+	return scanAuthUser(s.db.QueryRow("SELECT "+authUserFields+" FROM auth_users WHERE id_auth = $1", []byte(id)))
+}
+
+func (s *PostgreSQLStorage) GetAuthUserByEmail(email string) (*happydns.UserAuth, error) {
+	return scanAuthUser(s.db.QueryRow("SELECT "+authUserFields+" FROM auth_users WHERE email = $1", email))
+}
+
+func (s *PostgreSQLStorage) AuthUserExists(email string) bool {
+	var found bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM auth_users WHERE email = $1)", email).Scan(&found)
+	return err == nil && found
+}
+
+func (s *PostgreSQLStorage) CreateAuthUser(u *happydns.UserAuth) error {
+	id, err := s.newIdentifier("auth_users", "id_auth")
+	if err != nil {
+		return err
+	}
+
+	u.Id = id
+	return s.UpdateAuthUser(u)
+}
+
+func (s *PostgreSQLStorage) UpdateAuthUser(u *happydns.UserAuth) error {
+	_, err := s.db.Exec(`INSERT INTO auth_users (`+authUserFields+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (id_auth) DO UPDATE SET email = EXCLUDED.email, email_verification = EXCLUDED.email_verification, password = EXCLUDED.password, password_recovery_key = EXCLUDED.password_recovery_key, created_at = EXCLUDED.created_at, last_logged_in = EXCLUDED.last_logged_in, allow_commercials = EXCLUDED.allow_commercials`,
+		[]byte(u.Id), u.Email, u.EmailVerification, u.Password, u.PasswordRecoveryKey, u.CreatedAt, u.LastLoggedIn, u.AllowCommercials)
+	return err
+}
+
+func (s *PostgreSQLStorage) DeleteAuthUser(u *happydns.UserAuth) error {
+	_, err := s.db.Exec("DELETE FROM auth_users WHERE id_auth = $1", []byte(u.Id))
+	return err
+}
+
+func (s *PostgreSQLStorage) ClearAuthUsers() error {
+	return s.clearTable("auth_users")
+}
+
+func (s *PostgreSQLStorage) TidyAuthUsers() error {
+	_, err := s.db.Exec("DELETE FROM auth_users WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id_user = auth_users.id_auth)")
+	return err
+}
@@ -0,0 +1,127 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"git.happydns.org/happydomain/model"
+)
+
+const credentialFields = "id_credential, id_owner, id_domain, scope, subdomain, comment, secret_hash, created_at, last_used, challenges"
+
+func scanCredential(row rowScanner) (c *happydns.Credential, err error) {
+	var lastUsed sql.NullTime
+	var challenges []byte
+
+	c = &happydns.Credential{}
+	if err = row.Scan(&c.Id, &c.IdUser, &c.IdDomain, &c.Scope, &c.Subdomain, &c.Comment, &c.SecretHash, &c.CreatedAt, &lastUsed, &challenges); err != nil {
+		return
+	}
+
+	if lastUsed.Valid {
+		c.LastUsed = &lastUsed.Time
+	}
+
+	err = json.Unmarshal(challenges, &c.Challenges)
+	return
+}
+
+func (s *PostgreSQLStorage) GetCredentials(u *happydns.User) (credentials []*happydns.Credential, err error) {
+	var rows *sql.Rows
+	rows, err = s.db.Query("SELECT "+credentialFields+" FROM credentials WHERE id_owner = $1", []byte(u.Id))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c *happydns.Credential
+		if c, err = scanCredential(rows); err != nil {
+			return
+		}
+		credentials = append(credentials, c)
+	}
+	err = rows.Err()
+
+	return
+}
+
+func (s *PostgreSQLStorage) GetCredential(id happydns.Identifier) (*happydns.Credential, error) {
+	return scanCredential(s.db.QueryRow("SELECT "+credentialFields+" FROM credentials WHERE id_credential = $1", []byte(id)))
+}
+
+func (s *PostgreSQLStorage) CreateCredential(c *happydns.Credential) error {
+	id, err := s.newIdentifier("credentials", "id_credential")
+	if err != nil {
+		return err
+	}
+
+	c.Id = id
+	return s.UpdateCredential(c)
+}
+
+func (s *PostgreSQLStorage) UpdateCredential(c *happydns.Credential) error {
+	var lastUsed sql.NullTime
+	if c.LastUsed != nil {
+		lastUsed = sql.NullTime{Time: *c.LastUsed, Valid: true}
+	}
+
+	challenges := []byte("[]")
+	if len(c.Challenges) > 0 {
+		var err error
+		if challenges, err = json.Marshal(c.Challenges); err != nil {
+			return err
+		}
+	}
+
+	_, err := s.db.Exec(`INSERT INTO credentials (`+credentialFields+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (id_credential) DO UPDATE SET id_owner = EXCLUDED.id_owner, id_domain = EXCLUDED.id_domain, scope = EXCLUDED.scope, subdomain = EXCLUDED.subdomain, comment = EXCLUDED.comment, secret_hash = EXCLUDED.secret_hash, created_at = EXCLUDED.created_at, last_used = EXCLUDED.last_used, challenges = EXCLUDED.challenges`,
+		[]byte(c.Id), []byte(c.IdUser), []byte(c.IdDomain), string(c.Scope), c.Subdomain, c.Comment, c.SecretHash, c.CreatedAt, lastUsed, string(challenges))
+	return err
+}
+
+func (s *PostgreSQLStorage) DeleteCredential(c *happydns.Credential) error {
+	_, err := s.db.Exec("DELETE FROM credentials WHERE id_credential = $1", []byte(c.Id))
+	return err
+}
+
+func (s *PostgreSQLStorage) ClearCredentials() error {
+	return s.clearTable("credentials")
+}
+
+func (s *PostgreSQLStorage) TidyCredentials() error {
+	// Drop credentials of unexistant domains
+	_, err := s.db.Exec("DELETE FROM credentials WHERE NOT EXISTS (SELECT 1 FROM domains WHERE domains.id_domain = credentials.id_domain)")
+	return err
+}
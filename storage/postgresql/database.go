@@ -0,0 +1,210 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database // import "happydns.org/storage/postgresql"
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+
+	"git.happydns.org/happydomain/model"
+)
+
+// pqUndefinedTable is the error code returned when querying a table that
+// does not exist.
+const pqUndefinedTable = "42P01"
+
+//go:embed schemas/*.sql
+var schemas embed.FS
+
+type PostgreSQLStorage struct {
+	db *sql.DB
+}
+
+// NewPostgreSQLStorage establishes the connection to the database
+func NewPostgreSQLStorage(dsn string) (*PostgreSQLStorage, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	for i := 0; err != nil && i < 45; i += 1 {
+		log.Println("An error occurs when trying to connect to DB, will retry in 2 seconds: ", err)
+		time.Sleep(2 * time.Second)
+		err = db.Ping()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &PostgreSQLStorage{db}, nil
+}
+
+// schemaRevisions reads the embedded migration chain, indexed by revision
+// number.
+func schemaRevisions() (revisions map[uint16]string, latest uint16, err error) {
+	files, err := schemas.ReadDir("schemas")
+	if err != nil {
+		return
+	}
+
+	revisions = map[uint16]string{}
+	for _, file := range files {
+		var v uint64
+		v, err = strconv.ParseUint(strings.TrimSuffix(file.Name(), ".sql"), 10, 16)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid schema file name %q: %w", file.Name(), err)
+		}
+
+		var content []byte
+		content, err = schemas.ReadFile(path.Join("schemas", file.Name()))
+		if err != nil {
+			return
+		}
+
+		revisions[uint16(v)] = string(content)
+		if uint16(v) > latest {
+			latest = uint16(v)
+		}
+	}
+
+	return
+}
+
+func (s *PostgreSQLStorage) DoMigration() error {
+	revisions, schemaVersion, err := schemaRevisions()
+	if err != nil {
+		return err
+	}
+
+	var currentVersion uint16
+	err = s.db.QueryRow(`SELECT version FROM schema_version`).Scan(&currentVersion)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == pqUndefinedTable {
+		// Fresh database: schema_version is created by the first revision
+		currentVersion = 0
+	} else if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("unable to retrieve the schema version: %w", err)
+	}
+
+	log.Println("Current schema version:", currentVersion)
+	log.Println("Latest schema version:", schemaVersion)
+
+	if currentVersion > schemaVersion {
+		return fmt.Errorf("Your database has revision %d, which is newer than the revision this happyDomain version can handle (max DB revision %d). Please update happyDomain", currentVersion, schemaVersion)
+	}
+
+	var versions []int
+	for version := range revisions {
+		if version > currentVersion {
+			versions = append(versions, int(version))
+		}
+	}
+	sort.Ints(versions)
+
+	for _, version := range versions {
+		log.Println("Migrating to version:", version)
+
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(revisions[uint16(version)]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("unable to migrate to version %d: %w", version, err)
+		}
+
+		if _, err := tx.Exec(`DELETE FROM schema_version`); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := tx.Exec(`INSERT INTO schema_version (version) VALUES ($1)`, version); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *PostgreSQLStorage) Tidy() error {
+	for _, tidy := range []func() error{s.TidySessions, s.TidyAuthUsers, s.TidyUsers, s.TidyProviders, s.TidyDomains, s.TidyCredentials, s.TidyZones} {
+		if err := tidy(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *PostgreSQLStorage) Close() error {
+	return s.db.Close()
+}
+
+// newIdentifier generates a random identifier that is not already used in
+// the given column of the given table.
+func (s *PostgreSQLStorage) newIdentifier(table, column string) (id happydns.Identifier, err error) {
+	found := true
+	for found {
+		id, err = happydns.NewRandomIdentifier()
+		if err != nil {
+			return
+		}
+
+		err = s.db.QueryRow(fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE %s = $1)", table, column), []byte(id)).Scan(&found)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// clearTable deletes all records of the given table.
+func (s *PostgreSQLStorage) clearTable(table string) error {
+	_, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s", table))
+	return err
+}
@@ -0,0 +1,149 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"database/sql"
+
+	"github.com/lib/pq"
+
+	"git.happydns.org/happydomain/model"
+)
+
+const domainFields = "id_domain, id_owner, id_provider, domain, domain_group, zone_history, serial_policy, impersonator"
+
+func scanDomain(row rowScanner) (domain *happydns.Domain, err error) {
+	var history pq.ByteaArray
+
+	domain = &happydns.Domain{}
+	if err = row.Scan(&domain.Id, &domain.IdUser, &domain.IdProvider, &domain.DomainName, &domain.Group, &history, &domain.SerialPolicy, &domain.Impersonator); err != nil {
+		return
+	}
+
+	for _, zid := range history {
+		domain.ZoneHistory = append(domain.ZoneHistory, happydns.Identifier(zid))
+	}
+	return
+}
+
+func (s *PostgreSQLStorage) GetDomains(u *happydns.User) (domains happydns.Domains, err error) {
+	var rows *sql.Rows
+	rows, err = s.db.Query("SELECT "+domainFields+" FROM domains WHERE id_owner = $1", []byte(u.Id))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var domain *happydns.Domain
+		if domain, err = scanDomain(rows); err != nil {
+			return
+		}
+		domains = append(domains, domain)
+	}
+	err = rows.Err()
+
+	return
+}
+
+func (s *PostgreSQLStorage) GetDomain(u *happydns.User, id happydns.Identifier) (*happydns.Domain, error) {
+	return scanDomain(s.db.QueryRow("SELECT "+domainFields+" FROM domains WHERE id_domain = $1 AND id_owner = $2", []byte(id), []byte(u.Id)))
+}
+
+func (s *PostgreSQLStorage) GetDomainByDN(u *happydns.User, dn string) (*happydns.Domain, error) {
+	return scanDomain(s.db.QueryRow("SELECT "+domainFields+" FROM domains WHERE domain = $1 AND id_owner = $2 LIMIT 1", dn, []byte(u.Id)))
+}
+
+func (s *PostgreSQLStorage) GetDomainByZone(id happydns.Identifier) (*happydns.Domain, error) {
+	return scanDomain(s.db.QueryRow("SELECT "+domainFields+" FROM domains WHERE zone_history @> ARRAY[$1::BYTEA] LIMIT 1", []byte(id)))
+}
+
+func (s *PostgreSQLStorage) DomainExists(dn string) bool {
+	var found bool
+	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM domains WHERE domain = $1)", dn).Scan(&found); err != nil {
+		return false
+	}
+	return found
+}
+
+func (s *PostgreSQLStorage) CreateDomain(u *happydns.User, z *happydns.Domain) error {
+	id, err := s.newIdentifier("domains", "id_domain")
+	if err != nil {
+		return err
+	}
+
+	z.Id = id
+	z.IdUser = u.Id
+	return s.UpdateDomain(z)
+}
+
+func (s *PostgreSQLStorage) UpdateDomain(z *happydns.Domain) error {
+	history := pq.ByteaArray{}
+	for _, zid := range z.ZoneHistory {
+		history = append(history, []byte(zid))
+	}
+
+	_, err := s.db.Exec(`INSERT INTO domains (`+domainFields+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (id_domain) DO UPDATE SET id_owner = EXCLUDED.id_owner, id_provider = EXCLUDED.id_provider, domain = EXCLUDED.domain, domain_group = EXCLUDED.domain_group, zone_history = EXCLUDED.zone_history, serial_policy = EXCLUDED.serial_policy, impersonator = EXCLUDED.impersonator`,
+		[]byte(z.Id), []byte(z.IdUser), []byte(z.IdProvider), z.DomainName, z.Group, history, z.SerialPolicy, z.Impersonator)
+	return err
+}
+
+func (s *PostgreSQLStorage) UpdateDomainOwner(z *happydns.Domain, newOwner *happydns.User) error {
+	if _, err := s.db.Exec("UPDATE domains SET id_owner = $1 WHERE id_domain = $2", []byte(newOwner.Id), []byte(z.Id)); err != nil {
+		return err
+	}
+
+	z.IdUser = newOwner.Id
+	return nil
+}
+
+func (s *PostgreSQLStorage) DeleteDomain(z *happydns.Domain) error {
+	_, err := s.db.Exec("DELETE FROM domains WHERE id_domain = $1", []byte(z.Id))
+	return err
+}
+
+func (s *PostgreSQLStorage) ClearDomains() error {
+	if err := s.ClearZones(); err != nil {
+		return err
+	}
+
+	return s.clearTable("domains")
+}
+
+func (s *PostgreSQLStorage) TidyDomains() error {
+	// Drop domains of unexistant users or providers
+	_, err := s.db.Exec(`DELETE FROM domains WHERE
+  NOT EXISTS (SELECT 1 FROM users WHERE users.id_user = domains.id_owner)
+  OR NOT EXISTS (SELECT 1 FROM providers WHERE providers.id_provider = domains.id_provider AND providers.id_owner = domains.id_owner)`)
+	return err
+}
@@ -0,0 +1,152 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/providers"
+)
+
+const providerMetaFields = "id_provider, id_owner, type, comment"
+
+func scanProviderMeta(row rowScanner, extra ...interface{}) (p *happydns.ProviderMeta, err error) {
+	p = &happydns.ProviderMeta{}
+	err = row.Scan(append([]interface{}{&p.Id, &p.OwnerId, &p.Type, &p.Comment}, extra...)...)
+	return
+}
+
+func (s *PostgreSQLStorage) GetProviderMetas(u *happydns.User) (srcs []happydns.ProviderMeta, err error) {
+	var rows *sql.Rows
+	rows, err = s.db.Query("SELECT "+providerMetaFields+" FROM providers WHERE id_owner = $1", []byte(u.Id))
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p *happydns.ProviderMeta
+		if p, err = scanProviderMeta(rows); err != nil {
+			return
+		}
+		srcs = append(srcs, *p)
+	}
+	err = rows.Err()
+
+	return
+}
+
+func (s *PostgreSQLStorage) GetProviderMeta(u *happydns.User, id happydns.Identifier) (*happydns.ProviderMeta, error) {
+	return scanProviderMeta(s.db.QueryRow("SELECT "+providerMetaFields+" FROM providers WHERE id_provider = $1 AND id_owner = $2", []byte(id), []byte(u.Id)))
+}
+
+func (s *PostgreSQLStorage) GetProvider(u *happydns.User, id happydns.Identifier) (*happydns.ProviderCombined, error) {
+	var config []byte
+
+	meta, err := scanProviderMeta(s.db.QueryRow("SELECT "+providerMetaFields+", config FROM providers WHERE id_provider = $1 AND id_owner = $2", []byte(id), []byte(u.Id)), &config)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := providers.FindProvider(meta.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(config, p); err != nil {
+		return nil, err
+	}
+
+	return &happydns.ProviderCombined{
+		Provider:     p,
+		ProviderMeta: *meta,
+	}, nil
+}
+
+func (s *PostgreSQLStorage) CreateProvider(u *happydns.User, src happydns.Provider, comment string) (*happydns.ProviderCombined, error) {
+	id, err := s.newIdentifier("providers", "id_provider")
+	if err != nil {
+		return nil, err
+	}
+
+	sType := reflect.Indirect(reflect.ValueOf(src)).Type()
+
+	p := &happydns.ProviderCombined{
+		Provider: src,
+		ProviderMeta: happydns.ProviderMeta{
+			Type:    sType.Name(),
+			Id:      id,
+			OwnerId: u.Id,
+			Comment: comment,
+		},
+	}
+
+	return p, s.UpdateProvider(p)
+}
+
+func (s *PostgreSQLStorage) UpdateProvider(src *happydns.ProviderCombined) error {
+	config, err := json.Marshal(src.Provider)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO providers (`+providerMetaFields+`, config) VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id_provider) DO UPDATE SET id_owner = EXCLUDED.id_owner, type = EXCLUDED.type, comment = EXCLUDED.comment, config = EXCLUDED.config`,
+		[]byte(src.Id), []byte(src.OwnerId), src.Type, src.Comment, string(config))
+	return err
+}
+
+func (s *PostgreSQLStorage) UpdateProviderOwner(src *happydns.ProviderCombined, newOwner *happydns.User) error {
+	if _, err := s.db.Exec("UPDATE providers SET id_owner = $1 WHERE id_provider = $2", []byte(newOwner.Id), []byte(src.Id)); err != nil {
+		return err
+	}
+
+	src.OwnerId = newOwner.Id
+	return nil
+}
+
+func (s *PostgreSQLStorage) DeleteProvider(src *happydns.ProviderMeta) error {
+	_, err := s.db.Exec("DELETE FROM providers WHERE id_provider = $1", []byte(src.Id))
+	return err
+}
+
+func (s *PostgreSQLStorage) ClearProviders() error {
+	return s.clearTable("providers")
+}
+
+func (s *PostgreSQLStorage) TidyProviders() error {
+	_, err := s.db.Exec("DELETE FROM providers WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id_user = providers.id_owner)")
+	return err
+}
@@ -0,0 +1,73 @@
+CREATE TABLE schema_version (
+  version SMALLINT NOT NULL
+);
+
+CREATE TABLE auth_users (
+  id_auth BYTEA NOT NULL PRIMARY KEY,
+  email VARCHAR(255) NOT NULL UNIQUE,
+  email_verification TIMESTAMP WITH TIME ZONE,
+  password BYTEA,
+  password_recovery_key BYTEA,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  last_logged_in TIMESTAMP WITH TIME ZONE,
+  allow_commercials BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE users (
+  id_user BYTEA NOT NULL PRIMARY KEY,
+  email VARCHAR(255) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  last_seen TIMESTAMP WITH TIME ZONE NOT NULL,
+  settings JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX users_email_idx ON users (email);
+
+CREATE TABLE user_sessions (
+  id_session BYTEA NOT NULL PRIMARY KEY,
+  id_user BYTEA NOT NULL,
+  issued_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  content JSONB
+);
+
+CREATE INDEX user_sessions_user_idx ON user_sessions (id_user);
+
+CREATE TABLE providers (
+  id_provider BYTEA NOT NULL PRIMARY KEY,
+  id_owner BYTEA NOT NULL,
+  type VARCHAR(255) NOT NULL,
+  comment TEXT NOT NULL DEFAULT '',
+  config JSONB NOT NULL
+);
+
+CREATE INDEX providers_owner_idx ON providers (id_owner);
+
+CREATE TABLE domains (
+  id_domain BYTEA NOT NULL PRIMARY KEY,
+  id_owner BYTEA NOT NULL,
+  id_provider BYTEA NOT NULL,
+  domain VARCHAR(255) NOT NULL,
+  domain_group VARCHAR(255) NOT NULL DEFAULT '',
+  zone_history BYTEA[] NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX domains_owner_idx ON domains (id_owner);
+CREATE INDEX domains_domain_idx ON domains (domain);
+
+CREATE TABLE zones (
+  id_zone BYTEA NOT NULL PRIMARY KEY,
+  id_author BYTEA,
+  default_ttl BIGINT NOT NULL DEFAULT 0,
+  last_modified TIMESTAMP WITH TIME ZONE,
+  commit_message TEXT,
+  commit_date TIMESTAMP WITH TIME ZONE,
+  published TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE zone_services (
+  id_zone BYTEA NOT NULL REFERENCES zones(id_zone) ON DELETE CASCADE,
+  subdomain VARCHAR(255) NOT NULL,
+  position INTEGER NOT NULL,
+  service JSONB NOT NULL,
+  PRIMARY KEY (id_zone, subdomain, position)
+);
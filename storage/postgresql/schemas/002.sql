@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN quota JSONB NOT NULL DEFAULT '{}';
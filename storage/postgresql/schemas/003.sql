@@ -0,0 +1,13 @@
+CREATE TABLE credentials (
+  id_credential BYTEA NOT NULL PRIMARY KEY,
+  id_owner BYTEA NOT NULL,
+  id_domain BYTEA NOT NULL,
+  scope VARCHAR(32) NOT NULL,
+  subdomain VARCHAR(255) NOT NULL DEFAULT '',
+  comment TEXT NOT NULL DEFAULT '',
+  secret_hash BYTEA NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  last_used TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX credentials_owner_idx ON credentials (id_owner);
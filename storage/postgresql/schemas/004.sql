@@ -0,0 +1 @@
+ALTER TABLE credentials ADD COLUMN challenges JSONB NOT NULL DEFAULT '[]';
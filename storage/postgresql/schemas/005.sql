@@ -0,0 +1 @@
+ALTER TABLE domains ADD COLUMN serial_policy VARCHAR(16) NOT NULL DEFAULT '';
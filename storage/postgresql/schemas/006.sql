@@ -0,0 +1 @@
+ALTER TABLE zones ADD COLUMN ttl_plan JSONB;
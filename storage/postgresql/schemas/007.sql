@@ -0,0 +1,2 @@
+ALTER TABLE domains ADD COLUMN impersonator VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE zones ADD COLUMN impersonator VARCHAR(255) NOT NULL DEFAULT '';
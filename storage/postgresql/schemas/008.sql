@@ -0,0 +1 @@
+CREATE INDEX domains_zone_history_idx ON domains USING GIN (zone_history);
@@ -0,0 +1,128 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"git.happydns.org/happydomain/model"
+)
+
+const sessionFields = "id_session, id_user, issued_at, content"
+
+func scanSession(row rowScanner) (session *happydns.Session, err error) {
+	var content []byte
+
+	session = &happydns.Session{}
+	if err = row.Scan(&session.Id, &session.IdUser, &session.IssuedAt, &content); err != nil {
+		return
+	}
+
+	if content != nil {
+		err = json.Unmarshal(content, &session.Content)
+	}
+	return
+}
+
+func (s *PostgreSQLStorage) getSessions(query string, args ...interface{}) (sessions []*happydns.Session, err error) {
+	var rows *sql.Rows
+	rows, err = s.db.Query("SELECT "+sessionFields+" FROM user_sessions "+query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var session *happydns.Session
+		if session, err = scanSession(rows); err != nil {
+			return
+		}
+		sessions = append(sessions, session)
+	}
+	err = rows.Err()
+
+	return
+}
+
+func (s *PostgreSQLStorage) GetSession(id happydns.Identifier) (*happydns.Session, error) {
+	return scanSession(s.db.QueryRow("SELECT "+sessionFields+" FROM user_sessions WHERE id_session = $1", []byte(id)))
+}
+
+func (s *PostgreSQLStorage) GetAuthUserSessions(user *happydns.UserAuth) ([]*happydns.Session, error) {
+	return s.getSessions("WHERE id_user = $1", []byte(user.Id))
+}
+
+func (s *PostgreSQLStorage) GetUserSessions(user *happydns.User) ([]*happydns.Session, error) {
+	return s.getSessions("WHERE id_user = $1", []byte(user.Id))
+}
+
+func (s *PostgreSQLStorage) CreateSession(session *happydns.Session) error {
+	id, err := s.newIdentifier("user_sessions", "id_session")
+	if err != nil {
+		return err
+	}
+
+	session.Id = id
+	return s.UpdateSession(session)
+}
+
+func (s *PostgreSQLStorage) UpdateSession(session *happydns.Session) error {
+	// JSONB columns are filled from their text representation
+	var content interface{}
+	if session.Content != nil {
+		raw, err := json.Marshal(session.Content)
+		if err != nil {
+			return err
+		}
+		content = string(raw)
+	}
+
+	_, err := s.db.Exec(`INSERT INTO user_sessions (`+sessionFields+`) VALUES ($1, $2, $3, $4)
+ON CONFLICT (id_session) DO UPDATE SET id_user = EXCLUDED.id_user, issued_at = EXCLUDED.issued_at, content = EXCLUDED.content`,
+		[]byte(session.Id), []byte(session.IdUser), session.IssuedAt, content)
+	return err
+}
+
+func (s *PostgreSQLStorage) DeleteSession(session *happydns.Session) error {
+	_, err := s.db.Exec("DELETE FROM user_sessions WHERE id_session = $1", []byte(session.Id))
+	return err
+}
+
+func (s *PostgreSQLStorage) ClearSessions() error {
+	return s.clearTable("user_sessions")
+}
+
+func (s *PostgreSQLStorage) TidySessions() error {
+	_, err := s.db.Exec("DELETE FROM user_sessions WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id_user = user_sessions.id_user)")
+	return err
+}
@@ -0,0 +1,130 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"git.happydns.org/happydomain/model"
+)
+
+const userFields = "id_user, email, created_at, last_seen, settings, quota"
+
+func scanUser(row rowScanner) (u *happydns.User, err error) {
+	var settings, quota []byte
+
+	u = &happydns.User{}
+	if err = row.Scan(&u.Id, &u.Email, &u.CreatedAt, &u.LastSeen, &settings, &quota); err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(settings, &u.Settings); err != nil {
+		return
+	}
+
+	err = json.Unmarshal(quota, &u.Quota)
+	return
+}
+
+func (s *PostgreSQLStorage) GetUsers() (users happydns.Users, err error) {
+	var rows *sql.Rows
+	rows, err = s.db.Query("SELECT " + userFields + " FROM users")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u *happydns.User
+		if u, err = scanUser(rows); err != nil {
+			return
+		}
+		users = append(users, u)
+	}
+	err = rows.Err()
+
+	return
+}
+
+func (s *PostgreSQLStorage) GetUser(id happydns.Identifier) (*happydns.User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userFields+" FROM users WHERE id_user = $1", []byte(id)))
+}
+
+func (s *PostgreSQLStorage) GetUserByEmail(email string) (*happydns.User, error) {
+	return scanUser(s.db.QueryRow("SELECT "+userFields+" FROM users WHERE email = $1 LIMIT 1", email))
+}
+
+func (s *PostgreSQLStorage) CreateUser(u *happydns.User) error {
+	id, err := s.newIdentifier("users", "id_user")
+	if err != nil {
+		return err
+	}
+
+	u.Id = id
+	return s.UpdateUser(u)
+}
+
+func (s *PostgreSQLStorage) UpdateUser(u *happydns.User) error {
+	settings, err := json.Marshal(u.Settings)
+	if err != nil {
+		return err
+	}
+
+	quota, err := json.Marshal(u.Quota)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`INSERT INTO users (`+userFields+`) VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (id_user) DO UPDATE SET email = EXCLUDED.email, created_at = EXCLUDED.created_at, last_seen = EXCLUDED.last_seen, settings = EXCLUDED.settings, quota = EXCLUDED.quota`,
+		[]byte(u.Id), u.Email, u.CreatedAt, u.LastSeen, string(settings), string(quota))
+	return err
+}
+
+func (s *PostgreSQLStorage) DeleteUser(u *happydns.User) error {
+	_, err := s.db.Exec("DELETE FROM users WHERE id_user = $1", []byte(u.Id))
+	return err
+}
+
+func (s *PostgreSQLStorage) ClearUsers() error {
+	if err := s.ClearSessions(); err != nil {
+		return err
+	}
+
+	return s.clearTable("users")
+}
+
+func (s *PostgreSQLStorage) TidyUsers() error {
+	_, err := s.db.Exec("DELETE FROM users WHERE NOT EXISTS (SELECT 1 FROM auth_users WHERE auth_users.id_auth = users.id_user)")
+	return err
+}
@@ -0,0 +1,177 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"git.happydns.org/happydomain/model"
+)
+
+const zoneMetaFields = "id_zone, id_author, default_ttl, last_modified, commit_message, commit_date, published, ttl_plan, impersonator"
+
+func scanZoneMeta(row rowScanner) (zm *happydns.ZoneMeta, err error) {
+	var author []byte
+	var lastModified sql.NullTime
+	var ttlPlan []byte
+
+	zm = &happydns.ZoneMeta{}
+	if err = row.Scan(&zm.Id, &author, &zm.DefaultTTL, &lastModified, &zm.CommitMsg, &zm.CommitDate, &zm.Published, &ttlPlan, &zm.Impersonator); err != nil {
+		return
+	}
+
+	zm.IdAuthor = author
+	zm.LastModified = lastModified.Time
+	if ttlPlan != nil {
+		err = json.Unmarshal(ttlPlan, &zm.TTLPlan)
+	}
+	return
+}
+
+func (s *PostgreSQLStorage) GetZoneMeta(id happydns.Identifier) (*happydns.ZoneMeta, error) {
+	return scanZoneMeta(s.db.QueryRow("SELECT "+zoneMetaFields+" FROM zones WHERE id_zone = $1", []byte(id)))
+}
+
+func (s *PostgreSQLStorage) GetZone(id happydns.Identifier) (*happydns.Zone, error) {
+	zm, err := s.GetZoneMeta(id)
+	if err != nil {
+		return nil, err
+	}
+
+	z := &happydns.Zone{
+		ZoneMeta: *zm,
+		Services: map[string][]*happydns.ServiceCombined{},
+	}
+
+	rows, err := s.db.Query("SELECT subdomain, service FROM zone_services WHERE id_zone = $1 ORDER BY subdomain, position", []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var subdomain string
+		var service []byte
+		if err = rows.Scan(&subdomain, &service); err != nil {
+			return nil, err
+		}
+
+		svc := &happydns.ServiceCombined{}
+		if err = json.Unmarshal(service, svc); err != nil {
+			return nil, err
+		}
+
+		z.Services[subdomain] = append(z.Services[subdomain], svc)
+	}
+
+	return z, rows.Err()
+}
+
+func (s *PostgreSQLStorage) CreateZone(z *happydns.Zone) error {
+	id, err := s.newIdentifier("zones", "id_zone")
+	if err != nil {
+		return err
+	}
+
+	z.Id = id
+	return s.UpdateZone(z)
+}
+
+// UpdateZone stores the zone metadata and replaces its whole set of services
+// in a single transaction.
+func (s *PostgreSQLStorage) UpdateZone(z *happydns.Zone) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var author interface{}
+	if z.IdAuthor != nil {
+		author = []byte(z.IdAuthor)
+	}
+
+	var ttlPlan interface{}
+	if z.TTLPlan != nil {
+		plan, err := json.Marshal(z.TTLPlan)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		ttlPlan = string(plan)
+	}
+
+	_, err = tx.Exec(`INSERT INTO zones (`+zoneMetaFields+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (id_zone) DO UPDATE SET id_author = EXCLUDED.id_author, default_ttl = EXCLUDED.default_ttl, last_modified = EXCLUDED.last_modified, commit_message = EXCLUDED.commit_message, commit_date = EXCLUDED.commit_date, published = EXCLUDED.published, ttl_plan = EXCLUDED.ttl_plan, impersonator = EXCLUDED.impersonator`,
+		[]byte(z.Id), author, int64(z.DefaultTTL), z.LastModified, z.CommitMsg, z.CommitDate, z.Published, ttlPlan, z.Impersonator)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM zone_services WHERE id_zone = $1", []byte(z.Id)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for subdomain, svcs := range z.Services {
+		for position, svc := range svcs {
+			service, err := json.Marshal(svc)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+
+			if _, err = tx.Exec("INSERT INTO zone_services (id_zone, subdomain, position, service) VALUES ($1, $2, $3, $4)", []byte(z.Id), subdomain, position, string(service)); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgreSQLStorage) DeleteZone(z *happydns.Zone) error {
+	_, err := s.db.Exec("DELETE FROM zones WHERE id_zone = $1", []byte(z.Id))
+	return err
+}
+
+func (s *PostgreSQLStorage) ClearZones() error {
+	return s.clearTable("zones")
+}
+
+func (s *PostgreSQLStorage) TidyZones() error {
+	// Drop orphan zones, services are removed by cascade
+	_, err := s.db.Exec("DELETE FROM zones WHERE NOT EXISTS (SELECT 1 FROM domains WHERE zones.id_zone = ANY(domains.zone_history))")
+	return err
+}
@@ -0,0 +1,769 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+// Package storagetest implements a conformance suite for implementations of
+// storage.Storage.
+package storagetest // import "happydns.org/storage/storagetest"
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"git.happydns.org/happydomain/model"
+	"git.happydns.org/happydomain/providers"
+	"git.happydns.org/happydomain/services"
+	"git.happydns.org/happydomain/storage"
+)
+
+// Run checks that the given Storage behaves as expected by the rest of
+// happyDomain, each group of checks being a subtest of t.
+//
+// WARNING: the whole content of the storage is erased during the tests.
+func Run(t *testing.T, s storage.Storage) {
+	for _, check := range []struct {
+		name string
+		fn   func(*tester)
+	}{
+		{"clear", (*tester).checkClear},
+		{"auth users", (*tester).checkAuthUsers},
+		{"users", (*tester).checkUsers},
+		{"sessions", (*tester).checkSessions},
+		{"providers", (*tester).checkProviders},
+		{"domains", (*tester).checkDomains},
+		{"credentials", (*tester).checkCredentials},
+		{"zones", (*tester).checkZones},
+		{"tidy", (*tester).checkTidy},
+	} {
+		t.Run(check.name, func(t *testing.T) {
+			tt := &tester{t: t, s: s}
+			tt.clear()
+			check.fn(tt)
+		})
+	}
+
+	(&tester{t: t, s: s}).clear()
+}
+
+type tester struct {
+	t *testing.T
+	s storage.Storage
+}
+
+func (t *tester) errorf(format string, args ...interface{}) {
+	t.t.Helper()
+	t.t.Errorf(format, args...)
+}
+
+// must reports err and returns false when it is not nil.
+func (t *tester) must(err error, what string) bool {
+	t.t.Helper()
+	if err != nil {
+		t.errorf("%s: %s", what, err.Error())
+		return false
+	}
+	return true
+}
+
+func (t *tester) clear() {
+	t.must(t.s.ClearSessions(), "ClearSessions")
+	t.must(t.s.ClearCredentials(), "ClearCredentials")
+	t.must(t.s.ClearZones(), "ClearZones")
+	t.must(t.s.ClearDomains(), "ClearDomains")
+	t.must(t.s.ClearProviders(), "ClearProviders")
+	t.must(t.s.ClearUsers(), "ClearUsers")
+	t.must(t.s.ClearAuthUsers(), "ClearAuthUsers")
+}
+
+// newAccount creates a User along with its UserAuth, sharing the same
+// identifier as the API does.
+func (t *tester) newAccount(email string) *happydns.User {
+	u, err := happydns.NewUser(email)
+	if !t.must(err, "NewUser") {
+		return nil
+	}
+
+	if !t.must(t.s.CreateUser(u), "CreateUser") {
+		return nil
+	}
+
+	if !t.must(t.s.UpdateAuthUser(&happydns.UserAuth{Id: u.Id, Email: email}), "UpdateAuthUser") {
+		return nil
+	}
+
+	return u
+}
+
+func (t *tester) newProvider(u *happydns.User, comment string) *happydns.ProviderCombined {
+	p, err := providers.FindProvider("DDNSServer")
+	if !t.must(err, "FindProvider") {
+		return nil
+	}
+
+	src, err := t.s.CreateProvider(u, p, comment)
+	if !t.must(err, "CreateProvider") {
+		return nil
+	}
+
+	return src
+}
+
+func (t *tester) newDomain(u *happydns.User, src *happydns.ProviderCombined, dn string) *happydns.Domain {
+	domain := &happydns.Domain{
+		IdProvider: src.Id,
+		DomainName: dn,
+	}
+
+	if !t.must(t.s.CreateDomain(u, domain), "CreateDomain") {
+		return nil
+	}
+
+	return domain
+}
+
+func (t *tester) checkClear() {
+	if users, err := t.s.GetUsers(); t.must(err, "GetUsers") && len(users) != 0 {
+		t.errorf("GetUsers returns %d users after ClearUsers, expected none", len(users))
+	}
+
+	if users, err := t.s.GetAuthUsers(); t.must(err, "GetAuthUsers") && len(users) != 0 {
+		t.errorf("GetAuthUsers returns %d auth users after ClearAuthUsers, expected none", len(users))
+	}
+
+	u := t.newAccount("clear@happydomain.org")
+	if u == nil {
+		return
+	}
+
+	src := t.newProvider(u, "clear")
+	if src == nil {
+		return
+	}
+
+	domain := t.newDomain(u, src, "clear.example.com.")
+	if domain == nil {
+		return
+	}
+
+	zone := &happydns.Zone{}
+	if !t.must(t.s.CreateZone(zone), "CreateZone") {
+		return
+	}
+
+	session := &happydns.Session{IdUser: u.Id}
+	if !t.must(t.s.CreateSession(session), "CreateSession") {
+		return
+	}
+
+	t.must(t.s.ClearDomains(), "ClearDomains")
+	if domains, err := t.s.GetDomains(u); t.must(err, "GetDomains") && len(domains) != 0 {
+		t.errorf("GetDomains returns %d domains after ClearDomains, expected none", len(domains))
+	}
+	if _, err := t.s.GetZone(zone.Id); err == nil {
+		t.errorf("GetZone succeeds after ClearDomains, zones should be cleared too")
+	}
+
+	t.must(t.s.ClearProviders(), "ClearProviders")
+	if srcs, err := t.s.GetProviderMetas(u); t.must(err, "GetProviderMetas") && len(srcs) != 0 {
+		t.errorf("GetProviderMetas returns %d providers after ClearProviders, expected none", len(srcs))
+	}
+
+	t.must(t.s.ClearUsers(), "ClearUsers")
+	if _, err := t.s.GetUser(u.Id); err == nil {
+		t.errorf("GetUser succeeds after ClearUsers")
+	}
+	if _, err := t.s.GetSession(session.Id); err == nil {
+		t.errorf("GetSession succeeds after ClearUsers, sessions should be cleared too")
+	}
+
+	t.must(t.s.ClearAuthUsers(), "ClearAuthUsers")
+	if t.s.AuthUserExists("clear@happydomain.org") {
+		t.errorf("AuthUserExists returns true after ClearAuthUsers")
+	}
+}
+
+func (t *tester) checkAuthUsers() {
+	ua := &happydns.UserAuth{Email: "auth@happydomain.org", Password: []byte("secret")}
+	if !t.must(t.s.CreateAuthUser(ua), "CreateAuthUser") {
+		return
+	}
+
+	if len(ua.Id) == 0 {
+		t.errorf("CreateAuthUser doesn't assign an identifier")
+	}
+
+	if got, err := t.s.GetAuthUser(ua.Id); t.must(err, "GetAuthUser") && (got.Email != ua.Email || string(got.Password) != string(ua.Password)) {
+		t.errorf("GetAuthUser returns %q/%q, expected %q/%q", got.Email, got.Password, ua.Email, ua.Password)
+	}
+
+	if got, err := t.s.GetAuthUserByEmail(ua.Email); t.must(err, "GetAuthUserByEmail") && !got.Id.Equals(ua.Id) {
+		t.errorf("GetAuthUserByEmail returns %s, expected %s", got.Id.String(), ua.Id.String())
+	}
+
+	if _, err := t.s.GetAuthUserByEmail("nobody@happydomain.org"); err == nil {
+		t.errorf("GetAuthUserByEmail succeeds with an unknown email")
+	}
+
+	if !t.s.AuthUserExists(ua.Email) {
+		t.errorf("AuthUserExists returns false for an existing auth user")
+	}
+	if t.s.AuthUserExists("nobody@happydomain.org") {
+		t.errorf("AuthUserExists returns true for an unknown email")
+	}
+
+	ua.AllowCommercials = true
+	t.must(t.s.UpdateAuthUser(ua), "UpdateAuthUser")
+	if got, err := t.s.GetAuthUser(ua.Id); t.must(err, "GetAuthUser") && !got.AllowCommercials {
+		t.errorf("UpdateAuthUser doesn't store the modification")
+	}
+
+	// UpdateAuthUser is also used to store new auth users with a given identifier
+	other := &happydns.UserAuth{Id: happydns.Identifier("other-auth-id"), Email: "other@happydomain.org"}
+	t.must(t.s.UpdateAuthUser(other), "UpdateAuthUser on a new auth user")
+	if _, err := t.s.GetAuthUser(other.Id); err != nil {
+		t.errorf("UpdateAuthUser doesn't create an unknown auth user: %s", err.Error())
+	}
+
+	if users, err := t.s.GetAuthUsers(); t.must(err, "GetAuthUsers") && len(users) != 2 {
+		t.errorf("GetAuthUsers returns %d auth users, expected 2", len(users))
+	}
+
+	t.must(t.s.DeleteAuthUser(ua), "DeleteAuthUser")
+	if _, err := t.s.GetAuthUser(ua.Id); err == nil {
+		t.errorf("GetAuthUser succeeds after DeleteAuthUser")
+	}
+	if _, err := t.s.GetAuthUser(other.Id); err != nil {
+		t.errorf("DeleteAuthUser removes another auth user: %s", err.Error())
+	}
+}
+
+func (t *tester) checkUsers() {
+	u, err := happydns.NewUser("user@happydomain.org")
+	if !t.must(err, "NewUser") || !t.must(t.s.CreateUser(u), "CreateUser") {
+		return
+	}
+
+	if len(u.Id) == 0 {
+		t.errorf("CreateUser doesn't assign an identifier")
+	}
+
+	if got, err := t.s.GetUser(u.Id); t.must(err, "GetUser") && got.Email != u.Email {
+		t.errorf("GetUser returns %q, expected %q", got.Email, u.Email)
+	}
+
+	if got, err := t.s.GetUserByEmail(u.Email); t.must(err, "GetUserByEmail") && !got.Id.Equals(u.Id) {
+		t.errorf("GetUserByEmail returns %s, expected %s", got.Id.String(), u.Id.String())
+	}
+
+	if _, err := t.s.GetUserByEmail("nobody@happydomain.org"); err == nil {
+		t.errorf("GetUserByEmail succeeds with an unknown email")
+	}
+
+	u.Settings.Language = "fr"
+	t.must(t.s.UpdateUser(u), "UpdateUser")
+	if got, err := t.s.GetUser(u.Id); t.must(err, "GetUser") && got.Settings.Language != "fr" {
+		t.errorf("UpdateUser doesn't store the settings: got language %q", got.Settings.Language)
+	}
+
+	// UpdateUser is also used to store new users with a given identifier
+	other := &happydns.User{Id: happydns.Identifier("other-user-id"), Email: "other@happydomain.org"}
+	t.must(t.s.UpdateUser(other), "UpdateUser on a new user")
+	if _, err := t.s.GetUser(other.Id); err != nil {
+		t.errorf("UpdateUser doesn't create an unknown user: %s", err.Error())
+	}
+
+	if users, err := t.s.GetUsers(); t.must(err, "GetUsers") && len(users) != 2 {
+		t.errorf("GetUsers returns %d users, expected 2", len(users))
+	}
+
+	t.must(t.s.DeleteUser(u), "DeleteUser")
+	if _, err := t.s.GetUser(u.Id); err == nil {
+		t.errorf("GetUser succeeds after DeleteUser")
+	}
+	if _, err := t.s.GetUser(other.Id); err != nil {
+		t.errorf("DeleteUser removes another user: %s", err.Error())
+	}
+}
+
+func (t *tester) checkSessions() {
+	alice := t.newAccount("alice@happydomain.org")
+	bob := t.newAccount("bob@happydomain.org")
+	if alice == nil || bob == nil {
+		return
+	}
+
+	session := &happydns.Session{IdUser: alice.Id, Content: map[string][]byte{"key": []byte("value")}}
+	if !t.must(t.s.CreateSession(session), "CreateSession") {
+		return
+	}
+	t.must(t.s.CreateSession(&happydns.Session{IdUser: alice.Id}), "CreateSession")
+	t.must(t.s.CreateSession(&happydns.Session{IdUser: bob.Id}), "CreateSession")
+
+	if got, err := t.s.GetSession(session.Id); t.must(err, "GetSession") {
+		if !got.IdUser.Equals(alice.Id) {
+			t.errorf("GetSession returns a session of %s, expected %s", got.IdUser.String(), alice.Id.String())
+		}
+		if string(got.Content["key"]) != "value" {
+			t.errorf("GetSession returns content %q, expected %q", got.Content["key"], "value")
+		}
+	}
+
+	if sessions, err := t.s.GetUserSessions(alice); t.must(err, "GetUserSessions") && len(sessions) != 2 {
+		t.errorf("GetUserSessions returns %d sessions, expected 2", len(sessions))
+	}
+
+	if sessions, err := t.s.GetAuthUserSessions(&happydns.UserAuth{Id: bob.Id}); t.must(err, "GetAuthUserSessions") && len(sessions) != 1 {
+		t.errorf("GetAuthUserSessions returns %d sessions, expected 1", len(sessions))
+	}
+
+	session.Content["key"] = []byte("updated")
+	t.must(t.s.UpdateSession(session), "UpdateSession")
+	if got, err := t.s.GetSession(session.Id); t.must(err, "GetSession") && string(got.Content["key"]) != "updated" {
+		t.errorf("UpdateSession doesn't store the content: got %q", got.Content["key"])
+	}
+
+	// UpdateSession is also used to store new sessions with a given identifier
+	other := &happydns.Session{Id: happydns.Identifier("other-session-id"), IdUser: bob.Id}
+	t.must(t.s.UpdateSession(other), "UpdateSession on a new session")
+	if _, err := t.s.GetSession(other.Id); err != nil {
+		t.errorf("UpdateSession doesn't create an unknown session: %s", err.Error())
+	}
+
+	t.must(t.s.DeleteSession(session), "DeleteSession")
+	if _, err := t.s.GetSession(session.Id); err == nil {
+		t.errorf("GetSession succeeds after DeleteSession")
+	}
+	if sessions, err := t.s.GetUserSessions(alice); t.must(err, "GetUserSessions") && len(sessions) != 1 {
+		t.errorf("GetUserSessions returns %d sessions after DeleteSession, expected 1", len(sessions))
+	}
+}
+
+func (t *tester) checkProviders() {
+	alice := t.newAccount("alice@happydomain.org")
+	bob := t.newAccount("bob@happydomain.org")
+	if alice == nil || bob == nil {
+		return
+	}
+
+	src := t.newProvider(alice, "alice's provider")
+	if src == nil {
+		return
+	}
+
+	if len(src.Id) == 0 {
+		t.errorf("CreateProvider doesn't assign an identifier")
+	}
+	if src.Type != "DDNSServer" {
+		t.errorf("CreateProvider sets type %q, expected %q", src.Type, "DDNSServer")
+	}
+
+	if got, err := t.s.GetProvider(alice, src.Id); t.must(err, "GetProvider") {
+		if got.Comment != src.Comment || got.Type != src.Type || !got.OwnerId.Equals(alice.Id) {
+			t.errorf("GetProvider returns %v, expected %v", got.ProviderMeta, src.ProviderMeta)
+		}
+		if got.Provider == nil {
+			t.errorf("GetProvider returns no Provider")
+		}
+	}
+
+	if _, err := t.s.GetProvider(bob, src.Id); err == nil {
+		t.errorf("GetProvider succeeds with the wrong user")
+	}
+	if _, err := t.s.GetProviderMeta(bob, src.Id); err == nil {
+		t.errorf("GetProviderMeta succeeds with the wrong user")
+	}
+
+	if srcs, err := t.s.GetProviderMetas(bob); t.must(err, "GetProviderMetas") && len(srcs) != 0 {
+		t.errorf("GetProviderMetas returns %d providers of another user, expected none", len(srcs))
+	}
+
+	src.Comment = "updated"
+	t.must(t.s.UpdateProvider(src), "UpdateProvider")
+	if got, err := t.s.GetProviderMeta(alice, src.Id); t.must(err, "GetProviderMeta") && got.Comment != "updated" {
+		t.errorf("UpdateProvider doesn't store the comment: got %q", got.Comment)
+	}
+
+	t.must(t.s.UpdateProviderOwner(src, bob), "UpdateProviderOwner")
+	if _, err := t.s.GetProvider(alice, src.Id); err == nil {
+		t.errorf("GetProvider succeeds with the previous owner after UpdateProviderOwner")
+	}
+	if srcs, err := t.s.GetProviderMetas(bob); t.must(err, "GetProviderMetas") && len(srcs) != 1 {
+		t.errorf("GetProviderMetas returns %d providers for the new owner, expected 1", len(srcs))
+	}
+
+	t.must(t.s.DeleteProvider(&src.ProviderMeta), "DeleteProvider")
+	if _, err := t.s.GetProvider(bob, src.Id); err == nil {
+		t.errorf("GetProvider succeeds after DeleteProvider")
+	}
+}
+
+func (t *tester) checkDomains() {
+	alice := t.newAccount("alice@happydomain.org")
+	bob := t.newAccount("bob@happydomain.org")
+	if alice == nil || bob == nil {
+		return
+	}
+
+	src := t.newProvider(alice, "alice's provider")
+	if src == nil {
+		return
+	}
+
+	domain := t.newDomain(alice, src, "example.com.")
+	if domain == nil {
+		return
+	}
+
+	if len(domain.Id) == 0 {
+		t.errorf("CreateDomain doesn't assign an identifier")
+	}
+	if !domain.IdUser.Equals(alice.Id) {
+		t.errorf("CreateDomain doesn't set the owner")
+	}
+
+	if got, err := t.s.GetDomain(alice, domain.Id); t.must(err, "GetDomain") && (got.DomainName != domain.DomainName || !got.IdProvider.Equals(src.Id)) {
+		t.errorf("GetDomain returns %q (provider %s), expected %q (provider %s)", got.DomainName, got.IdProvider.String(), domain.DomainName, src.Id.String())
+	}
+
+	if _, err := t.s.GetDomain(bob, domain.Id); err == nil {
+		t.errorf("GetDomain succeeds with the wrong user")
+	}
+
+	if got, err := t.s.GetDomainByDN(alice, "example.com."); t.must(err, "GetDomainByDN") && !got.Id.Equals(domain.Id) {
+		t.errorf("GetDomainByDN returns %s, expected %s", got.Id.String(), domain.Id.String())
+	}
+	if _, err := t.s.GetDomainByDN(bob, "example.com."); err == nil {
+		t.errorf("GetDomainByDN succeeds with the wrong user")
+	}
+
+	if !t.s.DomainExists("example.com.") {
+		t.errorf("DomainExists returns false for an existing domain")
+	}
+	if t.s.DomainExists("example.org.") {
+		t.errorf("DomainExists returns true for an unknown domain")
+	}
+
+	if domains, err := t.s.GetDomains(bob); t.must(err, "GetDomains") && len(domains) != 0 {
+		t.errorf("GetDomains returns %d domains of another user, expected none", len(domains))
+	}
+
+	domain.Group = "group"
+	domain.Impersonator = "admin"
+	t.must(t.s.UpdateDomain(domain), "UpdateDomain")
+	if got, err := t.s.GetDomain(alice, domain.Id); t.must(err, "GetDomain") && (got.Group != "group" || got.Impersonator != "admin") {
+		t.errorf("UpdateDomain doesn't store the group and the impersonator: got %q and %q", got.Group, got.Impersonator)
+	}
+
+	t.must(t.s.UpdateDomainOwner(domain, bob), "UpdateDomainOwner")
+	if _, err := t.s.GetDomain(alice, domain.Id); err == nil {
+		t.errorf("GetDomain succeeds with the previous owner after UpdateDomainOwner")
+	}
+	if domains, err := t.s.GetDomains(bob); t.must(err, "GetDomains") && len(domains) != 1 {
+		t.errorf("GetDomains returns %d domains for the new owner, expected 1", len(domains))
+	}
+
+	t.must(t.s.DeleteDomain(domain), "DeleteDomain")
+	if _, err := t.s.GetDomain(bob, domain.Id); err == nil {
+		t.errorf("GetDomain succeeds after DeleteDomain")
+	}
+}
+
+func (t *tester) checkCredentials() {
+	alice := t.newAccount("alice@happydomain.org")
+	bob := t.newAccount("bob@happydomain.org")
+	if alice == nil || bob == nil {
+		return
+	}
+
+	src := t.newProvider(alice, "alice's provider")
+	if src == nil {
+		return
+	}
+
+	domain := t.newDomain(alice, src, "example.com.")
+	if domain == nil {
+		return
+	}
+
+	credential := &happydns.Credential{
+		IdUser:    alice.Id,
+		IdDomain:  domain.Id,
+		Scope:     happydns.CredentialDynDNS,
+		Subdomain: "home",
+		CreatedAt: time.Now(),
+	}
+	secret, err := credential.GenerateSecret()
+	if !t.must(err, "GenerateSecret") || !t.must(t.s.CreateCredential(credential), "CreateCredential") {
+		return
+	}
+
+	if len(credential.Id) == 0 {
+		t.errorf("CreateCredential doesn't assign an identifier")
+	}
+
+	if got, err := t.s.GetCredential(credential.Id); t.must(err, "GetCredential") {
+		if !got.IdDomain.Equals(domain.Id) || got.Scope != happydns.CredentialDynDNS || got.Subdomain != "home" {
+			t.errorf("GetCredential returns domain %s, scope %q, subdomain %q", got.IdDomain.String(), got.Scope, got.Subdomain)
+		}
+		if !got.CheckSecret(secret) {
+			t.errorf("GetCredential returns a credential whose secret doesn't match")
+		}
+		if got.LastUsed != nil {
+			t.errorf("GetCredential returns a LastUsed date for an unused credential")
+		}
+	}
+
+	now := time.Now()
+	credential.LastUsed = &now
+	credential.Challenges = []happydns.CredentialChallenge{{Value: "challenge", ExpiresAt: now.Add(time.Hour)}}
+	t.must(t.s.UpdateCredential(credential), "UpdateCredential")
+	if got, err := t.s.GetCredential(credential.Id); t.must(err, "GetCredential") {
+		if got.LastUsed == nil {
+			t.errorf("UpdateCredential doesn't store LastUsed")
+		}
+		if len(got.Challenges) != 1 || got.Challenges[0].Value != "challenge" || !got.Challenges[0].ExpiresAt.Equal(credential.Challenges[0].ExpiresAt) {
+			t.errorf("UpdateCredential doesn't store Challenges, got %v", got.Challenges)
+		}
+	}
+
+	if credentials, err := t.s.GetCredentials(alice); t.must(err, "GetCredentials") && len(credentials) != 1 {
+		t.errorf("GetCredentials returns %d credentials, expected 1", len(credentials))
+	}
+	if credentials, err := t.s.GetCredentials(bob); t.must(err, "GetCredentials") && len(credentials) != 0 {
+		t.errorf("GetCredentials returns %d credentials of another user, expected none", len(credentials))
+	}
+
+	t.must(t.s.DeleteCredential(credential), "DeleteCredential")
+	if _, err := t.s.GetCredential(credential.Id); err == nil {
+		t.errorf("GetCredential succeeds after DeleteCredential")
+	}
+}
+
+func (t *tester) checkZones() {
+	alice := t.newAccount("alice@happydomain.org")
+	if alice == nil {
+		return
+	}
+
+	src := t.newProvider(alice, "alice's provider")
+	if src == nil {
+		return
+	}
+
+	domain := t.newDomain(alice, src, "example.com.")
+	if domain == nil {
+		return
+	}
+
+	var zones []*happydns.Zone
+	for i := 0; i < 3; i++ {
+		zone := &happydns.Zone{
+			ZoneMeta: happydns.ZoneMeta{
+				IdAuthor:     alice.Id,
+				DefaultTTL:   uint32(300 * (i + 1)),
+				Impersonator: fmt.Sprintf("admin%d", i),
+			},
+			Services: map[string][]*happydns.ServiceCombined{
+				"": []*happydns.ServiceCombined{
+					&happydns.ServiceCombined{
+						Service: &svcs.TXT{Content: fmt.Sprintf("revision %d", i)},
+						ServiceMeta: happydns.ServiceMeta{
+							Type: "svcs.TXT",
+							Ttl:  3600,
+						},
+					},
+				},
+			},
+		}
+
+		if !t.must(t.s.CreateZone(zone), "CreateZone") {
+			return
+		}
+		if len(zone.Id) == 0 {
+			t.errorf("CreateZone doesn't assign an identifier")
+		}
+
+		zones = append(zones, zone)
+		domain.ZoneHistory = append([]happydns.Identifier{zone.Id}, domain.ZoneHistory...)
+		t.must(t.s.UpdateDomain(domain), "UpdateDomain")
+	}
+
+	got, err := t.s.GetDomain(alice, domain.Id)
+	if !t.must(err, "GetDomain") {
+		return
+	}
+
+	if len(got.ZoneHistory) != len(zones) {
+		t.errorf("domain has %d zones in history, expected %d", len(got.ZoneHistory), len(zones))
+		return
+	}
+
+	for i, zid := range got.ZoneHistory {
+		expected := zones[len(zones)-1-i]
+		if !zid.Equals(expected.Id) {
+			t.errorf("zone history #%d is %s, expected %s", i, zid.String(), expected.Id.String())
+			continue
+		}
+
+		if d, err := t.s.GetDomainByZone(zid); t.must(err, "GetDomainByZone") && !d.Id.Equals(domain.Id) {
+			t.errorf("GetDomainByZone returns domain %s, expected %s", d.Id.String(), domain.Id.String())
+		}
+
+		zone, err := t.s.GetZone(zid)
+		if !t.must(err, "GetZone") {
+			continue
+		}
+
+		if zone.DefaultTTL != expected.DefaultTTL || !zone.IdAuthor.Equals(alice.Id) {
+			t.errorf("GetZone returns TTL=%d author=%s, expected TTL=%d author=%s", zone.DefaultTTL, zone.IdAuthor.String(), expected.DefaultTTL, alice.Id.String())
+		}
+
+		if zone.Impersonator != expected.Impersonator {
+			t.errorf("GetZone returns impersonator %q, expected %q", zone.Impersonator, expected.Impersonator)
+		}
+
+		if len(zone.Services[""]) != 1 {
+			t.errorf("GetZone returns %d services at apex, expected 1", len(zone.Services[""]))
+		} else if txt, ok := zone.Services[""][0].Service.(*svcs.TXT); !ok {
+			t.errorf("GetZone returns a service of type %T, expected *svcs.TXT", zone.Services[""][0].Service)
+		} else if txt.Content != expected.Services[""][0].Service.(*svcs.TXT).Content {
+			t.errorf("GetZone returns service content %q, expected %q", txt.Content, expected.Services[""][0].Service.(*svcs.TXT).Content)
+		}
+
+		meta, err := t.s.GetZoneMeta(zid)
+		if t.must(err, "GetZoneMeta") && meta.DefaultTTL != expected.DefaultTTL {
+			t.errorf("GetZoneMeta returns TTL=%d, expected %d", meta.DefaultTTL, expected.DefaultTTL)
+		}
+	}
+
+	// Modifying a zone must not alter the others
+	zones[0].DefaultTTL = 42
+	zones[0].Services["www"] = zones[0].Services[""]
+	t.must(t.s.UpdateZone(zones[0]), "UpdateZone")
+	if zone, err := t.s.GetZone(zones[0].Id); t.must(err, "GetZone") && (zone.DefaultTTL != 42 || len(zone.Services["www"]) != 1) {
+		t.errorf("UpdateZone doesn't store the modifications")
+	}
+	if zone, err := t.s.GetZone(zones[1].Id); t.must(err, "GetZone") && (zone.DefaultTTL == 42 || len(zone.Services["www"]) != 0) {
+		t.errorf("UpdateZone modifies another zone")
+	}
+
+	t.must(t.s.DeleteZone(zones[0]), "DeleteZone")
+	if _, err := t.s.GetZone(zones[0].Id); err == nil {
+		t.errorf("GetZone succeeds after DeleteZone")
+	}
+	if _, err := t.s.GetZone(zones[1].Id); err != nil {
+		t.errorf("DeleteZone removes another zone: %s", err.Error())
+	}
+
+	if _, err := t.s.GetDomainByZone(happydns.Identifier("unknown")); err == nil {
+		t.errorf("GetDomainByZone succeeds with an unknown zone")
+	}
+}
+
+func (t *tester) checkTidy() {
+	alice := t.newAccount("alice@happydomain.org")
+	if alice == nil {
+		return
+	}
+
+	src := t.newProvider(alice, "alice's provider")
+	if src == nil {
+		return
+	}
+
+	domain := t.newDomain(alice, src, "example.com.")
+	if domain == nil {
+		return
+	}
+
+	zone := &happydns.Zone{}
+	orphanZone := &happydns.Zone{}
+	if !t.must(t.s.CreateZone(zone), "CreateZone") || !t.must(t.s.CreateZone(orphanZone), "CreateZone") {
+		return
+	}
+	domain.ZoneHistory = []happydns.Identifier{zone.Id}
+	t.must(t.s.UpdateDomain(domain), "UpdateDomain")
+
+	session := &happydns.Session{IdUser: alice.Id}
+	t.must(t.s.CreateSession(session), "CreateSession")
+
+	credential := &happydns.Credential{IdUser: alice.Id, IdDomain: domain.Id, Scope: happydns.CredentialDynDNS, SecretHash: []byte("hash"), CreatedAt: time.Now()}
+	orphanCredential := &happydns.Credential{IdUser: alice.Id, IdDomain: happydns.Identifier("ghost-domain-id"), Scope: happydns.CredentialDynDNS, SecretHash: []byte("hash"), CreatedAt: time.Now()}
+	t.must(t.s.CreateCredential(credential), "CreateCredential")
+	t.must(t.s.CreateCredential(orphanCredential), "CreateCredential")
+
+	// User without UserAuth, owning a provider
+	ghost := &happydns.User{Id: happydns.Identifier("ghost-user-id"), Email: "ghost@happydomain.org"}
+	t.must(t.s.UpdateUser(ghost), "UpdateUser")
+	ghostSrc := t.newProvider(ghost, "ghost's provider")
+
+	if !t.must(t.s.Tidy(), "Tidy") {
+		return
+	}
+
+	if _, err := t.s.GetUser(alice.Id); err != nil {
+		t.errorf("Tidy removes a valid user: %s", err.Error())
+	}
+	if _, err := t.s.GetAuthUser(alice.Id); err != nil {
+		t.errorf("Tidy removes a valid auth user: %s", err.Error())
+	}
+	if _, err := t.s.GetSession(session.Id); err != nil {
+		t.errorf("Tidy removes a valid session: %s", err.Error())
+	}
+	if _, err := t.s.GetProvider(alice, src.Id); err != nil {
+		t.errorf("Tidy removes a valid provider: %s", err.Error())
+	}
+	if _, err := t.s.GetDomain(alice, domain.Id); err != nil {
+		t.errorf("Tidy removes a valid domain: %s", err.Error())
+	}
+	if _, err := t.s.GetZone(zone.Id); err != nil {
+		t.errorf("Tidy removes a zone referenced in a domain history: %s", err.Error())
+	}
+
+	if _, err := t.s.GetCredential(credential.Id); err != nil {
+		t.errorf("Tidy removes a valid credential: %s", err.Error())
+	}
+	if _, err := t.s.GetCredential(orphanCredential.Id); err == nil {
+		t.errorf("Tidy keeps a credential of an unknown domain")
+	}
+
+	if _, err := t.s.GetZone(orphanZone.Id); err == nil {
+		t.errorf("Tidy keeps a zone not referenced by any domain")
+	}
+	if _, err := t.s.GetUser(ghost.Id); err == nil {
+		t.errorf("Tidy keeps a user without auth user")
+	}
+	if ghostSrc != nil {
+		if _, err := t.s.GetProvider(ghost, ghostSrc.Id); err == nil {
+			t.errorf("Tidy keeps a provider of a removed user")
+		}
+	}
+}
@@ -3,8 +3,9 @@ package ui
 import (
 	"embed"
 	"io/fs"
-	"log"
 	"net/http"
+
+	log "github.com/sirupsen/logrus"
 )
 
 //go:generate npm run build
@@ -1,5 +1,5 @@
 import { handleEmptyApiResponse, handleApiResponse } from '$lib/errors';
-import type { Domain, DomainInList } from '$lib/model/domain';
+import type { Domain, DomainInList, ReverseMismatch } from '$lib/model/domain';
 import type { Provider } from '$lib/model/provider';
 
 export async function listDomains(): Promise<Array<DomainInList>> {
@@ -43,3 +43,8 @@ export async function deleteDomain(id: string): Promise<boolean> {
     });
     return await handleEmptyApiResponse(res);
 }
+
+export async function getReverseMismatches(): Promise<Array<ReverseMismatch>> {
+    const res = await fetch('/api/reverse_mismatches', {headers: {'Accept': 'application/json'}});
+    return await handleApiResponse<Array<ReverseMismatch>>(res);
+}
@@ -1,5 +1,5 @@
 import { handleApiResponse } from '$lib/errors';
-import type { ResolverForm } from '$lib/model/resolver';
+import type { Resolver, ResolverForm } from '$lib/model/resolver';
 
 export async function resolve(form: ResolverForm): Promise<any> {
     const res = await fetch(`/api/resolver`, {
@@ -9,3 +9,8 @@ export async function resolve(form: ResolverForm): Promise<any> {
     });
     return await handleApiResponse(res);
 }
+
+export async function listResolvers(): Promise<Array<Resolver>> {
+    const res = await fetch('/api/resolvers', {headers: {'Accept': 'application/json'}});
+    return await handleApiResponse<Array<Resolver>>(res);
+}
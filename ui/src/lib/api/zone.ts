@@ -1,7 +1,7 @@
 import { handleApiResponse } from '$lib/errors';
 import type { Domain, DomainInList } from '$lib/model/domain';
 import type { ServiceCombined, ServiceMeta } from '$lib/model/service';
-import type { ServiceRecord, Zone, ZoneMeta } from '$lib/model/zone';
+import type { DelegationReport, PropagationReport, ServiceRecord, Zone, ZoneMeta } from '$lib/model/zone';
 
 export async function getZone(domain: Domain | DomainInList, id: string): Promise<Zone> {
     const dnid = encodeURIComponent(domain.id);
@@ -40,6 +40,41 @@ export async function applyZone(domain: Domain | DomainInList, id: string, selec
     return await handleApiResponse<ZoneMeta>(res);
 }
 
+export async function getZonePropagation(domain: Domain | DomainInList, id: string, wait: number = 0): Promise<PropagationReport> {
+    const dnid = encodeURIComponent(domain.id);
+    id = encodeURIComponent(id);
+    const res = await fetch(`/api/domains/${dnid}/zone/${id}/propagation?wait=${wait}`, {headers: {'Accept': 'application/json'}});
+    return await handleApiResponse<PropagationReport>(res);
+}
+
+export async function getZoneDelegations(domain: Domain | DomainInList, id: string): Promise<Array<DelegationReport>> {
+    const dnid = encodeURIComponent(domain.id);
+    id = encodeURIComponent(id);
+    const res = await fetch(`/api/domains/${dnid}/zone/${id}/delegations`, {headers: {'Accept': 'application/json'}});
+    return await handleApiResponse<Array<DelegationReport>>(res);
+}
+
+export async function lowerZoneTTLs(domain: Domain | DomainInList, id: string, ttl: number, services: Array<string>): Promise<Zone> {
+    const dnid = encodeURIComponent(domain.id);
+    id = encodeURIComponent(id);
+    const res = await fetch(`/api/domains/${dnid}/zone/${id}/ttl_plan`, {
+        method: 'POST',
+        headers: {'Accept': 'application/json'},
+        body: JSON.stringify({ttl, services}),
+    });
+    return await handleApiResponse<Zone>(res);
+}
+
+export async function restoreZoneTTLs(domain: Domain | DomainInList, id: string): Promise<Zone> {
+    const dnid = encodeURIComponent(domain.id);
+    id = encodeURIComponent(id);
+    const res = await fetch(`/api/domains/${dnid}/zone/${id}/ttl_plan`, {
+        method: 'DELETE',
+        headers: {'Accept': 'application/json'},
+    });
+    return await handleApiResponse<Zone>(res);
+}
+
 export async function diffZone(domain: Domain | DomainInList, id1: string, id2: string): Promise<Array<string>> {
     const dnid = encodeURIComponent(domain.id);
     id1 = encodeURIComponent(id1);
@@ -179,3 +179,23 @@ export function validateDomain(dn: string, subdomain: string = ""): boolean | un
 
     return ret;
 }
+
+// validateNetwork checks the given string is an IPv4 or IPv6 network in CIDR
+// notation, whose reverse zone can be managed.
+export function validateNetwork(cidr: string): boolean | undefined {
+    if (cidr.length === 0) return undefined;
+
+    const m4 = /^(\d{1,3})\.(\d{1,3})\.(\d{1,3})\.(\d{1,3})\/(\d{1,2})$/.exec(cidr);
+    if (m4) {
+        const prefix = parseInt(m4[5], 10);
+        return m4.slice(1, 5).every((b) => parseInt(b, 10) <= 255) && (prefix === 8 || prefix === 16 || prefix === 24 || (prefix > 24 && prefix < 32));
+    }
+
+    const m6 = /^([0-9a-fA-F:]+)\/(\d{1,3})$/.exec(cidr);
+    if (m6 && m6[1].indexOf(':') >= 0) {
+        const prefix = parseInt(m6[2], 10);
+        return prefix > 0 && prefix < 128 && prefix % 4 === 0;
+    }
+
+    return false;
+}
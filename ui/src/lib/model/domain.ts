@@ -13,6 +13,7 @@ export interface DomainInList {
     domain: string;
     group: string;
     zone_history: Array<string>;
+    serial_policy?: string;
 
     // interface property
     wait: boolean;
@@ -25,7 +26,16 @@ export interface Domain {
     domain: string;
     group: string;
     zone_history: Array<ZoneHistory>;
+    serial_policy?: string;
 
     // interface property
     wait: boolean;
 };
+
+export interface ReverseMismatch {
+    address: string;
+    name?: string;
+    pointer: string;
+    domain: string;
+    message: string;
+};
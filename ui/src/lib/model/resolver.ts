@@ -3,4 +3,51 @@ export interface ResolverForm {
     type: string;
     resolver: string;
     custom?: string;
+    transport?: string;
+    port?: number;
+    method?: string;
+    trace?: boolean;
+    validate?: boolean;
+};
+
+export interface TraceStep {
+    zone: string;
+    server: string;
+    address: string;
+    rtt_ms: number;
+    rcode?: string;
+    referral?: Array<any>;
+    glue?: Array<any>;
+    answer?: Array<any>;
+    error?: string;
+};
+
+export interface DNSSECKey {
+    key_tag: number;
+    algorithm: string;
+    flags: number;
+};
+
+export interface DNSSECLink {
+    name: string;
+    type: string;
+    status: string;
+    ds?: Array<any>;
+    keys?: Array<DNSSECKey>;
+    reason?: string;
+};
+
+export interface DNSSECReport {
+    status: string;
+    link?: string;
+    reason?: string;
+    chain: Array<DNSSECLink>;
+};
+
+export interface Resolver {
+    address: string;
+    name: string;
+    filtered?: boolean;
+    tls_name?: string;
+    doh_url?: string;
 };
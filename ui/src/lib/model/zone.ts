@@ -16,8 +16,85 @@ export interface ZoneMeta {
     commit_message?: string;
     commit_date?: Date;
     published?: Date;
+    ttl_plan?: TTLPlan;
+};
+
+export interface TTLPlan {
+    ttl: number;
+    default_ttl?: number;
+    services: Record<string, number>;
+    max_ttl: number;
+    created: Date;
+    published?: Date;
+    expires_at?: Date;
 };
 
 export interface Zone extends ZoneMeta {
     services: Record<string, Array<ServiceCombined>>;
 }
+
+export interface PropagationRRset {
+    name: string;
+    type: string;
+    records: Array<string>;
+};
+
+export interface PropagationCheck {
+    name: string;
+    type: string;
+    status: string;
+    records?: Array<string>;
+    expires_in?: number;
+    error?: string;
+};
+
+export interface PropagationServer {
+    name: string;
+    address?: string;
+    authoritative: boolean;
+    status: string;
+    serial?: number;
+    checks?: Array<PropagationCheck>;
+    error?: string;
+};
+
+export interface PropagationReport {
+    expected: Array<PropagationRRset>;
+    truncated?: boolean;
+    converged: boolean;
+    servers: Array<PropagationServer>;
+    checked_at: Date;
+};
+
+export interface DelegationIssue {
+    severity: string;
+    message: string;
+};
+
+export interface DelegationServer {
+    name: string;
+    address?: string;
+    status: string;
+    serial?: number;
+    error?: string;
+};
+
+export interface DelegationKey {
+    key_tag: number;
+    algorithm: string;
+    flags: number;
+};
+
+export interface DelegationReport {
+    domain: string;
+    parent?: string;
+    status: string;
+    zone_ns: Array<string>;
+    parent_ns?: Array<string>;
+    glue?: Record<string, Array<string>>;
+    child_ns?: Array<string>;
+    ds?: Array<string>;
+    dnskey?: Array<DelegationKey>;
+    servers?: Array<DelegationServer>;
+    issues: Array<DelegationIssue>;
+};
@@ -0,0 +1,240 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package utils
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	reverseIPv4Suffix = "in-addr.arpa."
+	reverseIPv6Suffix = "ip6.arpa."
+)
+
+// IsReverseZone tells whether the given domain is under in-addr.arpa or
+// ip6.arpa.
+func IsReverseZone(domain string) bool {
+	domain = strings.ToLower(dns.Fqdn(domain))
+	return dns.IsSubDomain(reverseIPv4Suffix, domain) || dns.IsSubDomain(reverseIPv6Suffix, domain)
+}
+
+// ReverseZone returns the name of the reverse zone covering the given
+// network. IPv4 networks need a prefix length multiple of 8, or longer than
+// 24, in which case the zone is named after RFC 2317 (eg. 0/26.2.0.192.in-addr.arpa.
+// for 192.0.2.0/26). IPv6 networks need a prefix length multiple of 4.
+func ReverseZone(network *net.IPNet) (string, error) {
+	ones, bits := network.Mask.Size()
+
+	if ip := network.IP.To4(); ip != nil && bits == 8*net.IPv4len {
+		if ones > 24 && ones < 32 {
+			return fmt.Sprintf("%d/%d.%d.%d.%d.%s", ip[3], ones, ip[2], ip[1], ip[0], reverseIPv4Suffix), nil
+		} else if ones == 0 || ones > 24 || ones%8 != 0 {
+			return "", fmt.Errorf("IPv4 reverse zones need a prefix length of 8, 16, 24, or between 25 and 31 for classless delegation, not %d", ones)
+		}
+
+		labels := make([]string, ones/8)
+		for i := range labels {
+			labels[len(labels)-1-i] = strconv.Itoa(int(ip[i]))
+		}
+		return strings.Join(labels, ".") + "." + reverseIPv4Suffix, nil
+	}
+
+	if bits != 8*net.IPv6len || ones == 0 || ones == bits || ones%4 != 0 {
+		return "", fmt.Errorf("IPv6 reverse zones need a prefix length multiple of 4, not %d", ones)
+	}
+
+	nibbles := hex.EncodeToString(network.IP.To16())[:ones/4]
+	labels := make([]string, len(nibbles))
+	for i := range nibbles {
+		labels[len(labels)-1-i] = nibbles[i : i+1]
+	}
+	return strings.Join(labels, ".") + "." + reverseIPv6Suffix, nil
+}
+
+// ReverseZoneNetwork returns the network covered by the given reverse zone,
+// named as by ReverseZone. Classless zones written 0-26.2.0.192.in-addr.arpa.
+// are also recognized.
+func ReverseZoneNetwork(zone string) (*net.IPNet, error) {
+	zone = strings.ToLower(dns.Fqdn(zone))
+
+	if dns.IsSubDomain(reverseIPv4Suffix, zone) {
+		labels := dns.SplitDomainName(strings.TrimSuffix(zone, reverseIPv4Suffix))
+
+		ones := 8 * len(labels)
+		ip := make(net.IP, net.IPv4len)
+
+		// RFC 2317 classless zone
+		if len(labels) == 4 {
+			if i := strings.IndexAny(labels[0], "/-"); i > 0 {
+				prefix, err := strconv.ParseUint(labels[0][i+1:], 10, 8)
+				if err != nil || prefix <= 24 || prefix >= 32 {
+					return nil, fmt.Errorf("%s is not a valid classless reverse zone", zone)
+				}
+				ones = int(prefix)
+				labels[0] = labels[0][:i]
+			}
+		}
+
+		if len(labels) == 0 || len(labels) > 4 || ones == 32 {
+			return nil, fmt.Errorf("%s is not a valid IPv4 reverse zone", zone)
+		}
+
+		for i, label := range labels {
+			b, err := strconv.ParseUint(label, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not a valid IPv4 reverse zone", zone)
+			}
+			ip[len(labels)-1-i] = byte(b)
+		}
+
+		network := &net.IPNet{IP: ip, Mask: net.CIDRMask(ones, 8*net.IPv4len)}
+		if !ip.Equal(ip.Mask(network.Mask)) {
+			return nil, fmt.Errorf("%s is not a valid classless reverse zone", zone)
+		}
+		return network, nil
+	} else if dns.IsSubDomain(reverseIPv6Suffix, zone) {
+		labels := dns.SplitDomainName(strings.TrimSuffix(zone, reverseIPv6Suffix))
+		if len(labels) == 0 || len(labels) >= 32 {
+			return nil, fmt.Errorf("%s is not a valid IPv6 reverse zone", zone)
+		}
+
+		nibbles := make([]byte, 32)
+		for i := range nibbles {
+			nibbles[i] = '0'
+		}
+		for i, label := range labels {
+			if len(label) != 1 || !strings.Contains("0123456789abcdef", label) {
+				return nil, fmt.Errorf("%s is not a valid IPv6 reverse zone", zone)
+			}
+			nibbles[len(labels)-1-i] = label[0]
+		}
+
+		ip, _ := hex.DecodeString(string(nibbles))
+		return &net.IPNet{IP: net.IP(ip), Mask: net.CIDRMask(4*len(labels), 8*net.IPv6len)}, nil
+	}
+
+	return nil, fmt.Errorf("%s is not a reverse zone", zone)
+}
+
+// ReverseOwner returns the owner name of the PTR record of ip, in the given
+// reverse zone. In RFC 2317 classless zones, the owner is the last byte of the
+// address under the zone, the parent zone being expected to hold a CNAME from
+// the usual name to this one.
+func ReverseOwner(ip net.IP, zone string) (string, error) {
+	network, err := ReverseZoneNetwork(zone)
+	if err != nil {
+		return "", err
+	}
+
+	if !network.Contains(ip) {
+		return "", fmt.Errorf("%s is not in the reverse zone %s", ip.String(), zone)
+	}
+
+	if ones, bits := network.Mask.Size(); bits == 8*net.IPv4len && ones > 24 {
+		return fmt.Sprintf("%d.%s", ip.To4()[3], strings.ToLower(dns.Fqdn(zone))), nil
+	}
+
+	return dns.ReverseAddr(ip.String())
+}
+
+// ReverseAddress returns the address whose PTR record is at owner, in the
+// given reverse zone. It is the converse of ReverseOwner.
+func ReverseAddress(owner, zone string) (net.IP, error) {
+	network, err := ReverseZoneNetwork(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	zone = strings.ToLower(dns.Fqdn(zone))
+	owner = strings.ToLower(dns.Fqdn(owner))
+	if !dns.IsSubDomain(zone, owner) {
+		return nil, fmt.Errorf("%s is not in the reverse zone %s", owner, zone)
+	}
+
+	var ip net.IP
+	if ones, bits := network.Mask.Size(); bits == 8*net.IPv4len && ones > 24 {
+		labels := dns.SplitDomainName(strings.TrimSuffix(owner, zone))
+		if len(labels) != 1 {
+			return nil, fmt.Errorf("%s is not an address of the reverse zone %s", owner, zone)
+		}
+
+		b, err := strconv.ParseUint(labels[0], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not an address of the reverse zone %s", owner, zone)
+		}
+
+		ip = make(net.IP, net.IPv4len)
+		copy(ip, network.IP.To4())
+		ip[3] = byte(b)
+	} else if bits == 8*net.IPv4len {
+		labels := dns.SplitDomainName(strings.TrimSuffix(owner, reverseIPv4Suffix))
+		if len(labels) != net.IPv4len {
+			return nil, fmt.Errorf("%s is not an IPv4 reverse name", owner)
+		}
+
+		ip = make(net.IP, net.IPv4len)
+		for i, label := range labels {
+			b, err := strconv.ParseUint(label, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not an IPv4 reverse name", owner)
+			}
+			ip[len(labels)-1-i] = byte(b)
+		}
+	} else {
+		labels := dns.SplitDomainName(strings.TrimSuffix(owner, reverseIPv6Suffix))
+		if len(labels) != 2*net.IPv6len {
+			return nil, fmt.Errorf("%s is not an IPv6 reverse name", owner)
+		}
+
+		nibbles := make([]byte, len(labels))
+		for i, label := range labels {
+			if len(label) != 1 || !strings.Contains("0123456789abcdef", label) {
+				return nil, fmt.Errorf("%s is not an IPv6 reverse name", owner)
+			}
+			nibbles[len(labels)-1-i] = label[0]
+		}
+
+		ip, _ = hex.DecodeString(string(nibbles))
+	}
+
+	if !network.Contains(ip) {
+		return nil, fmt.Errorf("%s is not in the reverse zone %s", ip.String(), zone)
+	}
+
+	return ip, nil
+}
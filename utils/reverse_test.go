@@ -0,0 +1,228 @@
+// Copyright or © or Copr. happyDNS (2023)
+//
+// contact@happydomain.org
+//
+// This software is a computer program whose purpose is to provide a modern
+// interface to interact with DNS systems.
+//
+// This software is governed by the CeCILL license under French law and abiding
+// by the rules of distribution of free software.  You can use, modify and/or
+// redistribute the software under the terms of the CeCILL license as
+// circulated by CEA, CNRS and INRIA at the following URL
+// "http://www.cecill.info".
+//
+// As a counterpart to the access to the source code and rights to copy, modify
+// and redistribute granted by the license, users are provided only with a
+// limited warranty and the software's author, the holder of the economic
+// rights, and the successive licensors have only limited liability.
+//
+// In this respect, the user's attention is drawn to the risks associated with
+// loading, using, modifying and/or developing or reproducing the software by
+// the user in light of its specific status of free software, that may mean
+// that it is complicated to manipulate, and that also therefore means that it
+// is reserved for developers and experienced professionals having in-depth
+// computer knowledge. Users are therefore encouraged to load and test the
+// software's suitability as regards their requirements in conditions enabling
+// the security of their systems and/or data to be ensured and, more generally,
+// to use and operate it in the same conditions as regards security.
+//
+// The fact that you are presently reading this means that you have had
+// knowledge of the CeCILL license and that you accept its terms.
+
+package utils
+
+import (
+	"net"
+	"testing"
+)
+
+func TestReverseZone(t *testing.T) {
+	for _, tc := range []struct {
+		network string
+		zone    string
+	}{
+		{"10.0.0.0/8", "10.in-addr.arpa."},
+		{"172.16.0.0/16", "16.172.in-addr.arpa."},
+		{"192.0.2.0/24", "2.0.192.in-addr.arpa."},
+		{"192.0.2.0/25", "0/25.2.0.192.in-addr.arpa."},
+		{"192.0.2.128/25", "128/25.2.0.192.in-addr.arpa."},
+		{"192.0.2.64/26", "64/26.2.0.192.in-addr.arpa."},
+		{"192.0.2.32/27", "32/27.2.0.192.in-addr.arpa."},
+		{"192.0.2.248/29", "248/29.2.0.192.in-addr.arpa."},
+		{"192.0.2.254/31", "254/31.2.0.192.in-addr.arpa."},
+		{"2001:db8::/32", "8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8::/36", "0.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8:1234::/48", "4.3.2.1.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8::/64", "0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2000::/4", "2.ip6.arpa."},
+		{"2001:db8::10/124", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	} {
+		_, network, err := net.ParseCIDR(tc.network)
+		if err != nil {
+			t.Fatalf("invalid network %s: %s", tc.network, err.Error())
+		}
+
+		zone, err := ReverseZone(network)
+		if err != nil {
+			t.Errorf("ReverseZone(%s): %s", tc.network, err.Error())
+			continue
+		}
+		if zone != tc.zone {
+			t.Errorf("ReverseZone(%s) = %s, expected %s", tc.network, zone, tc.zone)
+		}
+
+		back, err := ReverseZoneNetwork(zone)
+		if err != nil {
+			t.Errorf("ReverseZoneNetwork(%s): %s", zone, err.Error())
+		} else if back.String() != network.String() {
+			t.Errorf("ReverseZoneNetwork(%s) = %s, expected %s", zone, back.String(), network.String())
+		}
+	}
+}
+
+func TestReverseZoneInvalid(t *testing.T) {
+	for _, network := range []string{
+		"0.0.0.0/0",
+		"172.16.0.0/12",
+		"192.0.2.0/20",
+		"192.0.2.1/32",
+		"::/0",
+		"2001:db8::/30",
+		"2001:db8::/126",
+		"2001:db8::1/128",
+	} {
+		_, n, err := net.ParseCIDR(network)
+		if err != nil {
+			t.Fatalf("invalid network %s: %s", network, err.Error())
+		}
+
+		if zone, err := ReverseZone(n); err == nil {
+			t.Errorf("ReverseZone(%s) = %s, expected an error", network, zone)
+		}
+	}
+}
+
+func TestReverseZoneNetwork(t *testing.T) {
+	for _, tc := range []struct {
+		zone    string
+		network string
+	}{
+		{"0-26.2.0.192.in-addr.arpa.", "192.0.2.0/26"},
+		{"192-26.2.0.192.in-addr.arpa.", "192.0.2.192/26"},
+		{"128-25.2.0.192.in-addr.arpa", "192.0.2.128/25"},
+		{"0/26.2.0.192.IN-ADDR.ARPA.", "192.0.2.0/26"},
+		{"2.0.192.in-addr.arpa", "192.0.2.0/24"},
+		{"8.B.D.0.1.0.0.2.ip6.arpa.", "2001:db8::/32"},
+	} {
+		network, err := ReverseZoneNetwork(tc.zone)
+		if err != nil {
+			t.Errorf("ReverseZoneNetwork(%s): %s", tc.zone, err.Error())
+		} else if network.String() != tc.network {
+			t.Errorf("ReverseZoneNetwork(%s) = %s, expected %s", tc.zone, network.String(), tc.network)
+		}
+	}
+
+	for _, zone := range []string{
+		// Classless bases not aligned on the prefix length
+		"32/26.2.0.192.in-addr.arpa.",
+		"1-31.2.0.192.in-addr.arpa.",
+		"64/25.2.0.192.in-addr.arpa.",
+		// Invalid classless prefix lengths
+		"0/24.2.0.192.in-addr.arpa.",
+		"0/32.2.0.192.in-addr.arpa.",
+		"0/x.2.0.192.in-addr.arpa.",
+		"0/26.0.192.in-addr.arpa.",
+		// Invalid labels
+		"in-addr.arpa.",
+		"256.in-addr.arpa.",
+		"1.2.3.4.in-addr.arpa.",
+		"1.2.3.4.5.in-addr.arpa.",
+		"ip6.arpa.",
+		"10.8.b.d.0.1.0.0.2.ip6.arpa.",
+		"g.ip6.arpa.",
+		"example.com.",
+	} {
+		if network, err := ReverseZoneNetwork(zone); err == nil {
+			t.Errorf("ReverseZoneNetwork(%s) = %s, expected an error", zone, network.String())
+		}
+	}
+}
+
+func TestReverseOwner(t *testing.T) {
+	for _, tc := range []struct {
+		ip    string
+		zone  string
+		owner string
+	}{
+		{"10.1.2.3", "10.in-addr.arpa.", "3.2.1.10.in-addr.arpa."},
+		{"192.0.2.1", "2.0.192.in-addr.arpa.", "1.2.0.192.in-addr.arpa."},
+		{"192.0.2.0", "0/26.2.0.192.in-addr.arpa.", "0.0/26.2.0.192.in-addr.arpa."},
+		{"192.0.2.63", "0/26.2.0.192.in-addr.arpa.", "63.0/26.2.0.192.in-addr.arpa."},
+		{"192.0.2.5", "0-26.2.0.192.in-addr.arpa.", "5.0-26.2.0.192.in-addr.arpa."},
+		{"192.0.2.254", "254/31.2.0.192.in-addr.arpa.", "254.254/31.2.0.192.in-addr.arpa."},
+		{"192.0.2.255", "254-31.2.0.192.in-addr.arpa.", "255.254-31.2.0.192.in-addr.arpa."},
+		{"192.0.2.200", "128/25.2.0.192.in-addr.arpa.", "200.128/25.2.0.192.in-addr.arpa."},
+		// First and last addresses of IPv6 zones, on and between nibble
+		// boundaries
+		{"2001:db8::", "8.b.d.0.1.0.0.2.ip6.arpa.", "0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", "8.b.d.0.1.0.0.2.ip6.arpa.", "f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.f.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8:0fff::1", "0.8.b.d.0.1.0.0.2.ip6.arpa.", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.f.f.f.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8::1f", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", "f.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+	} {
+		owner, err := ReverseOwner(net.ParseIP(tc.ip), tc.zone)
+		if err != nil {
+			t.Errorf("ReverseOwner(%s, %s): %s", tc.ip, tc.zone, err.Error())
+			continue
+		}
+		if owner != tc.owner {
+			t.Errorf("ReverseOwner(%s, %s) = %s, expected %s", tc.ip, tc.zone, owner, tc.owner)
+		}
+
+		ip, err := ReverseAddress(owner, tc.zone)
+		if err != nil {
+			t.Errorf("ReverseAddress(%s, %s): %s", owner, tc.zone, err.Error())
+		} else if !ip.Equal(net.ParseIP(tc.ip)) {
+			t.Errorf("ReverseAddress(%s, %s) = %s, expected %s", owner, tc.zone, ip.String(), tc.ip)
+		}
+	}
+}
+
+func TestReverseOutsideZone(t *testing.T) {
+	for _, tc := range []struct {
+		ip   string
+		zone string
+	}{
+		{"192.0.3.1", "2.0.192.in-addr.arpa."},
+		{"192.0.2.64", "0/26.2.0.192.in-addr.arpa."},
+		{"192.0.2.127", "128-25.2.0.192.in-addr.arpa."},
+		{"2001:db8:1000::", "0.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db9::", "8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"2001:db8::1", "2.0.192.in-addr.arpa."},
+	} {
+		if owner, err := ReverseOwner(net.ParseIP(tc.ip), tc.zone); err == nil {
+			t.Errorf("ReverseOwner(%s, %s) = %s, expected an error", tc.ip, tc.zone, owner)
+		}
+	}
+
+	for _, tc := range []struct {
+		owner string
+		zone  string
+	}{
+		{"1.3.0.192.in-addr.arpa.", "2.0.192.in-addr.arpa."},
+		{"www.example.com.", "2.0.192.in-addr.arpa."},
+		{"2.0.192.in-addr.arpa.", "2.0.192.in-addr.arpa."},
+		{"x.2.0.192.in-addr.arpa.", "2.0.192.in-addr.arpa."},
+		{"1.1.2.0.192.in-addr.arpa.", "2.0.192.in-addr.arpa."},
+		// The usual name is in the parent zone, not the classless one
+		{"5.2.0.192.in-addr.arpa.", "0/26.2.0.192.in-addr.arpa."},
+		{"70.0/26.2.0.192.in-addr.arpa.", "0/26.2.0.192.in-addr.arpa."},
+		{"5.0-26.2.0.192.in-addr.arpa.", "0/26.2.0.192.in-addr.arpa."},
+		{"1.5.0/26.2.0.192.in-addr.arpa.", "0/26.2.0.192.in-addr.arpa."},
+		{"1.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa.", "8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.9.b.d.0.1.0.0.2.ip6.arpa.", "8.b.d.0.1.0.0.2.ip6.arpa."},
+	} {
+		if ip, err := ReverseAddress(tc.owner, tc.zone); err == nil {
+			t.Errorf("ReverseAddress(%s, %s) = %s, expected an error", tc.owner, tc.zone, ip.String())
+		}
+	}
+}